@@ -13,14 +13,33 @@ import (
 
 	_ "github.com/lib/pq"
 	"github.com/npezzotti/go-chatroom/internal/api"
+	"github.com/npezzotti/go-chatroom/internal/backplane"
+	"github.com/npezzotti/go-chatroom/internal/blob"
 	"github.com/npezzotti/go-chatroom/internal/config"
 	"github.com/npezzotti/go-chatroom/internal/database"
 	"github.com/npezzotti/go-chatroom/internal/server"
 	"github.com/npezzotti/go-chatroom/internal/stats"
+	"github.com/npezzotti/go-chatroom/internal/unfurl"
 )
 
 const defaultSigningKey = "wT0phFUusHZIrDhL9bUKPUhwaxKhpi/SaI6PtgB+MgU="
 
+// backplaneChannel is the Postgres notification channel the nodes of a cluster deliver messages on.
+const backplaneChannel = "gochat"
+
+const (
+	// unfurlWorkers is the number of link previews fetched at once
+	unfurlWorkers = 4
+	// unfurlQueueSize is the number of messages waiting for link previews before new ones are skipped
+	unfurlQueueSize = 256
+	// unfurlTimeout is how long fetching a linked page can take
+	unfurlTimeout = 5 * time.Second
+	// sweepInterval is how often the contents of deleted attachments are removed
+	sweepInterval = time.Hour
+	// unattachedUploadTTL is how long an upload is kept before it is attached to a message
+	unattachedUploadTTL = 24 * time.Hour
+)
+
 type stringSliceFlag []string
 
 func (s *stringSliceFlag) String() string {
@@ -38,6 +57,12 @@ var (
 	signingKey     string
 	allowedOrigins stringSliceFlag
 	devMode        bool
+	attachmentsDir string
+	maxMessageSize int64
+	maxContentLen  int
+	cluster        bool
+	queryTimeout   time.Duration
+	batchSize      int
 )
 
 func main() {
@@ -46,16 +71,27 @@ func main() {
 	flag.StringVar(&signingKey, "signing-key", defaultSigningKey, "base64 encoded signing key")
 	flag.Var(&allowedOrigins, "allowed-origins", "comma-separated list of allowed origins for CORS")
 	flag.BoolVar(&devMode, "dev", false, "run in development mode (serves frontend files)")
+	flag.StringVar(&attachmentsDir, "attachments-dir", "attachments", "directory where uploaded attachments are stored")
+	flag.Int64Var(&maxMessageSize, "max-message-size", config.DefaultMaxMessageSize, "maximum size in bytes of a websocket message")
+	flag.IntVar(&maxContentLen, "max-content-length", config.DefaultMaxContentLength, "maximum number of characters in a chat message")
+	flag.DurationVar(&queryTimeout, "query-timeout", config.DefaultQueryTimeout, "maximum duration of a database query")
+	flag.IntVar(&batchSize, "message-batch-size", 0, "maximum number of messages a room saves at once in the background, 0 to save each message before handling the next")
+	flag.BoolVar(&cluster, "cluster", false, "run as a node of a cluster, delivering messages between nodes through the database")
 	flag.Parse()
 
 	logger := log.New(os.Stderr, "[go-chat] ", log.LstdFlags)
 
-	cfg, err := config.NewConfig(addr, dsn, signingKey, allowedOrigins, devMode)
+	cfg, err := config.NewConfig(addr, dsn, signingKey, allowedOrigins, devMode,
+		config.WithMaxMessageSize(maxMessageSize),
+		config.WithMaxContentLength(maxContentLen),
+		config.WithQueryTimeout(queryTimeout),
+		config.WithMessageBatchSize(batchSize),
+	)
 	if err != nil {
 		logger.Fatal("config:", err)
 	}
 
-	dbConn, err := database.NewPgGoChatRepository(cfg.DatabaseDSN)
+	dbConn, err := database.NewPgGoChatRepository(cfg.DatabaseDSN, cfg.QueryTimeout)
 	if err != nil {
 		logger.Fatal("db open:", err)
 	}
@@ -71,16 +107,44 @@ func main() {
 
 	logger.Println("database migrations applied successfully")
 
+	blobStore, err := blob.NewLocalStore(attachmentsDir)
+	if err != nil {
+		logger.Fatal("blob store:", err)
+	}
+
+	sweeper := blob.NewSweeper(logger, dbConn, blobStore, sweepInterval, unattachedUploadTTL)
+	sweeper.Run()
+	defer sweeper.Stop()
+
 	mux := http.NewServeMux()
 
 	statsUpdater := stats.NewStatsUpdater(mux)
 
-	chatServer, err := server.NewChatServer(logger, dbConn, statsUpdater)
+	unfurler := unfurl.NewUnfurler(logger, dbConn, unfurlWorkers, unfurlQueueSize, unfurlTimeout, false)
+	unfurler.Run()
+	defer unfurler.Stop()
+
+	serverOpts := []server.Option{
+		server.WithMaxMessageSize(cfg.MaxMessageSize),
+		server.WithMaxContentLength(cfg.MaxContentLength),
+		server.WithMessageBatchSize(cfg.MessageBatchSize),
+	}
+	if cluster {
+		bp, err := backplane.NewPostgres(logger, cfg.DatabaseDSN, backplaneChannel)
+		if err != nil {
+			logger.Fatal("backplane:", err)
+		}
+		defer bp.Close()
+
+		serverOpts = append(serverOpts, server.WithBackplane(bp))
+	}
+
+	chatServer, err := server.NewChatServer(logger, dbConn, statsUpdater, unfurler, serverOpts...)
 	if err != nil {
 		logger.Fatal("new chat server:", err)
 	}
 
-	srv := api.NewGoChatApp(mux, logger, chatServer, dbConn, statsUpdater, cfg)
+	srv := api.NewGoChatApp(mux, logger, chatServer, dbConn, statsUpdater, blobStore, cfg)
 
 	statsUpdater.Run()
 	defer statsUpdater.Stop()
@@ -64,6 +64,20 @@ func NewForbiddenError() *ApiError {
 	}
 }
 
+func NewConflictError() *ApiError {
+	return &ApiError{
+		StatusCode: http.StatusConflict,
+		Message:    lower(http.StatusText(http.StatusConflict)),
+	}
+}
+
+func NewRequestEntityTooLargeError() *ApiError {
+	return &ApiError{
+		StatusCode: http.StatusRequestEntityTooLarge,
+		Message:    lower(http.StatusText(http.StatusRequestEntityTooLarge)),
+	}
+}
+
 func NewMethodNotAllowedError() *ApiError {
 	return &ApiError{
 		StatusCode: http.StatusMethodNotAllowed,
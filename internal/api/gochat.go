@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"github.com/gorilla/handlers"
+	"github.com/npezzotti/go-chatroom/internal/blob"
 	"github.com/npezzotti/go-chatroom/internal/config"
 	"github.com/npezzotti/go-chatroom/internal/database"
 	"github.com/npezzotti/go-chatroom/internal/server"
@@ -23,9 +24,10 @@ type GoChatApp struct {
 	generateShortId func() (string, error)
 	allowedOrigins  []string
 	stats           stats.StatsProvider
+	blobs           blob.Store
 }
 
-func NewGoChatApp(mux *http.ServeMux, logger *log.Logger, cs *server.ChatServer, db database.GoChatRepository, stats stats.StatsProvider, cfg *config.Config) *GoChatApp {
+func NewGoChatApp(mux *http.ServeMux, logger *log.Logger, cs *server.ChatServer, db database.GoChatRepository, stats stats.StatsProvider, blobs blob.Store, cfg *config.Config) *GoChatApp {
 	app := &GoChatApp{
 		log:             logger,
 		db:              db,
@@ -34,6 +36,7 @@ func NewGoChatApp(mux *http.ServeMux, logger *log.Logger, cs *server.ChatServer,
 		generateShortId: defaultGenerateShortId,
 		allowedOrigins:  cfg.AllowedOrigins,
 		stats:           stats,
+		blobs:           blobs,
 	}
 
 	mux.HandleFunc("GET /healthz", app.healthCheck)
@@ -42,10 +45,32 @@ func NewGoChatApp(mux *http.ServeMux, logger *log.Logger, cs *server.ChatServer,
 	mux.HandleFunc("GET /api/auth/session", app.authMiddleware(app.session))
 	mux.Handle("GET /api/auth/logout", app.authMiddleware(app.logout))
 	mux.Handle("/api/account", app.authMiddleware(app.account))
+	mux.Handle("GET /api/rooms", app.authMiddleware(app.listRooms))
 	mux.Handle("POST /api/rooms", app.authMiddleware(app.createRoom))
 	mux.Handle("DELETE /api/rooms", app.authMiddleware(app.deleteRoom))
+	mux.Handle("PATCH /api/rooms/{id}", app.authMiddleware(app.updateRoom))
+	mux.Handle("POST /api/rooms/{id}/invites", app.authMiddleware(app.createInvite))
+	mux.Handle("GET /api/rooms/{id}/invites", app.authMiddleware(app.listInvites))
+	mux.Handle("DELETE /api/rooms/{id}/invites/{user_id}", app.authMiddleware(app.deleteInvite))
+	mux.Handle("POST /api/rooms/{id}/admins", app.authMiddleware(app.promoteAdmin))
+	mux.Handle("DELETE /api/rooms/{id}/admins/{user_id}", app.authMiddleware(app.demoteAdmin))
+	mux.Handle("DELETE /api/rooms/{id}/subscribers/{user_id}", app.authMiddleware(app.kickUser))
+	mux.Handle("POST /api/rooms/{id}/bans", app.authMiddleware(app.createBan))
+	mux.Handle("GET /api/rooms/{id}/bans", app.authMiddleware(app.listBans))
+	mux.Handle("DELETE /api/rooms/{id}/bans/{user_id}", app.authMiddleware(app.deleteBan))
+	mux.Handle("GET /api/rooms/{id}/pins", app.authMiddleware(app.listPins))
+	mux.Handle("POST /api/rooms/{id}/pins", app.authMiddleware(app.pinMessage))
+	mux.Handle("DELETE /api/rooms/{id}/pins/{seq_id}", app.authMiddleware(app.unpinMessage))
+	mux.Handle("POST /api/dms", app.authMiddleware(app.createDirectMessage))
 	mux.Handle("GET /api/subscriptions", app.authMiddleware(app.getUsersSubscriptions))
 	mux.Handle("GET /api/messages", app.authMiddleware(app.getMessages))
+	mux.Handle("DELETE /api/messages", app.authMiddleware(app.deleteMessage))
+	mux.Handle("GET /api/messages/thread", app.authMiddleware(app.getThread))
+	mux.Handle("GET /api/messages/revisions", app.authMiddleware(app.getMessageRevisions))
+	mux.Handle("GET /api/search", app.authMiddleware(app.searchMessages))
+	mux.Handle("GET /api/mentions", app.authMiddleware(app.listMentions))
+	mux.Handle("POST /api/rooms/{id}/attachments", app.authMiddleware(app.uploadAttachment))
+	mux.Handle("GET /api/attachments/{id}", app.authMiddleware(app.getAttachment))
 	mux.Handle("GET /api/ws", app.authMiddleware(app.serveWs))
 
 	if cfg.DevMode {
@@ -56,7 +81,7 @@ func NewGoChatApp(mux *http.ServeMux, logger *log.Logger, cs *server.ChatServer,
 	h := handlers.CORS(
 		handlers.MaxAge(3600),
 		handlers.AllowedOrigins(app.allowedOrigins),
-		handlers.AllowedMethods([]string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions}),
+		handlers.AllowedMethods([]string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions}),
 		handlers.AllowedHeaders([]string{"Origin", "Content-Type", "Accept"}),
 		handlers.AllowCredentials(),
 	)(mux)
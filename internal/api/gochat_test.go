@@ -2,8 +2,10 @@ package api
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/npezzotti/go-chatroom/internal/blob"
 	"github.com/npezzotti/go-chatroom/internal/config"
 	"github.com/npezzotti/go-chatroom/internal/database"
 	"github.com/npezzotti/go-chatroom/internal/server"
@@ -16,6 +18,7 @@ func TestNewGoChatApp(t *testing.T) {
 	logger := testutil.TestLogger(t)
 	cs := &server.ChatServer{}
 	db := &database.MockGoChatRepository{}
+	blobs := &blob.MockStore{}
 	cfg := &config.Config{
 		ServerAddr:     "localhost:8080",
 		DatabaseDSN:    "dsn",
@@ -23,7 +26,7 @@ func TestNewGoChatApp(t *testing.T) {
 		AllowedOrigins: []string{"http://localhost:3000"},
 	}
 
-	app := NewGoChatApp(mux, logger, cs, db, nil, cfg)
+	app := NewGoChatApp(mux, logger, cs, db, nil, blobs, cfg)
 
 	assert.NotNil(t, app, "expected app to be initialized")
 	assert.NotNil(t, app.mux, "expected mux to be initialized")
@@ -33,6 +36,51 @@ func TestNewGoChatApp(t *testing.T) {
 	assert.Equal(t, app.log, logger, "expected logger to be set")
 	assert.Equal(t, app.db, db, "expected db to be set")
 	assert.Equal(t, app.cs, cs, "expected chat server to be set")
+	assert.Equal(t, app.blobs, blobs, "expected blob store to be set")
 	assert.Equal(t, app.signingKey, cfg.SigningKey, "expected signing key to be set")
 	assert.Equal(t, app.mux.Addr, cfg.ServerAddr, "expected server address to match config")
 }
+
+func TestNewGoChatApp_corsPreflight(t *testing.T) {
+	tcases := []struct {
+		name           string
+		method         string
+		expectedStatus int
+	}{
+		{
+			name:           "allows PATCH",
+			method:         http.MethodPatch,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "allows DELETE",
+			method:         http.MethodDelete,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "rejects TRACE",
+			method:         http.MethodTrace,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{AllowedOrigins: []string{"http://localhost:3000"}}
+			app := NewGoChatApp(http.NewServeMux(), testutil.TestLogger(t), &server.ChatServer{}, &database.MockGoChatRepository{}, nil, nil, cfg)
+
+			req := httptest.NewRequest(http.MethodOptions, "/api/rooms/EoGKUXPHgz", nil)
+			req.Header.Set("Origin", "http://localhost:3000")
+			req.Header.Set("Access-Control-Request-Method", tc.method)
+
+			rr := httptest.NewRecorder()
+			app.mux.Handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code, "expected preflight status code to match")
+			if tc.expectedStatus == http.StatusOK {
+				assert.Equal(t, "http://localhost:3000", rr.Header().Get("Access-Control-Allow-Origin"), "expected origin to be allowed")
+				assert.Equal(t, tc.method, rr.Header().Get("Access-Control-Allow-Methods"), "expected method to be allowed")
+			}
+		})
+	}
+}
@@ -1,21 +1,55 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"io"
+	"mime"
 	"net/http"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
+	"github.com/npezzotti/go-chatroom/internal/blob"
 	"github.com/npezzotti/go-chatroom/internal/database"
 	"github.com/npezzotti/go-chatroom/internal/server"
 	"github.com/npezzotti/go-chatroom/internal/types"
 )
 
+const (
+	// defaultRoomsLimit is the number of rooms listed if no limit is requested
+	defaultRoomsLimit = 20
+	// maxRoomsLimit is the maximum number of rooms that can be listed at once
+	maxRoomsLimit = 100
+	// defaultSearchLimit is the number of search results returned if no limit is requested
+	defaultSearchLimit = 20
+	// maxSearchLimit is the maximum number of search results that can be returned at once
+	maxSearchLimit = 50
+	// maxSearchQueryLength is the maximum length in bytes of a search query
+	maxSearchQueryLength = 256
+	// defaultMentionsLimit is the number of mentions returned if no limit is requested
+	defaultMentionsLimit = 20
+	// maxMentionsLimit is the maximum number of mentions that can be returned at once
+	maxMentionsLimit = 50
+	// maxAttachmentSize is the maximum size in bytes of an uploaded file
+	maxAttachmentSize = 10 << 20
+	// maxMultipartOverhead is the space allowed for the multipart headers and boundaries of an upload
+	maxMultipartOverhead = 64 << 10
+	// maxFilenameLength is the maximum length in bytes of the name of an uploaded file
+	maxFilenameLength = 255
+)
+
+// inlineContentTypes are the types of attachments that browsers may display
+// instead of downloading. Other types could run scripts in our origin.
+var inlineContentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
@@ -35,6 +69,38 @@ type UpdateAccountRequest struct {
 type CreateRoomRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	Private     bool   `json:"private,omitempty"`
+}
+
+// UpdateRoomRequest changes the name and/or description of a room.
+// Fields that are omitted are left unchanged.
+type UpdateRoomRequest struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+}
+
+type CreateInviteRequest struct {
+	UserId int `json:"user_id"`
+}
+
+type PromoteAdminRequest struct {
+	UserId int `json:"user_id"`
+}
+
+// BanRequest bans a user from a room. The ban is permanent unless ExpiresAt is set.
+type BanRequest struct {
+	UserId    int        `json:"user_id"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// PinRequest pins a message to a room.
+type PinRequest struct {
+	SeqId int `json:"seq_id"`
+}
+
+type DirectMessageRequest struct {
+	UserId int `json:"user_id"`
 }
 
 func (s *GoChatApp) writeJson(w http.ResponseWriter, statusCode int, v interface{}) {
@@ -50,7 +116,7 @@ func (s *GoChatApp) writeJson(w http.ResponseWriter, statusCode int, v interface
 }
 
 func (s *GoChatApp) healthCheck(w http.ResponseWriter, r *http.Request) {
-	if err := s.db.Ping(); err != nil {
+	if err := s.db.Ping(r.Context()); err != nil {
 		resp := NewInternalServerError(err)
 		s.writeJson(w, resp.StatusCode, resp)
 		return
@@ -92,7 +158,7 @@ func (s *GoChatApp) createAccount(w http.ResponseWriter, r *http.Request) {
 		PasswordHash: pwdHash,
 	}
 
-	newUser, err := s.db.CreateAccount(params)
+	newUser, err := s.db.CreateAccount(r.Context(), params)
 	if err != nil {
 		s.log.Printf("createAccount: %v", err)
 		errResp := NewInternalServerError(err)
@@ -119,7 +185,7 @@ func (s *GoChatApp) account(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		user, err := s.db.GetAccountById(userId)
+		user, err := s.db.GetAccountById(r.Context(), userId)
 		if err != nil {
 			var errResp *ApiError
 			if errors.Is(err, sql.ErrNoRows) {
@@ -148,7 +214,7 @@ func (s *GoChatApp) account(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		curUser, err := s.db.GetAccountById(userId)
+		curUser, err := s.db.GetAccountById(r.Context(), userId)
 		if err != nil {
 			var errResp *ApiError
 			if errors.Is(err, sql.ErrNoRows) {
@@ -190,7 +256,7 @@ func (s *GoChatApp) account(w http.ResponseWriter, r *http.Request) {
 			PasswordHash: pwdHash,
 		}
 
-		dbUser, err := s.db.UpdateAccount(params)
+		dbUser, err := s.db.UpdateAccount(r.Context(), params)
 		if err != nil {
 			errResp := NewInternalServerError(err)
 			s.writeJson(w, errResp.StatusCode, errResp)
@@ -220,7 +286,7 @@ func (s *GoChatApp) session(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := s.db.GetAccountById(userId)
+	user, err := s.db.GetAccountById(r.Context(), userId)
 	if err != nil {
 		var errResp *ApiError
 		if errors.Is(err, sql.ErrNoRows) {
@@ -259,7 +325,7 @@ func (s *GoChatApp) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dbUser, err := s.db.GetAccountByEmail(lr.Email)
+	dbUser, err := s.db.GetAccountByEmail(r.Context(), lr.Email)
 	if err != nil {
 		var errResp *ApiError
 		if errors.Is(err, sql.ErrNoRows) {
@@ -340,9 +406,13 @@ func (s *GoChatApp) createRoom(w http.ResponseWriter, r *http.Request) {
 		Description: createRoomReq.Description,
 		OwnerId:     userId,
 		ExternalId:  sid,
+		Kind:        database.RoomKindPublic,
+	}
+	if createRoomReq.Private {
+		params.Kind = database.RoomKindPrivate
 	}
 
-	newRoom, err := s.db.CreateRoom(params)
+	newRoom, err := s.db.CreateRoom(r.Context(), params)
 	if err != nil {
 		s.log.Printf("createRoom: %v", err)
 		errResp := NewInternalServerError(err)
@@ -356,6 +426,7 @@ func (s *GoChatApp) createRoom(w http.ResponseWriter, r *http.Request) {
 		Name:        newRoom.Name,
 		Description: newRoom.Description,
 		OwnerId:     newRoom.OwnerId,
+		Kind:        newRoom.Kind,
 		CreatedAt:   newRoom.CreatedAt,
 		UpdatedAt:   newRoom.UpdatedAt,
 	}
@@ -363,6 +434,221 @@ func (s *GoChatApp) createRoom(w http.ResponseWriter, r *http.Request) {
 	s.writeJson(w, http.StatusCreated, room)
 }
 
+// listRooms lists the public rooms that can be joined, optionally filtered by a
+// text query over their name and description. Results are paginated with the
+// limit and offset query parameters.
+func (s *GoChatApp) listRooms(w http.ResponseWriter, r *http.Request) {
+	params := database.ListRoomsParams{
+		Query: strings.TrimSpace(r.URL.Query().Get("q")),
+		Limit: defaultRoomsLimit,
+	}
+
+	var err error
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		params.Limit, err = strconv.Atoi(limitStr)
+		if err != nil || params.Limit <= 0 || params.Limit > maxRoomsLimit {
+			errResp := NewBadRequestError()
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return
+		}
+	}
+
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		params.Offset, err = strconv.Atoi(offsetStr)
+		if err != nil || params.Offset < 0 {
+			errResp := NewBadRequestError()
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return
+		}
+	}
+
+	dbRooms, err := s.db.ListRooms(r.Context(), params)
+	if err != nil {
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	// in a cluster, a room is loaded if any node holds its lease
+	rooms := make([]types.Room, 0, len(dbRooms))
+	for _, room := range dbRooms {
+		rooms = append(rooms, types.Room{
+			Id:              room.Id,
+			ExternalId:      room.ExternalId,
+			Name:            room.Name,
+			Description:     room.Description,
+			SeqId:           room.SeqId,
+			OwnerId:         room.OwnerId,
+			Kind:            room.Kind,
+			SubscriberCount: room.SubscriberCount,
+			Loaded:          room.Leased || s.cs.IsRoomLoaded(room.ExternalId),
+			CreatedAt:       room.CreatedAt,
+			UpdatedAt:       room.UpdatedAt,
+		})
+	}
+
+	s.writeJson(w, http.StatusOK, rooms)
+}
+
+// updateRoom renames a room or changes its description and notifies its subscribers.
+// Only the owner and admins of the room may update it.
+func (s *GoChatApp) updateRoom(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	var updateReq UpdateRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&updateReq); err != nil {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if updateReq.Name == nil && updateReq.Description == nil {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room, ok := s.authorizeRoom(w, r, userId, database.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	params := database.UpdateRoomParams{
+		Id:          room.Id,
+		Name:        room.Name,
+		Description: room.Description,
+	}
+	if updateReq.Name != nil {
+		params.Name = strings.TrimSpace(*updateReq.Name)
+	}
+	if updateReq.Description != nil {
+		params.Description = strings.TrimSpace(*updateReq.Description)
+	}
+
+	if params.Name == "" || params.Description == "" {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	updated, err := s.db.UpdateRoom(r.Context(), params)
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			s.log.Println("update room:", err)
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	// the room is already updated so don't fail the request
+	if err := s.cs.NotifyRoom(r.Context(), updated.ExternalId, &server.Notification{
+		RoomUpdated: &server.RoomUpdated{
+			RoomId:      updated.ExternalId,
+			Name:        updated.Name,
+			Description: updated.Description,
+			UpdatedAt:   updated.UpdatedAt,
+		},
+	}); err != nil {
+		s.log.Println("notify room of update:", err)
+	}
+
+	s.writeJson(w, http.StatusOK, types.Room{
+		Id:          updated.Id,
+		ExternalId:  updated.ExternalId,
+		Name:        updated.Name,
+		Description: updated.Description,
+		SeqId:       updated.SeqId,
+		OwnerId:     updated.OwnerId,
+		Kind:        updated.Kind,
+		CreatedAt:   updated.CreatedAt,
+		UpdatedAt:   updated.UpdatedAt,
+	})
+}
+
+// createDirectMessage finds or creates the direct message room between the
+// current user and another user. It responds with 201 Created if the room was
+// created, or 200 OK with the existing room.
+func (s *GoChatApp) createDirectMessage(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	var dmReq DirectMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&dmReq); err != nil {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if dmReq.UserId <= 0 || dmReq.UserId == userId {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if _, err := s.db.GetAccountById(r.Context(), dmReq.UserId); err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	sid, err := s.generateShortId()
+	if err != nil {
+		s.log.Print("generateShortId:", err)
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	dbRoom, created, err := s.db.GetOrCreateDirectRoom(r.Context(), database.CreateDirectRoomParams{
+		ExternalId: sid,
+		UserId:     userId,
+		PeerId:     dmReq.UserId,
+	})
+	if err != nil {
+		s.log.Printf("createDirectMessage: %v", err)
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room := &types.Room{
+		Id:          dbRoom.Id,
+		ExternalId:  dbRoom.ExternalId,
+		Name:        dbRoom.Name,
+		Description: dbRoom.Description,
+		SeqId:       dbRoom.SeqId,
+		OwnerId:     dbRoom.OwnerId,
+		Kind:        dbRoom.Kind,
+		CreatedAt:   dbRoom.CreatedAt,
+		UpdatedAt:   dbRoom.UpdatedAt,
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	s.writeJson(w, status, room)
+}
+
 func (s *GoChatApp) deleteRoom(w http.ResponseWriter, r *http.Request) {
 	userId, ok := UserId(r.Context())
 	if !ok {
@@ -378,7 +664,7 @@ func (s *GoChatApp) deleteRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := s.db.GetRoomByExternalId(externalId)
+	room, err := s.db.GetRoomByExternalId(r.Context(), externalId)
 	if err != nil {
 		var errResp *ApiError
 		if errors.Is(err, sql.ErrNoRows) {
@@ -390,14 +676,29 @@ func (s *GoChatApp) deleteRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the user is the owner of the room
-	if room.OwnerId != userId {
+	// Only the owner of the room may delete it
+	isOwner, err := s.hasRole(r.Context(), userId, room, database.RoleOwner)
+	if err != nil {
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+	if !isOwner {
 		errResp := NewForbiddenError()
 		s.writeJson(w, errResp.StatusCode, errResp)
 		return
 	}
 
-	err = s.db.DeleteRoom(room.Id)
+	// the subscriptions are deleted with the room, so get the subscribers to notify first
+	subs, err := s.db.GetSubscribersByRoomId(r.Context(), room.Id)
+	if err != nil {
+		s.log.Println("get subscribers:", err)
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	err = s.db.DeleteRoom(r.Context(), room.Id)
 	if err != nil {
 		s.log.Println("delete room:", err)
 		errResp := NewInternalServerError(err)
@@ -405,16 +706,27 @@ func (s *GoChatApp) deleteRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the clients in the room are notified by the room when it's unloaded, so the subscribers'
+	// other clients are notified first, while the clients in the room can still be skipped
+	userIds := make([]int, 0, len(subs))
+	for _, sub := range subs {
+		userIds = append(userIds, sub.Id)
+	}
+	s.cs.NotifyUsers(room.ExternalId, userIds, &server.Notification{
+		RoomDeleted: &server.RoomDeleted{RoomId: room.ExternalId},
+	})
+
 	if err := s.cs.UnloadRoom(r.Context(), room.ExternalId, true); err != nil {
 		s.log.Println("delete room from chat server:", err)
 		errResp := NewInternalServerError(err)
 		s.writeJson(w, errResp.StatusCode, errResp)
 		return
 	}
+
 	s.writeJson(w, http.StatusNoContent, nil)
 }
 
-func (s *GoChatApp) getUsersSubscriptions(w http.ResponseWriter, r *http.Request) {
+func (s *GoChatApp) createInvite(w http.ResponseWriter, r *http.Request) {
 	userId, ok := UserId(r.Context())
 	if !ok {
 		errResp := NewUnauthorizedError()
@@ -422,45 +734,26 @@ func (s *GoChatApp) getUsersSubscriptions(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	dbSubs, err := s.db.ListSubscriptions(userId)
-	if err != nil {
-		s.log.Println("list subscriptions:", err)
-		errResp := NewInternalServerError(err)
+	var inviteReq CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&inviteReq); err != nil || inviteReq.UserId <= 0 {
+		errResp := NewBadRequestError()
 		s.writeJson(w, errResp.StatusCode, errResp)
 		return
 	}
 
-	var subs []types.Subscription
-	for _, dbSub := range dbSubs {
-		subs = append(subs, types.Subscription{
-			Id:            dbSub.Id,
-			LastReadSeqId: dbSub.LastReadSeqId,
-			Room: types.Room{
-				Id:          dbSub.Room.Id,
-				ExternalId:  dbSub.Room.ExternalId,
-				Name:        dbSub.Room.Name,
-				Description: dbSub.Room.Description,
-				SeqId:       dbSub.Room.SeqId,
-				CreatedAt:   dbSub.Room.CreatedAt,
-				UpdatedAt:   dbSub.Room.UpdatedAt,
-			},
-			CreatedAt: dbSub.CreatedAt,
-			UpdatedAt: dbSub.UpdatedAt,
-		})
+	room, ok := s.authorizeRoom(w, r, userId, database.RoleAdmin)
+	if !ok {
+		return
 	}
 
-	s.writeJson(w, http.StatusOK, subs)
-}
-
-func (s *GoChatApp) getMessages(w http.ResponseWriter, r *http.Request) {
-	externalId := r.URL.Query().Get("room_id")
-	if externalId == "" {
+	// only private rooms require invitations
+	if room.Kind != database.RoomKindPrivate {
 		errResp := NewBadRequestError()
 		s.writeJson(w, errResp.StatusCode, errResp)
 		return
 	}
 
-	room, err := s.db.GetRoomByExternalId(externalId)
+	invitee, err := s.db.GetAccountById(r.Context(), inviteReq.UserId)
 	if err != nil {
 		var errResp *ApiError
 		if errors.Is(err, sql.ErrNoRows) {
@@ -472,60 +765,1312 @@ func (s *GoChatApp) getMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var before, after, limit int
+	if s.db.SubscriptionExists(r.Context(), invitee.Id, room.Id) {
+		// the user is already a member of the room
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
 
-	beforeStr := r.URL.Query().Get("before")
-	if beforeStr != "" {
-		before, err = strconv.Atoi(beforeStr)
-		if err != nil {
-			errResp := NewBadRequestError()
-			s.writeJson(w, errResp.StatusCode, errResp)
-			return
-		}
+	invite, err := s.db.CreateInvite(r.Context(), database.CreateInviteParams{
+		RoomId:    room.Id,
+		AccountId: invitee.Id,
+		InvitedBy: userId,
+	})
+	if err != nil {
+		s.log.Printf("createInvite: %v", err)
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
 	}
 
-	afterStr := r.URL.Query().Get("after")
-	if afterStr != "" {
-		after, err = strconv.Atoi(afterStr)
-		if err != nil {
-			errResp := NewBadRequestError()
-			s.writeJson(w, errResp.StatusCode, errResp)
-			return
-		}
+	s.writeJson(w, http.StatusCreated, types.Invite{
+		RoomId: room.ExternalId,
+		User: types.User{
+			Id:       invitee.Id,
+			Username: invitee.Username,
+		},
+		InvitedBy: invite.InvitedBy,
+		CreatedAt: invite.CreatedAt,
+	})
+}
+
+func (s *GoChatApp) listInvites(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
 	}
 
-	limitStr := r.URL.Query().Get("limit")
-	if limitStr != "" {
-		limit, err = strconv.Atoi(limitStr)
-		if err != nil {
-			errResp := NewBadRequestError()
-			s.writeJson(w, errResp.StatusCode, errResp)
-			return
-		}
+	room, ok := s.authorizeRoom(w, r, userId, database.RoleAdmin)
+	if !ok {
+		return
 	}
 
-	messages, err := s.db.GetMessages(room.Id, after, before, limit)
+	dbInvites, err := s.db.ListInvites(r.Context(), room.Id)
 	if err != nil {
 		errResp := NewInternalServerError(err)
 		s.writeJson(w, errResp.StatusCode, errResp)
 		return
 	}
 
-	var userMessages []types.Message
+	invites := make([]types.Invite, 0, len(dbInvites))
+	for _, invite := range dbInvites {
+		invites = append(invites, types.Invite{
+			RoomId: room.ExternalId,
+			User: types.User{
+				Id:       invite.AccountId,
+				Username: invite.Username,
+			},
+			InvitedBy: invite.InvitedBy,
+			CreatedAt: invite.CreatedAt,
+		})
+	}
 
-	for _, msg := range messages {
-		msg := types.Message{
-			SeqId:     msg.SeqId,
-			UserId:    msg.UserId,
-			RoomId:    msg.RoomId,
-			Content:   msg.Content,
-			Timestamp: msg.CreatedAt,
-		}
+	s.writeJson(w, http.StatusOK, invites)
+}
 
-		userMessages = append(userMessages, msg)
+func (s *GoChatApp) deleteInvite(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
 	}
 
-	s.writeJson(w, http.StatusOK, userMessages)
+	inviteeId, err := strconv.Atoi(r.PathValue("user_id"))
+	if err != nil {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room, ok := s.authorizeRoom(w, r, userId, database.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	if err := s.db.DeleteInvite(r.Context(), room.Id, inviteeId); err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	s.writeJson(w, http.StatusNoContent, nil)
+}
+
+// authorizeRoom looks up the room identified by the id path parameter and checks
+// that the user has at least the given role in it. If the room doesn't exist or the
+// user doesn't have the role, an error response is written and false is returned.
+func (s *GoChatApp) authorizeRoom(w http.ResponseWriter, r *http.Request, userId int, minRole string) (database.Room, bool) {
+	externalId := r.PathValue("id")
+	if externalId == "" {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return database.Room{}, false
+	}
+
+	room, err := s.db.GetRoomByExternalId(r.Context(), externalId)
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return database.Room{}, false
+	}
+
+	ok, err := s.hasRole(r.Context(), userId, room, minRole)
+	if err != nil {
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return database.Room{}, false
+	}
+	if !ok {
+		errResp := NewForbiddenError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return database.Room{}, false
+	}
+
+	return room, true
+}
+
+// hasRole reports whether the user has at least the given role in the room.
+// The owner of the room has every role, whether or not they are subscribed to it,
+// while other users that are not subscribed to the room have no role.
+func (s *GoChatApp) hasRole(ctx context.Context, userId int, room database.Room, minRole string) (bool, error) {
+	if room.OwnerId == userId {
+		return true, nil
+	}
+
+	role, err := s.db.GetSubscriptionRole(ctx, userId, room.Id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return database.RoleAtLeast(role, minRole), nil
+}
+
+// promoteAdmin makes a subscriber of the room an admin.
+func (s *GoChatApp) promoteAdmin(w http.ResponseWriter, r *http.Request) {
+	var req PromoteAdminRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserId <= 0 {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	s.changeRole(w, r, req.UserId, database.RoleAdmin)
+}
+
+// demoteAdmin makes an admin of the room a regular member.
+func (s *GoChatApp) demoteAdmin(w http.ResponseWriter, r *http.Request) {
+	targetId, err := strconv.Atoi(r.PathValue("user_id"))
+	if err != nil {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	s.changeRole(w, r, targetId, database.RoleMember)
+}
+
+// changeRole sets the role of a subscriber of the room and notifies the room of the change.
+// Only the owner of the room can change roles, and the owner's role can't be changed.
+func (s *GoChatApp) changeRole(w http.ResponseWriter, r *http.Request, targetId int, role string) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room, ok := s.authorizeRoom(w, r, userId, database.RoleOwner)
+	if !ok {
+		return
+	}
+
+	currentRole, err := s.db.GetSubscriptionRole(r.Context(), targetId, room.Id)
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if currentRole == database.RoleOwner || targetId == room.OwnerId {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	target, err := s.db.GetAccountById(r.Context(), targetId)
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	user := types.User{
+		Id:       target.Id,
+		Username: target.Username,
+		Role:     role,
+	}
+
+	if currentRole == role {
+		// nothing to change
+		s.writeJson(w, http.StatusOK, user)
+		return
+	}
+
+	if err := s.db.UpdateSubscriptionRole(r.Context(), targetId, room.Id, role); err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			s.log.Println("update subscription role:", err)
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	// the role is already changed so don't fail the request
+	if err := s.cs.NotifyRoom(r.Context(), room.ExternalId, &server.Notification{
+		RoleChange: &server.RoleChange{
+			RoomId: room.ExternalId,
+			User:   user,
+		},
+	}); err != nil {
+		s.log.Println("notify room of role change:", err)
+	}
+
+	s.writeJson(w, http.StatusOK, user)
+}
+
+// kickUser removes a user from the room by deleting their subscription.
+// Unlike a ban, the user is free to join the room again.
+func (s *GoChatApp) kickUser(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	targetId, err := strconv.Atoi(r.PathValue("user_id"))
+	if err != nil {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room, ok := s.authorizeRoom(w, r, userId, database.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	target, subscribed, ok := s.moderationTarget(w, r, userId, room, targetId)
+	if !ok {
+		return
+	}
+	if !subscribed {
+		errResp := NewNotFoundError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if err := s.db.DeleteSubscription(r.Context(), target.Id, room.Id); err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			s.log.Println("kick user:", err)
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	// the subscription is already deleted so don't fail the request
+	if err := s.cs.RemoveSubscriber(r.Context(), room.ExternalId, target, false); err != nil {
+		s.log.Println("remove kicked user from chat server:", err)
+	}
+
+	s.writeJson(w, http.StatusNoContent, nil)
+}
+
+// createBan bans a user from the room, removing them from it if they are subscribed.
+func (s *GoChatApp) createBan(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	var banReq BanRequest
+	if err := json.NewDecoder(r.Body).Decode(&banReq); err != nil || banReq.UserId <= 0 {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if banReq.ExpiresAt != nil && !banReq.ExpiresAt.After(time.Now()) {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room, ok := s.authorizeRoom(w, r, userId, database.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	target, subscribed, ok := s.moderationTarget(w, r, userId, room, banReq.UserId)
+	if !ok {
+		return
+	}
+
+	ban, err := s.db.CreateBan(r.Context(), database.CreateBanParams{
+		RoomId:    room.Id,
+		AccountId: target.Id,
+		BannedBy:  userId,
+		Reason:    banReq.Reason,
+		ExpiresAt: banReq.ExpiresAt,
+	})
+	if err != nil {
+		s.log.Println("create ban:", err)
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if subscribed {
+		// the ban is already created so don't fail the request
+		if err := s.cs.RemoveSubscriber(r.Context(), room.ExternalId, target, true); err != nil {
+			s.log.Println("remove banned user from chat server:", err)
+		}
+	}
+
+	s.writeJson(w, http.StatusCreated, types.Ban{
+		RoomId:    room.ExternalId,
+		User:      target,
+		BannedBy:  ban.BannedBy,
+		Reason:    ban.Reason,
+		ExpiresAt: ban.ExpiresAt,
+		CreatedAt: ban.CreatedAt,
+	})
+}
+
+func (s *GoChatApp) listBans(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room, ok := s.authorizeRoom(w, r, userId, database.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	dbBans, err := s.db.ListBans(r.Context(), room.Id)
+	if err != nil {
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	bans := make([]types.Ban, 0, len(dbBans))
+	for _, ban := range dbBans {
+		bans = append(bans, types.Ban{
+			RoomId: room.ExternalId,
+			User: types.User{
+				Id:       ban.AccountId,
+				Username: ban.Username,
+			},
+			BannedBy:  ban.BannedBy,
+			Reason:    ban.Reason,
+			ExpiresAt: ban.ExpiresAt,
+			CreatedAt: ban.CreatedAt,
+		})
+	}
+
+	s.writeJson(w, http.StatusOK, bans)
+}
+
+func (s *GoChatApp) deleteBan(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	targetId, err := strconv.Atoi(r.PathValue("user_id"))
+	if err != nil {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room, ok := s.authorizeRoom(w, r, userId, database.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	if err := s.db.DeleteBan(r.Context(), room.Id, targetId); err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	s.writeJson(w, http.StatusNoContent, nil)
+}
+
+// moderationTarget looks up a user that is about to be kicked or banned from the room.
+// The owner can moderate anyone but themselves, while admins can only moderate members.
+// It reports whether the target is subscribed to the room. If the target can't be
+// moderated by the user, an error response is written and false is returned.
+func (s *GoChatApp) moderationTarget(w http.ResponseWriter, r *http.Request, userId int, room database.Room, targetId int) (types.User, bool, bool) {
+	if targetId == userId {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return types.User{}, false, false
+	}
+
+	target, err := s.db.GetAccountById(r.Context(), targetId)
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return types.User{}, false, false
+	}
+
+	subscribed := true
+	role, err := s.db.GetSubscriptionRole(r.Context(), targetId, room.Id)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			errResp := NewInternalServerError(err)
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return types.User{}, false, false
+		}
+		subscribed = false
+	}
+
+	if targetId == room.OwnerId {
+		role = database.RoleOwner
+	}
+
+	allowed := true
+	switch role {
+	case database.RoleOwner:
+		allowed = false
+	case database.RoleAdmin:
+		allowed, err = s.hasRole(r.Context(), userId, room, database.RoleOwner)
+		if err != nil {
+			errResp := NewInternalServerError(err)
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return types.User{}, false, false
+		}
+	}
+	if !allowed {
+		errResp := NewForbiddenError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return types.User{}, false, false
+	}
+
+	return types.User{
+		Id:       target.Id,
+		Username: target.Username,
+	}, subscribed, true
+}
+
+func (s *GoChatApp) getUsersSubscriptions(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	dbSubs, err := s.db.ListSubscriptions(r.Context(), userId)
+	if err != nil {
+		s.log.Println("list subscriptions:", err)
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	var subs []types.Subscription
+	for _, dbSub := range dbSubs {
+		subs = append(subs, types.Subscription{
+			Id:            dbSub.Id,
+			LastReadSeqId: dbSub.LastReadSeqId,
+			UnreadCount:   dbSub.UnreadCount,
+			MentionCount:  dbSub.MentionCount,
+			Role:          dbSub.Role,
+			Room: types.Room{
+				Id:          dbSub.Room.Id,
+				ExternalId:  dbSub.Room.ExternalId,
+				Name:        dbSub.Room.Name,
+				Description: dbSub.Room.Description,
+				SeqId:       dbSub.Room.SeqId,
+				Kind:        dbSub.Room.Kind,
+				CreatedAt:   dbSub.Room.CreatedAt,
+				UpdatedAt:   dbSub.Room.UpdatedAt,
+			},
+			CreatedAt: dbSub.CreatedAt,
+			UpdatedAt: dbSub.UpdatedAt,
+		})
+	}
+
+	s.writeJson(w, http.StatusOK, subs)
+}
+
+func (s *GoChatApp) getMessages(w http.ResponseWriter, r *http.Request) {
+	externalId := r.URL.Query().Get("room_id")
+	if externalId == "" {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room, err := s.db.GetRoomByExternalId(r.Context(), externalId)
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if !s.canReadRoom(r, room) {
+		errResp := NewNotFoundError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	var before, after, limit int
+
+	beforeStr := r.URL.Query().Get("before")
+	if beforeStr != "" {
+		before, err = strconv.Atoi(beforeStr)
+		if err != nil {
+			errResp := NewBadRequestError()
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return
+		}
+	}
+
+	afterStr := r.URL.Query().Get("after")
+	if afterStr != "" {
+		after, err = strconv.Atoi(afterStr)
+		if err != nil {
+			errResp := NewBadRequestError()
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return
+		}
+	}
+
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil {
+			errResp := NewBadRequestError()
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return
+		}
+	}
+
+	messages, err := s.db.GetMessages(r.Context(), room.Id, after, before, limit)
+	if err != nil {
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	var userMessages []types.Message
+
+	for _, msg := range messages {
+		userMessages = append(userMessages, types.NewMessage(msg))
+	}
+
+	s.writeJson(w, http.StatusOK, userMessages)
+}
+
+// searchMessages performs a full-text search over the messages of the rooms the user
+// is subscribed to, optionally restricted to a single room with the room_id query parameter.
+// Results are ordered from newest to oldest and paginated with the cursor query parameter.
+func (s *GoChatApp) searchMessages(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	params := database.SearchMessagesParams{
+		AccountId: userId,
+		Query:     strings.TrimSpace(r.URL.Query().Get("q")),
+		Limit:     defaultSearchLimit,
+	}
+	if params.Query == "" || len(params.Query) > maxSearchQueryLength {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	var err error
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		params.Limit, err = strconv.Atoi(limitStr)
+		if err != nil || params.Limit <= 0 || params.Limit > maxSearchLimit {
+			errResp := NewBadRequestError()
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return
+		}
+	}
+
+	if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+		params.Before, err = strconv.Atoi(cursorStr)
+		if err != nil || params.Before <= 0 {
+			errResp := NewBadRequestError()
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return
+		}
+	}
+
+	if externalId := r.URL.Query().Get("room_id"); externalId != "" {
+		room, err := s.db.GetRoomByExternalId(r.Context(), externalId)
+		if err != nil {
+			var errResp *ApiError
+			if errors.Is(err, sql.ErrNoRows) {
+				errResp = NewNotFoundError()
+			} else {
+				errResp = NewInternalServerError(err)
+			}
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return
+		}
+		params.RoomId = room.Id
+	}
+
+	messages, err := s.db.SearchMessages(r.Context(), params)
+	if err != nil {
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	results := types.SearchResults{
+		Messages: make([]types.Message, 0, len(messages)),
+	}
+	for _, msg := range messages {
+		results.Messages = append(results.Messages, types.NewMessage(msg))
+	}
+	if len(messages) == params.Limit {
+		results.NextCursor = messages[len(messages)-1].Id
+	}
+
+	s.writeJson(w, http.StatusOK, results)
+}
+
+func (s *GoChatApp) listMentions(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	params := database.ListMentionsParams{
+		AccountId: userId,
+		Limit:     defaultMentionsLimit,
+	}
+
+	var err error
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		params.Limit, err = strconv.Atoi(limitStr)
+		if err != nil || params.Limit <= 0 || params.Limit > maxMentionsLimit {
+			errResp := NewBadRequestError()
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return
+		}
+	}
+
+	if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+		params.Before, err = strconv.Atoi(cursorStr)
+		if err != nil || params.Before <= 0 {
+			errResp := NewBadRequestError()
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return
+		}
+	}
+
+	mentions, err := s.db.ListMentions(r.Context(), params)
+	if err != nil {
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	results := types.Mentions{
+		Mentions: make([]types.Mention, 0, len(mentions)),
+	}
+	for _, mention := range mentions {
+		results.Mentions = append(results.Mentions, types.Mention{
+			Id:        mention.Id,
+			Message:   types.NewMessage(mention.Message),
+			CreatedAt: mention.CreatedAt,
+		})
+	}
+	if len(mentions) == params.Limit {
+		results.NextCursor = mentions[len(mentions)-1].Id
+	}
+
+	s.writeJson(w, http.StatusOK, results)
+}
+
+func (s *GoChatApp) getThread(w http.ResponseWriter, r *http.Request) {
+	externalId := r.URL.Query().Get("room_id")
+	seqId, err := strconv.Atoi(r.URL.Query().Get("seq_id"))
+	if externalId == "" || err != nil {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room, err := s.db.GetRoomByExternalId(r.Context(), externalId)
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if !s.canReadRoom(r, room) {
+		errResp := NewNotFoundError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	parent, err := s.db.GetMessage(r.Context(), room.Id, seqId)
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	replies, err := s.db.GetThread(r.Context(), room.Id, parent.SeqId)
+	if err != nil {
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	thread := types.Thread{
+		Parent:  types.NewMessage(parent),
+		Replies: make([]types.Message, 0, len(replies)),
+	}
+	for _, reply := range replies {
+		thread.Replies = append(thread.Replies, types.NewMessage(reply))
+	}
+
+	s.writeJson(w, http.StatusOK, thread)
+}
+
+// canReadRoom reports whether the user making the request can read the room's messages.
+// Private and direct message rooms can only be read by their members.
+func (s *GoChatApp) canReadRoom(r *http.Request, room database.Room) bool {
+	if room.Kind != database.RoomKindPrivate && room.Kind != database.RoomKindDirect {
+		return true
+	}
+
+	userId, ok := UserId(r.Context())
+	return ok && s.db.SubscriptionExists(r.Context(), userId, room.Id)
+}
+
+// uploadAttachment stores a file uploaded to a room as the "file" field of a multipart form.
+// The file can then be attached to a message published by the uploader.
+func (s *GoChatApp) uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room, ok := s.authorizeRoom(w, r, userId, database.RoleMember)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+maxMultipartOverhead)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	// stream the file to the blob store rather than buffering the form
+	var part io.ReadCloser
+	var filename string
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			var errResp *ApiError
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				errResp = NewRequestEntityTooLargeError()
+			} else {
+				errResp = NewBadRequestError()
+			}
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return
+		}
+		if p.FormName() == "file" {
+			part, filename = p, p.FileName()
+			break
+		}
+		p.Close()
+	}
+	defer part.Close()
+
+	filename = filepath.Base(strings.TrimSpace(filename))
+	if filename == "" || filename == "." || filename == string(filepath.Separator) ||
+		len(filename) > maxFilenameLength || !utf8.ValidString(filename) {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	// the content type is detected from the file rather than trusted from the client
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		var errResp *ApiError
+		if errors.Is(err, io.EOF) {
+			errResp = NewBadRequestError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+	head = head[:n]
+	contentType := http.DetectContentType(head)
+
+	externalId, err := s.generateShortId()
+	if err != nil {
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	size, err := s.blobs.Put(r.Context(), externalId, io.LimitReader(io.MultiReader(bytes.NewReader(head), part), maxAttachmentSize+1))
+	if err != nil || size > maxAttachmentSize {
+		if err := s.blobs.Delete(r.Context(), externalId); err != nil {
+			s.log.Println("delete attachment blob:", err)
+		}
+
+		var errResp *ApiError
+		var maxBytesErr *http.MaxBytesError
+		if err == nil || errors.As(err, &maxBytesErr) {
+			errResp = NewRequestEntityTooLargeError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	attachment, err := s.db.CreateAttachment(r.Context(), database.CreateAttachmentParams{
+		ExternalId:  externalId,
+		RoomId:      room.Id,
+		AccountId:   userId,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+	})
+	if err != nil {
+		if err := s.blobs.Delete(r.Context(), externalId); err != nil {
+			s.log.Println("delete attachment blob:", err)
+		}
+
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	s.writeJson(w, http.StatusCreated, types.NewAttachment(attachment))
+}
+
+// getAttachment downloads an attachment. Only subscribers of the room the file was
+// uploaded to can download it, and until it is attached to a message only the uploader can.
+func (s *GoChatApp) getAttachment(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	attachment, err := s.db.GetAttachment(r.Context(), r.PathValue("id"))
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if attachment.SeqId == 0 && attachment.AccountId != userId {
+		errResp := NewNotFoundError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if !s.db.SubscriptionExists(r.Context(), userId, attachment.RoomId) {
+		errResp := NewForbiddenError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	content, err := s.blobs.Get(r.Context(), attachment.ExternalId)
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, blob.ErrNotFound) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+	defer content.Close()
+
+	disposition := "attachment"
+	if slices.Contains(inlineContentTypes, attachment.ContentType) {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, content); err != nil {
+		s.log.Println("write attachment:", err)
+	}
+}
+
+func (s *GoChatApp) deleteMessage(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	externalId := r.URL.Query().Get("room_id")
+	seqId, err := strconv.Atoi(r.URL.Query().Get("seq_id"))
+	if externalId == "" || err != nil {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room, err := s.db.GetRoomByExternalId(r.Context(), externalId)
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	msg, err := s.db.GetMessage(r.Context(), room.Id, seqId)
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if msg.Deleted {
+		errResp := NewNotFoundError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	// Only the author of the message or the owner and admins of the room may delete it
+	if msg.UserId != userId {
+		isAdmin, err := s.hasRole(r.Context(), userId, room, database.RoleAdmin)
+		if err != nil {
+			errResp := NewInternalServerError(err)
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return
+		}
+		if !isAdmin {
+			errResp := NewForbiddenError()
+			s.writeJson(w, errResp.StatusCode, errResp)
+			return
+		}
+	}
+
+	if err := s.db.DeleteMessage(r.Context(), room.Id, seqId); err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			s.log.Println("delete message:", err)
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	// notify connected clients of the deletion, the message is already deleted so don't fail the request
+	if err := s.cs.NotifyRoom(r.Context(), room.ExternalId, &server.Notification{
+		MessageDeleted: &server.MessageDeleted{
+			RoomId: room.ExternalId,
+			SeqId:  seqId,
+		},
+	}); err != nil {
+		s.log.Println("notify room of message deletion:", err)
+	}
+
+	s.writeJson(w, http.StatusNoContent, nil)
+}
+
+// listPins lists the messages pinned to a room, most recently pinned first.
+func (s *GoChatApp) listPins(w http.ResponseWriter, r *http.Request) {
+	room, err := s.db.GetRoomByExternalId(r.Context(), r.PathValue("id"))
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if !s.canReadRoom(r, room) {
+		errResp := NewNotFoundError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	dbPins, err := s.db.ListPins(r.Context(), room.Id)
+	if err != nil {
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	pins := make([]types.Pin, 0, len(dbPins))
+	for _, pin := range dbPins {
+		pins = append(pins, types.NewPin(pin))
+	}
+
+	s.writeJson(w, http.StatusOK, pins)
+}
+
+// pinMessage pins a message to a room. Only the owner and admins of the room may pin messages.
+func (s *GoChatApp) pinMessage(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	var pinReq PinRequest
+	if err := json.NewDecoder(r.Body).Decode(&pinReq); err != nil || pinReq.SeqId <= 0 {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room, ok := s.authorizeRoom(w, r, userId, database.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	msg, err := s.db.GetMessage(r.Context(), room.Id, pinReq.SeqId)
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if msg.Deleted {
+		errResp := NewNotFoundError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	dbPin, err := s.db.PinMessage(r.Context(), database.PinParams{
+		RoomId:   room.Id,
+		SeqId:    pinReq.SeqId,
+		PinnedBy: userId,
+	})
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewConflictError()
+		} else {
+			s.log.Println("pin message:", err)
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+	dbPin.Message = msg
+
+	pin := types.NewPin(dbPin)
+
+	// the message is already pinned so don't fail the request
+	if err := s.cs.NotifyRoom(r.Context(), room.ExternalId, &server.Notification{
+		PinChange: &server.PinChange{
+			RoomId: room.ExternalId,
+			SeqId:  pinReq.SeqId,
+			UserId: userId,
+			Pin:    &pin,
+		},
+	}); err != nil {
+		s.log.Println("notify room of pinned message:", err)
+	}
+
+	s.writeJson(w, http.StatusCreated, pin)
+}
+
+// unpinMessage unpins a message from a room. Only the owner and admins of the room may unpin messages.
+func (s *GoChatApp) unpinMessage(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserId(r.Context())
+	if !ok {
+		errResp := NewUnauthorizedError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	seqId, err := strconv.Atoi(r.PathValue("seq_id"))
+	if err != nil {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room, ok := s.authorizeRoom(w, r, userId, database.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	if err := s.db.UnpinMessage(r.Context(), room.Id, seqId); err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			s.log.Println("unpin message:", err)
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	// the message is already unpinned so don't fail the request
+	if err := s.cs.NotifyRoom(r.Context(), room.ExternalId, &server.Notification{
+		PinChange: &server.PinChange{
+			RoomId:   room.ExternalId,
+			SeqId:    seqId,
+			UserId:   userId,
+			Unpinned: true,
+		},
+	}); err != nil {
+		s.log.Println("notify room of unpinned message:", err)
+	}
+
+	s.writeJson(w, http.StatusNoContent, nil)
+}
+
+func (s *GoChatApp) getMessageRevisions(w http.ResponseWriter, r *http.Request) {
+	externalId := r.URL.Query().Get("room_id")
+	seqId, err := strconv.Atoi(r.URL.Query().Get("seq_id"))
+	if externalId == "" || err != nil {
+		errResp := NewBadRequestError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	room, err := s.db.GetRoomByExternalId(r.Context(), externalId)
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	if !s.canReadRoom(r, room) {
+		errResp := NewNotFoundError()
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	msg, err := s.db.GetMessage(r.Context(), room.Id, seqId)
+	if err != nil {
+		var errResp *ApiError
+		if errors.Is(err, sql.ErrNoRows) {
+			errResp = NewNotFoundError()
+		} else {
+			errResp = NewInternalServerError(err)
+		}
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	dbRevisions, err := s.db.GetMessageRevisions(r.Context(), msg.Id)
+	if err != nil {
+		errResp := NewInternalServerError(err)
+		s.writeJson(w, errResp.StatusCode, errResp)
+		return
+	}
+
+	revisions := make([]types.MessageRevision, 0, len(dbRevisions))
+	for _, rev := range dbRevisions {
+		revisions = append(revisions, types.MessageRevision{
+			Content:   rev.Content,
+			Timestamp: rev.CreatedAt,
+		})
+	}
+
+	s.writeJson(w, http.StatusOK, revisions)
 }
 
 func (s *GoChatApp) serveWs(w http.ResponseWriter, r *http.Request) {
@@ -536,7 +2081,7 @@ func (s *GoChatApp) serveWs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := s.db.GetAccountById(id)
+	user, err := s.db.GetAccountById(r.Context(), id)
 	if err != nil {
 		var errResp *ApiError
 		if errors.Is(err, sql.ErrNoRows) {
@@ -574,7 +2119,7 @@ func (s *GoChatApp) serveWs(w http.ResponseWriter, r *http.Request) {
 		UpdatedAt:    user.UpdatedAt,
 	}, conn, s.cs, s.log, s.stats)
 
-	s.cs.RegisterClient(client)
+	s.cs.RegisterClient(r.Context(), client)
 	go client.Write()
 	go client.Read()
 }
@@ -2,11 +2,13 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
@@ -15,6 +17,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/npezzotti/go-chatroom/internal/blob"
 	"github.com/npezzotti/go-chatroom/internal/config"
 	"github.com/npezzotti/go-chatroom/internal/database"
 	"github.com/npezzotti/go-chatroom/internal/server"
@@ -56,11 +59,12 @@ func Test_healthCheck(t *testing.T) {
 
 	for _, tc := range tcases {
 		t.Run(tc.name, func(t *testing.T) {
-			mockRepo.On("Ping").Return(tc.mockErr).Once()
-			app := NewGoChatApp(http.NewServeMux(), nil, nil, mockRepo, nil, &config.Config{})
+			app := NewGoChatApp(http.NewServeMux(), nil, nil, mockRepo, nil, nil, &config.Config{})
 			rr := httptest.NewRecorder()
 			buf := &bytes.Buffer{}
 			req := httptest.NewRequest(http.MethodGet, "/healthz", buf)
+			// the query is canceled with the request
+			mockRepo.On("Ping", req.Context()).Return(tc.mockErr).Once()
 			app.healthCheck(rr, req)
 
 			if tc.mockErr != nil {
@@ -169,7 +173,7 @@ func TestCreateAccountHandler(t *testing.T) {
 						Username:     regReq.Username,
 						EmailAddress: regReq.Email,
 					}
-					mockRepo.On("CreateAccount", mock.MatchedBy(func(req database.CreateAccountParams) bool {
+					mockRepo.On("CreateAccount", mock.Anything, mock.MatchedBy(func(req database.CreateAccountParams) bool {
 						return req.Username == params.Username &&
 							req.EmailAddress == params.EmailAddress &&
 							verifyPassword(req.PasswordHash, regReq.Password)
@@ -179,7 +183,7 @@ func TestCreateAccountHandler(t *testing.T) {
 				}
 			}
 
-			app := NewGoChatApp(http.NewServeMux(), testutil.TestLogger(t), nil, mockRepo, nil, &config.Config{})
+			app := NewGoChatApp(http.NewServeMux(), testutil.TestLogger(t), nil, mockRepo, nil, nil, &config.Config{})
 
 			var req *http.Request
 			switch v := tc.body.(type) {
@@ -256,10 +260,10 @@ func TestAccountHandler_Get(t *testing.T) {
 			defer mockRepo.AssertExpectations(t)
 
 			if tc.mockUser != (database.User{}) || tc.mockErr != nil {
-				mockRepo.On("GetAccountById", 1).Return(tc.mockUser, tc.mockErr).Once()
+				mockRepo.On("GetAccountById", mock.Anything, 1).Return(tc.mockUser, tc.mockErr).Once()
 			}
 
-			app := NewGoChatApp(http.NewServeMux(), nil, nil, mockRepo, nil, &config.Config{})
+			app := NewGoChatApp(http.NewServeMux(), nil, nil, mockRepo, nil, nil, &config.Config{})
 			req := httptest.NewRequest(http.MethodGet, "/api/account", nil)
 
 			if tc.userId > 0 {
@@ -427,20 +431,20 @@ func TestAccountHandler_Put(t *testing.T) {
 			defer mockRepo.AssertExpectations(t)
 
 			if tc.userId > 0 && (tc.mockCurUser != (database.User{}) || tc.mockGetAccountByIdErr != nil) {
-				mockRepo.On("GetAccountById", tc.userId).Return(tc.mockCurUser, tc.mockGetAccountByIdErr).Once()
+				mockRepo.On("GetAccountById", mock.Anything, tc.userId).Return(tc.mockCurUser, tc.mockGetAccountByIdErr).Once()
 			}
 
 			if tc.mockExpectedUser != (database.User{}) || tc.mockUpdateAccountErr != nil {
 				updateReq, ok := tc.body.(UpdateAccountRequest)
 				assert.Truef(t, ok, "expected body to be of type UpdateAccountRequest, got %T", tc.body)
-				mockRepo.On("UpdateAccount", mock.MatchedBy(func(params database.UpdateAccountParams) bool {
+				mockRepo.On("UpdateAccount", mock.Anything, mock.MatchedBy(func(params database.UpdateAccountParams) bool {
 					return params.UserId == tc.userId &&
 						params.Username == updateReq.Username &&
 						verifyPassword(params.PasswordHash, updateReq.Password)
 				})).Return(tc.mockExpectedUser, tc.mockUpdateAccountErr).Once()
 			}
 
-			app := NewGoChatApp(http.NewServeMux(), nil, nil, mockRepo, nil, &config.Config{})
+			app := NewGoChatApp(http.NewServeMux(), nil, nil, mockRepo, nil, nil, &config.Config{})
 			rr := httptest.NewRecorder()
 
 			var req *http.Request
@@ -544,10 +548,10 @@ func Test_session(t *testing.T) {
 			// Only set up the mock if a user ID is provided
 			// and there is either a valid mock user or an error expected
 			if tc.userId > 0 && (tc.mockUser != (database.User{}) || tc.mockErr != nil) {
-				mockRepo.On("GetAccountById", tc.userId).Return(tc.mockUser, tc.mockErr).Once()
+				mockRepo.On("GetAccountById", mock.Anything, tc.userId).Return(tc.mockUser, tc.mockErr).Once()
 			}
 
-			app := NewGoChatApp(http.NewServeMux(), nil, nil, mockRepo, nil, &config.Config{})
+			app := NewGoChatApp(http.NewServeMux(), nil, nil, mockRepo, nil, nil, &config.Config{})
 
 			req := httptest.NewRequest(http.MethodGet, "/api/session", nil)
 			if tc.userId > 0 {
@@ -670,10 +674,10 @@ func Test_login(t *testing.T) {
 				req, ok := tc.body.(LoginRequest)
 				assert.Truef(t, ok, "expected body to be of type LoginRequest, got %T", tc.body)
 				// Mock the GetAccountByEmail method to return the mock user or error
-				mockRepo.On("GetAccountByEmail", req.Email).Return(tc.mockUser, tc.mockErr)
+				mockRepo.On("GetAccountByEmail", mock.Anything, req.Email).Return(tc.mockUser, tc.mockErr)
 			}
 
-			app := NewGoChatApp(http.NewServeMux(), nil, nil, mockRepo, nil, &config.Config{
+			app := NewGoChatApp(http.NewServeMux(), nil, nil, mockRepo, nil, nil, &config.Config{
 				SigningKey: []byte("test-signing-key"),
 			})
 
@@ -720,7 +724,7 @@ func Test_login(t *testing.T) {
 }
 
 func Test_logout(t *testing.T) {
-	app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, &database.MockGoChatRepository{}, nil, &config.Config{})
+	app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, &database.MockGoChatRepository{}, nil, nil, &config.Config{})
 
 	req := httptest.NewRequest(http.MethodPost, "/api/logout", nil)
 	req.AddCookie(createJwtCookie("testtoken", defaultJwtExpiration))
@@ -736,6 +740,170 @@ func Test_logout(t *testing.T) {
 	assert.Equal(t, "", token.Value, "expected token value to be empty")
 }
 
+func Test_listRooms(t *testing.T) {
+	createdAt := time.Date(2025, time.June, 28, 11, 17, 54, 0, time.UTC)
+	mockRooms := []database.Room{
+		{
+			Id:              1,
+			ExternalId:      "EoGKUXPHgz",
+			Name:            "Test Room",
+			Description:     "This is a test room",
+			SeqId:           4,
+			OwnerId:         1,
+			Kind:            database.RoomKindPublic,
+			SubscriberCount: 3,
+			CreatedAt:       createdAt,
+			UpdatedAt:       createdAt,
+		},
+		{
+			Id:              2,
+			ExternalId:      "hZ8kLmN2pQ",
+			Name:            "Hosted Room",
+			Description:     "This room is hosted by another node",
+			SeqId:           7,
+			OwnerId:         2,
+			Kind:            database.RoomKindPublic,
+			SubscriberCount: 2,
+			Leased:          true,
+			CreatedAt:       createdAt,
+			UpdatedAt:       createdAt,
+		},
+	}
+
+	tcases := []struct {
+		name         string
+		query        string
+		expectParams *database.ListRoomsParams
+		mockErr      error
+		expected     []types.Room
+		expectedErr  *ApiError
+	}{
+		{
+			name:         "lists rooms with default limit",
+			expectParams: &database.ListRoomsParams{Limit: defaultRoomsLimit},
+			expected: []types.Room{
+				{
+					Id:              1,
+					ExternalId:      "EoGKUXPHgz",
+					Name:            "Test Room",
+					Description:     "This is a test room",
+					SeqId:           4,
+					OwnerId:         1,
+					Kind:            database.RoomKindPublic,
+					SubscriberCount: 3,
+					CreatedAt:       createdAt,
+					UpdatedAt:       createdAt,
+				},
+				{
+					Id:              2,
+					ExternalId:      "hZ8kLmN2pQ",
+					Name:            "Hosted Room",
+					Description:     "This room is hosted by another node",
+					SeqId:           7,
+					OwnerId:         2,
+					Kind:            database.RoomKindPublic,
+					SubscriberCount: 2,
+					Loaded:          true,
+					CreatedAt:       createdAt,
+					UpdatedAt:       createdAt,
+				},
+			},
+		},
+		{
+			name:         "searches rooms with pagination",
+			query:        "?q=+test+&limit=10&offset=20",
+			expectParams: &database.ListRoomsParams{Query: "test", Limit: 10, Offset: 20},
+			expected: []types.Room{
+				{
+					Id:              1,
+					ExternalId:      "EoGKUXPHgz",
+					Name:            "Test Room",
+					Description:     "This is a test room",
+					SeqId:           4,
+					OwnerId:         1,
+					Kind:            database.RoomKindPublic,
+					SubscriberCount: 3,
+					CreatedAt:       createdAt,
+					UpdatedAt:       createdAt,
+				},
+				{
+					Id:              2,
+					ExternalId:      "hZ8kLmN2pQ",
+					Name:            "Hosted Room",
+					Description:     "This room is hosted by another node",
+					SeqId:           7,
+					OwnerId:         2,
+					Kind:            database.RoomKindPublic,
+					SubscriberCount: 2,
+					Loaded:          true,
+					CreatedAt:       createdAt,
+					UpdatedAt:       createdAt,
+				},
+			},
+		},
+		{
+			name:        "fails with invalid limit",
+			query:       "?limit=abc",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with limit above maximum",
+			query:       fmt.Sprintf("?limit=%d", maxRoomsLimit+1),
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with negative offset",
+			query:       "?offset=-1",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:         "fails with db error",
+			expectParams: &database.ListRoomsParams{Limit: defaultRoomsLimit},
+			mockErr:      errors.New("db error"),
+			expectedErr:  NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.expectParams != nil {
+				mockRepo.On("ListRooms", mock.Anything, *tc.expectParams).Return(mockRooms, tc.mockErr).Once()
+			}
+
+			su := &stats.MockStatsUpdater{}
+			su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
+			cs, err := server.NewChatServer(log.Default(), mockRepo, su, nil)
+			assert.NoError(t, err, "failed to create chat server")
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/rooms"+tc.query, nil)
+			req = req.WithContext(WithUserId(req.Context(), 1))
+
+			rr := httptest.NewRecorder()
+			app.listRooms(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			var rooms []types.Room
+			err = json.NewDecoder(rr.Body).Decode(&rooms)
+			assert.NoError(t, err, "failed to decode response")
+			assert.Equal(t, tc.expected, rooms)
+		})
+	}
+}
+
 func Test_createRoom(t *testing.T) {
 	mockRoom := database.Room{
 		Id:          1,
@@ -767,6 +935,22 @@ func Test_createRoom(t *testing.T) {
 			mockErr:     nil,
 			expectedErr: nil,
 		},
+		{
+			name: "successfully creates a private room",
+			body: CreateRoomRequest{
+				Name:        "Test Room",
+				Description: "This is a test room",
+				Private:     true,
+			},
+			userId: 1,
+			mockRoom: func() database.Room {
+				room := mockRoom
+				room.Kind = database.RoomKindPrivate
+				return room
+			}(),
+			mockErr:     nil,
+			expectedErr: nil,
+		},
 		{
 			name:        "fails with invalid json body",
 			body:        "invalid json",
@@ -838,15 +1022,20 @@ func Test_createRoom(t *testing.T) {
 				if !ok {
 					t.Fatalf("expected body to be of type CreateRoomRequest, got %T", tc.body)
 				}
-				mockRepo.On("CreateRoom", mock.MatchedBy(func(params database.CreateRoomParams) bool {
+				expectedKind := database.RoomKindPublic
+				if createRoomReq.Private {
+					expectedKind = database.RoomKindPrivate
+				}
+				mockRepo.On("CreateRoom", mock.Anything, mock.MatchedBy(func(params database.CreateRoomParams) bool {
 					return params.Name == createRoomReq.Name &&
+						params.Kind == expectedKind &&
 						params.Description == createRoomReq.Description &&
 						params.OwnerId == tc.userId &&
 						params.ExternalId == tc.mockRoom.ExternalId // shortid is typically up to 9 characters
 				})).Return(tc.mockRoom, tc.mockErr).Once()
 			}
 
-			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, &config.Config{})
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, nil, &config.Config{})
 
 			// Only override generateShortId if a shortIdErr is expected or a mockRoom is provided
 			app.generateShortId = func() (string, error) {
@@ -886,12 +1075,274 @@ func Test_createRoom(t *testing.T) {
 				assert.Equal(t, tc.mockRoom.ExternalId, room.ExternalId, "expected room external id to match")
 				assert.Equal(t, tc.mockRoom.Description, room.Description, "expected room description to match")
 				assert.Equal(t, tc.mockRoom.OwnerId, room.OwnerId, "expected room owner id to match requester ID")
+				assert.Equal(t, tc.mockRoom.Kind, room.Kind, "expected room kind to match")
 				assert.WithinDuration(t, time.Now().UTC(), room.CreatedAt, time.Second, "expected room created at to be close to now")
 				assert.WithinDuration(t, time.Now().UTC(), room.UpdatedAt, time.Second, "expected room updated at to be close to now")
 			}
 		})
 	}
 }
+
+// expectNotifySubscribers expects the subscribers of a room to be loaded to notify them,
+// since the room isn't loaded by the chat server.
+func expectNotifySubscribers(db *database.MockGoChatRepository, room database.Room) {
+	db.On("GetRoomByExternalId", mock.Anything, room.ExternalId).Return(room, nil).Once()
+	db.On("GetSubscribersByRoomId", mock.Anything, room.Id).Return([]database.User{{Id: room.OwnerId}}, nil).Once()
+}
+
+func Test_updateRoom(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz", Name: "Test Room", Description: "This is a test room", OwnerId: 1}
+	updatedAt := time.Date(2025, time.June, 28, 11, 17, 54, 0, time.UTC)
+	strPtr := func(s string) *string { return &s }
+
+	tcases := []struct {
+		name         string
+		body         any
+		userRole     string
+		expectRoom   bool
+		expectParams database.UpdateRoomParams
+		mockErr      error
+		expectedErr  *ApiError
+	}{
+		{
+			name:         "renames room",
+			body:         UpdateRoomRequest{Name: strPtr("  Renamed Room ")},
+			userRole:     database.RoleOwner,
+			expectRoom:   true,
+			expectParams: database.UpdateRoomParams{Id: mockRoom.Id, Name: "Renamed Room", Description: mockRoom.Description},
+		},
+		{
+			name:         "admin changes description",
+			body:         UpdateRoomRequest{Description: strPtr("A new description")},
+			userRole:     database.RoleAdmin,
+			expectRoom:   true,
+			expectParams: database.UpdateRoomParams{Id: mockRoom.Id, Name: mockRoom.Name, Description: "A new description"},
+		},
+		{
+			name:        "fails with invalid body",
+			body:        "invalid",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with no fields",
+			body:        UpdateRoomRequest{},
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with empty name",
+			body:        UpdateRoomRequest{Name: strPtr("   ")},
+			userRole:    database.RoleOwner,
+			expectRoom:  true,
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails when user is a member",
+			body:        UpdateRoomRequest{Name: strPtr("Renamed Room")},
+			userRole:    database.RoleMember,
+			expectRoom:  true,
+			expectedErr: NewForbiddenError(),
+		},
+		{
+			name:         "fails with db error",
+			body:         UpdateRoomRequest{Name: strPtr("Renamed Room")},
+			userRole:     database.RoleOwner,
+			expectRoom:   true,
+			expectParams: database.UpdateRoomParams{Id: mockRoom.Id, Name: "Renamed Room", Description: mockRoom.Description},
+			mockErr:      errors.New("db error"),
+			expectedErr:  NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.expectRoom {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, mockRoom.ExternalId).Return(mockRoom, nil).Once()
+				mockRepo.On("GetSubscriptionRole", mock.Anything, 2, mockRoom.Id).Return(tc.userRole, nil).Once()
+			}
+			if tc.expectParams.Id != 0 {
+				mockRepo.On("UpdateRoom", mock.Anything, tc.expectParams).Return(database.Room{
+					Id:          mockRoom.Id,
+					ExternalId:  mockRoom.ExternalId,
+					Name:        tc.expectParams.Name,
+					Description: tc.expectParams.Description,
+					OwnerId:     mockRoom.OwnerId,
+					UpdatedAt:   updatedAt,
+				}, tc.mockErr).Once()
+			}
+			if tc.expectParams.Id != 0 && tc.mockErr == nil {
+				expectNotifySubscribers(mockRepo, mockRoom)
+			}
+
+			su := &stats.MockStatsUpdater{}
+			su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
+			cs, err := server.NewChatServer(log.Default(), mockRepo, su, nil)
+			assert.NoError(t, err, "failed to create chat server")
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, nil, &config.Config{})
+
+			body, err := json.Marshal(tc.body)
+			assert.NoError(t, err, "failed to marshal request body")
+
+			req := httptest.NewRequest(http.MethodPatch, "/api/rooms/"+mockRoom.ExternalId, bytes.NewReader(body))
+			req.SetPathValue("id", mockRoom.ExternalId)
+			req = req.WithContext(WithUserId(req.Context(), 2))
+
+			rr := httptest.NewRecorder()
+			app.updateRoom(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			var room types.Room
+			err = json.NewDecoder(rr.Body).Decode(&room)
+			assert.NoError(t, err, "failed to decode response")
+			assert.Equal(t, types.Room{
+				Id:          mockRoom.Id,
+				ExternalId:  mockRoom.ExternalId,
+				Name:        tc.expectParams.Name,
+				Description: tc.expectParams.Description,
+				OwnerId:     mockRoom.OwnerId,
+				UpdatedAt:   updatedAt,
+			}, room)
+		})
+	}
+}
+
+func Test_createDirectMessage(t *testing.T) {
+	mockRoom := database.Room{
+		Id:         1,
+		ExternalId: "EoGKUXPHgz",
+		OwnerId:    1,
+		Kind:       database.RoomKindDirect,
+		CreatedAt:  time.Now().UTC(),
+		UpdatedAt:  time.Now().UTC(),
+	}
+
+	tcases := []struct {
+		name           string
+		body           any
+		userId         int
+		mockAccountErr error
+		mockCreated    bool
+		mockErr        error
+		expectedStatus int
+		expectedErr    *ApiError
+	}{
+		{
+			name:           "creates a direct message room",
+			body:           DirectMessageRequest{UserId: 2},
+			userId:         1,
+			mockCreated:    true,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "returns the existing direct message room",
+			body:           DirectMessageRequest{UserId: 2},
+			userId:         1,
+			mockCreated:    false,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:        "fails with no user id in context",
+			body:        DirectMessageRequest{UserId: 2},
+			expectedErr: NewUnauthorizedError(),
+		},
+		{
+			name:        "fails with invalid json body",
+			body:        "invalid json",
+			userId:      1,
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with missing user id",
+			body:        DirectMessageRequest{},
+			userId:      1,
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with own user id",
+			body:        DirectMessageRequest{UserId: 1},
+			userId:      1,
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:           "fails when peer does not exist",
+			body:           DirectMessageRequest{UserId: 2},
+			userId:         1,
+			mockAccountErr: sql.ErrNoRows,
+			expectedErr:    NewNotFoundError(),
+		},
+		{
+			name:        "fails with db error",
+			body:        DirectMessageRequest{UserId: 2},
+			userId:      1,
+			mockErr:     errors.New("db error"),
+			expectedErr: NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if req, ok := tc.body.(DirectMessageRequest); ok && tc.userId > 0 && req.UserId > 0 && req.UserId != tc.userId {
+				mockRepo.On("GetAccountById", mock.Anything, req.UserId).Return(database.User{Id: req.UserId}, tc.mockAccountErr).Once()
+				if tc.mockAccountErr == nil {
+					mockRepo.On("GetOrCreateDirectRoom", mock.Anything, database.CreateDirectRoomParams{
+						ExternalId: mockRoom.ExternalId,
+						UserId:     tc.userId,
+						PeerId:     req.UserId,
+					}).Return(mockRoom, tc.mockCreated, tc.mockErr).Once()
+				}
+			}
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, nil, &config.Config{})
+			app.generateShortId = func() (string, error) {
+				return mockRoom.ExternalId, nil
+			}
+
+			body, err := json.Marshal(tc.body)
+			assert.NoErrorf(t, err, "failed to marshal request body: %v", err)
+			req := httptest.NewRequest(http.MethodPost, "/api/dms", bytes.NewBuffer(body))
+
+			if tc.userId > 0 {
+				req = req.WithContext(WithUserId(req.Context(), tc.userId))
+			}
+
+			rr := httptest.NewRecorder()
+			app.createDirectMessage(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoErrorf(t, err, "failed to decode error response: %v", err)
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			var room types.Room
+			err = json.NewDecoder(rr.Body).Decode(&room)
+			assert.NoErrorf(t, err, "failed to decode response: %v", err)
+			assert.Equal(t, mockRoom.Id, room.Id, "expected room id to match")
+			assert.Equal(t, mockRoom.ExternalId, room.ExternalId, "expected room external id to match")
+			assert.Equal(t, database.RoomKindDirect, room.Kind, "expected direct room kind")
+		})
+	}
+}
+
 func Test_deleteRoom(t *testing.T) {
 	mockRoom := database.Room{
 		Id:          1,
@@ -908,6 +1359,9 @@ func Test_deleteRoom(t *testing.T) {
 		roomId                     string
 		mockRoom                   database.Room
 		mockGetRoomByExternalIdErr error
+		mockRole                   string
+		mockRoleErr                error
+		mockSubscribersErr         error
 		mockDeleteRoomErr          error
 		expectedErr                *ApiError
 	}{
@@ -920,6 +1374,16 @@ func Test_deleteRoom(t *testing.T) {
 			mockDeleteRoomErr:          nil,
 			expectedErr:                nil,
 		},
+		{
+			name:                       "successfully deletes a room for owner subscribed as member",
+			userId:                     1,
+			roomId:                     mockRoom.ExternalId,
+			mockRoom:                   mockRoom,
+			mockGetRoomByExternalIdErr: nil,
+			mockRole:                   database.RoleMember,
+			mockDeleteRoomErr:          nil,
+			expectedErr:                nil,
+		},
 		{
 			name:                       "fails with no query parameter",
 			userId:                     1,
@@ -953,18 +1417,55 @@ func Test_deleteRoom(t *testing.T) {
 			roomId:                     mockRoom.ExternalId,
 			mockRoom:                   mockRoom,
 			mockGetRoomByExternalIdErr: nil,
+			mockRole:                   database.RoleMember,
 			mockDeleteRoomErr:          nil,
 			expectedErr:                NewForbiddenError(),
 		},
+		{
+			name:                       "fails with forbidden access for admin",
+			userId:                     2,
+			roomId:                     mockRoom.ExternalId,
+			mockRoom:                   mockRoom,
+			mockGetRoomByExternalIdErr: nil,
+			mockRole:                   database.RoleAdmin,
+			expectedErr:                NewForbiddenError(),
+		},
+		{
+			name:                       "fails with forbidden access for non-subscriber",
+			userId:                     3,
+			roomId:                     mockRoom.ExternalId,
+			mockRoom:                   mockRoom,
+			mockGetRoomByExternalIdErr: nil,
+			mockRoleErr:                sql.ErrNoRows,
+			expectedErr:                NewForbiddenError(),
+		},
+		{
+			name:                       "fails with db error on get role",
+			userId:                     2,
+			roomId:                     mockRoom.ExternalId,
+			mockRoom:                   mockRoom,
+			mockGetRoomByExternalIdErr: nil,
+			mockRoleErr:                errors.New("db error"),
+			expectedErr:                NewInternalServerError(nil),
+		},
 		{
 			name:                       "fails with db error on delete room",
-			userId:                     1, // Different user ID than the room owner
+			userId:                     1,
 			roomId:                     mockRoom.ExternalId,
 			mockRoom:                   mockRoom,
 			mockGetRoomByExternalIdErr: nil,
 			mockDeleteRoomErr:          errors.New("db error"),
 			expectedErr:                NewInternalServerError(nil),
 		},
+		{
+			name:                       "fails with db error on get subscribers",
+			userId:                     1,
+			roomId:                     mockRoom.ExternalId,
+			mockRoom:                   mockRoom,
+			mockGetRoomByExternalIdErr: nil,
+			mockSubscribersErr:         errors.New("db error"),
+			expectedErr:                NewInternalServerError(nil),
+		},
 	}
 
 	for _, tc := range tcases {
@@ -973,12 +1474,19 @@ func Test_deleteRoom(t *testing.T) {
 			defer mockRepo.AssertExpectations(t)
 
 			if tc.roomId != "" || tc.mockGetRoomByExternalIdErr != nil {
-				mockRepo.On("GetRoomByExternalId", tc.roomId).Return(tc.mockRoom, tc.mockGetRoomByExternalIdErr).Once()
+				mockRepo.On("GetRoomByExternalId", mock.Anything, tc.roomId).Return(tc.mockRoom, tc.mockGetRoomByExternalIdErr).Once()
+			}
+
+			isOwner := tc.mockRoom.Id != 0 && tc.userId == tc.mockRoom.OwnerId
+			if tc.mockRoom.Id != 0 && !isOwner { // the owner's subscription isn't consulted
+				mockRepo.On("GetSubscriptionRole", mock.Anything, tc.userId, tc.mockRoom.Id).Return(tc.mockRole, tc.mockRoleErr).Once()
 			}
 
-			if tc.mockRoom.Id != 0 || tc.mockDeleteRoomErr != nil {
-				if tc.expectedErr == nil || *tc.expectedErr != *NewForbiddenError() { // "fails with forbidden access" case does not call DeleteRoom
-					mockRepo.On("DeleteRoom", tc.mockRoom.Id).Return(tc.mockDeleteRoomErr).Once()
+			if isOwner { // only the owner may delete the room
+				mockRepo.On("GetSubscribersByRoomId", mock.Anything, tc.mockRoom.Id).
+					Return([]database.User{{Id: 1}, {Id: 2}}, tc.mockSubscribersErr).Once()
+				if tc.mockSubscribersErr == nil {
+					mockRepo.On("DeleteRoom", mock.Anything, tc.mockRoom.Id).Return(tc.mockDeleteRoomErr).Once()
 				}
 			}
 
@@ -986,12 +1494,12 @@ func Test_deleteRoom(t *testing.T) {
 			defer su.AssertExpectations(t)
 			su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
 
-			cs, err := server.NewChatServer(log.Default(), mockRepo, su)
+			cs, err := server.NewChatServer(log.Default(), mockRepo, su, nil)
 			if err != nil {
 				t.Fatalf("failed to create chat server: %v", err)
 			}
 
-			app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, &config.Config{})
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, nil, &config.Config{})
 
 			var queryString string
 			if tc.roomId != "" {
@@ -1021,75 +1529,116 @@ func Test_deleteRoom(t *testing.T) {
 	}
 }
 
-func Test_getUsersSubscriptions(t *testing.T) {
-	mockSubs := []database.Subscription{
-		{
-			Id:            1,
-			LastReadSeqId: 0,
-			CreatedAt:     time.Now().UTC(),
-			UpdatedAt:     time.Now().UTC(),
-			Room: database.Room{
-				Id:          1,
-				ExternalId:  "EoGKUXPHgz",
-				Name:        "Test Room",
-				Description: "This is a test room",
-				SeqId:       0,
-				CreatedAt:   time.Now().UTC(),
-				UpdatedAt:   time.Now().UTC(),
-			},
-		},
-	}
+func Test_createInvite(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz", OwnerId: 1, Kind: database.RoomKindPrivate}
+	mockInvitee := database.User{Id: 2, Username: "invitee"}
+	mockRoles := map[int]string{1: database.RoleOwner, 3: database.RoleMember, 4: database.RoleAdmin}
+	createdAt := time.Date(2025, time.June, 28, 11, 17, 54, 0, time.UTC)
 
 	tcases := []struct {
-		name        string
-		userId      int
-		mockSubs    []database.Subscription
-		mockErr     error
-		expected    []types.Subscription
-		expectedErr *ApiError
+		name             string
+		body             any
+		userId           int
+		mockRoom         database.Room
+		mockRoomErr      error
+		mockAccountErr   error
+		mockSubscribed   bool
+		mockInviteErr    error
+		expectAccount    bool
+		expectSubscribed bool
+		expectInvite     bool
+		expectedErr      *ApiError
 	}{
 		{
-			name:     "successfully retrieves user subscriptions",
-			userId:   1,
-			mockSubs: mockSubs,
-			mockErr:  nil,
-			expected: func() []types.Subscription {
-				subs := make([]types.Subscription, len(mockSubs))
-				for i, sub := range mockSubs {
-					subs[i] = types.Subscription{
-						Id:            sub.Id,
-						LastReadSeqId: sub.LastReadSeqId,
-						CreatedAt:     sub.CreatedAt,
-						UpdatedAt:     sub.UpdatedAt,
-						Room: types.Room{
-							Id:          sub.Room.Id,
-							Name:        sub.Room.Name,
-							ExternalId:  sub.Room.ExternalId,
-							Description: sub.Room.Description,
-							CreatedAt:   sub.Room.CreatedAt,
-							UpdatedAt:   sub.Room.UpdatedAt,
-						},
-					}
-				}
-				return subs
-			}(),
-			expectedErr: nil,
+			name:             "owner invites a user",
+			body:             CreateInviteRequest{UserId: mockInvitee.Id},
+			userId:           1,
+			mockRoom:         mockRoom,
+			expectAccount:    true,
+			expectSubscribed: true,
+			expectInvite:     true,
 		},
 		{
-			name:        "fails with unauthorized access",
-			userId:      0,
-			mockSubs:    nil,
-			mockErr:     nil,
-			expected:    nil,
+			name:             "admin invites a user",
+			body:             CreateInviteRequest{UserId: mockInvitee.Id},
+			userId:           4,
+			mockRoom:         mockRoom,
+			expectAccount:    true,
+			expectSubscribed: true,
+			expectInvite:     true,
+		},
+		{
+			name:        "fails with no user id in context",
+			body:        CreateInviteRequest{UserId: mockInvitee.Id},
 			expectedErr: NewUnauthorizedError(),
 		},
 		{
-			name:        "fails with db error",
+			name:        "fails with invalid body",
+			body:        "invalid json",
 			userId:      1,
-			mockSubs:    nil,
-			mockErr:     errors.New("db error"),
-			expected:    nil,
-			expectedErr: NewInternalServerError(nil),
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with missing user id",
+			body:        CreateInviteRequest{},
+			userId:      1,
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails when room not found",
+			body:        CreateInviteRequest{UserId: mockInvitee.Id},
+			userId:      1,
+			mockRoomErr: sql.ErrNoRows,
+			expectedErr: NewNotFoundError(),
+		},
+		{
+			name:        "fails when user is not an admin",
+			body:        CreateInviteRequest{UserId: mockInvitee.Id},
+			userId:      3,
+			mockRoom:    mockRoom,
+			expectedErr: NewForbiddenError(),
+		},
+		{
+			name:   "fails for public rooms",
+			body:   CreateInviteRequest{UserId: mockInvitee.Id},
+			userId: 1,
+			mockRoom: database.Room{
+				Id:         1,
+				ExternalId: "EoGKUXPHgz",
+				OwnerId:    1,
+				Kind:       database.RoomKindPublic,
+			},
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:           "fails when invitee not found",
+			body:           CreateInviteRequest{UserId: mockInvitee.Id},
+			userId:         1,
+			mockRoom:       mockRoom,
+			mockAccountErr: sql.ErrNoRows,
+			expectAccount:  true,
+			expectedErr:    NewNotFoundError(),
+		},
+		{
+			name:             "fails when invitee is already subscribed",
+			body:             CreateInviteRequest{UserId: mockInvitee.Id},
+			userId:           1,
+			mockRoom:         mockRoom,
+			mockSubscribed:   true,
+			expectAccount:    true,
+			expectSubscribed: true,
+			expectedErr:      NewBadRequestError(),
+		},
+		{
+			name:             "fails with db error",
+			body:             CreateInviteRequest{UserId: mockInvitee.Id},
+			userId:           1,
+			mockRoom:         mockRoom,
+			mockInviteErr:    errors.New("db error"),
+			expectAccount:    true,
+			expectSubscribed: true,
+			expectInvite:     true,
+			expectedErr:      NewInternalServerError(nil),
 		},
 	}
 
@@ -1098,137 +1647,1118 @@ func Test_getUsersSubscriptions(t *testing.T) {
 			mockRepo := &database.MockGoChatRepository{}
 			defer mockRepo.AssertExpectations(t)
 
-			if tc.mockSubs != nil || tc.mockErr != nil {
-				mockRepo.On("ListSubscriptions", tc.userId).Return(tc.mockSubs, tc.mockErr).Once()
+			if tc.mockRoom.Id != 0 || tc.mockRoomErr != nil {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, mockRoom.ExternalId).Return(tc.mockRoom, tc.mockRoomErr).Once()
+			}
+			if tc.mockRoom.Id != 0 && tc.userId != mockRoom.OwnerId {
+				mockRepo.On("GetSubscriptionRole", mock.Anything, tc.userId, mockRoom.Id).Return(mockRoles[tc.userId], nil).Once()
+			}
+			if tc.expectAccount {
+				mockRepo.On("GetAccountById", mock.Anything, mockInvitee.Id).Return(mockInvitee, tc.mockAccountErr).Once()
+			}
+			if tc.expectSubscribed {
+				mockRepo.On("SubscriptionExists", mock.Anything, mockInvitee.Id, mockRoom.Id).Return(tc.mockSubscribed).Once()
+			}
+			if tc.expectInvite {
+				mockRepo.On("CreateInvite", mock.Anything, database.CreateInviteParams{
+					RoomId:    mockRoom.Id,
+					AccountId: mockInvitee.Id,
+					InvitedBy: tc.userId,
+				}).Return(database.Invite{
+					Id:        1,
+					RoomId:    mockRoom.Id,
+					AccountId: mockInvitee.Id,
+					InvitedBy: tc.userId,
+					CreatedAt: createdAt,
+				}, tc.mockInviteErr).Once()
 			}
 
-			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, &config.Config{})
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, nil, &config.Config{})
 
-			req := httptest.NewRequest(http.MethodGet, "/api/subscriptions", nil)
+			body, err := json.Marshal(tc.body)
+			assert.NoErrorf(t, err, "failed to marshal request body: %v", err)
+			req := httptest.NewRequest(http.MethodPost, "/api/rooms/"+mockRoom.ExternalId+"/invites", bytes.NewBuffer(body))
+			req.SetPathValue("id", mockRoom.ExternalId)
 			if tc.userId > 0 {
-				ctx := WithUserId(req.Context(), tc.userId)
-				req = req.WithContext(ctx)
+				req = req.WithContext(WithUserId(req.Context(), tc.userId))
 			}
 
 			rr := httptest.NewRecorder()
-			app.getUsersSubscriptions(rr, req)
+			app.createInvite(rr, req)
 
 			if tc.expectedErr != nil {
 				var apiErr ApiError
 				err := json.NewDecoder(rr.Body).Decode(&apiErr)
-				assert.NoError(t, err, "expected to decode ApiError successfully")
-				assert.Equal(t, rr.Code, tc.expectedErr.StatusCode, "expected status code to match")
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
 				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
 				return
 			}
 
-			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, http.StatusCreated, rr.Code)
+			var invite types.Invite
+			err = json.NewDecoder(rr.Body).Decode(&invite)
+			assert.NoError(t, err, "failed to decode response")
+			assert.Equal(t, types.Invite{
+				RoomId:    mockRoom.ExternalId,
+				User:      types.User{Id: mockInvitee.Id, Username: mockInvitee.Username},
+				InvitedBy: tc.userId,
+				CreatedAt: createdAt,
+			}, invite)
+		})
+	}
+}
 
-			var subs []types.Subscription
-			err := json.NewDecoder(rr.Body).Decode(&subs)
-			assert.NoError(t, err, "expected to decode subscriptions successfully")
+func Test_listInvites(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz", OwnerId: 1, Kind: database.RoomKindPrivate}
+	mockInvites := []database.Invite{
+		{Id: 1, RoomId: 1, AccountId: 2, Username: "invitee", InvitedBy: 1, CreatedAt: time.Date(2025, time.June, 28, 11, 17, 54, 0, time.UTC)},
+	}
+	mockRoles := map[int]string{1: database.RoleOwner, 2: database.RoleMember}
 
-			assert.Equal(t, len(tc.expected), len(subs), "expected number of subscriptions to match")
-			for i := range subs {
-				assert.Equal(t, tc.expected[i].Id, subs[i].Id)
-				assert.Equal(t, tc.expected[i].LastReadSeqId, subs[i].LastReadSeqId)
-				assert.WithinDuration(t, tc.expected[i].CreatedAt, subs[i].CreatedAt, time.Second)
-				assert.WithinDuration(t, tc.expected[i].UpdatedAt, subs[i].UpdatedAt, time.Second)
-				assert.Equal(t, tc.expected[i].Room.Id, subs[i].Room.Id)
-				assert.Equal(t, tc.expected[i].Room.Name, subs[i].Room.Name)
-				assert.Equal(t, tc.expected[i].Room.ExternalId, subs[i].Room.ExternalId)
-				assert.Equal(t, tc.expected[i].Room.Description, subs[i].Room.Description)
-				assert.WithinDuration(t, tc.expected[i].Room.CreatedAt, subs[i].CreatedAt, time.Second)
-				assert.WithinDuration(t, tc.expected[i].Room.UpdatedAt, subs[i].UpdatedAt, time.Second)
+	tcases := []struct {
+		name        string
+		userId      int
+		mockErr     error
+		expectList  bool
+		expectedErr *ApiError
+	}{
+		{
+			name:       "owner lists invites",
+			userId:     1,
+			expectList: true,
+		},
+		{
+			name:        "fails when user is not an admin",
+			userId:      2,
+			expectedErr: NewForbiddenError(),
+		},
+		{
+			name:        "fails with db error",
+			userId:      1,
+			mockErr:     errors.New("db error"),
+			expectList:  true,
+			expectedErr: NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			mockRepo.On("GetRoomByExternalId", mock.Anything, mockRoom.ExternalId).Return(mockRoom, nil).Once()
+			if tc.userId != mockRoom.OwnerId {
+				mockRepo.On("GetSubscriptionRole", mock.Anything, tc.userId, mockRoom.Id).Return(mockRoles[tc.userId], nil).Once()
+			}
+			if tc.expectList {
+				mockRepo.On("ListInvites", mock.Anything, mockRoom.Id).Return(mockInvites, tc.mockErr).Once()
+			}
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/rooms/"+mockRoom.ExternalId+"/invites", nil)
+			req.SetPathValue("id", mockRoom.ExternalId)
+			req = req.WithContext(WithUserId(req.Context(), tc.userId))
+
+			rr := httptest.NewRecorder()
+			app.listInvites(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
 			}
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			var invites []types.Invite
+			err := json.NewDecoder(rr.Body).Decode(&invites)
+			assert.NoError(t, err, "failed to decode response")
+			assert.Equal(t, []types.Invite{
+				{
+					RoomId:    mockRoom.ExternalId,
+					User:      types.User{Id: 2, Username: "invitee"},
+					InvitedBy: 1,
+					CreatedAt: mockInvites[0].CreatedAt,
+				},
+			}, invites)
 		})
 	}
 }
 
-func Test_getMessages(t *testing.T) {
-	fixedTime := time.Date(2025, time.June, 28, 11, 17, 54, 692262000, time.UTC)
-	mockMessages := []database.Message{
+func Test_deleteInvite(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz", OwnerId: 1, Kind: database.RoomKindPrivate}
+	mockRoles := map[int]string{1: database.RoleOwner, 2: database.RoleMember}
+
+	tcases := []struct {
+		name         string
+		userId       int
+		inviteeId    string
+		mockErr      error
+		expectRoom   bool
+		expectDelete bool
+		expectedErr  *ApiError
+	}{
 		{
-			Id:        3,
-			RoomId:    1,
-			UserId:    1,
-			Content:   "Hello!",
-			SeqId:     3,
-			CreatedAt: fixedTime,
+			name:         "owner revokes an invite",
+			userId:       1,
+			inviteeId:    "2",
+			expectRoom:   true,
+			expectDelete: true,
 		},
 		{
-			Id:        2,
-			RoomId:    1,
-			UserId:    2,
-			Content:   "Hi there!",
-			SeqId:     2,
-			CreatedAt: fixedTime.Add(-10 * time.Minute),
+			name:        "fails with invalid user id",
+			userId:      1,
+			inviteeId:   "abc",
+			expectedErr: NewBadRequestError(),
 		},
 		{
-			Id:        1,
-			RoomId:    1,
-			UserId:    3,
-			Content:   "Hey!",
-			SeqId:     1,
-			CreatedAt: fixedTime.Add(-20 * time.Minute),
+			name:        "fails when user is not an admin",
+			userId:      2,
+			inviteeId:   "2",
+			expectRoom:  true,
+			expectedErr: NewForbiddenError(),
+		},
+		{
+			name:         "fails when invite not found",
+			userId:       1,
+			inviteeId:    "2",
+			mockErr:      sql.ErrNoRows,
+			expectRoom:   true,
+			expectDelete: true,
+			expectedErr:  NewNotFoundError(),
 		},
 	}
 
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.expectRoom {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, mockRoom.ExternalId).Return(mockRoom, nil).Once()
+				if tc.userId != mockRoom.OwnerId {
+					mockRepo.On("GetSubscriptionRole", mock.Anything, tc.userId, mockRoom.Id).Return(mockRoles[tc.userId], nil).Once()
+				}
+			}
+			if tc.expectDelete {
+				mockRepo.On("DeleteInvite", mock.Anything, mockRoom.Id, 2).Return(tc.mockErr).Once()
+			}
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/rooms/"+mockRoom.ExternalId+"/invites/"+tc.inviteeId, nil)
+			req.SetPathValue("id", mockRoom.ExternalId)
+			req.SetPathValue("user_id", tc.inviteeId)
+			req = req.WithContext(WithUserId(req.Context(), tc.userId))
+
+			rr := httptest.NewRecorder()
+			app.deleteInvite(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusNoContent, rr.Code)
+		})
+	}
+}
+
+func Test_promoteAdmin(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz", OwnerId: 1}
+	mockTarget := database.User{Id: 2, Username: "member"}
+
 	tcases := []struct {
-		name                       string
-		roomId                     string
-		userId                     int
-		mockRoom                   database.Room
-		mockGetRoomByExternalIdErr error
-		mockMessages               []database.Message
-		mockGetMessagesErr         error
-		limit                      string
-		before                     string
-		after                      string
-		expected                   []types.Message
-		expectedErr                *ApiError
+		name          string
+		userId        int
+		body          any
+		userRole      string
+		targetRole    string
+		targetRoleErr error
+		expectRoom    bool
+		expectTarget  bool
+		expectUpdate  bool
+		expected      types.User
+		expectedErr   *ApiError
 	}{
 		{
-			name:                       "successfully retrieves messages with no query parameters",
-			roomId:                     "EoGKUXPHgz",
-			userId:                     1,
-			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
-			mockGetRoomByExternalIdErr: nil,
-			mockMessages:               mockMessages,
-			mockGetMessagesErr:         nil,
-			expected: func() []types.Message {
-				messages := make([]types.Message, len(mockMessages))
-				for i, msg := range mockMessages {
-					messages[i] = types.Message{
-						SeqId:     msg.SeqId,
-						RoomId:    msg.RoomId,
-						UserId:    msg.UserId,
-						Content:   msg.Content,
-						Timestamp: msg.CreatedAt,
-					}
-				}
-				return messages
-			}(),
-			expectedErr: nil,
+			name:         "owner promotes a member",
+			userId:       1,
+			body:         PromoteAdminRequest{UserId: mockTarget.Id},
+			userRole:     database.RoleOwner,
+			targetRole:   database.RoleMember,
+			expectRoom:   true,
+			expectTarget: true,
+			expectUpdate: true,
+			expected:     types.User{Id: mockTarget.Id, Username: mockTarget.Username, Role: database.RoleAdmin},
 		},
 		{
-			name:                       "successfully retrieves messages with after",
-			roomId:                     "EoGKUXPHgz",
-			userId:                     1,
-			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
-			mockGetRoomByExternalIdErr: nil,
-			mockMessages: func() []database.Message {
-				var messages []database.Message
-				for _, msg := range mockMessages {
-					if msg.SeqId > 1 {
-						messages = append(messages, msg)
-					}
+			name:         "promoting an admin is a no-op",
+			userId:       1,
+			body:         PromoteAdminRequest{UserId: mockTarget.Id},
+			userRole:     database.RoleOwner,
+			targetRole:   database.RoleAdmin,
+			expectRoom:   true,
+			expectTarget: true,
+			expected:     types.User{Id: mockTarget.Id, Username: mockTarget.Username, Role: database.RoleAdmin},
+		},
+		{
+			name:        "fails with invalid body",
+			userId:      1,
+			body:        "invalid",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails when user is an admin",
+			userId:      3,
+			body:        PromoteAdminRequest{UserId: mockTarget.Id},
+			userRole:    database.RoleAdmin,
+			expectRoom:  true,
+			expectedErr: NewForbiddenError(),
+		},
+		{
+			name:          "fails when target is not subscribed",
+			userId:        1,
+			body:          PromoteAdminRequest{UserId: mockTarget.Id},
+			userRole:      database.RoleOwner,
+			targetRoleErr: sql.ErrNoRows,
+			expectRoom:    true,
+			expectedErr:   NewNotFoundError(),
+		},
+		{
+			name:        "fails when target is the owner",
+			userId:      1,
+			body:        PromoteAdminRequest{UserId: mockTarget.Id},
+			userRole:    database.RoleOwner,
+			targetRole:  database.RoleOwner,
+			expectRoom:  true,
+			expectedErr: NewBadRequestError(),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.expectRoom {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, mockRoom.ExternalId).Return(mockRoom, nil).Once()
+				if tc.userId != mockRoom.OwnerId {
+					mockRepo.On("GetSubscriptionRole", mock.Anything, tc.userId, mockRoom.Id).Return(tc.userRole, nil).Once()
 				}
-				return messages
-			}(),
-			mockGetMessagesErr: nil,
-			after:              "1",
-			expected: func() []types.Message {
+				if tc.userRole == database.RoleOwner {
+					mockRepo.On("GetSubscriptionRole", mock.Anything, mockTarget.Id, mockRoom.Id).Return(tc.targetRole, tc.targetRoleErr).Once()
+				}
+			}
+			if tc.expectTarget {
+				mockRepo.On("GetAccountById", mock.Anything, mockTarget.Id).Return(mockTarget, nil).Once()
+			}
+			if tc.expectUpdate {
+				mockRepo.On("UpdateSubscriptionRole", mock.Anything, mockTarget.Id, mockRoom.Id, database.RoleAdmin).Return(nil).Once()
+				expectNotifySubscribers(mockRepo, mockRoom)
+			}
+
+			su := &stats.MockStatsUpdater{}
+			su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
+			cs, err := server.NewChatServer(log.Default(), mockRepo, su, nil)
+			assert.NoError(t, err, "failed to create chat server")
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, nil, &config.Config{})
+
+			body, err := json.Marshal(tc.body)
+			assert.NoError(t, err, "failed to marshal request body")
+
+			req := httptest.NewRequest(http.MethodPost, "/api/rooms/"+mockRoom.ExternalId+"/admins", bytes.NewReader(body))
+			req.SetPathValue("id", mockRoom.ExternalId)
+			req = req.WithContext(WithUserId(req.Context(), tc.userId))
+
+			rr := httptest.NewRecorder()
+			app.promoteAdmin(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			var user types.User
+			err = json.NewDecoder(rr.Body).Decode(&user)
+			assert.NoError(t, err, "failed to decode response")
+			assert.Equal(t, tc.expected, user)
+		})
+	}
+}
+
+func Test_demoteAdmin(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz", OwnerId: 1}
+	mockTarget := database.User{Id: 2, Username: "admin"}
+
+	tcases := []struct {
+		name         string
+		targetId     string
+		targetRole   string
+		expectRoom   bool
+		expectUpdate bool
+		updateErr    error
+		expectedErr  *ApiError
+	}{
+		{
+			name:         "owner demotes an admin",
+			targetId:     "2",
+			targetRole:   database.RoleAdmin,
+			expectRoom:   true,
+			expectUpdate: true,
+		},
+		{
+			name:        "fails with invalid user id",
+			targetId:    "abc",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:         "fails with db error on update",
+			targetId:     "2",
+			targetRole:   database.RoleAdmin,
+			expectRoom:   true,
+			expectUpdate: true,
+			updateErr:    errors.New("db error"),
+			expectedErr:  NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.expectRoom {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, mockRoom.ExternalId).Return(mockRoom, nil).Once()
+				mockRepo.On("GetSubscriptionRole", mock.Anything, mockTarget.Id, mockRoom.Id).Return(tc.targetRole, nil).Once()
+				mockRepo.On("GetAccountById", mock.Anything, mockTarget.Id).Return(mockTarget, nil).Once()
+			}
+			if tc.expectUpdate {
+				mockRepo.On("UpdateSubscriptionRole", mock.Anything, mockTarget.Id, mockRoom.Id, database.RoleMember).Return(tc.updateErr).Once()
+				if tc.updateErr == nil {
+					expectNotifySubscribers(mockRepo, mockRoom)
+				}
+			}
+
+			su := &stats.MockStatsUpdater{}
+			su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
+			cs, err := server.NewChatServer(log.Default(), mockRepo, su, nil)
+			assert.NoError(t, err, "failed to create chat server")
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/rooms/"+mockRoom.ExternalId+"/admins/"+tc.targetId, nil)
+			req.SetPathValue("id", mockRoom.ExternalId)
+			req.SetPathValue("user_id", tc.targetId)
+			req = req.WithContext(WithUserId(req.Context(), mockRoom.OwnerId))
+
+			rr := httptest.NewRecorder()
+			app.demoteAdmin(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			var user types.User
+			err = json.NewDecoder(rr.Body).Decode(&user)
+			assert.NoError(t, err, "failed to decode response")
+			assert.Equal(t, types.User{Id: mockTarget.Id, Username: mockTarget.Username, Role: database.RoleMember}, user)
+		})
+	}
+}
+
+func Test_kickUser(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz", OwnerId: 1}
+	mockTarget := database.User{Id: 2, Username: "disruptive"}
+
+	tcases := []struct {
+		name          string
+		userId        int
+		targetId      string
+		userRole      string
+		targetRole    string
+		targetRoleErr error
+		expectRoom    bool
+		expectTarget  bool
+		expectDelete  bool
+		deleteErr     error
+		expectedErr   *ApiError
+	}{
+		{
+			name:         "admin kicks a member",
+			userId:       3,
+			targetId:     "2",
+			userRole:     database.RoleAdmin,
+			targetRole:   database.RoleMember,
+			expectRoom:   true,
+			expectTarget: true,
+			expectDelete: true,
+		},
+		{
+			name:         "owner kicks an admin",
+			userId:       1,
+			targetId:     "2",
+			userRole:     database.RoleOwner,
+			targetRole:   database.RoleAdmin,
+			expectRoom:   true,
+			expectTarget: true,
+			expectDelete: true,
+		},
+		{
+			name:        "fails with invalid user id",
+			userId:      1,
+			targetId:    "abc",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails when user is a member",
+			userId:      4,
+			targetId:    "2",
+			userRole:    database.RoleMember,
+			expectRoom:  true,
+			expectedErr: NewForbiddenError(),
+		},
+		{
+			name:         "fails when admin kicks an admin",
+			userId:       3,
+			targetId:     "2",
+			userRole:     database.RoleAdmin,
+			targetRole:   database.RoleAdmin,
+			expectRoom:   true,
+			expectTarget: true,
+			expectedErr:  NewForbiddenError(),
+		},
+		{
+			name:         "fails when kicking the owner",
+			userId:       3,
+			targetId:     "2",
+			userRole:     database.RoleAdmin,
+			targetRole:   database.RoleOwner,
+			expectRoom:   true,
+			expectTarget: true,
+			expectedErr:  NewForbiddenError(),
+		},
+		{
+			name:        "fails when kicking themselves",
+			userId:      2,
+			targetId:    "2",
+			userRole:    database.RoleAdmin,
+			expectRoom:  true,
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:          "fails when target is not subscribed",
+			userId:        1,
+			targetId:      "2",
+			userRole:      database.RoleOwner,
+			targetRoleErr: sql.ErrNoRows,
+			expectRoom:    true,
+			expectTarget:  true,
+			expectedErr:   NewNotFoundError(),
+		},
+		{
+			name:         "fails with db error on delete",
+			userId:       1,
+			targetId:     "2",
+			userRole:     database.RoleOwner,
+			targetRole:   database.RoleMember,
+			expectRoom:   true,
+			expectTarget: true,
+			expectDelete: true,
+			deleteErr:    errors.New("db error"),
+			expectedErr:  NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.expectRoom {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, mockRoom.ExternalId).Return(mockRoom, nil).Once()
+				if tc.userId != mockRoom.OwnerId {
+					mockRepo.On("GetSubscriptionRole", mock.Anything, tc.userId, mockRoom.Id).Return(tc.userRole, nil).Once()
+				}
+			}
+			if tc.expectTarget {
+				mockRepo.On("GetAccountById", mock.Anything, mockTarget.Id).Return(mockTarget, nil).Once()
+				mockRepo.On("GetSubscriptionRole", mock.Anything, mockTarget.Id, mockRoom.Id).Return(tc.targetRole, tc.targetRoleErr).Once()
+				if tc.targetRole == database.RoleAdmin && tc.userId != mockRoom.OwnerId {
+					mockRepo.On("GetSubscriptionRole", mock.Anything, tc.userId, mockRoom.Id).Return(tc.userRole, nil).Once()
+				}
+			}
+			if tc.expectDelete {
+				mockRepo.On("DeleteSubscription", mock.Anything, mockTarget.Id, mockRoom.Id).Return(tc.deleteErr).Once()
+			}
+
+			su := &stats.MockStatsUpdater{}
+			su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
+			cs, err := server.NewChatServer(log.Default(), mockRepo, su, nil)
+			assert.NoError(t, err, "failed to create chat server")
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/rooms/"+mockRoom.ExternalId+"/subscribers/"+tc.targetId, nil)
+			req.SetPathValue("id", mockRoom.ExternalId)
+			req.SetPathValue("user_id", tc.targetId)
+			req = req.WithContext(WithUserId(req.Context(), tc.userId))
+
+			rr := httptest.NewRecorder()
+			app.kickUser(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusNoContent, rr.Code)
+		})
+	}
+}
+
+func Test_createBan(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz", OwnerId: 1}
+	mockTarget := database.User{Id: 2, Username: "disruptive"}
+	createdAt := time.Date(2025, time.June, 28, 11, 17, 54, 0, time.UTC)
+	expiresAt := time.Now().Add(time.Hour).UTC().Round(time.Millisecond)
+	expired := time.Now().Add(-time.Hour)
+
+	tcases := []struct {
+		name          string
+		body          any
+		targetRoleErr error
+		expectRoom    bool
+		expectBan     bool
+		expected      types.Ban
+		expectedErr   *ApiError
+	}{
+		{
+			name:       "bans a subscribed user",
+			body:       BanRequest{UserId: mockTarget.Id, Reason: "spam"},
+			expectRoom: true,
+			expectBan:  true,
+			expected: types.Ban{
+				RoomId:    mockRoom.ExternalId,
+				User:      types.User{Id: mockTarget.Id, Username: mockTarget.Username},
+				BannedBy:  mockRoom.OwnerId,
+				Reason:    "spam",
+				CreatedAt: createdAt,
+			},
+		},
+		{
+			name:          "bans a user that is not subscribed until expiry",
+			body:          BanRequest{UserId: mockTarget.Id, ExpiresAt: &expiresAt},
+			targetRoleErr: sql.ErrNoRows,
+			expectRoom:    true,
+			expectBan:     true,
+			expected: types.Ban{
+				RoomId:    mockRoom.ExternalId,
+				User:      types.User{Id: mockTarget.Id, Username: mockTarget.Username},
+				BannedBy:  mockRoom.OwnerId,
+				ExpiresAt: &expiresAt,
+				CreatedAt: createdAt,
+			},
+		},
+		{
+			name:        "fails with invalid body",
+			body:        "invalid",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with expiry in the past",
+			body:        BanRequest{UserId: mockTarget.Id, ExpiresAt: &expired},
+			expectedErr: NewBadRequestError(),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.expectRoom {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, mockRoom.ExternalId).Return(mockRoom, nil).Once()
+				mockRepo.On("GetAccountById", mock.Anything, mockTarget.Id).Return(mockTarget, nil).Once()
+				mockRepo.On("GetSubscriptionRole", mock.Anything, mockTarget.Id, mockRoom.Id).Return(database.RoleMember, tc.targetRoleErr).Once()
+			}
+			if tc.expectBan {
+				mockRepo.On("CreateBan", mock.Anything, database.CreateBanParams{
+					RoomId:    mockRoom.Id,
+					AccountId: mockTarget.Id,
+					BannedBy:  mockRoom.OwnerId,
+					Reason:    tc.expected.Reason,
+					ExpiresAt: tc.expected.ExpiresAt,
+				}).Return(database.Ban{
+					Id:        1,
+					RoomId:    mockRoom.Id,
+					AccountId: mockTarget.Id,
+					BannedBy:  mockRoom.OwnerId,
+					Reason:    tc.expected.Reason,
+					ExpiresAt: tc.expected.ExpiresAt,
+					CreatedAt: createdAt,
+				}, nil).Once()
+			}
+
+			su := &stats.MockStatsUpdater{}
+			su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
+			cs, err := server.NewChatServer(log.Default(), mockRepo, su, nil)
+			assert.NoError(t, err, "failed to create chat server")
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, nil, &config.Config{})
+
+			body, err := json.Marshal(tc.body)
+			assert.NoError(t, err, "failed to marshal request body")
+
+			req := httptest.NewRequest(http.MethodPost, "/api/rooms/"+mockRoom.ExternalId+"/bans", bytes.NewReader(body))
+			req.SetPathValue("id", mockRoom.ExternalId)
+			req = req.WithContext(WithUserId(req.Context(), mockRoom.OwnerId))
+
+			rr := httptest.NewRecorder()
+			app.createBan(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusCreated, rr.Code)
+			var ban types.Ban
+			err = json.NewDecoder(rr.Body).Decode(&ban)
+			assert.NoError(t, err, "failed to decode response")
+			assert.Equal(t, tc.expected, ban)
+		})
+	}
+}
+
+func Test_listBans(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz", OwnerId: 1}
+	createdAt := time.Date(2025, time.June, 28, 11, 17, 54, 0, time.UTC)
+	mockBans := []database.Ban{
+		{Id: 1, RoomId: 1, AccountId: 2, Username: "disruptive", BannedBy: 1, Reason: "spam", CreatedAt: createdAt},
+	}
+
+	tcases := []struct {
+		name        string
+		userRole    string
+		expectList  bool
+		mockErr     error
+		expected    []types.Ban
+		expectedErr *ApiError
+	}{
+		{
+			name:       "admin lists bans",
+			userRole:   database.RoleAdmin,
+			expectList: true,
+			expected: []types.Ban{
+				{
+					RoomId:    mockRoom.ExternalId,
+					User:      types.User{Id: 2, Username: "disruptive"},
+					BannedBy:  1,
+					Reason:    "spam",
+					CreatedAt: createdAt,
+				},
+			},
+		},
+		{
+			name:        "fails when user is a member",
+			userRole:    database.RoleMember,
+			expectedErr: NewForbiddenError(),
+		},
+		{
+			name:        "fails with db error",
+			userRole:    database.RoleOwner,
+			expectList:  true,
+			mockErr:     errors.New("db error"),
+			expectedErr: NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			mockRepo.On("GetRoomByExternalId", mock.Anything, mockRoom.ExternalId).Return(mockRoom, nil).Once()
+			mockRepo.On("GetSubscriptionRole", mock.Anything, 3, mockRoom.Id).Return(tc.userRole, nil).Once()
+			if tc.expectList {
+				mockRepo.On("ListBans", mock.Anything, mockRoom.Id).Return(mockBans, tc.mockErr).Once()
+			}
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/rooms/"+mockRoom.ExternalId+"/bans", nil)
+			req.SetPathValue("id", mockRoom.ExternalId)
+			req = req.WithContext(WithUserId(req.Context(), 3))
+
+			rr := httptest.NewRecorder()
+			app.listBans(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			var bans []types.Ban
+			err := json.NewDecoder(rr.Body).Decode(&bans)
+			assert.NoError(t, err, "failed to decode response")
+			assert.Equal(t, tc.expected, bans)
+		})
+	}
+}
+
+func Test_deleteBan(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz", OwnerId: 1}
+
+	tcases := []struct {
+		name        string
+		targetId    string
+		expectRoom  bool
+		mockErr     error
+		expectedErr *ApiError
+	}{
+		{
+			name:       "lifts a ban",
+			targetId:   "2",
+			expectRoom: true,
+		},
+		{
+			name:        "fails with invalid user id",
+			targetId:    "abc",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails when user is not banned",
+			targetId:    "2",
+			expectRoom:  true,
+			mockErr:     sql.ErrNoRows,
+			expectedErr: NewNotFoundError(),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.expectRoom {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, mockRoom.ExternalId).Return(mockRoom, nil).Once()
+				mockRepo.On("DeleteBan", mock.Anything, mockRoom.Id, 2).Return(tc.mockErr).Once()
+			}
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/rooms/"+mockRoom.ExternalId+"/bans/"+tc.targetId, nil)
+			req.SetPathValue("id", mockRoom.ExternalId)
+			req.SetPathValue("user_id", tc.targetId)
+			req = req.WithContext(WithUserId(req.Context(), mockRoom.OwnerId))
+
+			rr := httptest.NewRecorder()
+			app.deleteBan(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusNoContent, rr.Code)
+		})
+	}
+}
+
+func Test_getUsersSubscriptions(t *testing.T) {
+	mockSubs := []database.Subscription{
+		{
+			Id:            1,
+			LastReadSeqId: 0,
+			UnreadCount:   3,
+			MentionCount:  1,
+			CreatedAt:     time.Now().UTC(),
+			UpdatedAt:     time.Now().UTC(),
+			Room: database.Room{
+				Id:          1,
+				ExternalId:  "EoGKUXPHgz",
+				Name:        "Test Room",
+				Description: "This is a test room",
+				SeqId:       0,
+				CreatedAt:   time.Now().UTC(),
+				UpdatedAt:   time.Now().UTC(),
+			},
+		},
+	}
+
+	tcases := []struct {
+		name        string
+		userId      int
+		mockSubs    []database.Subscription
+		mockErr     error
+		expected    []types.Subscription
+		expectedErr *ApiError
+	}{
+		{
+			name:     "successfully retrieves user subscriptions",
+			userId:   1,
+			mockSubs: mockSubs,
+			mockErr:  nil,
+			expected: func() []types.Subscription {
+				subs := make([]types.Subscription, len(mockSubs))
+				for i, sub := range mockSubs {
+					subs[i] = types.Subscription{
+						Id:            sub.Id,
+						LastReadSeqId: sub.LastReadSeqId,
+						UnreadCount:   sub.UnreadCount,
+						MentionCount:  sub.MentionCount,
+						CreatedAt:     sub.CreatedAt,
+						UpdatedAt:     sub.UpdatedAt,
+						Room: types.Room{
+							Id:          sub.Room.Id,
+							Name:        sub.Room.Name,
+							ExternalId:  sub.Room.ExternalId,
+							Description: sub.Room.Description,
+							CreatedAt:   sub.Room.CreatedAt,
+							UpdatedAt:   sub.Room.UpdatedAt,
+						},
+					}
+				}
+				return subs
+			}(),
+			expectedErr: nil,
+		},
+		{
+			name:        "fails with unauthorized access",
+			userId:      0,
+			mockSubs:    nil,
+			mockErr:     nil,
+			expected:    nil,
+			expectedErr: NewUnauthorizedError(),
+		},
+		{
+			name:        "fails with db error",
+			userId:      1,
+			mockSubs:    nil,
+			mockErr:     errors.New("db error"),
+			expected:    nil,
+			expectedErr: NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.mockSubs != nil || tc.mockErr != nil {
+				mockRepo.On("ListSubscriptions", mock.Anything, tc.userId).Return(tc.mockSubs, tc.mockErr).Once()
+			}
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/subscriptions", nil)
+			if tc.userId > 0 {
+				ctx := WithUserId(req.Context(), tc.userId)
+				req = req.WithContext(ctx)
+			}
+
+			rr := httptest.NewRecorder()
+			app.getUsersSubscriptions(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "expected to decode ApiError successfully")
+				assert.Equal(t, rr.Code, tc.expectedErr.StatusCode, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+
+			var subs []types.Subscription
+			err := json.NewDecoder(rr.Body).Decode(&subs)
+			assert.NoError(t, err, "expected to decode subscriptions successfully")
+
+			assert.Equal(t, len(tc.expected), len(subs), "expected number of subscriptions to match")
+			for i := range subs {
+				assert.Equal(t, tc.expected[i].Id, subs[i].Id)
+				assert.Equal(t, tc.expected[i].LastReadSeqId, subs[i].LastReadSeqId)
+				assert.WithinDuration(t, tc.expected[i].CreatedAt, subs[i].CreatedAt, time.Second)
+				assert.WithinDuration(t, tc.expected[i].UpdatedAt, subs[i].UpdatedAt, time.Second)
+				assert.Equal(t, tc.expected[i].Room.Id, subs[i].Room.Id)
+				assert.Equal(t, tc.expected[i].Room.Name, subs[i].Room.Name)
+				assert.Equal(t, tc.expected[i].Room.ExternalId, subs[i].Room.ExternalId)
+				assert.Equal(t, tc.expected[i].Room.Description, subs[i].Room.Description)
+				assert.WithinDuration(t, tc.expected[i].Room.CreatedAt, subs[i].CreatedAt, time.Second)
+				assert.WithinDuration(t, tc.expected[i].Room.UpdatedAt, subs[i].UpdatedAt, time.Second)
+			}
+		})
+	}
+}
+
+func Test_getMessages(t *testing.T) {
+	fixedTime := time.Date(2025, time.June, 28, 11, 17, 54, 692262000, time.UTC)
+	mockMessages := []database.Message{
+		{
+			Id:        3,
+			RoomId:    1,
+			UserId:    1,
+			Content:   "Hello!",
+			SeqId:     3,
+			CreatedAt: fixedTime,
+		},
+		{
+			Id:        2,
+			RoomId:    1,
+			UserId:    2,
+			Content:   "Hi there!",
+			SeqId:     2,
+			CreatedAt: fixedTime.Add(-10 * time.Minute),
+		},
+		{
+			Id:        1,
+			RoomId:    1,
+			UserId:    3,
+			Content:   "Hey!",
+			SeqId:     1,
+			CreatedAt: fixedTime.Add(-20 * time.Minute),
+		},
+	}
+
+	tcases := []struct {
+		name                       string
+		roomId                     string
+		userId                     int
+		mockRoom                   database.Room
+		mockGetRoomByExternalIdErr error
+		mockMessages               []database.Message
+		mockGetMessagesErr         error
+		limit                      string
+		before                     string
+		after                      string
+		expected                   []types.Message
+		expectedErr                *ApiError
+	}{
+		{
+			name:                       "successfully retrieves messages with no query parameters",
+			roomId:                     "EoGKUXPHgz",
+			userId:                     1,
+			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
+			mockGetRoomByExternalIdErr: nil,
+			mockMessages:               mockMessages,
+			mockGetMessagesErr:         nil,
+			expected: func() []types.Message {
+				messages := make([]types.Message, len(mockMessages))
+				for i, msg := range mockMessages {
+					messages[i] = types.Message{
+						SeqId:     msg.SeqId,
+						RoomId:    msg.RoomId,
+						UserId:    msg.UserId,
+						Content:   msg.Content,
+						Timestamp: msg.CreatedAt,
+					}
+				}
+				return messages
+			}(),
+			expectedErr: nil,
+		},
+		{
+			name:         "successfully retrieves direct messages as a member",
+			roomId:       "EoGKUXPHgz",
+			userId:       1,
+			mockRoom:     database.Room{Id: 1, ExternalId: "EoGKUXPHgz", Kind: database.RoomKindDirect},
+			mockMessages: mockMessages[:1],
+			expected: []types.Message{
+				{SeqId: 3, RoomId: 1, UserId: 1, Content: "Hello!", Timestamp: fixedTime},
+			},
+		},
+		{
+			name:        "fails to retrieve direct messages as a non-member",
+			roomId:      "EoGKUXPHgz",
+			userId:      4,
+			mockRoom:    database.Room{Id: 1, ExternalId: "EoGKUXPHgz", Kind: database.RoomKindDirect},
+			expectedErr: NewNotFoundError(),
+		},
+		{
+			name:     "successfully retrieves messages with reactions",
+			roomId:   "EoGKUXPHgz",
+			userId:   1,
+			mockRoom: database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
+			mockMessages: []database.Message{
+				{
+					Id:        3,
+					RoomId:    1,
+					UserId:    1,
+					Content:   "Hello!",
+					SeqId:     3,
+					Reactions: []database.Reaction{{Emoji: "👍", UserIds: []int{2, 3}}, {Emoji: "🎉", UserIds: []int{1}}},
+					CreatedAt: fixedTime,
+				},
+			},
+			expected: []types.Message{
+				{
+					SeqId:     3,
+					RoomId:    1,
+					UserId:    1,
+					Content:   "Hello!",
+					Reactions: []types.Reaction{{Emoji: "👍", Count: 2, UserIds: []int{2, 3}}, {Emoji: "🎉", Count: 1, UserIds: []int{1}}},
+					Timestamp: fixedTime,
+				},
+			},
+		},
+		{
+			name:                       "successfully retrieves messages with after",
+			roomId:                     "EoGKUXPHgz",
+			userId:                     1,
+			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
+			mockGetRoomByExternalIdErr: nil,
+			mockMessages: func() []database.Message {
+				var messages []database.Message
+				for _, msg := range mockMessages {
+					if msg.SeqId > 1 {
+						messages = append(messages, msg)
+					}
+				}
+				return messages
+			}(),
+			mockGetMessagesErr: nil,
+			after:              "1",
+			expected: func() []types.Message {
 				var messages []types.Message
 				for _, msg := range mockMessages {
 					if msg.SeqId > 1 {
@@ -1241,153 +2771,1328 @@ func Test_getMessages(t *testing.T) {
 						})
 					}
 				}
-				return messages
-			}(),
-			expectedErr: nil,
+				return messages
+			}(),
+			expectedErr: nil,
+		},
+		{
+			name:                       "successfully retrieves messages with limit",
+			roomId:                     "EoGKUXPHgz",
+			userId:                     1,
+			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
+			mockGetRoomByExternalIdErr: nil,
+			mockMessages: func() []database.Message {
+				messages := make([]database.Message, 0, 2)
+				messages = append(messages, mockMessages[:2]...)
+				return messages
+			}(),
+			mockGetMessagesErr: nil,
+			limit:              "2",
+			expected: func() []types.Message {
+				messages := make([]types.Message, 0, 2)
+				for _, msg := range mockMessages[:2] {
+					messages = append(messages, types.Message{
+						SeqId:     msg.SeqId,
+						RoomId:    msg.RoomId,
+						UserId:    msg.UserId,
+						Content:   msg.Content,
+						Timestamp: msg.CreatedAt,
+					})
+				}
+				return messages
+			}(),
+		},
+		{
+			name:                       "successfully retrieves messages with before",
+			roomId:                     "EoGKUXPHgz",
+			userId:                     1,
+			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
+			mockGetRoomByExternalIdErr: nil,
+			mockMessages: func() []database.Message {
+				messages := make([]database.Message, 0, 2)
+				messages = append(messages, mockMessages[1:]...)
+				return messages
+			}(),
+			mockGetMessagesErr: nil,
+			before:             "3", // Get messages before SeqId 3
+			expected: func() []types.Message {
+				messages := make([]types.Message, 0, 2)
+				for _, msg := range mockMessages[1:] { // Exclude the message with SeqId 3
+					messages = append(messages, types.Message{
+						SeqId:     msg.SeqId,
+						RoomId:    msg.RoomId,
+						UserId:    msg.UserId,
+						Content:   msg.Content,
+						Timestamp: msg.CreatedAt,
+					})
+				}
+				return messages
+			}(),
+		},
+		{
+			name:                       "missing room_id query parameter",
+			userId:                     1,
+			mockRoom:                   database.Room{},
+			mockGetRoomByExternalIdErr: nil,
+			mockMessages:               nil,
+			mockGetMessagesErr:         nil,
+			expected:                   nil,
+			expectedErr:                NewBadRequestError(),
+		},
+		{
+			name:                       "room not found",
+			roomId:                     "nonexistent",
+			userId:                     1,
+			mockRoom:                   database.Room{},
+			mockGetRoomByExternalIdErr: sql.ErrNoRows,
+			mockMessages:               nil,
+			mockGetMessagesErr:         nil,
+			expected:                   nil,
+			expectedErr:                NewNotFoundError(),
+		},
+		{
+			name:                       "GetExternalId db error",
+			roomId:                     "EoGKUXPHgz",
+			userId:                     1,
+			mockRoom:                   database.Room{},
+			mockGetRoomByExternalIdErr: errors.New("db error"),
+			mockMessages:               nil,
+			mockGetMessagesErr:         nil,
+			expected:                   nil,
+			expectedErr:                NewInternalServerError(nil),
+		},
+		{
+			name:                       "GetMessages with no messages",
+			roomId:                     "EoGKUXPHgz",
+			userId:                     1,
+			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
+			mockGetRoomByExternalIdErr: nil,
+			mockMessages:               []database.Message{},
+			mockGetMessagesErr:         nil,
+			expected:                   []types.Message{},
+			expectedErr:                nil,
+		},
+		{
+			name:                       "GetMessages db error",
+			roomId:                     "EoGKUXPHgz",
+			userId:                     1,
+			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
+			mockGetRoomByExternalIdErr: nil,
+			mockMessages:               nil,
+			mockGetMessagesErr:         errors.New("db error"),
+			expected:                   nil,
+			expectedErr:                NewInternalServerError(nil),
+		},
+		{
+			name:                       "invalid after parameter",
+			roomId:                     "EoGKUXPHgz",
+			userId:                     1,
+			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
+			mockGetRoomByExternalIdErr: nil,
+			mockMessages:               nil,
+			mockGetMessagesErr:         nil,
+			after:                      "invalid", // Invalid after parameter
+			expected:                   nil,
+			expectedErr:                NewBadRequestError(),
+		},
+		{
+			name:                       "invalid limit parameter",
+			roomId:                     "EoGKUXPHgz",
+			userId:                     1,
+			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
+			mockGetRoomByExternalIdErr: nil,
+			mockMessages:               nil,
+			mockGetMessagesErr:         nil,
+			limit:                      "invalid", // Invalid after parameter
+			expected:                   nil,
+			expectedErr:                NewBadRequestError(),
+		},
+		{
+			name:                       "invalid before parameter",
+			roomId:                     "EoGKUXPHgz",
+			userId:                     1,
+			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
+			mockGetRoomByExternalIdErr: nil,
+			mockMessages:               nil,
+			mockGetMessagesErr:         nil,
+			before:                     "invalid", // Invalid after parameter
+			expected:                   nil,
+			expectedErr:                NewBadRequestError(),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.mockRoom.Id != 0 || tc.mockGetRoomByExternalIdErr != nil {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, tc.roomId).Return(tc.mockRoom, tc.mockGetRoomByExternalIdErr).Once()
+			}
+
+			if tc.mockRoom.Kind == database.RoomKindDirect {
+				// only user 1 is a member of the direct message room
+				mockRepo.On("SubscriptionExists", mock.Anything, tc.userId, tc.mockRoom.Id).Return(tc.userId == 1).Once()
+			}
+
+			if tc.mockMessages != nil || tc.mockGetMessagesErr != nil {
+				// Convert query parameters to integers for the mock
+				// Note: limit, after, and before are optional, so they may be empty
+				var limitInt, afterInt, beforeInt int
+				var err error
+				if tc.limit != "" {
+					limitInt, err = strconv.Atoi(tc.limit)
+				}
+				if tc.after != "" {
+					afterInt, err = strconv.Atoi(tc.after)
+				}
+				if tc.before != "" {
+					beforeInt, err = strconv.Atoi(tc.before)
+				}
+				assert.NoError(t, err, "failed to convert query parameters to integers")
+				mockRepo.On("GetMessages", mock.Anything, tc.mockRoom.Id, afterInt, beforeInt, limitInt).Return(tc.mockMessages, tc.mockGetMessagesErr).Once()
+			}
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, nil, &config.Config{})
+
+			var queryString string
+			if tc.roomId != "" {
+				queryString = fmt.Sprintf("?room_id=%s", tc.roomId)
+			}
+
+			if tc.limit != "" {
+				queryString += fmt.Sprintf("&limit=%s", tc.limit)
+			}
+
+			if tc.after != "" {
+				queryString += fmt.Sprintf("&after=%s", tc.after)
+			}
+
+			if tc.before != "" {
+				queryString += fmt.Sprintf("&before=%s", tc.before)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/messages"+queryString, nil)
+
+			if tc.userId > 0 {
+				ctx := WithUserId(req.Context(), tc.userId)
+				req = req.WithContext(ctx)
+			}
+
+			rr := httptest.NewRecorder()
+			app.getMessages(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, rr.Code, tc.expectedErr.StatusCode, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			var messages []types.Message
+			err := json.NewDecoder(rr.Body).Decode(&messages)
+			assert.NoError(t, err, "failed to decode response: %v", err)
+			assert.Len(t, messages, len(tc.expected), "expected number of messages to match")
+			for i := range messages {
+				assert.Equal(t, tc.expected[i].UserId, messages[i].UserId)
+				assert.Equal(t, tc.expected[i].Content, messages[i].Content)
+				assert.Equal(t, tc.expected[i].SeqId, messages[i].SeqId)
+				assert.Equal(t, tc.expected[i].Timestamp, messages[i].Timestamp)
+				assert.Equal(t, tc.expected[i].Reactions, messages[i].Reactions)
+			}
+		})
+	}
+}
+func Test_deleteMessage(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz", OwnerId: 3}
+	mockRoles := map[int]string{2: database.RoleMember, 3: database.RoleOwner, 4: database.RoleAdmin}
+
+	tcases := []struct {
+		name             string
+		userId           int
+		roomId           string
+		seqId            string
+		mockMessage      database.Message
+		mockRoomErr      error
+		mockMessageErr   error
+		mockDeleteErr    error
+		expectDeleteCall bool
+		expectedErr      *ApiError
+	}{
+		{
+			name:             "author deletes message",
+			userId:           1,
+			roomId:           mockRoom.ExternalId,
+			seqId:            "2",
+			mockMessage:      database.Message{Id: 5, SeqId: 2, RoomId: 1, UserId: 1},
+			expectDeleteCall: true,
+		},
+		{
+			name:             "room owner deletes message",
+			userId:           3,
+			roomId:           mockRoom.ExternalId,
+			seqId:            "2",
+			mockMessage:      database.Message{Id: 5, SeqId: 2, RoomId: 1, UserId: 1},
+			expectDeleteCall: true,
+		},
+		{
+			name:             "room admin deletes message",
+			userId:           4,
+			roomId:           mockRoom.ExternalId,
+			seqId:            "2",
+			mockMessage:      database.Message{Id: 5, SeqId: 2, RoomId: 1, UserId: 1},
+			expectDeleteCall: true,
+		},
+		{
+			name:        "fails with unauthorized",
+			userId:      0,
+			roomId:      mockRoom.ExternalId,
+			seqId:       "2",
+			expectedErr: NewUnauthorizedError(),
+		},
+		{
+			name:        "fails with invalid seq_id",
+			userId:      1,
+			roomId:      mockRoom.ExternalId,
+			seqId:       "invalid",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with room not found",
+			userId:      1,
+			roomId:      mockRoom.ExternalId,
+			seqId:       "2",
+			mockRoomErr: sql.ErrNoRows,
+			expectedErr: NewNotFoundError(),
+		},
+		{
+			name:           "fails with message not found",
+			userId:         1,
+			roomId:         mockRoom.ExternalId,
+			seqId:          "2",
+			mockMessageErr: sql.ErrNoRows,
+			expectedErr:    NewNotFoundError(),
+		},
+		{
+			name:        "fails with message already deleted",
+			userId:      1,
+			roomId:      mockRoom.ExternalId,
+			seqId:       "2",
+			mockMessage: database.Message{Id: 5, SeqId: 2, RoomId: 1, UserId: 1, Deleted: true},
+			expectedErr: NewNotFoundError(),
+		},
+		{
+			name:        "fails with forbidden",
+			userId:      2,
+			roomId:      mockRoom.ExternalId,
+			seqId:       "2",
+			mockMessage: database.Message{Id: 5, SeqId: 2, RoomId: 1, UserId: 1},
+			expectedErr: NewForbiddenError(),
+		},
+		{
+			name:             "fails with db error on delete",
+			userId:           1,
+			roomId:           mockRoom.ExternalId,
+			seqId:            "2",
+			mockMessage:      database.Message{Id: 5, SeqId: 2, RoomId: 1, UserId: 1},
+			mockDeleteErr:    errors.New("db error"),
+			expectDeleteCall: true,
+			expectedErr:      NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.mockMessage.Id != 0 || tc.mockRoomErr != nil || tc.mockMessageErr != nil {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, tc.roomId).Return(mockRoom, tc.mockRoomErr).Once()
+				if tc.mockRoomErr == nil {
+					mockRepo.On("GetMessage", mock.Anything, mockRoom.Id, 2).Return(tc.mockMessage, tc.mockMessageErr).Once()
+				}
+				if !tc.mockMessage.Deleted && tc.mockMessage.Id != 0 && tc.mockMessage.UserId != tc.userId && tc.userId != mockRoom.OwnerId {
+					mockRepo.On("GetSubscriptionRole", mock.Anything, tc.userId, mockRoom.Id).Return(mockRoles[tc.userId], nil).Once()
+				}
+			}
+			if tc.expectDeleteCall {
+				mockRepo.On("DeleteMessage", mock.Anything, mockRoom.Id, 2).Return(tc.mockDeleteErr).Once()
+				if tc.mockDeleteErr == nil {
+					expectNotifySubscribers(mockRepo, mockRoom)
+				}
+			}
+
+			su := &stats.MockStatsUpdater{}
+			su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
+			cs, err := server.NewChatServer(log.Default(), mockRepo, su, nil)
+			assert.NoError(t, err, "failed to create chat server")
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/messages?room_id=%s&seq_id=%s", tc.roomId, tc.seqId), nil)
+			if tc.userId > 0 {
+				req = req.WithContext(WithUserId(req.Context(), tc.userId))
+			}
+
+			rr := httptest.NewRecorder()
+			app.deleteMessage(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusNoContent, rr.Code)
+		})
+	}
+}
+
+func Test_listPins(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz", Kind: database.RoomKindPublic}
+	pinnedAt := time.Date(2025, time.June, 28, 11, 17, 54, 0, time.UTC)
+	mockPins := []database.Pin{
+		{
+			Id:       1,
+			RoomId:   1,
+			PinnedBy: 3,
+			Message: database.Message{
+				Id:          5,
+				SeqId:       2,
+				RoomId:      1,
+				UserId:      1,
+				Content:     "see the runbook",
+				ContentHTML: "<p>see the runbook</p>",
+				CreatedAt:   pinnedAt,
+				UpdatedAt:   pinnedAt,
+			},
+			CreatedAt: pinnedAt,
+		},
+	}
+
+	tcases := []struct {
+		name          string
+		room          database.Room
+		mockRoomErr   error
+		subscribed    bool
+		mockPinsErr   error
+		expectedPins  []types.Pin
+		expectedError *ApiError
+	}{
+		{
+			name: "lists pins",
+			room: mockRoom,
+			expectedPins: []types.Pin{
+				{
+					Message: types.Message{
+						SeqId:       2,
+						RoomId:      1,
+						UserId:      1,
+						Content:     "see the runbook",
+						ContentHTML: "<p>see the runbook</p>",
+						Timestamp:   pinnedAt,
+					},
+					PinnedBy: 3,
+					PinnedAt: pinnedAt,
+				},
+			},
+		},
+		{
+			name:          "room not found",
+			room:          mockRoom,
+			mockRoomErr:   sql.ErrNoRows,
+			expectedError: NewNotFoundError(),
+		},
+		{
+			name:          "private room without subscription",
+			room:          database.Room{Id: 1, ExternalId: "EoGKUXPHgz", Kind: database.RoomKindPrivate},
+			expectedError: NewNotFoundError(),
+		},
+		{
+			name:          "db error",
+			room:          mockRoom,
+			mockPinsErr:   errors.New("db error"),
+			expectedError: NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			mockRepo.On("GetRoomByExternalId", mock.Anything, tc.room.ExternalId).Return(tc.room, tc.mockRoomErr).Once()
+			if tc.room.Kind == database.RoomKindPrivate {
+				mockRepo.On("SubscriptionExists", mock.Anything, 1, tc.room.Id).Return(tc.subscribed).Once()
+			}
+			if tc.mockRoomErr == nil && (tc.room.Kind != database.RoomKindPrivate || tc.subscribed) {
+				mockRepo.On("ListPins", mock.Anything, tc.room.Id).Return(mockPins, tc.mockPinsErr).Once()
+			}
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/rooms/"+tc.room.ExternalId+"/pins", nil)
+			req.SetPathValue("id", tc.room.ExternalId)
+			req = req.WithContext(WithUserId(req.Context(), 1))
+
+			rr := httptest.NewRecorder()
+			app.listPins(rr, req)
+
+			if tc.expectedError != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedError.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedError, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusOK, rr.Code, "expected status code to be 200")
+
+			var pins []types.Pin
+			err := json.NewDecoder(rr.Body).Decode(&pins)
+			assert.NoError(t, err, "failed to decode response body")
+			assert.Equal(t, tc.expectedPins, pins, "expected pins to match")
+		})
+	}
+}
+
+func Test_pinMessage(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz", OwnerId: 3}
+	mockRoles := map[int]string{2: database.RoleMember, 3: database.RoleOwner, 4: database.RoleAdmin}
+	pinnedAt := time.Date(2025, time.June, 28, 11, 17, 54, 0, time.UTC)
+
+	tcases := []struct {
+		name           string
+		userId         int
+		body           string
+		mockMessage    database.Message
+		mockMessageErr error
+		mockPinErr     error
+		expectPinCall  bool
+		expectedErr    *ApiError
+	}{
+		{
+			name:          "owner pins message",
+			userId:        3,
+			body:          `{"seq_id": 2}`,
+			mockMessage:   database.Message{Id: 5, SeqId: 2, RoomId: 1, UserId: 1, Content: "runbook"},
+			expectPinCall: true,
+		},
+		{
+			name:          "admin pins message",
+			userId:        4,
+			body:          `{"seq_id": 2}`,
+			mockMessage:   database.Message{Id: 5, SeqId: 2, RoomId: 1, UserId: 1, Content: "runbook"},
+			expectPinCall: true,
+		},
+		{
+			name:        "fails with unauthorized",
+			userId:      0,
+			body:        `{"seq_id": 2}`,
+			expectedErr: NewUnauthorizedError(),
+		},
+		{
+			name:        "fails with invalid seq_id",
+			userId:      3,
+			body:        `{"seq_id": 0}`,
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with forbidden",
+			userId:      2,
+			body:        `{"seq_id": 2}`,
+			expectedErr: NewForbiddenError(),
+		},
+		{
+			name:           "fails with message not found",
+			userId:         3,
+			body:           `{"seq_id": 2}`,
+			mockMessageErr: sql.ErrNoRows,
+			expectedErr:    NewNotFoundError(),
+		},
+		{
+			name:        "fails with message deleted",
+			userId:      3,
+			body:        `{"seq_id": 2}`,
+			mockMessage: database.Message{Id: 5, SeqId: 2, RoomId: 1, UserId: 1, Deleted: true},
+			expectedErr: NewNotFoundError(),
+		},
+		{
+			name:          "fails with message already pinned",
+			userId:        3,
+			body:          `{"seq_id": 2}`,
+			mockMessage:   database.Message{Id: 5, SeqId: 2, RoomId: 1, UserId: 1, Content: "runbook"},
+			mockPinErr:    sql.ErrNoRows,
+			expectPinCall: true,
+			expectedErr:   NewConflictError(),
+		},
+		{
+			name:          "fails with db error on pin",
+			userId:        3,
+			body:          `{"seq_id": 2}`,
+			mockMessage:   database.Message{Id: 5, SeqId: 2, RoomId: 1, UserId: 1, Content: "runbook"},
+			mockPinErr:    errors.New("db error"),
+			expectPinCall: true,
+			expectedErr:   NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.userId > 0 && tc.body != `{"seq_id": 0}` {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, mockRoom.ExternalId).Return(mockRoom, nil).Once()
+				if tc.userId != mockRoom.OwnerId {
+					mockRepo.On("GetSubscriptionRole", mock.Anything, tc.userId, mockRoom.Id).Return(mockRoles[tc.userId], nil).Once()
+				}
+				if database.RoleAtLeast(mockRoles[tc.userId], database.RoleAdmin) {
+					mockRepo.On("GetMessage", mock.Anything, mockRoom.Id, 2).Return(tc.mockMessage, tc.mockMessageErr).Once()
+				}
+			}
+			if tc.expectPinCall {
+				mockRepo.On("PinMessage", mock.Anything, database.PinParams{RoomId: mockRoom.Id, SeqId: 2, PinnedBy: tc.userId}).
+					Return(database.Pin{Id: 1, RoomId: mockRoom.Id, PinnedBy: tc.userId, CreatedAt: pinnedAt}, tc.mockPinErr).Once()
+				if tc.mockPinErr == nil {
+					expectNotifySubscribers(mockRepo, mockRoom)
+				}
+			}
+
+			su := &stats.MockStatsUpdater{}
+			su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
+			cs, err := server.NewChatServer(log.Default(), mockRepo, su, nil)
+			assert.NoError(t, err, "failed to create chat server")
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/rooms/"+mockRoom.ExternalId+"/pins", strings.NewReader(tc.body))
+			req.SetPathValue("id", mockRoom.ExternalId)
+			if tc.userId > 0 {
+				req = req.WithContext(WithUserId(req.Context(), tc.userId))
+			}
+
+			rr := httptest.NewRecorder()
+			app.pinMessage(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusCreated, rr.Code, "expected status code to be 201")
+
+			var pin types.Pin
+			err = json.NewDecoder(rr.Body).Decode(&pin)
+			assert.NoError(t, err, "failed to decode response body")
+			assert.Equal(t, types.Pin{
+				Message:  types.NewMessage(tc.mockMessage),
+				PinnedBy: tc.userId,
+				PinnedAt: pinnedAt,
+			}, pin, "expected pin to match")
+		})
+	}
+}
+
+func Test_unpinMessage(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz", OwnerId: 3}
+
+	tcases := []struct {
+		name        string
+		userId      int
+		seqId       string
+		role        string
+		expectUnpin bool
+		mockErr     error
+		expectedErr *ApiError
+	}{
+		{
+			name:        "unpins message",
+			userId:      3,
+			seqId:       "2",
+			role:        database.RoleOwner,
+			expectUnpin: true,
+		},
+		{
+			name:        "fails with unauthorized",
+			seqId:       "2",
+			expectedErr: NewUnauthorizedError(),
+		},
+		{
+			name:        "fails with invalid seq_id",
+			userId:      3,
+			seqId:       "abc",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with forbidden",
+			userId:      2,
+			seqId:       "2",
+			role:        database.RoleMember,
+			expectedErr: NewForbiddenError(),
+		},
+		{
+			name:        "fails when message is not pinned",
+			userId:      3,
+			seqId:       "2",
+			role:        database.RoleOwner,
+			expectUnpin: true,
+			mockErr:     sql.ErrNoRows,
+			expectedErr: NewNotFoundError(),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.role != "" {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, mockRoom.ExternalId).Return(mockRoom, nil).Once()
+				if tc.userId != mockRoom.OwnerId {
+					mockRepo.On("GetSubscriptionRole", mock.Anything, tc.userId, mockRoom.Id).Return(tc.role, nil).Once()
+				}
+			}
+			if tc.expectUnpin {
+				mockRepo.On("UnpinMessage", mock.Anything, mockRoom.Id, 2).Return(tc.mockErr).Once()
+				if tc.mockErr == nil {
+					expectNotifySubscribers(mockRepo, mockRoom)
+				}
+			}
+
+			su := &stats.MockStatsUpdater{}
+			su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
+			cs, err := server.NewChatServer(log.Default(), mockRepo, su, nil)
+			assert.NoError(t, err, "failed to create chat server")
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/rooms/"+mockRoom.ExternalId+"/pins/"+tc.seqId, nil)
+			req.SetPathValue("id", mockRoom.ExternalId)
+			req.SetPathValue("seq_id", tc.seqId)
+			if tc.userId > 0 {
+				req = req.WithContext(WithUserId(req.Context(), tc.userId))
+			}
+
+			rr := httptest.NewRecorder()
+			app.unpinMessage(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusNoContent, rr.Code)
+		})
+	}
+}
+
+func Test_searchMessages(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz"}
+	createdAt := time.Date(2025, time.June, 28, 11, 17, 54, 0, time.UTC)
+	mockMessages := []database.Message{
+		{
+			Id:             42,
+			SeqId:          7,
+			RoomId:         1,
+			UserId:         2,
+			Content:        "we decided to ship on friday",
+			ExternalRoomId: mockRoom.ExternalId,
+			Snippet:        "we <mark>decided</mark> to ship on friday",
+			CreatedAt:      createdAt,
+			UpdatedAt:      createdAt,
+		},
+	}
+	expectedMessages := []types.Message{
+		{
+			SeqId:          7,
+			RoomId:         1,
+			UserId:         2,
+			Content:        "we decided to ship on friday",
+			ExternalRoomId: mockRoom.ExternalId,
+			Snippet:        "we <mark>decided</mark> to ship on friday",
+			Timestamp:      createdAt,
+		},
+	}
+
+	tcases := []struct {
+		name         string
+		query        string
+		expectRoom   bool
+		mockRoomErr  error
+		expectParams *database.SearchMessagesParams
+		mockErr      error
+		expected     types.SearchResults
+		expectedErr  *ApiError
+	}{
+		{
+			name:         "searches all subscribed rooms",
+			query:        "?q=decided",
+			expectParams: &database.SearchMessagesParams{AccountId: 1, Query: "decided", Limit: defaultSearchLimit},
+			expected:     types.SearchResults{Messages: expectedMessages},
+		},
+		{
+			name:         "searches a room with a cursor",
+			query:        "?q=decided&room_id=" + mockRoom.ExternalId + "&cursor=50&limit=1",
+			expectRoom:   true,
+			expectParams: &database.SearchMessagesParams{AccountId: 1, Query: "decided", RoomId: mockRoom.Id, Before: 50, Limit: 1},
+			expected:     types.SearchResults{Messages: expectedMessages, NextCursor: 42},
+		},
+		{
+			name:        "fails with empty query",
+			query:       "?q=+",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with invalid cursor",
+			query:       "?q=decided&cursor=abc",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with limit above maximum",
+			query:       fmt.Sprintf("?q=decided&limit=%d", maxSearchLimit+1),
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with room not found",
+			query:       "?q=decided&room_id=" + mockRoom.ExternalId,
+			expectRoom:  true,
+			mockRoomErr: sql.ErrNoRows,
+			expectedErr: NewNotFoundError(),
+		},
+		{
+			name:         "fails with db error",
+			query:        "?q=decided",
+			expectParams: &database.SearchMessagesParams{AccountId: 1, Query: "decided", Limit: defaultSearchLimit},
+			mockErr:      errors.New("db error"),
+			expectedErr:  NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.expectRoom {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, mockRoom.ExternalId).Return(mockRoom, tc.mockRoomErr).Once()
+			}
+			if tc.expectParams != nil {
+				mockRepo.On("SearchMessages", mock.Anything, *tc.expectParams).Return(mockMessages, tc.mockErr).Once()
+			}
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/search"+tc.query, nil)
+			req = req.WithContext(WithUserId(req.Context(), 1))
+
+			rr := httptest.NewRecorder()
+			app.searchMessages(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			var results types.SearchResults
+			err := json.NewDecoder(rr.Body).Decode(&results)
+			assert.NoError(t, err, "failed to decode response")
+			assert.Equal(t, tc.expected, results)
+		})
+	}
+}
+
+func Test_listMentions(t *testing.T) {
+	createdAt := time.Date(2025, time.June, 28, 11, 17, 54, 0, time.UTC)
+	mockMentions := []database.Mention{
+		{
+			Id:        12,
+			AccountId: 1,
+			CreatedAt: createdAt,
+			Message: database.Message{
+				Id:             42,
+				SeqId:          7,
+				RoomId:         1,
+				UserId:         2,
+				Content:        "@testuser can you review this?",
+				ExternalRoomId: "EoGKUXPHgz",
+				CreatedAt:      createdAt,
+				UpdatedAt:      createdAt,
+			},
+		},
+	}
+	expectedMentions := []types.Mention{
+		{
+			Id:        12,
+			CreatedAt: createdAt,
+			Message: types.Message{
+				SeqId:          7,
+				RoomId:         1,
+				UserId:         2,
+				Content:        "@testuser can you review this?",
+				ExternalRoomId: "EoGKUXPHgz",
+				Timestamp:      createdAt,
+			},
+		},
+	}
+
+	tcases := []struct {
+		name         string
+		query        string
+		expectParams *database.ListMentionsParams
+		mockErr      error
+		expected     types.Mentions
+		expectedErr  *ApiError
+	}{
+		{
+			name:         "lists recent mentions",
+			expectParams: &database.ListMentionsParams{AccountId: 1, Limit: defaultMentionsLimit},
+			expected:     types.Mentions{Mentions: expectedMentions},
+		},
+		{
+			name:         "lists mentions with a cursor",
+			query:        "?cursor=50&limit=1",
+			expectParams: &database.ListMentionsParams{AccountId: 1, Before: 50, Limit: 1},
+			expected:     types.Mentions{Mentions: expectedMentions, NextCursor: 12},
+		},
+		{
+			name:        "fails with invalid cursor",
+			query:       "?cursor=-1",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with limit above maximum",
+			query:       fmt.Sprintf("?limit=%d", maxMentionsLimit+1),
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:         "fails with db error",
+			expectParams: &database.ListMentionsParams{AccountId: 1, Limit: defaultMentionsLimit},
+			mockErr:      errors.New("db error"),
+			expectedErr:  NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.expectParams != nil {
+				mockRepo.On("ListMentions", mock.Anything, *tc.expectParams).Return(mockMentions, tc.mockErr).Once()
+			}
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/mentions"+tc.query, nil)
+			req = req.WithContext(WithUserId(req.Context(), 1))
+
+			rr := httptest.NewRecorder()
+			app.listMentions(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			var results types.Mentions
+			err := json.NewDecoder(rr.Body).Decode(&results)
+			assert.NoError(t, err, "failed to decode response")
+			assert.Equal(t, tc.expected, results)
+		})
+	}
+}
+
+// newMultipartBody returns a multipart form with a single file field and its content type.
+func newMultipartBody(t *testing.T, field, filename string, content []byte) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile(field, filename)
+	assert.NoError(t, err, "failed to create form file")
+	_, err = fw.Write(content)
+	assert.NoError(t, err, "failed to write form file")
+	assert.NoError(t, mw.Close(), "failed to close multipart writer")
+
+	return body, mw.FormDataContentType()
+}
+
+func Test_uploadAttachment(t *testing.T) {
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz"}
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	createdAt := time.Date(2025, time.June, 28, 11, 17, 54, 0, time.UTC)
+
+	tcases := []struct {
+		name         string
+		userRole     string
+		field        string
+		filename     string
+		content      []byte
+		expectParams *database.CreateAttachmentParams
+		mockErr      error
+		expected     types.Attachment
+		expectedErr  *ApiError
+	}{
+		{
+			name:     "uploads image",
+			userRole: database.RoleMember,
+			field:    "file",
+			filename: "screenshot.png",
+			content:  png,
+			expectParams: &database.CreateAttachmentParams{
+				ExternalId:  "aB3dE5fG7h",
+				RoomId:      mockRoom.Id,
+				AccountId:   1,
+				Filename:    "screenshot.png",
+				ContentType: "image/png",
+				Size:        int64(len(png)),
+			},
+			expected: types.Attachment{
+				Id:          "aB3dE5fG7h",
+				Filename:    "screenshot.png",
+				ContentType: "image/png",
+				Size:        int64(len(png)),
+				URL:         "/api/attachments/aB3dE5fG7h",
+			},
+		},
+		{
+			name:     "strips directories from file name",
+			userRole: database.RoleMember,
+			field:    "file",
+			filename: "../../server.log",
+			content:  []byte("panic: runtime error"),
+			expectParams: &database.CreateAttachmentParams{
+				ExternalId:  "aB3dE5fG7h",
+				RoomId:      mockRoom.Id,
+				AccountId:   1,
+				Filename:    "server.log",
+				ContentType: "text/plain; charset=utf-8",
+				Size:        20,
+			},
+			expected: types.Attachment{
+				Id:          "aB3dE5fG7h",
+				Filename:    "server.log",
+				ContentType: "text/plain; charset=utf-8",
+				Size:        20,
+				URL:         "/api/attachments/aB3dE5fG7h",
+			},
+		},
+		{
+			name:        "fails when user is not subscribed",
+			field:       "file",
+			filename:    "screenshot.png",
+			content:     png,
+			expectedErr: NewForbiddenError(),
+		},
+		{
+			name:        "fails without file field",
+			userRole:    database.RoleMember,
+			field:       "image",
+			filename:    "screenshot.png",
+			content:     png,
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with empty file",
+			userRole:    database.RoleMember,
+			field:       "file",
+			filename:    "empty.txt",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "fails with file too large",
+			userRole:    database.RoleMember,
+			field:       "file",
+			filename:    "huge.log",
+			content:     bytes.Repeat([]byte("a"), maxAttachmentSize+1),
+			expectedErr: NewRequestEntityTooLargeError(),
+		},
+		{
+			name:     "fails with db error",
+			userRole: database.RoleMember,
+			field:    "file",
+			filename: "screenshot.png",
+			content:  png,
+			expectParams: &database.CreateAttachmentParams{
+				ExternalId:  "aB3dE5fG7h",
+				RoomId:      mockRoom.Id,
+				AccountId:   1,
+				Filename:    "screenshot.png",
+				ContentType: "image/png",
+				Size:        int64(len(png)),
+			},
+			mockErr:     errors.New("db error"),
+			expectedErr: NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			mockRepo.On("GetRoomByExternalId", mock.Anything, mockRoom.ExternalId).Return(mockRoom, nil).Once()
+			var roleErr error
+			if tc.userRole == "" {
+				roleErr = sql.ErrNoRows
+			}
+			mockRepo.On("GetSubscriptionRole", mock.Anything, 1, mockRoom.Id).Return(tc.userRole, roleErr).Once()
+			if tc.expectParams != nil {
+				mockRepo.On("CreateAttachment", mock.Anything, *tc.expectParams).Return(database.Attachment{
+					Id:          1,
+					ExternalId:  tc.expectParams.ExternalId,
+					RoomId:      tc.expectParams.RoomId,
+					AccountId:   tc.expectParams.AccountId,
+					Filename:    tc.expectParams.Filename,
+					ContentType: tc.expectParams.ContentType,
+					Size:        tc.expectParams.Size,
+					CreatedAt:   createdAt,
+				}, tc.mockErr).Once()
+			}
+
+			blobs, err := blob.NewLocalStore(t.TempDir())
+			assert.NoError(t, err, "failed to create blob store")
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, blobs, &config.Config{})
+			app.generateShortId = func() (string, error) {
+				return "aB3dE5fG7h", nil
+			}
+
+			body, contentType := newMultipartBody(t, tc.field, tc.filename, tc.content)
+			req := httptest.NewRequest(http.MethodPost, "/api/rooms/"+mockRoom.ExternalId+"/attachments", body)
+			req.Header.Set("Content-Type", contentType)
+			req.SetPathValue("id", mockRoom.ExternalId)
+			req = req.WithContext(WithUserId(req.Context(), 1))
+
+			rr := httptest.NewRecorder()
+			app.uploadAttachment(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+
+				// nothing is left in the blob store after a failed upload
+				_, err = blobs.Get(req.Context(), "aB3dE5fG7h")
+				assert.ErrorIs(t, err, blob.ErrNotFound, "expected blob to be deleted")
+				return
+			}
+
+			assert.Equal(t, http.StatusCreated, rr.Code)
+			var attachment types.Attachment
+			err = json.NewDecoder(rr.Body).Decode(&attachment)
+			assert.NoError(t, err, "failed to decode response")
+			assert.Equal(t, tc.expected, attachment)
+
+			rc, err := blobs.Get(req.Context(), "aB3dE5fG7h")
+			if assert.NoError(t, err, "expected blob to be stored") {
+				defer rc.Close()
+				var stored bytes.Buffer
+				stored.ReadFrom(rc)
+				assert.Equal(t, tc.content, stored.Bytes(), "expected stored content to match upload")
+			}
+		})
+	}
+}
+
+func Test_getAttachment(t *testing.T) {
+	content := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	attachment := database.Attachment{
+		Id:          1,
+		ExternalId:  "aB3dE5fG7h",
+		RoomId:      1,
+		AccountId:   2,
+		SeqId:       7,
+		Filename:    "screenshot.png",
+		ContentType: "image/png",
+		Size:        int64(len(content)),
+	}
+
+	tcases := []struct {
+		name               string
+		attachment         database.Attachment
+		mockErr            error
+		expectSubscription bool
+		subscribed         bool
+		storeBlob          bool
+		expectedHeaders    map[string]string
+		expectedErr        *ApiError
+	}{
+		{
+			name:               "downloads image inline",
+			attachment:         attachment,
+			expectSubscription: true,
+			subscribed:         true,
+			storeBlob:          true,
+			expectedHeaders: map[string]string{
+				"Content-Type":           "image/png",
+				"Content-Disposition":    `inline; filename=screenshot.png`,
+				"X-Content-Type-Options": "nosniff",
+			},
 		},
 		{
-			name:                       "successfully retrieves messages with limit",
-			roomId:                     "EoGKUXPHgz",
-			userId:                     1,
-			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
-			mockGetRoomByExternalIdErr: nil,
-			mockMessages: func() []database.Message {
-				messages := make([]database.Message, 0, 2)
-				messages = append(messages, mockMessages[:2]...)
-				return messages
-			}(),
-			mockGetMessagesErr: nil,
-			limit:              "2",
-			expected: func() []types.Message {
-				messages := make([]types.Message, 0, 2)
-				for _, msg := range mockMessages[:2] {
-					messages = append(messages, types.Message{
-						SeqId:     msg.SeqId,
-						RoomId:    msg.RoomId,
-						UserId:    msg.UserId,
-						Content:   msg.Content,
-						Timestamp: msg.CreatedAt,
-					})
-				}
-				return messages
+			name: "downloads other files as attachments",
+			attachment: func() database.Attachment {
+				a := attachment
+				a.Filename = "page.html"
+				a.ContentType = "text/html; charset=utf-8"
+				return a
 			}(),
+			expectSubscription: true,
+			subscribed:         true,
+			storeBlob:          true,
+			expectedHeaders: map[string]string{
+				"Content-Type":        "text/html; charset=utf-8",
+				"Content-Disposition": `attachment; filename=page.html`,
+			},
 		},
 		{
-			name:                       "successfully retrieves messages with before",
-			roomId:                     "EoGKUXPHgz",
-			userId:                     1,
-			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
-			mockGetRoomByExternalIdErr: nil,
-			mockMessages: func() []database.Message {
-				messages := make([]database.Message, 0, 2)
-				messages = append(messages, mockMessages[1:]...)
-				return messages
-			}(),
-			mockGetMessagesErr: nil,
-			before:             "3", // Get messages before SeqId 3
-			expected: func() []types.Message {
-				messages := make([]types.Message, 0, 2)
-				for _, msg := range mockMessages[1:] { // Exclude the message with SeqId 3
-					messages = append(messages, types.Message{
-						SeqId:     msg.SeqId,
-						RoomId:    msg.RoomId,
-						UserId:    msg.UserId,
-						Content:   msg.Content,
-						Timestamp: msg.CreatedAt,
-					})
-				}
-				return messages
-			}(),
+			name:               "fails when user is not subscribed",
+			attachment:         attachment,
+			expectSubscription: true,
+			expectedErr:        NewForbiddenError(),
 		},
 		{
-			name:                       "missing room_id query parameter",
-			userId:                     1,
-			mockRoom:                   database.Room{},
-			mockGetRoomByExternalIdErr: nil,
-			mockMessages:               nil,
-			mockGetMessagesErr:         nil,
-			expected:                   nil,
-			expectedErr:                NewBadRequestError(),
+			name: "fails when attachment is unattached and uploaded by another user",
+			attachment: func() database.Attachment {
+				a := attachment
+				a.SeqId = 0
+				return a
+			}(),
+			expectedErr: NewNotFoundError(),
 		},
 		{
-			name:                       "room not found",
-			roomId:                     "nonexistent",
-			userId:                     1,
-			mockRoom:                   database.Room{},
-			mockGetRoomByExternalIdErr: sql.ErrNoRows,
-			mockMessages:               nil,
-			mockGetMessagesErr:         nil,
-			expected:                   nil,
-			expectedErr:                NewNotFoundError(),
+			name:        "fails with attachment not found",
+			mockErr:     sql.ErrNoRows,
+			expectedErr: NewNotFoundError(),
 		},
 		{
-			name:                       "GetExternalId db error",
-			roomId:                     "EoGKUXPHgz",
-			userId:                     1,
-			mockRoom:                   database.Room{},
-			mockGetRoomByExternalIdErr: errors.New("db error"),
-			mockMessages:               nil,
-			mockGetMessagesErr:         nil,
-			expected:                   nil,
-			expectedErr:                NewInternalServerError(nil),
+			name:               "fails with blob not found",
+			attachment:         attachment,
+			expectSubscription: true,
+			subscribed:         true,
+			expectedErr:        NewNotFoundError(),
 		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			mockRepo.On("GetAttachment", mock.Anything, "aB3dE5fG7h").Return(tc.attachment, tc.mockErr).Once()
+			if tc.expectSubscription {
+				mockRepo.On("SubscriptionExists", mock.Anything, 1, tc.attachment.RoomId).Return(tc.subscribed).Once()
+			}
+
+			blobs, err := blob.NewLocalStore(t.TempDir())
+			assert.NoError(t, err, "failed to create blob store")
+			if tc.storeBlob {
+				_, err := blobs.Put(context.Background(), "aB3dE5fG7h", bytes.NewReader(content))
+				assert.NoError(t, err, "failed to store blob")
+			}
+
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, blobs, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/attachments/aB3dE5fG7h", nil)
+			req.SetPathValue("id", "aB3dE5fG7h")
+			req = req.WithContext(WithUserId(req.Context(), 1))
+
+			rr := httptest.NewRecorder()
+			app.getAttachment(rr, req)
+
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
+			}
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			for header, value := range tc.expectedHeaders {
+				assert.Equal(t, value, rr.Header().Get(header), "expected %s header to match", header)
+			}
+			assert.Equal(t, content, rr.Body.Bytes(), "expected attachment content to match")
+		})
+	}
+}
+
+func Test_getThread(t *testing.T) {
+	fixedTime := time.Date(2025, time.June, 28, 11, 17, 54, 0, time.UTC)
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz"}
+	mockParent := database.Message{Id: 1, SeqId: 1, RoomId: 1, UserId: 1, Content: "parent", ReplyCount: 2, CreatedAt: fixedTime, UpdatedAt: fixedTime}
+	mockReplies := []database.Message{
+		{Id: 2, SeqId: 2, RoomId: 1, UserId: 2, Content: "first reply", ParentSeqId: 1, CreatedAt: fixedTime, UpdatedAt: fixedTime},
+		{Id: 4, SeqId: 4, RoomId: 1, UserId: 1, Content: "second reply", ParentSeqId: 1, CreatedAt: fixedTime, UpdatedAt: fixedTime},
+	}
+
+	tcases := []struct {
+		name           string
+		roomId         string
+		seqId          string
+		mockRoomErr    error
+		mockMessageErr error
+		mockThreadErr  error
+		expectedErr    *ApiError
+	}{
 		{
-			name:                       "GetMessages with no messages",
-			roomId:                     "EoGKUXPHgz",
-			userId:                     1,
-			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
-			mockGetRoomByExternalIdErr: nil,
-			mockMessages:               []database.Message{},
-			mockGetMessagesErr:         nil,
-			expected:                   []types.Message{},
-			expectedErr:                nil,
+			name:   "successfully retrieves thread",
+			roomId: mockRoom.ExternalId,
+			seqId:  "1",
 		},
 		{
-			name:                       "GetMessages db error",
-			roomId:                     "EoGKUXPHgz",
-			userId:                     1,
-			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
-			mockGetRoomByExternalIdErr: nil,
-			mockMessages:               nil,
-			mockGetMessagesErr:         errors.New("db error"),
-			expected:                   nil,
-			expectedErr:                NewInternalServerError(nil),
+			name:        "missing seq_id",
+			roomId:      mockRoom.ExternalId,
+			expectedErr: NewBadRequestError(),
 		},
 		{
-			name:                       "invalid after parameter",
-			roomId:                     "EoGKUXPHgz",
-			userId:                     1,
-			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
-			mockGetRoomByExternalIdErr: nil,
-			mockMessages:               nil,
-			mockGetMessagesErr:         nil,
-			after:                      "invalid", // Invalid after parameter
-			expected:                   nil,
-			expectedErr:                NewBadRequestError(),
+			name:        "room not found",
+			roomId:      mockRoom.ExternalId,
+			seqId:       "1",
+			mockRoomErr: sql.ErrNoRows,
+			expectedErr: NewNotFoundError(),
 		},
 		{
-			name:                       "invalid limit parameter",
-			roomId:                     "EoGKUXPHgz",
-			userId:                     1,
-			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
-			mockGetRoomByExternalIdErr: nil,
-			mockMessages:               nil,
-			mockGetMessagesErr:         nil,
-			limit:                      "invalid", // Invalid after parameter
-			expected:                   nil,
-			expectedErr:                NewBadRequestError(),
+			name:           "parent not found",
+			roomId:         mockRoom.ExternalId,
+			seqId:          "1",
+			mockMessageErr: sql.ErrNoRows,
+			expectedErr:    NewNotFoundError(),
 		},
 		{
-			name:                       "invalid before parameter",
-			roomId:                     "EoGKUXPHgz",
-			userId:                     1,
-			mockRoom:                   database.Room{Id: 1, ExternalId: "EoGKUXPHgz"},
-			mockGetRoomByExternalIdErr: nil,
-			mockMessages:               nil,
-			mockGetMessagesErr:         nil,
-			before:                     "invalid", // Invalid after parameter
-			expected:                   nil,
-			expectedErr:                NewBadRequestError(),
+			name:          "db error getting thread",
+			roomId:        mockRoom.ExternalId,
+			seqId:         "1",
+			mockThreadErr: errors.New("db error"),
+			expectedErr:   NewInternalServerError(nil),
 		},
 	}
 
@@ -1396,80 +4101,152 @@ func Test_getMessages(t *testing.T) {
 			mockRepo := &database.MockGoChatRepository{}
 			defer mockRepo.AssertExpectations(t)
 
-			if tc.mockRoom.Id != 0 || tc.mockGetRoomByExternalIdErr != nil {
-				mockRepo.On("GetRoomByExternalId", tc.roomId).Return(tc.mockRoom, tc.mockGetRoomByExternalIdErr).Once()
-			}
-
-			if tc.mockMessages != nil || tc.mockGetMessagesErr != nil {
-				// Convert query parameters to integers for the mock
-				// Note: limit, after, and before are optional, so they may be empty
-				var limitInt, afterInt, beforeInt int
-				var err error
-				if tc.limit != "" {
-					limitInt, err = strconv.Atoi(tc.limit)
-				}
-				if tc.after != "" {
-					afterInt, err = strconv.Atoi(tc.after)
+			if tc.seqId != "" {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, tc.roomId).Return(mockRoom, tc.mockRoomErr).Once()
+				if tc.mockRoomErr == nil {
+					mockRepo.On("GetMessage", mock.Anything, mockRoom.Id, 1).Return(mockParent, tc.mockMessageErr).Once()
 				}
-				if tc.before != "" {
-					beforeInt, err = strconv.Atoi(tc.before)
+				if tc.mockRoomErr == nil && tc.mockMessageErr == nil {
+					mockRepo.On("GetThread", mock.Anything, mockRoom.Id, mockParent.SeqId).Return(mockReplies, tc.mockThreadErr).Once()
 				}
-				assert.NoError(t, err, "failed to convert query parameters to integers")
-				mockRepo.On("GetMessages", tc.mockRoom.Id, afterInt, beforeInt, limitInt).Return(tc.mockMessages, tc.mockGetMessagesErr).Once()
 			}
 
-			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, &config.Config{})
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, nil, &config.Config{})
 
-			var queryString string
-			if tc.roomId != "" {
-				queryString = fmt.Sprintf("?room_id=%s", tc.roomId)
-			}
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/messages/thread?room_id=%s&seq_id=%s", tc.roomId, tc.seqId), nil)
+			req = req.WithContext(WithUserId(req.Context(), 1))
 
-			if tc.limit != "" {
-				queryString += fmt.Sprintf("&limit=%s", tc.limit)
-			}
+			rr := httptest.NewRecorder()
+			app.getThread(rr, req)
 
-			if tc.after != "" {
-				queryString += fmt.Sprintf("&after=%s", tc.after)
+			if tc.expectedErr != nil {
+				var apiErr ApiError
+				err := json.NewDecoder(rr.Body).Decode(&apiErr)
+				assert.NoError(t, err, "failed to decode error response")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
+				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
+				return
 			}
 
-			if tc.before != "" {
-				queryString += fmt.Sprintf("&before=%s", tc.before)
+			assert.Equal(t, http.StatusOK, rr.Code)
+			var thread types.Thread
+			err := json.NewDecoder(rr.Body).Decode(&thread)
+			assert.NoError(t, err, "failed to decode response")
+			assert.Equal(t, mockParent.SeqId, thread.Parent.SeqId, "expected parent seq id to match")
+			assert.Equal(t, mockParent.ReplyCount, thread.Parent.ReplyCount, "expected parent reply count to match")
+			assert.Len(t, thread.Replies, len(mockReplies), "expected number of replies to match")
+			for i := range thread.Replies {
+				assert.Equal(t, mockReplies[i].SeqId, thread.Replies[i].SeqId)
+				assert.Equal(t, mockReplies[i].Content, thread.Replies[i].Content)
+				assert.Equal(t, mockParent.SeqId, thread.Replies[i].ParentSeqId)
 			}
+		})
+	}
+}
 
-			req := httptest.NewRequest(http.MethodGet, "/api/messages"+queryString, nil)
+func Test_getMessageRevisions(t *testing.T) {
+	fixedTime := time.Date(2025, time.June, 28, 11, 17, 54, 0, time.UTC)
+	mockRoom := database.Room{Id: 1, ExternalId: "EoGKUXPHgz"}
+	mockMessage := database.Message{Id: 7, SeqId: 3, RoomId: 1, UserId: 1, Content: "hello world"}
+	mockRevisions := []database.MessageRevision{
+		{Id: 1, MessageId: 7, Content: "helo world", CreatedAt: fixedTime},
+		{Id: 2, MessageId: 7, Content: "hello wrld", CreatedAt: fixedTime.Add(time.Minute)},
+	}
 
-			if tc.userId > 0 {
-				ctx := WithUserId(req.Context(), tc.userId)
-				req = req.WithContext(ctx)
+	tcases := []struct {
+		name             string
+		roomId           string
+		seqId            string
+		mockRoomErr      error
+		mockMessageErr   error
+		mockRevisionsErr error
+		expected         []types.MessageRevision
+		expectedErr      *ApiError
+	}{
+		{
+			name:   "successfully retrieves revisions",
+			roomId: mockRoom.ExternalId,
+			seqId:  "3",
+			expected: []types.MessageRevision{
+				{Content: "helo world", Timestamp: fixedTime},
+				{Content: "hello wrld", Timestamp: fixedTime.Add(time.Minute)},
+			},
+		},
+		{
+			name:        "missing room_id",
+			seqId:       "3",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "invalid seq_id",
+			roomId:      mockRoom.ExternalId,
+			seqId:       "invalid",
+			expectedErr: NewBadRequestError(),
+		},
+		{
+			name:        "room not found",
+			roomId:      mockRoom.ExternalId,
+			seqId:       "3",
+			mockRoomErr: sql.ErrNoRows,
+			expectedErr: NewNotFoundError(),
+		},
+		{
+			name:           "message not found",
+			roomId:         mockRoom.ExternalId,
+			seqId:          "3",
+			mockMessageErr: sql.ErrNoRows,
+			expectedErr:    NewNotFoundError(),
+		},
+		{
+			name:             "db error getting revisions",
+			roomId:           mockRoom.ExternalId,
+			seqId:            "3",
+			mockRevisionsErr: errors.New("db error"),
+			expectedErr:      NewInternalServerError(nil),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &database.MockGoChatRepository{}
+			defer mockRepo.AssertExpectations(t)
+
+			if tc.expectedErr == nil || tc.mockRoomErr != nil || tc.mockMessageErr != nil || tc.mockRevisionsErr != nil {
+				mockRepo.On("GetRoomByExternalId", mock.Anything, tc.roomId).Return(mockRoom, tc.mockRoomErr).Once()
+				if tc.mockRoomErr == nil {
+					mockRepo.On("GetMessage", mock.Anything, mockRoom.Id, 3).Return(mockMessage, tc.mockMessageErr).Once()
+				}
+				if tc.mockRoomErr == nil && tc.mockMessageErr == nil {
+					mockRepo.On("GetMessageRevisions", mock.Anything, mockMessage.Id).Return(mockRevisions, tc.mockRevisionsErr).Once()
+				}
 			}
 
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), nil, mockRepo, nil, nil, &config.Config{})
+
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/messages/revisions?room_id=%s&seq_id=%s", tc.roomId, tc.seqId), nil)
+			req = req.WithContext(WithUserId(req.Context(), 1))
+
 			rr := httptest.NewRecorder()
-			app.getMessages(rr, req)
+			app.getMessageRevisions(rr, req)
 
 			if tc.expectedErr != nil {
 				var apiErr ApiError
 				err := json.NewDecoder(rr.Body).Decode(&apiErr)
 				assert.NoError(t, err, "failed to decode error response")
-				assert.Equal(t, rr.Code, tc.expectedErr.StatusCode, "expected status code to match")
+				assert.Equal(t, tc.expectedErr.StatusCode, rr.Code, "expected status code to match")
 				assert.Equal(t, *tc.expectedErr, apiErr, "expected ApiError response")
 				return
 			}
 
 			assert.Equal(t, http.StatusOK, rr.Code)
-			var messages []types.Message
-			err := json.NewDecoder(rr.Body).Decode(&messages)
-			assert.NoError(t, err, "failed to decode response: %v", err)
-			assert.Len(t, messages, len(tc.expected), "expected number of messages to match")
-			for i := range messages {
-				assert.Equal(t, tc.expected[i].UserId, messages[i].UserId)
-				assert.Equal(t, tc.expected[i].Content, messages[i].Content)
-				assert.Equal(t, tc.expected[i].SeqId, messages[i].SeqId)
-				assert.Equal(t, tc.expected[i].Timestamp, messages[i].Timestamp)
-			}
+			var revisions []types.MessageRevision
+			err := json.NewDecoder(rr.Body).Decode(&revisions)
+			assert.NoError(t, err, "failed to decode response")
+			assert.Equal(t, tc.expected, revisions, "expected revisions to match")
 		})
 	}
 }
+
 func Test_serveWs(t *testing.T) {
 	mockUser := database.User{
 		Id:           1,
@@ -1491,15 +4268,15 @@ func Test_serveWs(t *testing.T) {
 		su.On("Decr", "NumActiveClients").Return(nil).Maybe()
 		su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
 
-		cs, err := server.NewChatServer(log.Default(), mockRepo, su)
+		cs, err := server.NewChatServer(log.Default(), mockRepo, su, nil)
 		if err != nil {
 			t.Fatalf("failed to create chat server: %v", err)
 		}
 
-		mockRepo.On("GetAccountById", mockUser.Id).Return(mockUser, nil).Once()
-		mockRepo.On("ListSubscriptions", mockUser.Id).Return([]database.Subscription{}, nil).Once() // called during client registration
+		mockRepo.On("GetAccountById", mock.Anything, mockUser.Id).Return(mockUser, nil).Once()
+		mockRepo.On("ListSubscriptions", mock.Anything, mockUser.Id).Return([]database.Subscription{}, nil).Once() // called during client registration
 
-		app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, &config.Config{})
+		app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, nil, &config.Config{})
 
 		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := WithUserId(r.Context(), 1)
@@ -1560,12 +4337,12 @@ func Test_serveWs(t *testing.T) {
 			defer su.AssertExpectations(t)
 			su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
 
-			cs, err := server.NewChatServer(log.Default(), mockRepo, su)
+			cs, err := server.NewChatServer(log.Default(), mockRepo, su, nil)
 			assert.NoError(t, err, "failed to create chat server")
-			app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, &config.Config{})
+			app := NewGoChatApp(http.NewServeMux(), log.Default(), cs, mockRepo, nil, nil, &config.Config{})
 
 			if tc.mockUser != (database.User{}) || tc.mockErr != nil {
-				mockRepo.On("GetAccountById", tc.userId).Return(tc.mockUser, tc.mockErr).Once()
+				mockRepo.On("GetAccountById", mock.Anything, tc.userId).Return(tc.mockUser, tc.mockErr).Once()
 			}
 
 			req := httptest.NewRequest(http.MethodGet, "/api/ws", nil)
@@ -66,6 +66,7 @@ func Test_authMiddleware_ValidToken(t *testing.T) {
 		nil,
 		nil,
 		nil,
+		nil,
 		&config.Config{
 			SigningKey: []byte("test-signing-key"),
 		},
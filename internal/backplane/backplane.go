@@ -0,0 +1,25 @@
+// Package backplane delivers messages between the instances of a chat server,
+// so that clients connected to different instances can chat with each other.
+package backplane
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrClosed is returned when publishing to a closed backplane.
+var ErrClosed = errors.New("backplane closed")
+
+// Backplane publishes messages to every node of a cluster. Messages are delivered
+// at most once, including to the node that published them, and messages published
+// by a node are received in the order they were published. Implementations must be
+// safe for concurrent use.
+type Backplane interface {
+	// Publish sends payload to every node.
+	Publish(ctx context.Context, payload []byte) error
+	// Messages returns the channel payloads published by any node are received on.
+	// The channel is closed when the backplane is closed.
+	Messages() <-chan []byte
+	// Close stops receiving messages and releases the backplane's resources.
+	Close() error
+}
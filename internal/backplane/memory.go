@@ -0,0 +1,80 @@
+package backplane
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// memoryBufferSize is the number of messages a node can fall behind before publishing blocks.
+const memoryBufferSize = 256
+
+// Hub connects in-memory backplanes, standing in for a cluster of nodes in a single process.
+type Hub struct {
+	mu    sync.RWMutex
+	nodes []*Memory
+}
+
+// NewHub creates a Hub with no nodes.
+func NewHub() *Hub {
+	return &Hub{}
+}
+
+// Connect creates a backplane for a new node in the hub.
+func (h *Hub) Connect() *Memory {
+	m := &Memory{
+		hub:      h,
+		messages: make(chan []byte, memoryBufferSize),
+	}
+
+	h.mu.Lock()
+	h.nodes = append(h.nodes, m)
+	h.mu.Unlock()
+
+	return m
+}
+
+// Memory is a Backplane for a node connected to a Hub.
+type Memory struct {
+	hub      *Hub
+	messages chan []byte
+	closed   bool
+}
+
+func (m *Memory) Publish(ctx context.Context, payload []byte) error {
+	m.hub.mu.RLock()
+	defer m.hub.mu.RUnlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	for _, node := range m.hub.nodes {
+		select {
+		case node.messages <- slices.Clone(payload):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) Messages() <-chan []byte {
+	return m.messages
+}
+
+func (m *Memory) Close() error {
+	m.hub.mu.Lock()
+	defer m.hub.mu.Unlock()
+
+	if m.closed {
+		return nil
+	}
+
+	m.closed = true
+	m.hub.nodes = slices.DeleteFunc(m.hub.nodes, func(node *Memory) bool { return node == m })
+	close(m.messages)
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package backplane
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	hub := NewHub()
+	a, b := hub.Connect(), hub.Connect()
+	defer b.Close()
+
+	assert.NoError(t, a.Publish(ctx, []byte("first")), "expected no error publishing")
+	assert.NoError(t, a.Publish(ctx, []byte("second")), "expected no error publishing")
+
+	// every node receives the messages in order, including the publisher
+	for _, node := range []*Memory{a, b} {
+		for _, expected := range []string{"first", "second"} {
+			select {
+			case payload := <-node.Messages():
+				assert.Equal(t, expected, string(payload), "expected payload to match")
+			case <-time.After(time.Second):
+				t.Fatal("timeout: node did not receive message")
+			}
+		}
+	}
+
+	assert.NoError(t, a.Close(), "expected no error closing")
+	assert.NoError(t, a.Close(), "expected closing twice to be a no-op")
+	_, ok := <-a.Messages()
+	assert.False(t, ok, "expected messages channel to be closed")
+	assert.ErrorIs(t, a.Publish(ctx, []byte("third")), ErrClosed, "expected publishing to a closed backplane to fail")
+
+	// closed nodes no longer receive messages
+	assert.NoError(t, b.Publish(ctx, []byte("fourth")), "expected no error publishing")
+	assert.Equal(t, "fourth", string(<-b.Messages()), "expected payload to match")
+}
+
+func TestMemory_publishCanceled(t *testing.T) {
+	hub := NewHub()
+	a := hub.Connect()
+	defer a.Close()
+
+	for range memoryBufferSize {
+		assert.NoError(t, a.Publish(context.Background(), []byte("message")), "expected no error publishing")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, a.Publish(ctx, []byte("message")), context.DeadlineExceeded, "expected publishing to a full node to time out")
+}
@@ -0,0 +1,240 @@
+package backplane
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// chunkSize is the maximum length of the data in a notification. Notification
+	// payloads must be shorter than 8000 bytes, so larger messages are split up.
+	chunkSize = 7000
+	// minReconnectInterval and maxReconnectInterval bound how long the listener
+	// waits before reconnecting after losing its connection to the database.
+	minReconnectInterval = 100 * time.Millisecond
+	maxReconnectInterval = 10 * time.Second
+)
+
+// Postgres is a Backplane that uses PostgreSQL LISTEN/NOTIFY to deliver messages
+// between the nodes connected to the same database. Messages published while a
+// node is reconnecting to the database are lost.
+type Postgres struct {
+	channel  string
+	id       string
+	seq      atomic.Uint64
+	db       *sql.DB
+	listener *pq.Listener
+	log      *log.Logger
+	messages chan []byte
+	done     chan struct{}
+}
+
+// NewPostgres creates a Postgres backplane that publishes and listens on channel
+// in the database at dsn.
+func NewPostgres(logger *log.Logger, dsn, channel string) (*Postgres, error) {
+	if channel == "" {
+		return nil, fmt.Errorf("channel cannot be empty")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+
+	listener := pq.NewListener(dsn, minReconnectInterval, maxReconnectInterval, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Println("backplane listener:", err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		db.Close()
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		listener.Close()
+		db.Close()
+		return nil, fmt.Errorf("generate id: %w", err)
+	}
+
+	p := &Postgres{
+		channel:  channel,
+		id:       hex.EncodeToString(id),
+		db:       db,
+		listener: listener,
+		log:      logger,
+		messages: make(chan []byte, 256),
+		done:     make(chan struct{}),
+	}
+
+	go p.receive()
+
+	return p, nil
+}
+
+// Publish sends payload as one or more notifications in a single transaction,
+// so that they are delivered to listeners together.
+func (p *Postgres) Publish(ctx context.Context, payload []byte) error {
+	select {
+	case <-p.done:
+		return ErrClosed
+	default:
+	}
+
+	chunks := encodeChunks(p.id+"-"+strconv.FormatUint(p.seq.Add(1), 10), payload)
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, chunk := range chunks {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", p.channel, chunk); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (p *Postgres) Messages() <-chan []byte {
+	return p.messages
+}
+
+func (p *Postgres) Close() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+
+	close(p.done)
+	err := p.listener.Close()
+	if dbErr := p.db.Close(); err == nil {
+		err = dbErr
+	}
+
+	return err
+}
+
+// receive reassembles the notifications received by the listener into messages
+// until the backplane is closed.
+func (p *Postgres) receive() {
+	defer close(p.messages)
+
+	var a assembler
+	for {
+		select {
+		case n, ok := <-p.listener.Notify:
+			if !ok {
+				return
+			}
+			if n == nil {
+				// the listener reconnected, so notifications may have been missed
+				a.reset()
+				continue
+			}
+
+			payload, err := a.add(n.Extra)
+			if err != nil {
+				p.log.Println("backplane:", err)
+				continue
+			}
+			if payload == nil {
+				continue
+			}
+
+			select {
+			case p.messages <- payload:
+			case <-p.done:
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// encodeChunks encodes a payload as notifications of the form id:index:count:data,
+// where the data of all of the chunks is the base64 encoded payload.
+func encodeChunks(id string, payload []byte) []string {
+	data := base64.StdEncoding.EncodeToString(payload)
+
+	count := max(1, (len(data)+chunkSize-1)/chunkSize)
+	chunks := make([]string, 0, count)
+	for i := range count {
+		end := min(len(data), (i+1)*chunkSize)
+		chunks = append(chunks, fmt.Sprintf("%s:%d:%d:%s", id, i, count, data[i*chunkSize:end]))
+	}
+
+	return chunks
+}
+
+// assembler reassembles payloads from the chunks created by encodeChunks. The
+// notifications of a transaction are delivered together, so the chunks of one
+// payload are never interleaved with those of another.
+type assembler struct {
+	id     string
+	count  int
+	chunks []string
+}
+
+// add adds a chunk, and returns the payload once all of its chunks have been added.
+func (a *assembler) add(chunk string) ([]byte, error) {
+	parts := strings.SplitN(chunk, ":", 4)
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("malformed notification")
+	}
+
+	index, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed notification index: %w", err)
+	}
+	count, err := strconv.Atoi(parts[2])
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("malformed notification count")
+	}
+
+	if index == 0 {
+		a.id = parts[0]
+		a.count = count
+		a.chunks = a.chunks[:0]
+	} else if parts[0] != a.id || index != len(a.chunks) || count != a.count {
+		a.reset()
+		return nil, fmt.Errorf("notification %s is missing chunks", parts[0])
+	}
+
+	a.chunks = append(a.chunks, parts[3])
+	if len(a.chunks) < a.count {
+		return nil, nil
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(strings.Join(a.chunks, ""))
+	a.reset()
+	if err != nil {
+		return nil, fmt.Errorf("decode notification: %w", err)
+	}
+
+	return payload, nil
+}
+
+// reset discards any partially assembled payload.
+func (a *assembler) reset() {
+	a.id = ""
+	a.count = 0
+	a.chunks = a.chunks[:0]
+}
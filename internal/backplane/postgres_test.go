@@ -0,0 +1,80 @@
+package backplane
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_encodeChunks(t *testing.T) {
+	tcases := []struct {
+		name          string
+		payload       []byte
+		expectedCount int
+	}{
+		{
+			name:          "empty payload",
+			payload:       []byte{},
+			expectedCount: 1,
+		},
+		{
+			name:          "small payload",
+			payload:       []byte(`{"message":"hello"}`),
+			expectedCount: 1,
+		},
+		{
+			name:          "payload spanning chunks",
+			payload:       []byte(strings.Repeat("é", chunkSize)),
+			expectedCount: 3,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			chunks := encodeChunks("node-1", tc.payload)
+			assert.Len(t, chunks, tc.expectedCount, "expected number of chunks to match")
+
+			var a assembler
+			for i, chunk := range chunks {
+				assert.Less(t, len(chunk), 8000, "expected chunk to fit in a notification")
+
+				payload, err := a.add(chunk)
+				assert.NoError(t, err, "expected no error adding chunk")
+				if i < len(chunks)-1 {
+					assert.Nil(t, payload, "expected no payload before the last chunk")
+				} else {
+					assert.Equal(t, tc.payload, payload, "expected payload to be reassembled")
+				}
+			}
+		})
+	}
+}
+
+func Test_assembler(t *testing.T) {
+	first := encodeChunks("node-1", []byte(strings.Repeat("a", chunkSize)))
+	second := encodeChunks("node-2", []byte("hello"))
+
+	t.Run("discards incomplete payloads", func(t *testing.T) {
+		var a assembler
+		payload, err := a.add(first[0])
+		assert.NoError(t, err, "expected no error adding chunk")
+		assert.Nil(t, payload, "expected no payload")
+
+		// the rest of the first payload was missed
+		payload, err = a.add(second[0])
+		assert.NoError(t, err, "expected no error adding chunk")
+		assert.Equal(t, []byte("hello"), payload, "expected payload to match")
+
+		_, err = a.add(first[1])
+		assert.Error(t, err, "expected error adding chunk of discarded payload")
+	})
+
+	t.Run("malformed notifications", func(t *testing.T) {
+		for _, chunk := range []string{"", "node-1:0:1", "node-1:x:1:aGk=", "node-1:0:0:aGk=", "node-1:0:1:!!!"} {
+			var a assembler
+			_, err := a.add(chunk)
+			assert.Error(t, err, "expected error adding %q", chunk)
+		}
+	})
+}
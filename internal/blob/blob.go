@@ -0,0 +1,27 @@
+// Package blob stores binary data, such as the contents of attachments, by key.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrNotFound is returned when no blob is stored under a key.
+	ErrNotFound = errors.New("blob not found")
+	// ErrInvalidKey is returned when a key can't be used to store a blob.
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Store stores blobs by key. Implementations must be safe for concurrent use.
+type Store interface {
+	// Put stores the contents of r under key, replacing any existing blob,
+	// and returns the number of bytes stored.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Get returns a reader for the blob stored under key, which the caller must close.
+	// It returns ErrNotFound if there is no such blob.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
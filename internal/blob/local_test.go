@@ -0,0 +1,87 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLocalStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "attachments")
+
+	s, err := NewLocalStore(dir)
+	if !assert.NoError(t, err, "expected no error creating store") {
+		return
+	}
+	assert.Equal(t, dir, s.dir, "expected store directory to match")
+	assert.DirExists(t, dir, "expected directory to be created")
+
+	_, err = NewLocalStore("")
+	assert.Error(t, err, "expected error with empty directory")
+}
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLocalStore(t.TempDir())
+	if !assert.NoError(t, err, "expected no error creating store") {
+		return
+	}
+
+	n, err := s.Put(ctx, "abc123", strings.NewReader("hello, world"))
+	assert.NoError(t, err, "expected no error putting blob")
+	assert.Equal(t, int64(12), n, "expected number of bytes stored to match")
+
+	rc, err := s.Get(ctx, "abc123")
+	if !assert.NoError(t, err, "expected no error getting blob") {
+		return
+	}
+	content, err := io.ReadAll(rc)
+	rc.Close()
+	assert.NoError(t, err, "expected no error reading blob")
+	assert.Equal(t, "hello, world", string(content), "expected blob content to match")
+
+	assert.NoError(t, s.Delete(ctx, "abc123"), "expected no error deleting blob")
+	_, err = s.Get(ctx, "abc123")
+	assert.ErrorIs(t, err, ErrNotFound, "expected deleted blob to be missing")
+	assert.NoError(t, s.Delete(ctx, "abc123"), "expected no error deleting missing blob")
+
+	entries, err := os.ReadDir(s.dir)
+	assert.NoError(t, err, "expected no error reading directory")
+	assert.Empty(t, entries, "expected no files to be left behind")
+}
+
+func TestLocalStore_invalidKeys(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLocalStore(t.TempDir())
+	if !assert.NoError(t, err, "expected no error creating store") {
+		return
+	}
+
+	for _, key := range []string{"", ".", "..", "../secret", "a/b", `a\b`} {
+		t.Run(key, func(t *testing.T) {
+			_, err := s.Put(ctx, key, strings.NewReader("data"))
+			assert.ErrorIs(t, err, ErrInvalidKey, "expected put to fail")
+			_, err = s.Get(ctx, key)
+			assert.ErrorIs(t, err, ErrInvalidKey, "expected get to fail")
+			assert.ErrorIs(t, s.Delete(ctx, key), ErrInvalidKey, "expected delete to fail")
+		})
+	}
+}
+
+func TestLocalStore_cancelledContext(t *testing.T) {
+	s, err := NewLocalStore(t.TempDir())
+	if !assert.NoError(t, err, "expected no error creating store") {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = s.Put(ctx, "abc123", strings.NewReader("data"))
+	assert.ErrorIs(t, err, context.Canceled, "expected put to fail with cancelled context")
+}
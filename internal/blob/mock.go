@@ -0,0 +1,26 @@
+package blob
+
+import (
+	"context"
+	"io"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockStore struct {
+	mock.Mock
+}
+
+func (m *MockStore) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	args := m.Called(ctx, key, r)
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *MockStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	args := m.Called(ctx, key)
+	rc, _ := args.Get(0).(io.ReadCloser)
+	return rc, args.Error(1)
+}
+func (m *MockStore) Delete(ctx context.Context, key string) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
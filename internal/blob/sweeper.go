@@ -0,0 +1,107 @@
+package blob
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/npezzotti/go-chatroom/internal/database"
+)
+
+// sweepBatchSize is the maximum number of attachments removed from the store at once
+const sweepBatchSize = 100
+
+// Sweeper periodically removes the contents of deleted attachments from a store. Uploads
+// that are never attached to a message are deleted once they are older than maxAge.
+type Sweeper struct {
+	log      *log.Logger
+	db       database.GoChatRepository
+	store    Store
+	interval time.Duration
+	maxAge   time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewSweeper creates a Sweeper that sweeps the store every interval.
+func NewSweeper(logger *log.Logger, db database.GoChatRepository, store Store, interval, maxAge time.Duration) *Sweeper {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Sweeper{
+		log:      logger,
+		db:       db,
+		store:    store,
+		interval: interval,
+		maxAge:   maxAge,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+// Run starts sweeping the store in the background.
+func (s *Sweeper) Run() {
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := s.sweep(s.ctx); err != nil && s.ctx.Err() == nil {
+				s.log.Println("sweep attachments:", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops sweeping the store, canceling a sweep in progress.
+func (s *Sweeper) Stop() {
+	s.cancel()
+	<-s.done
+}
+
+// sweep deletes the expired uploads and removes the contents of every deleted attachment
+// from the store. Attachments whose contents can't be removed are retried on the next sweep.
+func (s *Sweeper) sweep(ctx context.Context) error {
+	if err := s.db.DeleteUnattachedAttachments(ctx, time.Now().UTC().Add(-s.maxAge)); err != nil {
+		return err
+	}
+
+	for {
+		attachments, err := s.db.ListDeletedAttachments(ctx, sweepBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(attachments) == 0 {
+			return nil
+		}
+
+		ids := make([]int, 0, len(attachments))
+		for _, a := range attachments {
+			if err := s.store.Delete(ctx, a.ExternalId); err != nil {
+				s.log.Printf("delete attachment %q: %v", a.ExternalId, err)
+				continue
+			}
+			ids = append(ids, a.Id)
+		}
+		if len(ids) == 0 {
+			// none of the contents could be removed, so the next batch would be the same
+			return nil
+		}
+
+		if err := s.db.PurgeAttachments(ctx, ids); err != nil {
+			return err
+		}
+		if len(attachments) < sweepBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/npezzotti/go-chatroom/internal/database"
+	"github.com/npezzotti/go-chatroom/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSweeper_sweep(t *testing.T) {
+	maxAge := 24 * time.Hour
+	deleted := []database.Attachment{
+		{Id: 1, ExternalId: "aB3dE5fG7h"},
+		{Id: 2, ExternalId: "hG7fE5dB3a"},
+	}
+	fullBatch := make([]database.Attachment, sweepBatchSize)
+	for i := range fullBatch {
+		fullBatch[i] = database.Attachment{Id: i + 10, ExternalId: fmt.Sprintf("batch%d", i)}
+	}
+
+	tcases := []struct {
+		name          string
+		unattachedErr error
+		setup         func(db *database.MockGoChatRepository, store *MockStore)
+		expectErr     bool
+	}{
+		{
+			name: "removes deleted attachments",
+			setup: func(db *database.MockGoChatRepository, store *MockStore) {
+				db.On("ListDeletedAttachments", mock.Anything, sweepBatchSize).Return(deleted, nil).Once()
+				store.On("Delete", mock.Anything, "aB3dE5fG7h").Return(nil).Once()
+				store.On("Delete", mock.Anything, "hG7fE5dB3a").Return(nil).Once()
+				db.On("PurgeAttachments", mock.Anything, []int{1, 2}).Return(nil).Once()
+			},
+		},
+		{
+			name: "nothing to remove",
+			setup: func(db *database.MockGoChatRepository, store *MockStore) {
+				db.On("ListDeletedAttachments", mock.Anything, sweepBatchSize).Return([]database.Attachment{}, nil).Once()
+			},
+		},
+		{
+			name: "keeps attachments whose contents can't be removed",
+			setup: func(db *database.MockGoChatRepository, store *MockStore) {
+				db.On("ListDeletedAttachments", mock.Anything, sweepBatchSize).Return(deleted, nil).Once()
+				store.On("Delete", mock.Anything, "aB3dE5fG7h").Return(errors.New("store error")).Once()
+				store.On("Delete", mock.Anything, "hG7fE5dB3a").Return(nil).Once()
+				db.On("PurgeAttachments", mock.Anything, []int{2}).Return(nil).Once()
+			},
+		},
+		{
+			name: "stops when no contents can be removed",
+			setup: func(db *database.MockGoChatRepository, store *MockStore) {
+				db.On("ListDeletedAttachments", mock.Anything, sweepBatchSize).Return(fullBatch, nil).Once()
+				store.On("Delete", mock.Anything, mock.Anything).Return(errors.New("store error")).Times(sweepBatchSize)
+			},
+		},
+		{
+			name: "sweeps until a batch isn't full",
+			setup: func(db *database.MockGoChatRepository, store *MockStore) {
+				db.On("ListDeletedAttachments", mock.Anything, sweepBatchSize).Return(fullBatch, nil).Once()
+				store.On("Delete", mock.Anything, mock.Anything).Return(nil).Times(sweepBatchSize + len(deleted))
+				db.On("PurgeAttachments", mock.Anything, mock.Anything).Return(nil).Twice()
+				db.On("ListDeletedAttachments", mock.Anything, sweepBatchSize).Return(deleted, nil).Once()
+			},
+		},
+		{
+			name:          "fails to delete unattached uploads",
+			unattachedErr: errors.New("db error"),
+			setup:         func(db *database.MockGoChatRepository, store *MockStore) {},
+			expectErr:     true,
+		},
+		{
+			name: "fails to purge attachments",
+			setup: func(db *database.MockGoChatRepository, store *MockStore) {
+				db.On("ListDeletedAttachments", mock.Anything, sweepBatchSize).Return(deleted, nil).Once()
+				store.On("Delete", mock.Anything, mock.Anything).Return(nil).Twice()
+				db.On("PurgeAttachments", mock.Anything, []int{1, 2}).Return(errors.New("db error")).Once()
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &database.MockGoChatRepository{}
+			defer db.AssertExpectations(t)
+			store := &MockStore{}
+			defer store.AssertExpectations(t)
+
+			before := time.Now().UTC().Add(-maxAge)
+			db.On("DeleteUnattachedAttachments", mock.Anything, mock.MatchedBy(func(createdBefore time.Time) bool {
+				return !createdBefore.Before(before) && createdBefore.Before(time.Now().UTC().Add(-maxAge+time.Minute))
+			})).Return(tc.unattachedErr).Once()
+			tc.setup(db, store)
+
+			s := NewSweeper(testutil.TestLogger(t), db, store, time.Hour, maxAge)
+			err := s.sweep(context.Background())
+			if tc.expectErr {
+				assert.Error(t, err, "expected error sweeping")
+			} else {
+				assert.NoError(t, err, "expected no error sweeping")
+			}
+		})
+	}
+}
+
+func TestSweeper_RunStop(t *testing.T) {
+	db := &database.MockGoChatRepository{}
+	store := &MockStore{}
+
+	swept := make(chan struct{}, 1)
+	db.On("DeleteUnattachedAttachments", mock.Anything, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+		select {
+		case swept <- struct{}{}:
+		default:
+		}
+	})
+	db.On("ListDeletedAttachments", mock.Anything, sweepBatchSize).Return([]database.Attachment{}, nil)
+
+	s := NewSweeper(testutil.TestLogger(t), db, store, time.Hour, time.Hour)
+	s.Run()
+
+	select {
+	case <-swept:
+	case <-time.After(time.Second):
+		t.Fatal("expected the store to be swept when the sweeper starts")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected the sweeper to stop")
+	}
+}
@@ -3,6 +3,18 @@ package config
 import (
 	"encoding/base64"
 	"fmt"
+	"time"
+
+	"github.com/npezzotti/go-chatroom/internal/database"
+)
+
+const (
+	// DefaultMaxMessageSize is the default maximum size in bytes of a websocket message
+	DefaultMaxMessageSize int64 = 16 * 1024
+	// DefaultMaxContentLength is the default maximum number of characters in a chat message
+	DefaultMaxContentLength = 4000
+	// DefaultQueryTimeout is the default time limit of a call to the database
+	DefaultQueryTimeout = 5 * time.Second
 )
 
 type Config struct {
@@ -11,6 +23,48 @@ type Config struct {
 	SigningKey     []byte
 	AllowedOrigins []string
 	DevMode        bool
+	// MaxMessageSize is the maximum size in bytes of a message read from a websocket.
+	// Messages can span multiple frames, the limit applies to the whole message.
+	MaxMessageSize int64
+	// MaxContentLength is the maximum number of characters in the content of a chat message.
+	MaxContentLength int
+	// QueryTimeout is how long a call to the database can take before it's canceled,
+	// so a slow database doesn't block requests and rooms indefinitely.
+	QueryTimeout time.Duration
+	// MessageBatchSize is the maximum number of messages a room saves at once in the
+	// background. If it is zero, each message is saved before the next one is handled.
+	MessageBatchSize int
+}
+
+// Option configures optional settings of a Config.
+type Option func(*Config)
+
+// WithMaxMessageSize sets the maximum size in bytes of a websocket message.
+func WithMaxMessageSize(size int64) Option {
+	return func(c *Config) {
+		c.MaxMessageSize = size
+	}
+}
+
+// WithMaxContentLength sets the maximum number of characters in a chat message.
+func WithMaxContentLength(length int) Option {
+	return func(c *Config) {
+		c.MaxContentLength = length
+	}
+}
+
+// WithQueryTimeout sets how long a call to the database can take.
+func WithQueryTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.QueryTimeout = timeout
+	}
+}
+
+// WithMessageBatchSize sets the maximum number of messages a room saves at once.
+func WithMessageBatchSize(size int) Option {
+	return func(c *Config) {
+		c.MessageBatchSize = size
+	}
 }
 
 func decodeSigningSecret(base64Secret string) ([]byte, error) {
@@ -20,7 +74,7 @@ func decodeSigningSecret(base64Secret string) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(base64Secret)
 }
 
-func NewConfig(serverAddr, databaseDSN, base64Secret string, allowedOrigins []string, devMode bool) (*Config, error) {
+func NewConfig(serverAddr, databaseDSN, base64Secret string, allowedOrigins []string, devMode bool, opts ...Option) (*Config, error) {
 	if serverAddr == "" {
 		return nil, fmt.Errorf("server address cannot be empty")
 	}
@@ -37,11 +91,33 @@ func NewConfig(serverAddr, databaseDSN, base64Secret string, allowedOrigins []st
 		return nil, fmt.Errorf("decode signing secret: %w", err)
 	}
 
-	return &Config{
-		DatabaseDSN:    databaseDSN,
-		ServerAddr:     serverAddr,
-		SigningKey:     signingKey,
-		AllowedOrigins: allowedOrigins,
-		DevMode:        devMode,
-	}, nil
+	cfg := &Config{
+		DatabaseDSN:      databaseDSN,
+		ServerAddr:       serverAddr,
+		SigningKey:       signingKey,
+		AllowedOrigins:   allowedOrigins,
+		DevMode:          devMode,
+		MaxMessageSize:   DefaultMaxMessageSize,
+		MaxContentLength: DefaultMaxContentLength,
+		QueryTimeout:     DefaultQueryTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	if cfg.MaxMessageSize <= 0 {
+		return nil, fmt.Errorf("max message size must be positive")
+	}
+	if cfg.MaxContentLength <= 0 {
+		return nil, fmt.Errorf("max content length must be positive")
+	}
+	if cfg.QueryTimeout <= 0 {
+		return nil, fmt.Errorf("query timeout must be positive")
+	}
+	if cfg.MessageBatchSize < 0 || cfg.MessageBatchSize > database.MaxMessageBatchSize {
+		return nil, fmt.Errorf("message batch size must be between 0 and %d", database.MaxMessageBatchSize)
+	}
+
+	return cfg, nil
 }
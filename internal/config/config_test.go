@@ -2,7 +2,9 @@ package config
 
 import (
 	"testing"
+	"time"
 
+	"github.com/npezzotti/go-chatroom/internal/database"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,6 +23,7 @@ func TestNewConfig(t *testing.T) {
 		key     string
 		orig    []string
 		devMode bool
+		opts    []Option
 		err     bool
 	}{
 		{
@@ -31,6 +34,60 @@ func TestNewConfig(t *testing.T) {
 			orig: orig,
 			err:  false,
 		},
+		{
+			name: "custom limits",
+			addr: addr,
+			dsn:  dsn,
+			key:  key,
+			orig: orig,
+			opts: []Option{WithMaxMessageSize(64 * 1024), WithMaxContentLength(10000), WithQueryTimeout(time.Second), WithMessageBatchSize(100)},
+			err:  false,
+		},
+		{
+			name: "zero max message size",
+			addr: addr,
+			dsn:  dsn,
+			key:  key,
+			orig: orig,
+			opts: []Option{WithMaxMessageSize(0)},
+			err:  true,
+		},
+		{
+			name: "negative max content length",
+			addr: addr,
+			dsn:  dsn,
+			key:  key,
+			orig: orig,
+			opts: []Option{WithMaxContentLength(-1)},
+			err:  true,
+		},
+		{
+			name: "zero query timeout",
+			addr: addr,
+			dsn:  dsn,
+			key:  key,
+			orig: orig,
+			opts: []Option{WithQueryTimeout(0)},
+			err:  true,
+		},
+		{
+			name: "negative message batch size",
+			addr: addr,
+			dsn:  dsn,
+			key:  key,
+			orig: orig,
+			opts: []Option{WithMessageBatchSize(-1)},
+			err:  true,
+		},
+		{
+			name: "message batch size too large",
+			addr: addr,
+			dsn:  dsn,
+			key:  key,
+			orig: orig,
+			opts: []Option{WithMessageBatchSize(database.MaxMessageBatchSize + 1)},
+			err:  true,
+		},
 		{
 			name: "empty address",
 			addr: "",
@@ -59,7 +116,7 @@ func TestNewConfig(t *testing.T) {
 
 	for _, tc := range tcases {
 		t.Run(tc.name, func(t *testing.T) {
-			config, err := NewConfig(tc.addr, tc.dsn, tc.key, tc.orig, tc.devMode)
+			config, err := NewConfig(tc.addr, tc.dsn, tc.key, tc.orig, tc.devMode, tc.opts...)
 			if tc.err {
 				assert.Error(t, err, "expected error for config: %s", tc.name)
 				return
@@ -71,6 +128,15 @@ func TestNewConfig(t *testing.T) {
 			assert.Equal(t, tc.orig, config.AllowedOrigins, "expected allowed origins to match")
 			assert.Equal(t, tc.devMode, config.DevMode, "expected dev mode to match")
 			assert.NotEmpty(t, config.SigningKey, "expected signing key to be decoded and not empty")
+
+			expected := &Config{MaxMessageSize: DefaultMaxMessageSize, MaxContentLength: DefaultMaxContentLength, QueryTimeout: DefaultQueryTimeout}
+			for _, opt := range tc.opts {
+				opt(expected)
+			}
+			assert.Equal(t, expected.MaxMessageSize, config.MaxMessageSize, "expected max message size to match")
+			assert.Equal(t, expected.MaxContentLength, config.MaxContentLength, "expected max content length to match")
+			assert.Equal(t, expected.QueryTimeout, config.QueryTimeout, "expected query timeout to match")
+			assert.Equal(t, expected.MessageBatchSize, config.MessageBatchSize, "expected message batch size to match")
 		})
 	}
 }
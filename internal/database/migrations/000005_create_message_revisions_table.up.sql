@@ -0,0 +1,8 @@
+CREATE TABLE message_revisions(
+  id         SERIAL PRIMARY KEY,
+  message_id integer NOT NULL,
+  content    character varying(100),
+  created_at timestamp(3) without time zone DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_message_revisions_message_id ON message_revisions(message_id);
@@ -0,0 +1 @@
+ALTER TABLE messages ADD COLUMN deleted_at timestamp(3) without time zone;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_room_parent_seq_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS parent_seq_id;
@@ -0,0 +1,2 @@
+ALTER TABLE messages ADD COLUMN parent_seq_id integer;
+CREATE INDEX idx_messages_room_parent_seq_id ON messages(room_id, parent_seq_id);
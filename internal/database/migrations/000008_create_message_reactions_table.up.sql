@@ -0,0 +1,11 @@
+CREATE TABLE message_reactions(
+  id         SERIAL PRIMARY KEY,
+  room_id    integer NOT NULL,
+  seq_id     integer NOT NULL,
+  account_id integer NOT NULL,
+  emoji      character varying(32) NOT NULL,
+  created_at timestamp(3) without time zone DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(room_id, seq_id) REFERENCES messages(room_id, seq_id) ON DELETE CASCADE,
+  FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX message_reactions_room_seq_account_emoji ON message_reactions(room_id, seq_id, account_id, emoji);
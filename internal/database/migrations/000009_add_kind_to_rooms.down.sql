@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS rooms_direct_key;
+ALTER TABLE rooms DROP COLUMN IF EXISTS direct_key;
+ALTER TABLE rooms DROP COLUMN IF EXISTS kind;
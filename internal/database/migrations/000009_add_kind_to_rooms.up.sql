@@ -0,0 +1,3 @@
+ALTER TABLE rooms ADD COLUMN kind character varying(16) DEFAULT 'public' NOT NULL;
+ALTER TABLE rooms ADD COLUMN direct_key character varying(50);
+CREATE UNIQUE INDEX rooms_direct_key ON rooms(direct_key);
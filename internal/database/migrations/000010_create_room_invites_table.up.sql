@@ -0,0 +1,11 @@
+CREATE TABLE room_invites(
+  id         SERIAL PRIMARY KEY,
+  room_id    integer NOT NULL,
+  account_id integer NOT NULL,
+  invited_by integer NOT NULL,
+  created_at timestamp(3) without time zone DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(room_id) REFERENCES rooms(id) ON DELETE CASCADE,
+  FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE CASCADE,
+  FOREIGN KEY(invited_by) REFERENCES accounts(id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX room_invites_room_account_id ON room_invites(room_id, account_id);
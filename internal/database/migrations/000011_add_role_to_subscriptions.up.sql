@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions ADD COLUMN role character varying(16) DEFAULT 'member' NOT NULL;
+UPDATE subscriptions s SET role = 'owner' FROM rooms r WHERE r.id = s.room_id AND r.owner_id = s.account_id;
@@ -0,0 +1,13 @@
+CREATE TABLE room_bans(
+  id         SERIAL PRIMARY KEY,
+  room_id    integer NOT NULL,
+  account_id integer NOT NULL,
+  banned_by  integer NOT NULL,
+  reason     text DEFAULT '' NOT NULL,
+  expires_at timestamp(3) without time zone,
+  created_at timestamp(3) without time zone DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(room_id) REFERENCES rooms(id) ON DELETE CASCADE,
+  FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE CASCADE,
+  FOREIGN KEY(banned_by) REFERENCES accounts(id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX room_bans_room_account_id ON room_bans(room_id, account_id);
@@ -0,0 +1,3 @@
+ALTER TABLE messages ADD COLUMN search_vector tsvector
+  GENERATED ALWAYS AS (to_tsvector('english', coalesce(content, ''))) STORED;
+CREATE INDEX messages_search_vector_idx ON messages USING GIN (search_vector);
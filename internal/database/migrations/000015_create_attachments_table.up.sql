@@ -0,0 +1,15 @@
+CREATE TABLE attachments(
+  id           SERIAL PRIMARY KEY,
+  external_id  character varying(32) NOT NULL,
+  room_id      integer NOT NULL,
+  account_id   integer NOT NULL,
+  seq_id       integer,
+  filename     character varying(255) NOT NULL,
+  content_type character varying(255) NOT NULL,
+  size         bigint NOT NULL,
+  created_at   timestamp(3) without time zone DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(room_id) REFERENCES rooms(id) ON DELETE CASCADE,
+  FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX attachments_external_id ON attachments(external_id);
+CREATE INDEX attachments_room_seq_id ON attachments(room_id, seq_id);
@@ -0,0 +1,8 @@
+CREATE TABLE link_previews(
+  url         text PRIMARY KEY,
+  title       text DEFAULT '' NOT NULL,
+  description text DEFAULT '' NOT NULL,
+  image_url   text DEFAULT '' NOT NULL,
+  site_name   text DEFAULT '' NOT NULL,
+  fetched_at  timestamp(3) without time zone DEFAULT CURRENT_TIMESTAMP
+);
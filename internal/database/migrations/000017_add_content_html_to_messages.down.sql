@@ -0,0 +1,8 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS content_html;
+DROP INDEX IF EXISTS messages_search_vector_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE messages ALTER COLUMN content TYPE character varying(100) USING left(content, 100);
+ALTER TABLE message_revisions ALTER COLUMN content TYPE character varying(100) USING left(content, 100);
+ALTER TABLE messages ADD COLUMN search_vector tsvector
+  GENERATED ALWAYS AS (to_tsvector('english', coalesce(content, ''))) STORED;
+CREATE INDEX messages_search_vector_idx ON messages USING GIN (search_vector);
@@ -0,0 +1,14 @@
+-- the search vector is generated from the content, so it is recreated when the content type changes
+DROP INDEX IF EXISTS messages_search_vector_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE messages ALTER COLUMN content TYPE text;
+ALTER TABLE message_revisions ALTER COLUMN content TYPE text;
+ALTER TABLE messages ADD COLUMN search_vector tsvector
+  GENERATED ALWAYS AS (to_tsvector('english', coalesce(content, ''))) STORED;
+CREATE INDEX messages_search_vector_idx ON messages USING GIN (search_vector);
+
+ALTER TABLE messages ADD COLUMN content_html text DEFAULT '' NOT NULL;
+-- existing messages were plain text, so they are rendered as escaped paragraphs
+UPDATE messages SET content_html = '<p>' || replace(replace(replace(replace(replace(replace(
+  content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;'), E'\n', '<br>') || '</p>'
+WHERE content <> '';
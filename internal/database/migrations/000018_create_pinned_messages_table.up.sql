@@ -0,0 +1,10 @@
+CREATE TABLE pinned_messages(
+  id         SERIAL PRIMARY KEY,
+  room_id    integer NOT NULL,
+  seq_id     integer NOT NULL,
+  pinned_by  integer NOT NULL,
+  created_at timestamp(3) without time zone DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(room_id, seq_id) REFERENCES messages(room_id, seq_id) ON DELETE CASCADE,
+  FOREIGN KEY(pinned_by) REFERENCES accounts(id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX pinned_messages_room_seq_id ON pinned_messages(room_id, seq_id);
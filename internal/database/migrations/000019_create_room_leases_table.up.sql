@@ -0,0 +1,6 @@
+CREATE TABLE room_leases(
+  room_id    integer PRIMARY KEY,
+  node_id    text NOT NULL,
+  expires_at timestamp(3) with time zone NOT NULL,
+  FOREIGN KEY(room_id) REFERENCES rooms(id) ON DELETE CASCADE
+);
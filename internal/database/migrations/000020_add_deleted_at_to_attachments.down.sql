@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS attachments_unattached_created_at;
+DROP INDEX IF EXISTS attachments_deleted_at;
+DELETE FROM attachments WHERE deleted_at IS NOT NULL OR room_id NOT IN (SELECT id FROM rooms);
+ALTER TABLE attachments DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE attachments ADD FOREIGN KEY(room_id) REFERENCES rooms(id) ON DELETE CASCADE;
@@ -0,0 +1,6 @@
+-- attachments of deleted messages and rooms are kept until their contents are removed
+-- from the blob store, so the rows outlive the rooms they belong to
+ALTER TABLE attachments DROP CONSTRAINT IF EXISTS attachments_room_id_fkey;
+ALTER TABLE attachments ADD COLUMN deleted_at timestamp(3) without time zone;
+CREATE INDEX attachments_deleted_at ON attachments(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX attachments_unattached_created_at ON attachments(created_at) WHERE seq_id IS NULL;
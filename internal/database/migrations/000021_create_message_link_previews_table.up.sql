@@ -0,0 +1,9 @@
+CREATE TABLE message_link_previews(
+  room_id  integer NOT NULL,
+  seq_id   integer NOT NULL,
+  url      text NOT NULL,
+  position integer NOT NULL,
+  PRIMARY KEY(room_id, seq_id, url),
+  FOREIGN KEY(room_id, seq_id) REFERENCES messages(room_id, seq_id) ON DELETE CASCADE,
+  FOREIGN KEY(url) REFERENCES link_previews(url) ON DELETE CASCADE
+);
@@ -1,6 +1,9 @@
 package database
 
 import (
+	"context"
+	"time"
+
 	"github.com/stretchr/testify/mock"
 )
 
@@ -8,78 +11,241 @@ type MockGoChatRepository struct {
 	mock.Mock
 }
 
-func (m *MockGoChatRepository) Ping() error {
-	args := m.Called()
+func (m *MockGoChatRepository) Ping(ctx context.Context) error {
+	args := m.Called(ctx)
 	return args.Error(0)
 }
-func (m *MockGoChatRepository) CreateAccount(accountParams CreateAccountParams) (User, error) {
-	args := m.Called(accountParams)
+func (m *MockGoChatRepository) CreateAccount(ctx context.Context, accountParams CreateAccountParams) (User, error) {
+	args := m.Called(ctx, accountParams)
 	return args.Get(0).(User), args.Error(1)
 }
-func (m *MockGoChatRepository) UpdateAccount(params UpdateAccountParams) (User, error) {
-	args := m.Called(params)
+func (m *MockGoChatRepository) UpdateAccount(ctx context.Context, params UpdateAccountParams) (User, error) {
+	args := m.Called(ctx, params)
 	return args.Get(0).(User), args.Error(1)
 }
-func (m *MockGoChatRepository) GetAccountById(userId int) (User, error) {
-	args := m.Called(userId)
+func (m *MockGoChatRepository) GetAccountById(ctx context.Context, userId int) (User, error) {
+	args := m.Called(ctx, userId)
 	return args.Get(0).(User), args.Error(1)
 }
-func (m *MockGoChatRepository) GetAccountByEmail(email string) (User, error) {
-	args := m.Called(email)
+func (m *MockGoChatRepository) GetAccountByEmail(ctx context.Context, email string) (User, error) {
+	args := m.Called(ctx, email)
 	return args.Get(0).(User), args.Error(1)
 }
-func (m *MockGoChatRepository) GetRoomByExternalId(externalId string) (Room, error) {
-	args := m.Called(externalId)
+func (m *MockGoChatRepository) GetRoomByExternalId(ctx context.Context, externalId string) (Room, error) {
+	args := m.Called(ctx, externalId)
 	return args.Get(0).(Room), args.Error(1)
 }
-func (m *MockGoChatRepository) GetRoomWithSubscribers(roomId int) (*Room, error) {
-	args := m.Called(roomId)
+func (m *MockGoChatRepository) GetRoomWithSubscribers(ctx context.Context, roomId int) (*Room, error) {
+	args := m.Called(ctx, roomId)
 	if room, ok := args.Get(0).(*Room); ok {
 		return room, args.Error(1)
 	}
 	return nil, args.Error(1)
 }
-func (m *MockGoChatRepository) CreateRoom(params CreateRoomParams) (Room, error) {
-	args := m.Called(params)
+func (m *MockGoChatRepository) CreateRoom(ctx context.Context, params CreateRoomParams) (Room, error) {
+	args := m.Called(ctx, params)
+	return args.Get(0).(Room), args.Error(1)
+}
+func (m *MockGoChatRepository) ListRooms(ctx context.Context, params ListRoomsParams) ([]Room, error) {
+	args := m.Called(ctx, params)
+	return args.Get(0).([]Room), args.Error(1)
+}
+func (m *MockGoChatRepository) UpdateRoom(ctx context.Context, params UpdateRoomParams) (Room, error) {
+	args := m.Called(ctx, params)
 	return args.Get(0).(Room), args.Error(1)
 }
-func (m *MockGoChatRepository) DeleteRoom(id int) error {
-	args := m.Called(id)
+func (m *MockGoChatRepository) GetOrCreateDirectRoom(ctx context.Context, params CreateDirectRoomParams) (Room, bool, error) {
+	args := m.Called(ctx, params)
+	return args.Get(0).(Room), args.Bool(1), args.Error(2)
+}
+func (m *MockGoChatRepository) DeleteRoom(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
 	return args.Error(0)
 }
-func (m *MockGoChatRepository) CreateSubscription(userId, roomId int) (Subscription, error) {
-	args := m.Called(userId, roomId)
+func (m *MockGoChatRepository) CreateSubscription(ctx context.Context, userId, roomId int) (Subscription, error) {
+	args := m.Called(ctx, userId, roomId)
 	return args.Get(0).(Subscription), args.Error(1)
 }
-func (m *MockGoChatRepository) SubscriptionExists(account_id, room_id int) bool {
-	args := m.Called(account_id, room_id)
+func (m *MockGoChatRepository) SubscriptionExists(ctx context.Context, account_id, room_id int) bool {
+	args := m.Called(ctx, account_id, room_id)
 	return args.Bool(0)
 }
-func (m *MockGoChatRepository) ListSubscriptions(account_id int) ([]Subscription, error) {
-	args := m.Called(account_id)
+func (m *MockGoChatRepository) ListSubscriptions(ctx context.Context, account_id int) ([]Subscription, error) {
+	args := m.Called(ctx, account_id)
 	return args.Get(0).([]Subscription), args.Error(1)
 }
-func (m *MockGoChatRepository) DeleteSubscription(accountId, roomId int) error {
-	args := m.Called(accountId, roomId)
+func (m *MockGoChatRepository) DeleteSubscription(ctx context.Context, accountId, roomId int) error {
+	args := m.Called(ctx, accountId, roomId)
 	return args.Error(0)
 }
-func (m *MockGoChatRepository) UpdateLastReadSeqId(userId, roomId, seqId int) error {
-	args := m.Called(userId, roomId, seqId)
+func (m *MockGoChatRepository) GetSubscriptionRole(ctx context.Context, accountId, roomId int) (string, error) {
+	args := m.Called(ctx, accountId, roomId)
+	return args.String(0), args.Error(1)
+}
+func (m *MockGoChatRepository) UpdateSubscriptionRole(ctx context.Context, accountId, roomId int, role string) error {
+	args := m.Called(ctx, accountId, roomId, role)
 	return args.Error(0)
 }
-func (m *MockGoChatRepository) CreateMessage(msg Message) error {
-	args := m.Called(msg)
+func (m *MockGoChatRepository) CreateInvite(ctx context.Context, params CreateInviteParams) (Invite, error) {
+	args := m.Called(ctx, params)
+	return args.Get(0).(Invite), args.Error(1)
+}
+func (m *MockGoChatRepository) ListInvites(ctx context.Context, roomId int) ([]Invite, error) {
+	args := m.Called(ctx, roomId)
+	return args.Get(0).([]Invite), args.Error(1)
+}
+func (m *MockGoChatRepository) DeleteInvite(ctx context.Context, roomId, accountId int) error {
+	args := m.Called(ctx, roomId, accountId)
 	return args.Error(0)
 }
-func (m *MockGoChatRepository) UpdateRoomOnMessage(msg Message) error {
-	args := m.Called(msg)
+func (m *MockGoChatRepository) AcceptInvite(ctx context.Context, accountId, roomId int) (Subscription, error) {
+	args := m.Called(ctx, accountId, roomId)
+	return args.Get(0).(Subscription), args.Error(1)
+}
+func (m *MockGoChatRepository) CreateBan(ctx context.Context, params CreateBanParams) (Ban, error) {
+	args := m.Called(ctx, params)
+	return args.Get(0).(Ban), args.Error(1)
+}
+func (m *MockGoChatRepository) ListBans(ctx context.Context, roomId int) ([]Ban, error) {
+	args := m.Called(ctx, roomId)
+	return args.Get(0).([]Ban), args.Error(1)
+}
+func (m *MockGoChatRepository) DeleteBan(ctx context.Context, roomId, accountId int) error {
+	args := m.Called(ctx, roomId, accountId)
+	return args.Error(0)
+}
+func (m *MockGoChatRepository) IsBanned(ctx context.Context, accountId, roomId int) (bool, error) {
+	args := m.Called(ctx, accountId, roomId)
+	return args.Bool(0), args.Error(1)
+}
+func (m *MockGoChatRepository) UpdateLastReadSeqId(ctx context.Context, userId, roomId, seqId int) error {
+	args := m.Called(ctx, userId, roomId, seqId)
 	return args.Error(0)
 }
-func (m *MockGoChatRepository) GetSubscribersByRoomId(roomId int) ([]User, error) {
-	args := m.Called(roomId)
+func (m *MockGoChatRepository) GetUnread(ctx context.Context, accountId, roomId int) (Unread, error) {
+	args := m.Called(ctx, accountId, roomId)
+	return args.Get(0).(Unread), args.Error(1)
+}
+func (m *MockGoChatRepository) ListUnread(ctx context.Context, roomId int) ([]Unread, error) {
+	args := m.Called(ctx, roomId)
+	return args.Get(0).([]Unread), args.Error(1)
+}
+func (m *MockGoChatRepository) CreateMessage(ctx context.Context, msg Message) (Message, error) {
+	args := m.Called(ctx, msg)
+	return args.Get(0).(Message), args.Error(1)
+}
+func (m *MockGoChatRepository) GetSubscribersByRoomId(ctx context.Context, roomId int) ([]User, error) {
+	args := m.Called(ctx, roomId)
 	return args.Get(0).([]User), args.Error(1)
 }
-func (m *MockGoChatRepository) GetMessages(roomId, since, before, limit int) ([]Message, error) {
-	args := m.Called(roomId, since, before, limit)
+func (m *MockGoChatRepository) GetMessages(ctx context.Context, roomId, since, before, limit int) ([]Message, error) {
+	args := m.Called(ctx, roomId, since, before, limit)
+	return args.Get(0).([]Message), args.Error(1)
+}
+func (m *MockGoChatRepository) GetMessage(ctx context.Context, roomId, seqId int) (Message, error) {
+	args := m.Called(ctx, roomId, seqId)
+	return args.Get(0).(Message), args.Error(1)
+}
+func (m *MockGoChatRepository) SearchMessages(ctx context.Context, params SearchMessagesParams) ([]Message, error) {
+	args := m.Called(ctx, params)
+	return args.Get(0).([]Message), args.Error(1)
+}
+func (m *MockGoChatRepository) CreateMentions(ctx context.Context, roomId, seqId int, accountIds []int) error {
+	args := m.Called(ctx, roomId, seqId, accountIds)
+	return args.Error(0)
+}
+func (m *MockGoChatRepository) ListMentions(ctx context.Context, params ListMentionsParams) ([]Mention, error) {
+	args := m.Called(ctx, params)
+	return args.Get(0).([]Mention), args.Error(1)
+}
+func (m *MockGoChatRepository) EditMessage(ctx context.Context, params EditMessageParams) (Message, error) {
+	args := m.Called(ctx, params)
+	return args.Get(0).(Message), args.Error(1)
+}
+func (m *MockGoChatRepository) GetMessageRevisions(ctx context.Context, messageId int) ([]MessageRevision, error) {
+	args := m.Called(ctx, messageId)
+	return args.Get(0).([]MessageRevision), args.Error(1)
+}
+func (m *MockGoChatRepository) DeleteMessage(ctx context.Context, roomId, seqId int) error {
+	args := m.Called(ctx, roomId, seqId)
+	return args.Error(0)
+}
+func (m *MockGoChatRepository) GetThread(ctx context.Context, roomId, parentSeqId int) ([]Message, error) {
+	args := m.Called(ctx, roomId, parentSeqId)
 	return args.Get(0).([]Message), args.Error(1)
 }
+func (m *MockGoChatRepository) CountReplies(ctx context.Context, roomId, parentSeqId int) (int, error) {
+	args := m.Called(ctx, roomId, parentSeqId)
+	return args.Int(0), args.Error(1)
+}
+func (m *MockGoChatRepository) AddReaction(ctx context.Context, params ReactionParams) error {
+	args := m.Called(ctx, params)
+	return args.Error(0)
+}
+func (m *MockGoChatRepository) RemoveReaction(ctx context.Context, params ReactionParams) error {
+	args := m.Called(ctx, params)
+	return args.Error(0)
+}
+func (m *MockGoChatRepository) CreateAttachment(ctx context.Context, params CreateAttachmentParams) (Attachment, error) {
+	args := m.Called(ctx, params)
+	return args.Get(0).(Attachment), args.Error(1)
+}
+func (m *MockGoChatRepository) GetAttachment(ctx context.Context, externalId string) (Attachment, error) {
+	args := m.Called(ctx, externalId)
+	return args.Get(0).(Attachment), args.Error(1)
+}
+func (m *MockGoChatRepository) GetAttachments(ctx context.Context, roomId int, externalIds []string) ([]Attachment, error) {
+	args := m.Called(ctx, roomId, externalIds)
+	return args.Get(0).([]Attachment), args.Error(1)
+}
+func (m *MockGoChatRepository) DeleteUnattachedAttachments(ctx context.Context, createdBefore time.Time) error {
+	args := m.Called(ctx, createdBefore)
+	return args.Error(0)
+}
+func (m *MockGoChatRepository) ListDeletedAttachments(ctx context.Context, limit int) ([]Attachment, error) {
+	args := m.Called(ctx, limit)
+	return args.Get(0).([]Attachment), args.Error(1)
+}
+func (m *MockGoChatRepository) PurgeAttachments(ctx context.Context, ids []int) error {
+	args := m.Called(ctx, ids)
+	return args.Error(0)
+}
+func (m *MockGoChatRepository) GetLinkPreview(ctx context.Context, url string) (LinkPreview, error) {
+	args := m.Called(ctx, url)
+	return args.Get(0).(LinkPreview), args.Error(1)
+}
+func (m *MockGoChatRepository) SaveLinkPreview(ctx context.Context, preview LinkPreview) error {
+	args := m.Called(ctx, preview)
+	return args.Error(0)
+}
+func (m *MockGoChatRepository) SaveMessageLinkPreviews(ctx context.Context, roomId, seqId int, urls []string) error {
+	args := m.Called(ctx, roomId, seqId, urls)
+	return args.Error(0)
+}
+func (m *MockGoChatRepository) PinMessage(ctx context.Context, params PinParams) (Pin, error) {
+	args := m.Called(ctx, params)
+	return args.Get(0).(Pin), args.Error(1)
+}
+func (m *MockGoChatRepository) UnpinMessage(ctx context.Context, roomId, seqId int) error {
+	args := m.Called(ctx, roomId, seqId)
+	return args.Error(0)
+}
+func (m *MockGoChatRepository) ListPins(ctx context.Context, roomId int) ([]Pin, error) {
+	args := m.Called(ctx, roomId)
+	return args.Get(0).([]Pin), args.Error(1)
+}
+func (m *MockGoChatRepository) AcquireRoomLease(ctx context.Context, roomId int, nodeId string, ttl time.Duration) (string, error) {
+	args := m.Called(ctx, roomId, nodeId, ttl)
+	return args.String(0), args.Error(1)
+}
+func (m *MockGoChatRepository) ReleaseRoomLease(ctx context.Context, roomId int, nodeId string) error {
+	args := m.Called(ctx, roomId, nodeId)
+	return args.Error(0)
+}
+func (m *MockGoChatRepository) CreateMessages(ctx context.Context, msgs []Message) ([]Message, error) {
+	args := m.Called(ctx, msgs)
+	if stored, ok := args.Get(0).([]Message); ok {
+		return stored, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
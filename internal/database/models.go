@@ -2,6 +2,37 @@ package database
 
 import "time"
 
+const (
+	// RoomKindPublic rooms can be joined by anyone who knows their external id.
+	RoomKindPublic = "public"
+	// RoomKindPrivate rooms can only be joined with an invitation.
+	RoomKindPrivate = "private"
+	// RoomKindDirect rooms are one-to-one conversations that only their two members can join.
+	RoomKindDirect = "direct"
+)
+
+const (
+	// RoleOwner is the role of the user that created the room.
+	RoleOwner = "owner"
+	// RoleAdmin is the role of users that moderate the room.
+	RoleAdmin = "admin"
+	// RoleMember is the role of all other subscribers.
+	RoleMember = "member"
+)
+
+var roleRanks = map[string]int{
+	RoleMember: 1,
+	RoleAdmin:  2,
+	RoleOwner:  3,
+}
+
+// RoleAtLeast reports whether role grants at least the permissions of min.
+// Unknown roles grant no permissions.
+func RoleAtLeast(role, min string) bool {
+	rank, ok := roleRanks[role]
+	return ok && rank >= roleRanks[min]
+}
+
 type Room struct {
 	Id            int
 	Name          string
@@ -9,9 +40,14 @@ type Room struct {
 	Description   string
 	SeqId         int
 	OwnerId       int
+	Kind          string
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	Subscriptions []Subscription
+	// SubscriberCount is only set when listing rooms
+	SubscriberCount int
+	// Leased is only set when listing rooms, it reports whether a node of the cluster hosts the room
+	Leased bool
 }
 
 type User struct {
@@ -29,19 +65,144 @@ type Subscription struct {
 	Room          Room
 	AccountId     int
 	Username      string
+	Role          string
 	RoomId        int
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
+	// UnreadCount and MentionCount are only set by ListSubscriptions
+	UnreadCount  int
+	MentionCount int
+}
+
+// Unread is the read state of a user in a room. Mentions is the number of
+// messages after the last read message that mention the user.
+type Unread struct {
+	AccountId     int
+	LastReadSeqId int
+	Mentions      int
 }
 
 type Message struct {
+	Id           int
+	SeqId        int
+	RoomId       int
+	UserId       int
+	Content      string
+	ContentHTML  string
+	Deleted      bool
+	ParentSeqId  int
+	ReplyCount   int
+	Reactions    []Reaction
+	Attachments  []Attachment
+	LinkPreviews []LinkPreview
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	// ExternalRoomId and Snippet are only set by SearchMessages
+	ExternalRoomId string
+	Snippet        string
+}
+
+// SearchMessagesParams searches the messages of the rooms a user is subscribed to.
+// RoomId optionally restricts the search to a single room. Results are ordered from
+// newest to oldest, and Before is the id of the message to continue after.
+type SearchMessagesParams struct {
+	AccountId int
+	Query     string
+	RoomId    int
+	Before    int
+	Limit     int
+}
+
+// Attachment is a file uploaded to a room. SeqId is the message the file is
+// attached to, or 0 if it hasn't been attached to a message yet.
+type Attachment struct {
+	Id          int
+	ExternalId  string
+	RoomId      int
+	AccountId   int
+	SeqId       int
+	Filename    string
+	ContentType string
+	Size        int64
+	CreatedAt   time.Time
+}
+
+type CreateAttachmentParams struct {
+	ExternalId  string
+	RoomId      int
+	AccountId   int
+	Filename    string
+	ContentType string
+	Size        int64
+}
+
+// LinkPreview is the cached preview of a linked page. Pages without
+// a title have nothing to preview.
+type LinkPreview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+	SiteName    string
+	FetchedAt   time.Time
+}
+
+// Mention is a message that mentions a user.
+type Mention struct {
 	Id        int
-	SeqId     int
+	AccountId int
+	Message   Message
+	CreatedAt time.Time
+}
+
+// ListMentionsParams lists the mentions of a user from newest to oldest.
+// Before is the id of the mention to continue after.
+type ListMentionsParams struct {
+	AccountId int
+	Before    int
+	Limit     int
+}
+
+// Pin is a message pinned to a room.
+type Pin struct {
+	Id        int
+	RoomId    int
+	PinnedBy  int
+	Message   Message
+	CreatedAt time.Time
+}
+
+type PinParams struct {
+	RoomId   int
+	SeqId    int
+	PinnedBy int
+}
+
+type Reaction struct {
+	Emoji   string
+	UserIds []int
+}
+
+type ReactionParams struct {
 	RoomId    int
-	UserId    int
+	SeqId     int
+	AccountId int
+	Emoji     string
+}
+
+type MessageRevision struct {
+	Id        int
+	MessageId int
 	Content   string
 	CreatedAt time.Time
-	UpdatedAt time.Time
+}
+
+type EditMessageParams struct {
+	RoomId      int
+	SeqId       int
+	Content     string
+	ContentHTML string
+	EditedAt    time.Time
 }
 
 type CreateAccountParams struct {
@@ -56,9 +217,65 @@ type UpdateAccountParams struct {
 	PasswordHash string
 }
 
+type Invite struct {
+	Id        int
+	RoomId    int
+	AccountId int
+	Username  string
+	InvitedBy int
+	CreatedAt time.Time
+}
+
+type CreateInviteParams struct {
+	RoomId    int
+	AccountId int
+	InvitedBy int
+}
+
+// Ban prevents a user from joining a room. A ban without an expiry is permanent.
+type Ban struct {
+	Id        int
+	RoomId    int
+	AccountId int
+	Username  string
+	BannedBy  int
+	Reason    string
+	ExpiresAt *time.Time
+	CreatedAt time.Time
+}
+
+type CreateBanParams struct {
+	RoomId    int
+	AccountId int
+	BannedBy  int
+	Reason    string
+	ExpiresAt *time.Time
+}
+
+type CreateDirectRoomParams struct {
+	ExternalId string
+	UserId     int
+	PeerId     int
+}
+
+// ListRoomsParams filters and paginates public rooms. Query matches
+// the name or description of a room and is ignored if empty.
+type ListRoomsParams struct {
+	Query  string
+	Limit  int
+	Offset int
+}
+
+type UpdateRoomParams struct {
+	Id          int
+	Name        string
+	Description string
+}
+
 type CreateRoomParams struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	OwnerId     int    `json:"-"`
 	ExternalId  string `json:"external_id"`
+	Kind        string `json:"kind"`
 }
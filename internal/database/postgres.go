@@ -1,42 +1,81 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"embed"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/lib/pq"
 )
 
 //go:embed migrations/*.sql
 var migrationsFS embed.FS
 
+// Postgres error codes of errors caused by concurrent transactions.
+const (
+	uniqueViolation      = "23505"
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+// maxCreateMessageAttempts is how many times saving a message is attempted
+// when it conflicts with concurrent transactions.
+const maxCreateMessageAttempts = 3
+
+// MaxMessageBatchSize is the maximum number of messages CreateMessages saves at once,
+// which keeps the parameters of the insert within the limit of Postgres.
+const MaxMessageBatchSize = 1000
+
+// messageInsertColumns is the number of parameters of each message inserted.
+const messageInsertColumns = 8
+
 type PgGoChatRepository struct {
 	conn *sql.DB
+	// queryTimeout is how long each call to the repository can take, zero for no limit
+	queryTimeout time.Duration
 }
 
-func NewPgGoChatRepository(dsn string) (*PgGoChatRepository, error) {
+// NewPgGoChatRepository connects to the database. Each call to the repository is
+// canceled once queryTimeout elapses, or when its context is, whichever is first.
+func NewPgGoChatRepository(dsn string, queryTimeout time.Duration) (*PgGoChatRepository, error) {
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
+	repo := &PgGoChatRepository{conn: db, queryTimeout: queryTimeout}
+	if err := repo.Ping(context.Background()); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	return &PgGoChatRepository{conn: db}, nil
+	return repo, nil
+}
+
+// withTimeout returns a context for a call to the repository, with the query timeout as its deadline.
+func (db *PgGoChatRepository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.queryTimeout)
 }
 
-func (db *PgGoChatRepository) Ping() error {
+func (db *PgGoChatRepository) Ping(ctx context.Context) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	if db.conn == nil {
 		return fmt.Errorf("database connection is nil")
 	}
-	if err := db.conn.Ping(); err != nil {
+	if err := db.conn.PingContext(ctx); err != nil {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 	return nil
@@ -75,10 +114,20 @@ func (db *PgGoChatRepository) Close() error {
 
 const (
 	createSubQuery = "INSERT INTO subscriptions (account_id, room_id) VALUES ($1, $2) RETURNING id, account_id, room_id"
+	// unreadMentionsQuery counts the messages after a subscription's last read message that mention
+	// the subscriber, where s is the subscription.
+	unreadMentionsQuery = "(SELECT count(*) FROM mentions mn JOIN messages m ON m.room_id = mn.room_id AND m.seq_id = mn.seq_id " +
+		"WHERE mn.room_id = s.room_id AND mn.account_id = s.account_id AND mn.seq_id > s.last_read_seq_id AND m.deleted_at IS NULL)"
 )
 
-func (db *PgGoChatRepository) CreateAccount(accountParams CreateAccountParams) (User, error) {
-	res := db.conn.QueryRow(
+// likeEscaper escapes the wildcards of a LIKE pattern so user input is matched literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (db *PgGoChatRepository) CreateAccount(ctx context.Context, accountParams CreateAccountParams) (User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	res := db.conn.QueryRowContext(ctx,
 		"INSERT INTO accounts (username, email, password_hash) "+
 			"VALUES ($1, $2, $3) RETURNING id, username, email, password_hash, created_at, updated_at",
 		accountParams.Username,
@@ -99,8 +148,11 @@ func (db *PgGoChatRepository) CreateAccount(accountParams CreateAccountParams) (
 	return u, err
 }
 
-func (db *PgGoChatRepository) UpdateAccount(accountParams UpdateAccountParams) (User, error) {
-	res := db.conn.QueryRow(
+func (db *PgGoChatRepository) UpdateAccount(ctx context.Context, accountParams UpdateAccountParams) (User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	res := db.conn.QueryRowContext(ctx,
 		"UPDATE accounts SET username = $2, password_hash = $3, updated_at = $4 "+
 			"WHERE id = $1 RETURNING id, username, email",
 		accountParams.UserId,
@@ -119,8 +171,11 @@ func (db *PgGoChatRepository) UpdateAccount(accountParams UpdateAccountParams) (
 	return u, err
 }
 
-func (db *PgGoChatRepository) GetAccountById(id int) (User, error) {
-	row := db.conn.QueryRow(
+func (db *PgGoChatRepository) GetAccountById(ctx context.Context, id int) (User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	row := db.conn.QueryRowContext(ctx,
 		"SELECT id, username, email FROM accounts "+
 			"WHERE id = $1 LIMIT 1",
 		id,
@@ -136,8 +191,11 @@ func (db *PgGoChatRepository) GetAccountById(id int) (User, error) {
 	return user, err
 }
 
-func (db *PgGoChatRepository) GetAccountByEmail(email string) (User, error) {
-	row := db.conn.QueryRow(
+func (db *PgGoChatRepository) GetAccountByEmail(ctx context.Context, email string) (User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	row := db.conn.QueryRowContext(ctx,
 		"SELECT id, username, email, password_hash FROM accounts "+
 			"WHERE email = $1 LIMIT 1",
 		email,
@@ -153,9 +211,12 @@ func (db *PgGoChatRepository) GetAccountByEmail(email string) (User, error) {
 	return user, err
 }
 
-func (db *PgGoChatRepository) GetRoomByExternalId(externalId string) (Room, error) {
-	row := db.conn.QueryRow(
-		"SELECT id, name, external_id, description, seq_id, owner_id, created_at, updated_at FROM rooms "+
+func (db *PgGoChatRepository) GetRoomByExternalId(ctx context.Context, externalId string) (Room, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	row := db.conn.QueryRowContext(ctx,
+		"SELECT id, name, external_id, description, seq_id, owner_id, kind, created_at, updated_at FROM rooms "+
 			"WHERE external_id = $1 LIMIT 1",
 		externalId,
 	)
@@ -168,6 +229,7 @@ func (db *PgGoChatRepository) GetRoomByExternalId(externalId string) (Room, erro
 		&room.Description,
 		&room.SeqId,
 		&room.OwnerId,
+		&room.Kind,
 		&room.CreatedAt,
 		&room.UpdatedAt,
 	)
@@ -175,7 +237,10 @@ func (db *PgGoChatRepository) GetRoomByExternalId(externalId string) (Room, erro
 	return room, err
 }
 
-func (db *PgGoChatRepository) GetRoomWithSubscribers(roomId int) (*Room, error) {
+func (db *PgGoChatRepository) GetRoomWithSubscribers(ctx context.Context, roomId int) (*Room, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		SELECT 
 				r.id AS room_id,
@@ -184,11 +249,14 @@ func (db *PgGoChatRepository) GetRoomWithSubscribers(roomId int) (*Room, error)
 				r.description,
 				r.seq_id,
 				r.owner_id,
+				r.kind,
 				r.created_at AS room_created_at,
 				r.updated_at AS room_updated_at,
 				s.id,
 				s.account_id,
 				a.username,
+				s.role,
+				s.last_read_seq_id,
 				s.created_at AS subscription_created_at,
 				s.updated_at AS subscription_updated_at
 		FROM rooms r
@@ -197,7 +265,7 @@ func (db *PgGoChatRepository) GetRoomWithSubscribers(roomId int) (*Room, error)
 		WHERE r.id = $1;
 `
 
-	rows, err := db.conn.Query(query, roomId)
+	rows, err := db.conn.QueryContext(ctx, query, roomId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch room with subscribers: %w", err)
 	}
@@ -212,11 +280,14 @@ func (db *PgGoChatRepository) GetRoomWithSubscribers(roomId int) (*Room, error)
 			description           string
 			seqId                 int
 			ownerId               int
+			kind                  string
 			roomCreatedAt         time.Time
 			roomUpdatedAt         time.Time
 			subscriptionId        sql.NullInt64
 			accountId             sql.NullInt64
 			username              sql.NullString
+			role                  sql.NullString
+			lastReadSeqId         sql.NullInt64
 			subscriptionCreatedAt sql.NullTime
 			subscriptionUpdatedAt sql.NullTime
 		)
@@ -228,11 +299,14 @@ func (db *PgGoChatRepository) GetRoomWithSubscribers(roomId int) (*Room, error)
 			&description,
 			&seqId,
 			&ownerId,
+			&kind,
 			&roomCreatedAt,
 			&roomUpdatedAt,
 			&subscriptionId,
 			&accountId,
 			&username,
+			&role,
+			&lastReadSeqId,
 			&subscriptionCreatedAt,
 			&subscriptionUpdatedAt,
 		)
@@ -248,6 +322,7 @@ func (db *PgGoChatRepository) GetRoomWithSubscribers(roomId int) (*Room, error)
 				Description:   description,
 				SeqId:         seqId,
 				OwnerId:       ownerId,
+				Kind:          kind,
 				CreatedAt:     roomCreatedAt,
 				UpdatedAt:     roomUpdatedAt,
 				Subscriptions: make([]Subscription, 0),
@@ -256,11 +331,13 @@ func (db *PgGoChatRepository) GetRoomWithSubscribers(roomId int) (*Room, error)
 
 		if accountId.Valid && username.Valid {
 			room.Subscriptions = append(room.Subscriptions, Subscription{
-				Id:        int(subscriptionId.Int64),
-				AccountId: int(accountId.Int64),
-				Username:  username.String,
-				CreatedAt: subscriptionCreatedAt.Time,
-				UpdatedAt: subscriptionUpdatedAt.Time,
+				Id:            int(subscriptionId.Int64),
+				AccountId:     int(accountId.Int64),
+				Username:      username.String,
+				Role:          role.String,
+				LastReadSeqId: int(lastReadSeqId.Int64),
+				CreatedAt:     subscriptionCreatedAt.Time,
+				UpdatedAt:     subscriptionUpdatedAt.Time,
 			})
 		}
 	}
@@ -276,8 +353,64 @@ func (db *PgGoChatRepository) GetRoomWithSubscribers(roomId int) (*Room, error)
 	return room, nil
 }
 
-func (db *PgGoChatRepository) CreateRoom(params CreateRoomParams) (Room, error) {
-	tx, err := db.conn.Begin()
+// ListRooms returns public rooms ordered by their number of subscribers.
+// Private and direct message rooms are never listed.
+func (db *PgGoChatRepository) ListRooms(ctx context.Context, params ListRoomsParams) ([]Room, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	limit := params.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT r.id, r.name, r.external_id, r.description, r.seq_id, r.owner_id, r.kind, r.created_at, r.updated_at, "+
+			"(SELECT count(*) FROM subscriptions s WHERE s.room_id = r.id) AS subscriber_count, "+
+			"EXISTS(SELECT 1 FROM room_leases l WHERE l.room_id = r.id AND l.expires_at >= now()) AS leased FROM rooms r "+
+			"WHERE r.kind = $1 AND ($2 = '' OR r.name ILIKE $3 OR r.description ILIKE $3) "+
+			"ORDER BY subscriber_count DESC, r.id ASC LIMIT $4 OFFSET $5",
+		RoomKindPublic,
+		params.Query,
+		"%"+likeEscaper.Replace(params.Query)+"%",
+		limit,
+		params.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms = make([]Room, 0, limit)
+	for rows.Next() {
+		var room Room
+		if err = rows.Scan(
+			&room.Id,
+			&room.Name,
+			&room.ExternalId,
+			&room.Description,
+			&room.SeqId,
+			&room.OwnerId,
+			&room.Kind,
+			&room.CreatedAt,
+			&room.UpdatedAt,
+			&room.SubscriberCount,
+			&room.Leased,
+		); err != nil {
+			return nil, err
+		}
+
+		rooms = append(rooms, room)
+	}
+
+	return rooms, rows.Err()
+}
+
+func (db *PgGoChatRepository) CreateRoom(ctx context.Context, params CreateRoomParams) (Room, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
 	if err != nil {
 		return Room{}, err
 	}
@@ -286,13 +419,20 @@ func (db *PgGoChatRepository) CreateRoom(params CreateRoomParams) (Room, error)
 			tx.Rollback()
 		}
 	}()
-	res := tx.QueryRow(
-		"INSERT INTO rooms (name, external_id, description, owner_id) "+
-			"VALUES ($1, $2, $3, $4) RETURNING id, name, external_id, description, owner_id, created_at, updated_at",
+
+	kind := params.Kind
+	if kind == "" {
+		kind = RoomKindPublic
+	}
+
+	res := tx.QueryRowContext(ctx,
+		"INSERT INTO rooms (name, external_id, description, owner_id, kind) "+
+			"VALUES ($1, $2, $3, $4, $5) RETURNING id, name, external_id, description, owner_id, kind, created_at, updated_at",
 		params.Name,
 		params.ExternalId,
 		params.Description,
 		params.OwnerId,
+		kind,
 	)
 
 	var room Room
@@ -302,6 +442,7 @@ func (db *PgGoChatRepository) CreateRoom(params CreateRoomParams) (Room, error)
 		&room.ExternalId,
 		&room.Description,
 		&room.OwnerId,
+		&room.Kind,
 		&room.CreatedAt,
 		&room.UpdatedAt,
 	)
@@ -309,10 +450,11 @@ func (db *PgGoChatRepository) CreateRoom(params CreateRoomParams) (Room, error)
 		return Room{}, err
 	}
 
-	_, err = tx.Exec(
-		createSubQuery,
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO subscriptions (account_id, room_id, role) VALUES ($1, $2, $3)",
 		params.OwnerId,
 		room.Id,
+		RoleOwner,
 	)
 	if err != nil {
 		return Room{}, err
@@ -325,8 +467,131 @@ func (db *PgGoChatRepository) CreateRoom(params CreateRoomParams) (Room, error)
 	return room, err
 }
 
-func (db *PgGoChatRepository) DeleteRoom(id int) error {
-	tx, err := db.conn.Begin()
+// UpdateRoom changes the name and description of a room.
+// It returns sql.ErrNoRows if the room doesn't exist.
+func (db *PgGoChatRepository) UpdateRoom(ctx context.Context, params UpdateRoomParams) (Room, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var room Room
+	err := db.conn.QueryRowContext(ctx,
+		"UPDATE rooms SET name = $1, description = $2, updated_at = $3 WHERE id = $4 "+
+			"RETURNING id, name, external_id, description, seq_id, owner_id, kind, created_at, updated_at",
+		params.Name,
+		params.Description,
+		time.Now().UTC(),
+		params.Id,
+	).Scan(
+		&room.Id,
+		&room.Name,
+		&room.ExternalId,
+		&room.Description,
+		&room.SeqId,
+		&room.OwnerId,
+		&room.Kind,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+
+	return room, err
+}
+
+// GetOrCreateDirectRoom returns the direct message room between two users, creating it
+// if it doesn't exist. Both users are (re)subscribed to the room. The returned bool
+// reports whether the room was created. Concurrent calls for the same pair of users
+// resolve to the same room.
+func (db *PgGoChatRepository) GetOrCreateDirectRoom(ctx context.Context, params CreateDirectRoomParams) (Room, bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return Room{}, false, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	directKey := directRoomKey(params.UserId, params.PeerId)
+	created := true
+
+	var room Room
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO rooms (name, external_id, description, owner_id, kind, direct_key) "+
+			"VALUES ('', $1, '', $2, $3, $4) ON CONFLICT (direct_key) DO NOTHING "+
+			"RETURNING id, name, external_id, description, seq_id, owner_id, kind, created_at, updated_at",
+		params.ExternalId,
+		params.UserId,
+		RoomKindDirect,
+		directKey,
+	).Scan(
+		&room.Id,
+		&room.Name,
+		&room.ExternalId,
+		&room.Description,
+		&room.SeqId,
+		&room.OwnerId,
+		&room.Kind,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the room already exists
+		created = false
+		err = tx.QueryRowContext(ctx,
+			"SELECT id, name, external_id, description, seq_id, owner_id, kind, created_at, updated_at "+
+				"FROM rooms WHERE direct_key = $1",
+			directKey,
+		).Scan(
+			&room.Id,
+			&room.Name,
+			&room.ExternalId,
+			&room.Description,
+			&room.SeqId,
+			&room.OwnerId,
+			&room.Kind,
+			&room.CreatedAt,
+			&room.UpdatedAt,
+		)
+	}
+	if err != nil {
+		return Room{}, false, err
+	}
+
+	for _, accountId := range []int{params.UserId, params.PeerId} {
+		if _, err = tx.ExecContext(ctx,
+			"INSERT INTO subscriptions (account_id, room_id) VALUES ($1, $2) "+
+				"ON CONFLICT (account_id, room_id) DO NOTHING",
+			accountId,
+			room.Id,
+		); err != nil {
+			return Room{}, false, fmt.Errorf("failed to create subscription: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Room{}, false, err
+	}
+
+	return room, created, nil
+}
+
+// directRoomKey returns a key identifying the direct message room between
+// two users that is independent of the order of the users.
+func directRoomKey(userId, peerId int) string {
+	if userId > peerId {
+		userId, peerId = peerId, userId
+	}
+	return fmt.Sprintf("%d:%d", userId, peerId)
+}
+
+func (db *PgGoChatRepository) DeleteRoom(ctx context.Context, id int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -336,17 +601,27 @@ func (db *PgGoChatRepository) DeleteRoom(id int) error {
 		}
 	}()
 
-	_, err = tx.Exec("DELETE FROM subscriptions WHERE room_id = $1", id)
+	_, err = tx.ExecContext(ctx, "DELETE FROM subscriptions WHERE room_id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM messages WHERE room_id = $1", id)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec("DELETE FROM messages WHERE room_id = $1", id)
+	// the attachments are removed from the blob store by the sweeper
+	_, err = tx.ExecContext(ctx,
+		"UPDATE attachments SET deleted_at = $1 WHERE room_id = $2 AND deleted_at IS NULL",
+		time.Now().UTC(),
+		id,
+	)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec("DELETE FROM rooms WHERE id = $1", id)
+	_, err = tx.ExecContext(ctx, "DELETE FROM rooms WHERE id = $1", id)
 	if err != nil {
 		return err
 	}
@@ -354,8 +629,11 @@ func (db *PgGoChatRepository) DeleteRoom(id int) error {
 	return tx.Commit()
 }
 
-func (db *PgGoChatRepository) CreateSubscription(userId, roomId int) (Subscription, error) {
-	res := db.conn.QueryRow(
+func (db *PgGoChatRepository) CreateSubscription(ctx context.Context, userId, roomId int) (Subscription, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	res := db.conn.QueryRowContext(ctx,
 		createSubQuery,
 		userId,
 		roomId,
@@ -371,8 +649,11 @@ func (db *PgGoChatRepository) CreateSubscription(userId, roomId int) (Subscripti
 	return sub, err
 }
 
-func (db *PgGoChatRepository) SubscriptionExists(account_id, room_id int) bool {
-	res := db.conn.QueryRow(
+func (db *PgGoChatRepository) SubscriptionExists(ctx context.Context, account_id, room_id int) bool {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	res := db.conn.QueryRowContext(ctx,
 		"SELECT id FROM subscriptions WHERE account_id = $1 AND room_id = $2 LIMIT 1",
 		account_id,
 		room_id,
@@ -386,10 +667,14 @@ func (db *PgGoChatRepository) SubscriptionExists(account_id, room_id int) bool {
 	return err == nil
 }
 
-func (db *PgGoChatRepository) ListSubscriptions(account_id int) ([]Subscription, error) {
-	rows, err := db.conn.Query(
-		"SELECT s.id, s.last_read_seq_id, s.created_at, s.updated_at, r.id AS room_id, r.external_id, "+
-			"r.name, r.description, r.seq_id, r.created_at AS room_created_at, r.updated_at AS room_updated_at "+
+func (db *PgGoChatRepository) ListSubscriptions(ctx context.Context, account_id int) ([]Subscription, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT s.id, s.last_read_seq_id, s.role, s.created_at, s.updated_at, r.id AS room_id, r.external_id, "+
+			"r.name, r.description, r.seq_id, r.kind, r.created_at AS room_created_at, r.updated_at AS room_updated_at, "+
+			"GREATEST(r.seq_id - s.last_read_seq_id, 0), "+unreadMentionsQuery+" "+
 			"FROM subscriptions s JOIN rooms r ON r.id = s.room_id WHERE s.account_id = $1",
 		account_id,
 	)
@@ -407,6 +692,7 @@ func (db *PgGoChatRepository) ListSubscriptions(account_id int) ([]Subscription,
 		if err = rows.Scan(
 			&sub.Id,
 			&sub.LastReadSeqId,
+			&sub.Role,
 			&sub.CreatedAt,
 			&sub.UpdatedAt,
 			&room.Id,
@@ -414,8 +700,11 @@ func (db *PgGoChatRepository) ListSubscriptions(account_id int) ([]Subscription,
 			&room.Name,
 			&room.Description,
 			&room.SeqId,
+			&room.Kind,
 			&room.CreatedAt,
 			&room.UpdatedAt,
+			&sub.UnreadCount,
+			&sub.MentionCount,
 		); err != nil {
 			break
 		}
@@ -427,8 +716,11 @@ func (db *PgGoChatRepository) ListSubscriptions(account_id int) ([]Subscription,
 	return subs, err
 }
 
-func (db *PgGoChatRepository) DeleteSubscription(accountId, roomId int) error {
-	_, err := db.conn.Exec(
+func (db *PgGoChatRepository) DeleteSubscription(ctx context.Context, accountId, roomId int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.conn.ExecContext(ctx,
 		"DELETE FROM subscriptions WHERE account_id = $1 AND room_id = $2",
 		accountId,
 		roomId,
@@ -437,111 +729,1540 @@ func (db *PgGoChatRepository) DeleteSubscription(accountId, roomId int) error {
 	return err
 }
 
-func (db *PgGoChatRepository) UpdateLastReadSeqId(userId, roomId, seqId int) error {
-	_, err := db.conn.Exec(
-		"UPDATE subscriptions SET last_read_seq_id = $1, updated_at = $2 "+
-			"WHERE account_id = $3 AND room_id = $4",
-		seqId,
+// GetSubscriptionRole returns the role of a user in a room.
+// It returns sql.ErrNoRows if the user is not subscribed to the room.
+func (db *PgGoChatRepository) GetSubscriptionRole(ctx context.Context, accountId, roomId int) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var role string
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT role FROM subscriptions WHERE account_id = $1 AND room_id = $2",
+		accountId,
+		roomId,
+	).Scan(&role)
+
+	return role, err
+}
+
+// UpdateSubscriptionRole changes the role of a user in a room.
+// It returns sql.ErrNoRows if the user is not subscribed to the room.
+func (db *PgGoChatRepository) UpdateSubscriptionRole(ctx context.Context, accountId, roomId int, role string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var id int
+	return db.conn.QueryRowContext(ctx,
+		"UPDATE subscriptions SET role = $1, updated_at = $2 WHERE account_id = $3 AND room_id = $4 RETURNING id",
+		role,
 		time.Now().UTC(),
-		userId,
+		accountId,
 		roomId,
+	).Scan(&id)
+}
+
+// CreateInvite invites a user to a room. Inviting a user that already
+// has a pending invitation to the room refreshes the invitation.
+func (db *PgGoChatRepository) CreateInvite(ctx context.Context, params CreateInviteParams) (Invite, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var invite Invite
+	err := db.conn.QueryRowContext(ctx,
+		"INSERT INTO room_invites (room_id, account_id, invited_by, created_at) VALUES ($1, $2, $3, $4) "+
+			"ON CONFLICT (room_id, account_id) DO UPDATE SET invited_by = EXCLUDED.invited_by, created_at = EXCLUDED.created_at "+
+			"RETURNING id, room_id, account_id, invited_by, created_at",
+		params.RoomId,
+		params.AccountId,
+		params.InvitedBy,
+		time.Now().UTC(),
+	).Scan(
+		&invite.Id,
+		&invite.RoomId,
+		&invite.AccountId,
+		&invite.InvitedBy,
+		&invite.CreatedAt,
 	)
 
-	return err
+	return invite, err
+}
+
+func (db *PgGoChatRepository) ListInvites(ctx context.Context, roomId int) ([]Invite, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT i.id, i.room_id, i.account_id, a.username, i.invited_by, i.created_at FROM room_invites i "+
+			"JOIN accounts a ON a.id = i.account_id WHERE i.room_id = $1 ORDER BY i.created_at ASC",
+		roomId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites = make([]Invite, 0)
+	for rows.Next() {
+		var invite Invite
+		if err = rows.Scan(
+			&invite.Id,
+			&invite.RoomId,
+			&invite.AccountId,
+			&invite.Username,
+			&invite.InvitedBy,
+			&invite.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		invites = append(invites, invite)
+	}
+
+	return invites, rows.Err()
+}
+
+// DeleteInvite revokes a pending invitation. It returns sql.ErrNoRows if the user is not invited to the room.
+func (db *PgGoChatRepository) DeleteInvite(ctx context.Context, roomId, accountId int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var id int
+	return db.conn.QueryRowContext(ctx,
+		"DELETE FROM room_invites WHERE room_id = $1 AND account_id = $2 RETURNING id",
+		roomId,
+		accountId,
+	).Scan(&id)
 }
 
-func (db *PgGoChatRepository) CreateMessage(msg Message) error {
-	tx, err := db.conn.Begin()
+// AcceptInvite consumes a user's invitation to a room and subscribes them to it.
+// It returns sql.ErrNoRows if the user is not invited to the room.
+func (db *PgGoChatRepository) AcceptInvite(ctx context.Context, accountId, roomId int) (Subscription, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return Subscription{}, err
+	}
 	defer func() {
 		if err != nil {
 			tx.Rollback()
 		}
 	}()
 
-	if err = db.UpdateRoomOnMessage(msg); err != nil {
-		return fmt.Errorf("failed to update room on message: %w", err)
+	var inviteId int
+	err = tx.QueryRowContext(ctx,
+		"DELETE FROM room_invites WHERE room_id = $1 AND account_id = $2 RETURNING id",
+		roomId,
+		accountId,
+	).Scan(&inviteId)
+	if err != nil {
+		return Subscription{}, err
 	}
-	if _, err = db.conn.Exec(
-		"INSERT INTO messages (seq_id, room_id, user_id, content, created_at, updated_at) "+
-			"VALUES ($1, $2, $3, $4, $5, $6)",
-		msg.SeqId,
-		msg.RoomId,
-		msg.UserId,
-		msg.Content,
-		msg.CreatedAt,
-		msg.CreatedAt,
+
+	var sub Subscription
+	if err = tx.QueryRowContext(ctx, createSubQuery, accountId, roomId).Scan(
+		&sub.Id,
+		&sub.AccountId,
+		&sub.RoomId,
 	); err != nil {
-		return fmt.Errorf("failed to insert message: %w", err)
+		return Subscription{}, fmt.Errorf("failed to create subscription: %w", err)
 	}
 
 	if err = tx.Commit(); err != nil {
-		return err
+		return Subscription{}, err
 	}
 
-	return err
-}
-
-func (db *PgGoChatRepository) UpdateRoomOnMessage(msg Message) error {
-	_, err := db.conn.Exec("UPDATE rooms SET seq_id = $1 WHERE id = $2", msg.SeqId, msg.RoomId)
-
-	return err
+	return sub, nil
 }
 
-func (db *PgGoChatRepository) GetSubscribersByRoomId(roomId int) ([]User, error) {
-	rows, err := db.conn.Query(
-		"SELECT a.id, a.username FROM subscriptions AS s "+
-			"JOIN accounts AS a ON s.account_id = a.id WHERE s.room_id = $1",
-		roomId,
-	)
+// CreateBan bans a user from a room, removing their subscription and any pending
+// invitation. Banning a user that is already banned replaces the existing ban.
+func (db *PgGoChatRepository) CreateBan(ctx context.Context, params CreateBanParams) (Ban, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	var subs = make([]User, 0)
-	for rows.Next() {
-		var sub User
-		if err = rows.Scan(&sub.Id, &sub.Username); err != nil {
-			break
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return Ban{}, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
 		}
+	}()
 
-		subs = append(subs, sub)
+	var (
+		ban       Ban
+		expiresAt sql.NullTime
+	)
+	if params.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: params.ExpiresAt.UTC(), Valid: true}
 	}
 
-	return subs, err
-}
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO room_bans (room_id, account_id, banned_by, reason, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6) "+
+			"ON CONFLICT (room_id, account_id) DO UPDATE SET banned_by = EXCLUDED.banned_by, reason = EXCLUDED.reason, "+
+			"expires_at = EXCLUDED.expires_at, created_at = EXCLUDED.created_at "+
+			"RETURNING id, room_id, account_id, banned_by, reason, expires_at, created_at",
+		params.RoomId,
+		params.AccountId,
+		params.BannedBy,
+		params.Reason,
+		expiresAt,
+		time.Now().UTC(),
+	).Scan(
+		&ban.Id,
+		&ban.RoomId,
+		&ban.AccountId,
+		&ban.BannedBy,
+		&ban.Reason,
+		&expiresAt,
+		&ban.CreatedAt,
+	)
+	if err != nil {
+		return Ban{}, fmt.Errorf("failed to insert ban: %w", err)
+	}
+	if expiresAt.Valid {
+		ban.ExpiresAt = &expiresAt.Time
+	}
 
-func (db *PgGoChatRepository) GetMessages(roomId, since, before, limit int) ([]Message, error) {
-	var upper, lower int = 1<<31 - 1, 0
-	if before > 0 {
-		upper = before - 1
+	if _, err = tx.ExecContext(ctx,
+		"DELETE FROM subscriptions WHERE account_id = $1 AND room_id = $2",
+		params.AccountId,
+		params.RoomId,
+	); err != nil {
+		return Ban{}, fmt.Errorf("failed to delete subscription: %w", err)
 	}
 
-	if since > 0 {
-		lower = since
+	if _, err = tx.ExecContext(ctx,
+		"DELETE FROM room_invites WHERE account_id = $1 AND room_id = $2",
+		params.AccountId,
+		params.RoomId,
+	); err != nil {
+		return Ban{}, fmt.Errorf("failed to delete invite: %w", err)
 	}
 
-	if limit <= 0 {
-		limit = 20
+	if err = tx.Commit(); err != nil {
+		return Ban{}, err
 	}
 
-	rows, err := db.conn.Query(
-		"SELECT id, seq_id, room_id, user_id, content, created_at FROM messages "+
-			"WHERE room_id = $1 AND seq_id BETWEEN $2 AND $3 ORDER BY seq_id DESC LIMIT $4",
+	return ban, nil
+}
+
+// ListBans returns the bans of a room that have not expired.
+func (db *PgGoChatRepository) ListBans(ctx context.Context, roomId int) ([]Ban, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT b.id, b.room_id, b.account_id, a.username, b.banned_by, b.reason, b.expires_at, b.created_at FROM room_bans b "+
+			"JOIN accounts a ON a.id = b.account_id WHERE b.room_id = $1 AND (b.expires_at IS NULL OR b.expires_at > $2) "+
+			"ORDER BY b.created_at ASC",
 		roomId,
-		lower,
-		upper,
-		limit,
+		time.Now().UTC(),
 	)
-
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	var messages = make([]Message, 0, limit)
+	var bans = make([]Ban, 0)
 	for rows.Next() {
-		var msg Message
-		if err = rows.Scan(&msg.Id, &msg.SeqId, &msg.RoomId, &msg.UserId, &msg.Content, &msg.CreatedAt); err != nil {
-			break
+		var (
+			ban       Ban
+			expiresAt sql.NullTime
+		)
+		if err = rows.Scan(
+			&ban.Id,
+			&ban.RoomId,
+			&ban.AccountId,
+			&ban.Username,
+			&ban.BannedBy,
+			&ban.Reason,
+			&expiresAt,
+			&ban.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			ban.ExpiresAt = &expiresAt.Time
 		}
 
-		messages = append(messages, msg)
+		bans = append(bans, ban)
 	}
-	return messages, err
+
+	return bans, rows.Err()
+}
+
+// DeleteBan lifts a user's ban from a room. It returns sql.ErrNoRows if the user is not banned.
+func (db *PgGoChatRepository) DeleteBan(ctx context.Context, roomId, accountId int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var id int
+	return db.conn.QueryRowContext(ctx,
+		"DELETE FROM room_bans WHERE room_id = $1 AND account_id = $2 RETURNING id",
+		roomId,
+		accountId,
+	).Scan(&id)
+}
+
+// IsBanned reports whether a user has a ban from a room that has not expired.
+func (db *PgGoChatRepository) IsBanned(ctx context.Context, accountId, roomId int) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var banned bool
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM room_bans WHERE account_id = $1 AND room_id = $2 "+
+			"AND (expires_at IS NULL OR expires_at > $3))",
+		accountId,
+		roomId,
+		time.Now().UTC(),
+	).Scan(&banned)
+
+	return banned, err
+}
+
+func (db *PgGoChatRepository) UpdateLastReadSeqId(ctx context.Context, userId, roomId, seqId int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.conn.ExecContext(ctx,
+		"UPDATE subscriptions SET last_read_seq_id = $1, updated_at = $2 "+
+			"WHERE account_id = $3 AND room_id = $4",
+		seqId,
+		time.Now().UTC(),
+		userId,
+		roomId,
+	)
+
+	return err
+}
+
+// GetUnread returns the read state of a user in a room.
+// It returns sql.ErrNoRows if the user is not subscribed to the room.
+func (db *PgGoChatRepository) GetUnread(ctx context.Context, accountId, roomId int) (Unread, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var unread Unread
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT s.account_id, s.last_read_seq_id, "+unreadMentionsQuery+" "+
+			"FROM subscriptions s WHERE s.account_id = $1 AND s.room_id = $2",
+		accountId,
+		roomId,
+	).Scan(&unread.AccountId, &unread.LastReadSeqId, &unread.Mentions)
+
+	return unread, err
+}
+
+// ListUnread returns the read state of all subscribers of a room.
+func (db *PgGoChatRepository) ListUnread(ctx context.Context, roomId int) ([]Unread, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT s.account_id, s.last_read_seq_id, "+unreadMentionsQuery+" "+
+			"FROM subscriptions s WHERE s.room_id = $1",
+		roomId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var unread = make([]Unread, 0)
+	for rows.Next() {
+		var u Unread
+		if err = rows.Scan(&u.AccountId, &u.LastReadSeqId, &u.Mentions); err != nil {
+			return nil, err
+		}
+
+		unread = append(unread, u)
+	}
+
+	return unread, rows.Err()
+}
+
+// CreateMessage saves a message with the next seq id in its room. The seq id is allocated in
+// the same transaction the message is inserted in, so messages saved concurrently, e.g. by
+// different nodes, get consecutive seq ids. The seq id of msg is ignored and the stored message
+// is returned. If saving the message keeps conflicting with concurrent transactions,
+// ErrSeqIdConflict is returned.
+func (db *PgGoChatRepository) CreateMessage(ctx context.Context, msg Message) (Message, error) {
+	stored, err := db.CreateMessages(ctx, []Message{msg})
+	if err != nil {
+		return Message{}, err
+	}
+
+	return stored[0], nil
+}
+
+// CreateMessages saves messages published in the same room in a single transaction, like
+// CreateMessage does for one message. The messages get consecutive seq ids in the order
+// they are given and are returned in the same order. Either all of them are saved or none.
+func (db *PgGoChatRepository) CreateMessages(ctx context.Context, msgs []Message) ([]Message, error) {
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	if len(msgs) > MaxMessageBatchSize {
+		return nil, fmt.Errorf("cannot save more than %d messages at once", MaxMessageBatchSize)
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		stored, err := db.createMessages(ctx, msgs)
+		if !errors.Is(err, ErrSeqIdConflict) || attempt == maxCreateMessageAttempts {
+			return stored, err
+		}
+	}
+}
+
+// createMessages makes a single attempt at saving messages for CreateMessages.
+func (db *PgGoChatRepository) createMessages(ctx context.Context, msgs []Message) (stored []Message, err error) {
+	roomId := msgs[0].RoomId
+	for _, msg := range msgs {
+		if msg.RoomId != roomId {
+			return nil, errors.New("messages must be in the same room")
+		}
+	}
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// the room stays locked until the transaction ends, so the messages in a room are
+	// saved one batch at a time. Messages saved without updating the room are skipped over.
+	var lastSeqId int
+	if err = tx.QueryRowContext(ctx,
+		"UPDATE rooms SET seq_id = GREATEST(seq_id, (SELECT COALESCE(MAX(seq_id), 0) FROM messages WHERE room_id = $1)) + $2 "+
+			"WHERE id = $1 RETURNING seq_id",
+		roomId,
+		len(msgs),
+	).Scan(&lastSeqId); err != nil {
+		return nil, fmt.Errorf("failed to allocate seq ids: %w", conflictErr(err))
+	}
+
+	stored = slices.Clone(msgs)
+	firstSeqId := lastSeqId - len(msgs) + 1
+
+	var (
+		values strings.Builder
+		args   = make([]any, 0, len(msgs)*messageInsertColumns)
+	)
+	for i := range stored {
+		stored[i].SeqId = firstSeqId + i
+		if i > 0 {
+			values.WriteString(", ")
+		}
+		n := i * messageInsertColumns
+		fmt.Fprintf(&values, "($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8)
+		args = append(args,
+			stored[i].SeqId,
+			stored[i].RoomId,
+			stored[i].UserId,
+			stored[i].Content,
+			stored[i].ContentHTML,
+			sql.NullInt64{Int64: int64(stored[i].ParentSeqId), Valid: stored[i].ParentSeqId > 0},
+			stored[i].CreatedAt,
+			stored[i].CreatedAt,
+		)
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		"INSERT INTO messages (seq_id, room_id, user_id, content, content_html, parent_seq_id, created_at, updated_at) "+
+			"VALUES "+values.String()+" RETURNING seq_id, id, created_at, updated_at",
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert messages: %w", conflictErr(err))
+	}
+
+	// the rows aren't necessarily returned in the order they were inserted in
+	for rows.Next() {
+		var seqId int
+		var m Message
+		if err = rows.Scan(&seqId, &m.Id, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to insert messages: %w", err)
+		}
+
+		msg := &stored[seqId-firstSeqId]
+		msg.Id, msg.CreatedAt, msg.UpdatedAt = m.Id, m.CreatedAt, m.UpdatedAt
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to insert messages: %w", conflictErr(err))
+	}
+
+	for i := range stored {
+		if err = attachAttachments(ctx, tx, &stored[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, conflictErr(err)
+	}
+
+	return stored, nil
+}
+
+// attachAttachments attaches the attachments of a message being saved to it.
+func attachAttachments(ctx context.Context, tx *sql.Tx, msg *Message) error {
+	if len(msg.Attachments) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(msg.Attachments))
+	for _, a := range msg.Attachments {
+		ids = append(ids, int64(a.Id))
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE attachments SET seq_id = $1 WHERE room_id = $2 AND id = ANY($3) AND seq_id IS NULL AND deleted_at IS NULL",
+		msg.SeqId,
+		msg.RoomId,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to attach attachments: %w", conflictErr(err))
+	}
+
+	n, err := res.RowsAffected()
+	if err == nil && n != int64(len(ids)) {
+		err = errors.New("attachments are already attached to a message")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to attach attachments: %w", err)
+	}
+
+	msg.Attachments = slices.Clone(msg.Attachments)
+	for i := range msg.Attachments {
+		msg.Attachments[i].SeqId = msg.SeqId
+	}
+
+	return nil
+}
+
+// conflictErr returns ErrSeqIdConflict if err is caused by a concurrent transaction,
+// so the transaction can be tried again, otherwise it returns err.
+func conflictErr(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case uniqueViolation, serializationFailure, deadlockDetected:
+			return ErrSeqIdConflict
+		}
+	}
+
+	return err
+}
+
+func (db *PgGoChatRepository) GetSubscribersByRoomId(ctx context.Context, roomId int) ([]User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT a.id, a.username FROM subscriptions AS s "+
+			"JOIN accounts AS a ON s.account_id = a.id WHERE s.room_id = $1",
+		roomId,
+	)
+
+	var subs = make([]User, 0)
+	for rows.Next() {
+		var sub User
+		if err = rows.Scan(&sub.Id, &sub.Username); err != nil {
+			break
+		}
+
+		subs = append(subs, sub)
+	}
+
+	return subs, err
+}
+
+func (db *PgGoChatRepository) GetMessages(ctx context.Context, roomId, since, before, limit int) ([]Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var upper, lower int = 1<<31 - 1, 0
+	if before > 0 {
+		upper = before - 1
+	}
+
+	if since > 0 {
+		lower = since
+	}
+
+	if limit <= 0 {
+		limit = 20
+	}
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT "+messageColumns+" FROM messages m "+
+			"WHERE m.room_id = $1 AND m.seq_id BETWEEN $2 AND $3 ORDER BY m.seq_id DESC LIMIT $4",
+		roomId,
+		lower,
+		upper,
+		limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages = make([]Message, 0, limit)
+	for rows.Next() {
+		var msg Message
+		if msg, err = scanMessage(rows); err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err = db.loadReactions(ctx, roomId, messages); err != nil {
+		return nil, fmt.Errorf("failed to load reactions: %w", err)
+	}
+
+	if err = db.loadAttachments(ctx, roomId, messages); err != nil {
+		return nil, fmt.Errorf("failed to load attachments: %w", err)
+	}
+
+	if err = db.loadLinkPreviews(ctx, messages); err != nil {
+		return nil, fmt.Errorf("failed to load link previews: %w", err)
+	}
+
+	return messages, nil
+}
+
+func (db *PgGoChatRepository) GetMessage(ctx context.Context, roomId, seqId int) (Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	row := db.conn.QueryRowContext(ctx,
+		"SELECT "+messageColumns+" FROM messages m "+
+			"WHERE m.room_id = $1 AND m.seq_id = $2 LIMIT 1",
+		roomId,
+		seqId,
+	)
+
+	return scanMessage(row)
+}
+
+// searchHeadlineOptions configures the snippets of search results. Content is
+// HTML escaped before the headline is generated so the only markup in a snippet
+// is the <mark> tags around matching terms.
+const searchHeadlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxWords=20, MinWords=5, MaxFragments=2"
+
+// SearchMessages finds messages matching a full-text query in the rooms the user is subscribed to.
+// Deleted messages are never matched.
+func (db *PgGoChatRepository) SearchMessages(ctx context.Context, params SearchMessagesParams) ([]Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	limit := params.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+
+	before := params.Before
+	if before <= 0 {
+		before = 1<<31 - 1
+	}
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT m.id, m.seq_id, m.room_id, r.external_id, m.user_id, m.content, m.content_html, m.parent_seq_id, m.created_at, m.updated_at, "+
+			"ts_headline('english', replace(replace(replace(coalesce(m.content, ''), '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), q, $1) "+
+			"FROM messages m "+
+			"JOIN subscriptions s ON s.room_id = m.room_id AND s.account_id = $2 "+
+			"JOIN rooms r ON r.id = m.room_id, "+
+			"websearch_to_tsquery('english', $3) q "+
+			"WHERE m.search_vector @@ q AND m.deleted_at IS NULL AND ($4 = 0 OR m.room_id = $4) AND m.id < $5 "+
+			"ORDER BY m.id DESC LIMIT $6",
+		searchHeadlineOptions,
+		params.AccountId,
+		params.Query,
+		params.RoomId,
+		before,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages = make([]Message, 0, limit)
+	for rows.Next() {
+		var (
+			msg         Message
+			parentSeqId sql.NullInt64
+		)
+		if err = rows.Scan(
+			&msg.Id,
+			&msg.SeqId,
+			&msg.RoomId,
+			&msg.ExternalRoomId,
+			&msg.UserId,
+			&msg.Content,
+			&msg.ContentHTML,
+			&parentSeqId,
+			&msg.CreatedAt,
+			&msg.UpdatedAt,
+			&msg.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		msg.ParentSeqId = int(parentSeqId.Int64)
+
+		messages = append(messages, msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err = db.loadLinkPreviews(ctx, messages); err != nil {
+		return nil, fmt.Errorf("failed to load link previews: %w", err)
+	}
+
+	return messages, nil
+}
+
+// CreateMentions records that a message mentions each of the accounts.
+func (db *PgGoChatRepository) CreateMentions(ctx context.Context, roomId, seqId int, accountIds []int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.conn.ExecContext(ctx,
+		"INSERT INTO mentions (room_id, seq_id, account_id, created_at) "+
+			"SELECT $1, $2, unnest($3::integer[]), $4 ON CONFLICT DO NOTHING",
+		roomId,
+		seqId,
+		pq.Array(accountIds),
+		time.Now().UTC(),
+	)
+
+	return err
+}
+
+// ListMentions returns the messages that mention a user in the rooms they are
+// subscribed to, ordered from newest to oldest.
+func (db *PgGoChatRepository) ListMentions(ctx context.Context, params ListMentionsParams) ([]Mention, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	limit := params.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+
+	before := params.Before
+	if before <= 0 {
+		before = 1<<31 - 1
+	}
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT mn.id, mn.account_id, mn.created_at, m.id, m.seq_id, m.room_id, r.external_id, m.user_id, "+
+			"m.content, m.content_html, m.parent_seq_id, m.created_at, m.updated_at "+
+			"FROM mentions mn "+
+			"JOIN messages m ON m.room_id = mn.room_id AND m.seq_id = mn.seq_id "+
+			"JOIN subscriptions s ON s.room_id = mn.room_id AND s.account_id = mn.account_id "+
+			"JOIN rooms r ON r.id = mn.room_id "+
+			"WHERE mn.account_id = $1 AND m.deleted_at IS NULL AND mn.id < $2 "+
+			"ORDER BY mn.id DESC LIMIT $3",
+		params.AccountId,
+		before,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mentions = make([]Mention, 0, limit)
+	for rows.Next() {
+		var (
+			mention     Mention
+			parentSeqId sql.NullInt64
+		)
+		if err = rows.Scan(
+			&mention.Id,
+			&mention.AccountId,
+			&mention.CreatedAt,
+			&mention.Message.Id,
+			&mention.Message.SeqId,
+			&mention.Message.RoomId,
+			&mention.Message.ExternalRoomId,
+			&mention.Message.UserId,
+			&mention.Message.Content,
+			&mention.Message.ContentHTML,
+			&parentSeqId,
+			&mention.Message.CreatedAt,
+			&mention.Message.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		mention.Message.ParentSeqId = int(parentSeqId.Int64)
+
+		mentions = append(mentions, mention)
+	}
+
+	return mentions, rows.Err()
+}
+
+// GetThread returns the replies to a message ordered from oldest to newest.
+func (db *PgGoChatRepository) GetThread(ctx context.Context, roomId, parentSeqId int) ([]Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT "+messageColumns+" FROM messages m "+
+			"WHERE m.room_id = $1 AND m.parent_seq_id = $2 ORDER BY m.seq_id ASC",
+		roomId,
+		parentSeqId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages = make([]Message, 0)
+	for rows.Next() {
+		var msg Message
+		if msg, err = scanMessage(rows); err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err = db.loadReactions(ctx, roomId, messages); err != nil {
+		return nil, fmt.Errorf("failed to load reactions: %w", err)
+	}
+
+	if err = db.loadAttachments(ctx, roomId, messages); err != nil {
+		return nil, fmt.Errorf("failed to load attachments: %w", err)
+	}
+
+	if err = db.loadLinkPreviews(ctx, messages); err != nil {
+		return nil, fmt.Errorf("failed to load link previews: %w", err)
+	}
+
+	return messages, nil
+}
+
+// loadAttachments populates the attachments of the given messages, which must all belong to the same room.
+func (db *PgGoChatRepository) loadAttachments(ctx context.Context, roomId int, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	seqIds := make([]int64, 0, len(messages))
+	index := make(map[int]int, len(messages))
+	for i, msg := range messages {
+		seqIds = append(seqIds, int64(msg.SeqId))
+		index[msg.SeqId] = i
+	}
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT "+attachmentColumns+" FROM attachments a "+
+			"WHERE a.room_id = $1 AND a.seq_id = ANY($2) AND a.deleted_at IS NULL ORDER BY a.id",
+		roomId,
+		pq.Array(seqIds),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return err
+		}
+
+		msg := &messages[index[a.SeqId]]
+		msg.Attachments = append(msg.Attachments, a)
+	}
+
+	return rows.Err()
+}
+
+const attachmentColumns = "a.id, a.external_id, a.room_id, a.account_id, a.seq_id, a.filename, a.content_type, a.size, a.created_at"
+
+func scanAttachment(row rowScanner) (Attachment, error) {
+	var (
+		a     Attachment
+		seqId sql.NullInt64
+	)
+	err := row.Scan(
+		&a.Id,
+		&a.ExternalId,
+		&a.RoomId,
+		&a.AccountId,
+		&seqId,
+		&a.Filename,
+		&a.ContentType,
+		&a.Size,
+		&a.CreatedAt,
+	)
+	a.SeqId = int(seqId.Int64)
+
+	return a, err
+}
+
+// CreateAttachment records an uploaded attachment that isn't attached to a message yet.
+func (db *PgGoChatRepository) CreateAttachment(ctx context.Context, params CreateAttachmentParams) (Attachment, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	row := db.conn.QueryRowContext(ctx,
+		"INSERT INTO attachments AS a (external_id, room_id, account_id, filename, content_type, size, created_at) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING "+attachmentColumns,
+		params.ExternalId,
+		params.RoomId,
+		params.AccountId,
+		params.Filename,
+		params.ContentType,
+		params.Size,
+		time.Now().UTC(),
+	)
+
+	return scanAttachment(row)
+}
+
+func (db *PgGoChatRepository) GetAttachment(ctx context.Context, externalId string) (Attachment, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	row := db.conn.QueryRowContext(ctx,
+		"SELECT "+attachmentColumns+" FROM attachments a WHERE a.external_id = $1 AND a.deleted_at IS NULL",
+		externalId,
+	)
+
+	return scanAttachment(row)
+}
+
+// GetAttachments returns the attachments of a room with the given external ids.
+// Ids that don't match an attachment in the room are ignored.
+func (db *PgGoChatRepository) GetAttachments(ctx context.Context, roomId int, externalIds []string) ([]Attachment, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT "+attachmentColumns+" FROM attachments a "+
+			"WHERE a.room_id = $1 AND a.external_id = ANY($2) AND a.deleted_at IS NULL ORDER BY a.id",
+		roomId,
+		pq.Array(externalIds),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments = make([]Attachment, 0, len(externalIds))
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		attachments = append(attachments, a)
+	}
+
+	return attachments, rows.Err()
+}
+
+// DeleteUnattachedAttachments deletes the uploads created before the given time that
+// were never attached to a message. Attaching an upload and deleting it exclude each
+// other, so a deleted upload can't end up attached to a message.
+func (db *PgGoChatRepository) DeleteUnattachedAttachments(ctx context.Context, createdBefore time.Time) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.conn.ExecContext(ctx,
+		"UPDATE attachments SET deleted_at = $1 WHERE seq_id IS NULL AND created_at < $2 AND deleted_at IS NULL",
+		time.Now().UTC(),
+		createdBefore,
+	)
+
+	return err
+}
+
+// ListDeletedAttachments returns up to limit deleted attachments whose contents may still
+// be in the blob store, oldest first.
+func (db *PgGoChatRepository) ListDeletedAttachments(ctx context.Context, limit int) ([]Attachment, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT "+attachmentColumns+" FROM attachments a WHERE a.deleted_at IS NOT NULL ORDER BY a.deleted_at LIMIT $1",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		attachments = append(attachments, a)
+	}
+
+	return attachments, rows.Err()
+}
+
+// PurgeAttachments removes deleted attachments once their contents are removed from the blob store.
+func (db *PgGoChatRepository) PurgeAttachments(ctx context.Context, ids []int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.conn.ExecContext(ctx,
+		"DELETE FROM attachments WHERE id = ANY($1) AND deleted_at IS NOT NULL",
+		pq.Array(ids),
+	)
+
+	return err
+}
+
+// GetLinkPreview returns the cached preview of a page. It returns sql.ErrNoRows if the page isn't cached.
+func (db *PgGoChatRepository) GetLinkPreview(ctx context.Context, url string) (LinkPreview, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var p LinkPreview
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT url, title, description, image_url, site_name, fetched_at FROM link_previews WHERE url = $1",
+		url,
+	).Scan(&p.URL, &p.Title, &p.Description, &p.ImageURL, &p.SiteName, &p.FetchedAt)
+
+	return p, err
+}
+
+// SaveLinkPreview caches the preview of a page, replacing any previous preview.
+func (db *PgGoChatRepository) SaveLinkPreview(ctx context.Context, p LinkPreview) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.conn.ExecContext(ctx,
+		"INSERT INTO link_previews (url, title, description, image_url, site_name, fetched_at) "+
+			"VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (url) DO UPDATE SET "+
+			"title = EXCLUDED.title, description = EXCLUDED.description, image_url = EXCLUDED.image_url, "+
+			"site_name = EXCLUDED.site_name, fetched_at = EXCLUDED.fetched_at",
+		p.URL,
+		p.Title,
+		p.Description,
+		p.ImageURL,
+		p.SiteName,
+		p.FetchedAt,
+	)
+
+	return err
+}
+
+// SaveMessageLinkPreviews links a message to the cached previews of the pages it links to,
+// in the order they appear in the message. Pages that aren't cached are skipped.
+func (db *PgGoChatRepository) SaveMessageLinkPreviews(ctx context.Context, roomId, seqId int, urls []string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.conn.ExecContext(ctx,
+		"INSERT INTO message_link_previews (room_id, seq_id, url, position) "+
+			"SELECT $1, $2, u.url, u.position FROM unnest($3::text[]) WITH ORDINALITY AS u(url, position) "+
+			"JOIN link_previews p ON p.url = u.url ON CONFLICT DO NOTHING",
+		roomId,
+		seqId,
+		pq.Array(urls),
+	)
+
+	return err
+}
+
+// loadLinkPreviews populates the link previews of the given messages, which may belong to different rooms.
+// Pages that no longer have a title have nothing to preview and are left out.
+func (db *PgGoChatRepository) loadLinkPreviews(ctx context.Context, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(messages))
+	index := make(map[int]int, len(messages))
+	for i, msg := range messages {
+		ids = append(ids, int64(msg.Id))
+		index[msg.Id] = i
+	}
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT m.id, p.url, p.title, p.description, p.image_url, p.site_name, p.fetched_at FROM message_link_previews mp "+
+			"JOIN messages m ON m.room_id = mp.room_id AND m.seq_id = mp.seq_id "+
+			"JOIN link_previews p ON p.url = mp.url "+
+			"WHERE m.id = ANY($1) AND p.title <> '' ORDER BY m.id, mp.position",
+		pq.Array(ids),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			messageId int
+			p         LinkPreview
+		)
+		if err := rows.Scan(&messageId, &p.URL, &p.Title, &p.Description, &p.ImageURL, &p.SiteName, &p.FetchedAt); err != nil {
+			return err
+		}
+
+		msg := &messages[index[messageId]]
+		msg.LinkPreviews = append(msg.LinkPreviews, p)
+	}
+
+	return rows.Err()
+}
+
+// loadReactions populates the reactions of the given messages, which must all belong to the same room.
+// Reactions are grouped by emoji and ordered by when the emoji was first used on the message.
+func (db *PgGoChatRepository) loadReactions(ctx context.Context, roomId int, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	seqIds := make([]int64, 0, len(messages))
+	index := make(map[int]int, len(messages))
+	for i, msg := range messages {
+		seqIds = append(seqIds, int64(msg.SeqId))
+		index[msg.SeqId] = i
+	}
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT seq_id, emoji, array_agg(account_id ORDER BY created_at, id) FROM message_reactions "+
+			"WHERE room_id = $1 AND seq_id = ANY($2) GROUP BY seq_id, emoji ORDER BY seq_id, min(created_at), emoji",
+		roomId,
+		pq.Array(seqIds),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			seqId      int
+			emoji      string
+			accountIds []int64
+		)
+		if err := rows.Scan(&seqId, &emoji, pq.Array(&accountIds)); err != nil {
+			return err
+		}
+
+		reaction := Reaction{Emoji: emoji, UserIds: make([]int, 0, len(accountIds))}
+		for _, id := range accountIds {
+			reaction.UserIds = append(reaction.UserIds, int(id))
+		}
+
+		msg := &messages[index[seqId]]
+		msg.Reactions = append(msg.Reactions, reaction)
+	}
+
+	return rows.Err()
+}
+
+// AddReaction records a reaction to a message. It returns sql.ErrNoRows
+// if the user has already reacted to the message with the same emoji.
+func (db *PgGoChatRepository) AddReaction(ctx context.Context, params ReactionParams) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var id int
+	return db.conn.QueryRowContext(ctx,
+		"INSERT INTO message_reactions (room_id, seq_id, account_id, emoji, created_at) "+
+			"VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING RETURNING id",
+		params.RoomId,
+		params.SeqId,
+		params.AccountId,
+		params.Emoji,
+		time.Now().UTC(),
+	).Scan(&id)
+}
+
+// RemoveReaction removes a reaction from a message. It returns sql.ErrNoRows
+// if the user has not reacted to the message with the emoji.
+func (db *PgGoChatRepository) RemoveReaction(ctx context.Context, params ReactionParams) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	res, err := db.conn.ExecContext(ctx,
+		"DELETE FROM message_reactions WHERE room_id = $1 AND seq_id = $2 AND account_id = $3 AND emoji = $4",
+		params.RoomId,
+		params.SeqId,
+		params.AccountId,
+		params.Emoji,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// PinMessage pins a message to its room. sql.ErrNoRows is returned if the message is already pinned.
+// The message of the returned pin isn't loaded.
+func (db *PgGoChatRepository) PinMessage(ctx context.Context, params PinParams) (Pin, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	pin := Pin{
+		RoomId:   params.RoomId,
+		PinnedBy: params.PinnedBy,
+	}
+	err := db.conn.QueryRowContext(ctx,
+		"INSERT INTO pinned_messages (room_id, seq_id, pinned_by, created_at) "+
+			"VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING RETURNING id, created_at",
+		params.RoomId,
+		params.SeqId,
+		params.PinnedBy,
+		time.Now().UTC(),
+	).Scan(&pin.Id, &pin.CreatedAt)
+
+	return pin, err
+}
+
+// UnpinMessage unpins a message from its room. sql.ErrNoRows is returned if the message isn't pinned.
+func (db *PgGoChatRepository) UnpinMessage(ctx context.Context, roomId, seqId int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	res, err := db.conn.ExecContext(ctx,
+		"DELETE FROM pinned_messages WHERE room_id = $1 AND seq_id = $2",
+		roomId,
+		seqId,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ListPins lists the messages pinned to a room, most recently pinned first.
+func (db *PgGoChatRepository) ListPins(ctx context.Context, roomId int) ([]Pin, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT p.id, p.room_id, p.pinned_by, p.created_at, "+messageColumns+" FROM pinned_messages p "+
+			"JOIN messages m ON m.room_id = p.room_id AND m.seq_id = p.seq_id "+
+			"WHERE p.room_id = $1 AND m.deleted_at IS NULL "+
+			"ORDER BY p.created_at DESC, p.id DESC",
+		roomId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pins = make([]Pin, 0)
+	for rows.Next() {
+		var (
+			pin         Pin
+			parentSeqId sql.NullInt64
+		)
+		if err = rows.Scan(
+			&pin.Id,
+			&pin.RoomId,
+			&pin.PinnedBy,
+			&pin.CreatedAt,
+			&pin.Message.Id,
+			&pin.Message.SeqId,
+			&pin.Message.RoomId,
+			&pin.Message.UserId,
+			&pin.Message.Content,
+			&pin.Message.ContentHTML,
+			&pin.Message.Deleted,
+			&parentSeqId,
+			&pin.Message.ReplyCount,
+			&pin.Message.CreatedAt,
+			&pin.Message.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		pin.Message.ParentSeqId = int(parentSeqId.Int64)
+
+		pins = append(pins, pin)
+	}
+
+	return pins, rows.Err()
+}
+
+func (db *PgGoChatRepository) CountReplies(ctx context.Context, roomId, parentSeqId int) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var count int
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT count(*) FROM messages WHERE room_id = $1 AND parent_seq_id = $2",
+		roomId,
+		parentSeqId,
+	).Scan(&count)
+
+	return count, err
+}
+
+// messageColumns is the list of columns selected by queries returning messages.
+// Queries must alias the messages table as m and scan rows with scanMessage.
+const messageColumns = "m.id, m.seq_id, m.room_id, m.user_id, m.content, m.content_html, m.deleted_at IS NOT NULL, m.parent_seq_id, " +
+	"(SELECT count(*) FROM messages r WHERE r.room_id = m.room_id AND r.parent_seq_id = m.seq_id), " +
+	"m.created_at, m.updated_at"
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMessage(row rowScanner) (Message, error) {
+	var (
+		msg         Message
+		parentSeqId sql.NullInt64
+	)
+	err := row.Scan(
+		&msg.Id,
+		&msg.SeqId,
+		&msg.RoomId,
+		&msg.UserId,
+		&msg.Content,
+		&msg.ContentHTML,
+		&msg.Deleted,
+		&parentSeqId,
+		&msg.ReplyCount,
+		&msg.CreatedAt,
+		&msg.UpdatedAt,
+	)
+	msg.ParentSeqId = int(parentSeqId.Int64)
+
+	return msg, err
+}
+
+// EditMessage replaces the content of a message and records the previous
+// content as a revision in the same transaction.
+func (db *PgGoChatRepository) EditMessage(ctx context.Context, params EditMessageParams) (Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return Message{}, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var (
+		messageId  int
+		oldContent string
+	)
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, content FROM messages WHERE room_id = $1 AND seq_id = $2 AND deleted_at IS NULL FOR UPDATE",
+		params.RoomId,
+		params.SeqId,
+	).Scan(&messageId, &oldContent)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if _, err = tx.ExecContext(ctx,
+		"INSERT INTO message_revisions (message_id, content, created_at) VALUES ($1, $2, $3)",
+		messageId,
+		oldContent,
+		params.EditedAt,
+	); err != nil {
+		return Message{}, fmt.Errorf("failed to insert message revision: %w", err)
+	}
+
+	var msg Message
+	err = tx.QueryRowContext(ctx,
+		"UPDATE messages SET content = $1, content_html = $2, updated_at = $3 WHERE id = $4 "+
+			"RETURNING id, seq_id, room_id, user_id, content, content_html, created_at, updated_at",
+		params.Content,
+		params.ContentHTML,
+		params.EditedAt,
+		messageId,
+	).Scan(
+		&msg.Id,
+		&msg.SeqId,
+		&msg.RoomId,
+		&msg.UserId,
+		&msg.Content,
+		&msg.ContentHTML,
+		&msg.CreatedAt,
+		&msg.UpdatedAt,
+	)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to update message: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Message{}, err
+	}
+
+	return msg, nil
+}
+
+func (db *PgGoChatRepository) GetMessageRevisions(ctx context.Context, messageId int) ([]MessageRevision, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT id, message_id, content, created_at FROM message_revisions "+
+			"WHERE message_id = $1 ORDER BY id ASC",
+		messageId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions = make([]MessageRevision, 0)
+	for rows.Next() {
+		var rev MessageRevision
+		if err = rows.Scan(&rev.Id, &rev.MessageId, &rev.Content, &rev.CreatedAt); err != nil {
+			break
+		}
+
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, err
+}
+
+// DeleteMessage tombstones a message. The row is kept so sequence IDs remain
+// contiguous, but its content, revision history and reactions are discarded.
+// It returns sql.ErrNoRows if the message does not exist or is already deleted.
+func (db *PgGoChatRepository) DeleteMessage(ctx context.Context, roomId, seqId int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var messageId int
+	err = tx.QueryRowContext(ctx,
+		"UPDATE messages SET content = '', content_html = '', deleted_at = $1, updated_at = $1 "+
+			"WHERE room_id = $2 AND seq_id = $3 AND deleted_at IS NULL RETURNING id",
+		time.Now().UTC(),
+		roomId,
+		seqId,
+	).Scan(&messageId)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM message_revisions WHERE message_id = $1", messageId); err != nil {
+		return fmt.Errorf("failed to delete message revisions: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM message_reactions WHERE room_id = $1 AND seq_id = $2", roomId, seqId); err != nil {
+		return fmt.Errorf("failed to delete message reactions: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM message_link_previews WHERE room_id = $1 AND seq_id = $2", roomId, seqId); err != nil {
+		return fmt.Errorf("failed to delete message link previews: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM pinned_messages WHERE room_id = $1 AND seq_id = $2", roomId, seqId); err != nil {
+		return fmt.Errorf("failed to unpin message: %w", err)
+	}
+
+	// the attachments can no longer be downloaded, and are removed from the blob store by the sweeper
+	if _, err = tx.ExecContext(ctx,
+		"UPDATE attachments SET deleted_at = $1 WHERE room_id = $2 AND seq_id = $3 AND deleted_at IS NULL",
+		time.Now().UTC(),
+		roomId,
+		seqId,
+	); err != nil {
+		return fmt.Errorf("failed to delete message attachments: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// AcquireRoomLease makes the node the host of a room until ttl has passed, unless another
+// node holds an unexpired lease on the room. Holding nodes renew their lease by acquiring it
+// again. The id of the node that holds the lease is returned.
+func (db *PgGoChatRepository) AcquireRoomLease(ctx context.Context, roomId int, nodeId string, ttl time.Duration) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var holder string
+	err := db.conn.QueryRowContext(ctx,
+		"INSERT INTO room_leases (room_id, node_id, expires_at) "+
+			"VALUES ($1, $2, now() + $3 * interval '1 millisecond') "+
+			"ON CONFLICT (room_id) DO UPDATE SET node_id = EXCLUDED.node_id, expires_at = EXCLUDED.expires_at "+
+			"WHERE room_leases.node_id = EXCLUDED.node_id OR room_leases.expires_at < now() "+
+			"RETURNING node_id",
+		roomId,
+		nodeId,
+		ttl.Milliseconds(),
+	).Scan(&holder)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the lease is held by another node
+		err = db.conn.QueryRowContext(ctx, "SELECT node_id FROM room_leases WHERE room_id = $1", roomId).Scan(&holder)
+	}
+
+	return holder, err
+}
+
+// ReleaseRoomLease gives up the node's lease on a room so another node can host it.
+func (db *PgGoChatRepository) ReleaseRoomLease(ctx context.Context, roomId int, nodeId string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM room_leases WHERE room_id = $1 AND node_id = $2", roomId, nodeId)
+	return err
 }
@@ -0,0 +1,448 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// testDSNEnv is the environment variable with the connection string of the Postgres database
+// the repository is tested against. Tests that need a database are skipped if it isn't set.
+const testDSNEnv = "GOCHAT_TEST_DSN"
+
+// testQueryTimeout is how long each call to the test repository can take.
+const testQueryTimeout = 10 * time.Second
+
+// newTestRepository connects to the test database and applies the migrations.
+func newTestRepository(t *testing.T) *PgGoChatRepository {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set, skipping database test", testDSNEnv)
+	}
+
+	db, err := NewPgGoChatRepository(dsn, testQueryTimeout)
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+
+	return db
+}
+
+// newTestRoom creates a room owned by a new account. Both are deleted when the test ends.
+func newTestRoom(t *testing.T, db *PgGoChatRepository) (User, Room) {
+	ctx := context.Background()
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	user, err := db.CreateAccount(ctx, CreateAccountParams{
+		Username:     "test-" + suffix,
+		EmailAddress: "test-" + suffix + "@example.com",
+		PasswordHash: "hash",
+	})
+	if err != nil {
+		t.Fatalf("failed to create test account: %v", err)
+	}
+
+	room, err := db.CreateRoom(ctx, CreateRoomParams{
+		Name:       "test",
+		ExternalId: "test-" + suffix,
+		OwnerId:    user.Id,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test room: %v", err)
+	}
+
+	t.Cleanup(func() {
+		db.DeleteRoom(ctx, room.Id)
+		db.conn.Exec("DELETE FROM accounts WHERE id = $1", user.Id)
+	})
+
+	return user, room
+}
+
+func TestCreateMessage(t *testing.T) {
+	ctx := context.Background()
+	db := newTestRepository(t)
+	user, room := newTestRoom(t, db)
+
+	for i := 1; i <= 3; i++ {
+		createdAt := time.Now().UTC().Truncate(time.Millisecond)
+		stored, err := db.CreateMessage(ctx, Message{
+			SeqId:       99, // ignored, the seq id is allocated by the database
+			RoomId:      room.Id,
+			UserId:      user.Id,
+			Content:     "hello",
+			ContentHTML: "<p>hello</p>",
+			CreatedAt:   createdAt,
+		})
+		assert.NoError(t, err, "expected no error creating message")
+		assert.Equal(t, i, stored.SeqId, "expected consecutive seq ids")
+		assert.NotZero(t, stored.Id, "expected stored message to have an id")
+		assert.True(t, createdAt.Equal(stored.CreatedAt), "expected created at to be stored")
+
+		got, err := db.GetMessage(ctx, room.Id, stored.SeqId)
+		assert.NoError(t, err, "expected no error getting message")
+		assert.Equal(t, stored.Id, got.Id, "expected stored message to be returned")
+		assert.Equal(t, "hello", got.Content, "expected content to be stored")
+	}
+
+	got, err := db.GetRoomByExternalId(ctx, room.ExternalId)
+	assert.NoError(t, err, "expected no error getting room")
+	assert.Equal(t, 3, got.SeqId, "expected room seq id to be the last message's")
+}
+
+func TestCreateMessage_concurrent(t *testing.T) {
+	ctx := context.Background()
+	db := newTestRepository(t)
+	user, room := newTestRoom(t, db)
+
+	const n = 20
+	seqIds := make([]int, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stored, err := db.CreateMessage(ctx, Message{RoomId: room.Id, UserId: user.Id, Content: "hi", CreatedAt: time.Now().UTC()})
+			seqIds[i], errs[i] = stored.SeqId, err
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err, "expected no error creating messages concurrently")
+	}
+
+	slices.Sort(seqIds)
+	for i, seqId := range seqIds {
+		assert.Equal(t, i+1, seqId, "expected every message to get a distinct consecutive seq id")
+	}
+}
+
+func TestCreateMessage_roomSeqIdBehind(t *testing.T) {
+	ctx := context.Background()
+	db := newTestRepository(t)
+	user, room := newTestRoom(t, db)
+
+	// messages saved without updating the room, as they were before seq ids were allocated atomically
+	_, err := db.conn.Exec(
+		"INSERT INTO messages (seq_id, room_id, user_id, content) VALUES (1, $1, $2, 'old'), (2, $1, $2, 'old')",
+		room.Id,
+		user.Id,
+	)
+	assert.NoError(t, err, "expected no error inserting messages")
+
+	stored, err := db.CreateMessage(ctx, Message{RoomId: room.Id, UserId: user.Id, Content: "new", CreatedAt: time.Now().UTC()})
+	assert.NoError(t, err, "expected no error creating message")
+	assert.Equal(t, 3, stored.SeqId, "expected seq id to follow the existing messages")
+}
+
+func TestCreateMessage_rollback(t *testing.T) {
+	ctx := context.Background()
+	db := newTestRepository(t)
+	user, room := newTestRoom(t, db)
+
+	attachment, err := db.CreateAttachment(ctx, CreateAttachmentParams{
+		ExternalId:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		RoomId:      room.Id,
+		AccountId:   user.Id,
+		Filename:    "a.txt",
+		ContentType: "text/plain",
+		Size:        1,
+	})
+	assert.NoError(t, err, "expected no error creating attachment")
+
+	msg := Message{RoomId: room.Id, UserId: user.Id, Content: "see attached", Attachments: []Attachment{attachment}, CreatedAt: time.Now().UTC()}
+	stored, err := db.CreateMessage(ctx, msg)
+	assert.NoError(t, err, "expected no error creating message")
+	assert.Equal(t, 1, stored.SeqId, "expected first seq id")
+	assert.Equal(t, 1, stored.Attachments[0].SeqId, "expected attachment to be attached to the message")
+
+	// the attachment can't be attached twice, so nothing is saved
+	_, err = db.CreateMessage(ctx, msg)
+	assert.Error(t, err, "expected error attaching attachment again")
+
+	_, err = db.GetMessage(ctx, room.Id, 2)
+	assert.ErrorIs(t, err, sql.ErrNoRows, "expected message not to be saved")
+
+	got, err := db.GetRoomByExternalId(ctx, room.ExternalId)
+	assert.NoError(t, err, "expected no error getting room")
+	assert.Equal(t, 1, got.SeqId, "expected seq id allocation to be rolled back")
+}
+
+func TestCreateMessages(t *testing.T) {
+	ctx := context.Background()
+	db := newTestRepository(t)
+	user, room := newTestRoom(t, db)
+
+	_, err := db.CreateMessage(ctx, Message{RoomId: room.Id, UserId: user.Id, Content: "first", CreatedAt: time.Now().UTC()})
+	assert.NoError(t, err, "expected no error creating message")
+
+	msgs := make([]Message, 5)
+	for i := range msgs {
+		msgs[i] = Message{RoomId: room.Id, UserId: user.Id, Content: strconv.Itoa(i), CreatedAt: time.Now().UTC()}
+	}
+
+	stored, err := db.CreateMessages(ctx, msgs)
+	assert.NoError(t, err, "expected no error creating messages")
+	if assert.Len(t, stored, len(msgs), "expected every message to be returned") {
+		for i, msg := range stored {
+			assert.Equal(t, i+2, msg.SeqId, "expected consecutive seq ids in the order of the messages")
+			assert.Equal(t, strconv.Itoa(i), msg.Content, "expected messages in the order they were given")
+			assert.NotZero(t, msg.Id, "expected stored message to have an id")
+
+			got, err := db.GetMessage(ctx, room.Id, msg.SeqId)
+			assert.NoError(t, err, "expected no error getting message")
+			assert.Equal(t, msg.Id, got.Id, "expected stored message to be returned")
+		}
+	}
+
+	got, err := db.GetRoomByExternalId(ctx, room.ExternalId)
+	assert.NoError(t, err, "expected no error getting room")
+	assert.Equal(t, 6, got.SeqId, "expected room seq id to be the last message's")
+}
+
+func TestCreateMessages_rollback(t *testing.T) {
+	ctx := context.Background()
+	db := newTestRepository(t)
+	user, room := newTestRoom(t, db)
+
+	attachment, err := db.CreateAttachment(ctx, CreateAttachmentParams{
+		ExternalId:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		RoomId:      room.Id,
+		AccountId:   user.Id,
+		Filename:    "a.txt",
+		ContentType: "text/plain",
+		Size:        1,
+	})
+	assert.NoError(t, err, "expected no error creating attachment")
+
+	// the attachment can't be attached to both messages, so neither is saved
+	msg := Message{RoomId: room.Id, UserId: user.Id, Content: "see attached", Attachments: []Attachment{attachment}, CreatedAt: time.Now().UTC()}
+	_, err = db.CreateMessages(ctx, []Message{msg, msg})
+	assert.Error(t, err, "expected error attaching attachment twice")
+
+	_, err = db.GetMessage(ctx, room.Id, 1)
+	assert.ErrorIs(t, err, sql.ErrNoRows, "expected messages not to be saved")
+}
+
+func TestCreateMessages_invalid(t *testing.T) {
+	db := &PgGoChatRepository{}
+
+	tcases := []struct {
+		name string
+		msgs []Message
+	}{
+		{
+			name: "different rooms",
+			msgs: []Message{{RoomId: 1}, {RoomId: 2}},
+		},
+		{
+			name: "too many messages",
+			msgs: make([]Message, MaxMessageBatchSize+1),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := db.CreateMessages(context.Background(), tc.msgs)
+			assert.Error(t, err, "expected error creating messages")
+		})
+	}
+}
+
+// BenchmarkCreateMessages compares saving messages one at a time with saving them in batches.
+func BenchmarkCreateMessages(b *testing.B) {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		b.Skipf("%s is not set, skipping database benchmark", testDSNEnv)
+	}
+
+	db, err := NewPgGoChatRepository(dsn, testQueryTimeout)
+	if err != nil {
+		b.Fatalf("failed to connect to test database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Migrate(); err != nil {
+		b.Fatalf("failed to migrate test database: %v", err)
+	}
+
+	for _, batchSize := range []int{1, 10, 100} {
+		b.Run("batch size "+strconv.Itoa(batchSize), func(b *testing.B) {
+			ctx := context.Background()
+			suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+			user, err := db.CreateAccount(ctx, CreateAccountParams{
+				Username:     "bench-" + suffix,
+				EmailAddress: "bench-" + suffix + "@example.com",
+				PasswordHash: "hash",
+			})
+			if err != nil {
+				b.Fatalf("failed to create account: %v", err)
+			}
+			room, err := db.CreateRoom(ctx, CreateRoomParams{Name: "bench", ExternalId: "bench-" + suffix, OwnerId: user.Id})
+			if err != nil {
+				b.Fatalf("failed to create room: %v", err)
+			}
+			defer func() {
+				db.DeleteRoom(ctx, room.Id)
+				db.conn.Exec("DELETE FROM accounts WHERE id = $1", user.Id)
+			}()
+
+			msgs := make([]Message, batchSize)
+			for i := range msgs {
+				msgs[i] = Message{RoomId: room.Id, UserId: user.Id, Content: "hello", ContentHTML: "<p>hello</p>", CreatedAt: time.Now().UTC()}
+			}
+
+			b.ResetTimer()
+			for saved := 0; saved < b.N; saved += batchSize {
+				if _, err := db.CreateMessages(ctx, msgs[:min(batchSize, b.N-saved)]); err != nil {
+					b.Fatalf("failed to create messages: %v", err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+		})
+	}
+}
+
+func TestSaveMessageLinkPreviews(t *testing.T) {
+	ctx := context.Background()
+	db := newTestRepository(t)
+	user, room := newTestRoom(t, db)
+
+	stored, err := db.CreateMessage(ctx, Message{RoomId: room.Id, UserId: user.Id, Content: "see links", CreatedAt: time.Now().UTC()})
+	if err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	urls := []string{"https://example.com/b-" + suffix, "https://example.com/a-" + suffix, "https://example.com/uncached-" + suffix}
+	for _, url := range urls[:2] {
+		if err := db.SaveLinkPreview(ctx, LinkPreview{URL: url, Title: "title " + url, FetchedAt: time.Now().UTC()}); err != nil {
+			t.Fatalf("failed to save link preview: %v", err)
+		}
+	}
+	t.Cleanup(func() { db.conn.Exec("DELETE FROM link_previews WHERE url = ANY($1)", pq.Array(urls)) })
+
+	assert.NoError(t, db.SaveMessageLinkPreviews(ctx, room.Id, stored.SeqId, urls), "expected no error saving message link previews")
+
+	messages, err := db.GetMessages(ctx, room.Id, 0, 0, 10)
+	assert.NoError(t, err, "expected no error getting messages")
+	if assert.Len(t, messages, 1, "expected the message to be returned") {
+		var got []string
+		for _, p := range messages[0].LinkPreviews {
+			got = append(got, p.URL)
+		}
+		assert.Equal(t, urls[:2], got, "expected the cached previews in the order they were linked")
+	}
+
+	assert.NoError(t, db.DeleteMessage(ctx, room.Id, stored.SeqId), "expected no error deleting message")
+
+	messages, err = db.GetMessages(ctx, room.Id, 0, 0, 10)
+	assert.NoError(t, err, "expected no error getting messages")
+	if assert.Len(t, messages, 1, "expected the deleted message to be returned") {
+		assert.Empty(t, messages[0].LinkPreviews, "expected no previews on a deleted message")
+	}
+}
+
+func Test_conflictErr(t *testing.T) {
+	tcases := []struct {
+		name     string
+		err      error
+		conflict bool
+	}{
+		{
+			name:     "unique violation",
+			err:      &pq.Error{Code: uniqueViolation},
+			conflict: true,
+		},
+		{
+			name:     "serialization failure",
+			err:      &pq.Error{Code: serializationFailure},
+			conflict: true,
+		},
+		{
+			name:     "deadlock",
+			err:      &pq.Error{Code: deadlockDetected},
+			conflict: true,
+		},
+		{
+			name: "other postgres error",
+			err:  &pq.Error{Code: "23503"},
+		},
+		{
+			name: "other error",
+			err:  errors.New("connection refused"),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := conflictErr(tc.err)
+			if tc.conflict {
+				assert.ErrorIs(t, err, ErrSeqIdConflict, "expected seq id conflict")
+			} else {
+				assert.Equal(t, tc.err, err, "expected error to be returned unchanged")
+			}
+		})
+	}
+}
+
+func TestPgGoChatRepository_withTimeout(t *testing.T) {
+	t.Run("query timeout", func(t *testing.T) {
+		db := &PgGoChatRepository{queryTimeout: time.Minute}
+		ctx, cancel := db.withTimeout(context.Background())
+		defer cancel()
+
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok, "expected context to have a deadline")
+		assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second, "expected deadline to be the query timeout")
+	})
+
+	t.Run("no query timeout", func(t *testing.T) {
+		db := &PgGoChatRepository{}
+		ctx, cancel := db.withTimeout(context.Background())
+		defer cancel()
+
+		_, ok := ctx.Deadline()
+		assert.False(t, ok, "expected context to have no deadline")
+	})
+
+	t.Run("earlier deadline", func(t *testing.T) {
+		db := &PgGoChatRepository{queryTimeout: time.Minute}
+		parent, parentCancel := context.WithTimeout(context.Background(), time.Second)
+		defer parentCancel()
+
+		ctx, cancel := db.withTimeout(parent)
+		defer cancel()
+
+		parentDeadline, _ := parent.Deadline()
+		deadline, _ := ctx.Deadline()
+		assert.Equal(t, parentDeadline, deadline, "expected the caller's earlier deadline to be kept")
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		db := &PgGoChatRepository{queryTimeout: time.Minute}
+		parent, parentCancel := context.WithCancel(context.Background())
+		ctx, cancel := db.withTimeout(parent)
+		defer cancel()
+
+		parentCancel()
+		assert.ErrorIs(t, ctx.Err(), context.Canceled, "expected the call to be canceled with the caller's context")
+	})
+}
@@ -1,22 +1,72 @@
 package database
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrSeqIdConflict is returned by CreateMessage when a message can't be saved
+// because of concurrent transactions saving messages in the same room.
+var ErrSeqIdConflict = errors.New("seq id conflict")
+
 type GoChatRepository interface {
-	Ping() error
-	CreateAccount(accountParams CreateAccountParams) (User, error)
-	UpdateAccount(params UpdateAccountParams) (User, error)
-	GetAccountById(accountId int) (User, error)
-	GetAccountByEmail(email string) (User, error)
-	GetRoomByExternalId(externalId string) (Room, error)
-	GetRoomWithSubscribers(roomId int) (*Room, error)
-	CreateRoom(params CreateRoomParams) (Room, error)
-	DeleteRoom(id int) error
-	CreateSubscription(accountId, roomId int) (Subscription, error)
-	SubscriptionExists(accountId, roomId int) bool
-	ListSubscriptions(accountId int) ([]Subscription, error)
-	DeleteSubscription(accountId, roomId int) error
-	UpdateLastReadSeqId(accountId, roomId, seqId int) error
-	CreateMessage(msg Message) error
-	UpdateRoomOnMessage(msg Message) error
-	GetSubscribersByRoomId(roomId int) ([]User, error)
-	GetMessages(roomId, since, before, limit int) ([]Message, error)
+	Ping(ctx context.Context) error
+	CreateAccount(ctx context.Context, accountParams CreateAccountParams) (User, error)
+	UpdateAccount(ctx context.Context, params UpdateAccountParams) (User, error)
+	GetAccountById(ctx context.Context, accountId int) (User, error)
+	GetAccountByEmail(ctx context.Context, email string) (User, error)
+	GetRoomByExternalId(ctx context.Context, externalId string) (Room, error)
+	GetRoomWithSubscribers(ctx context.Context, roomId int) (*Room, error)
+	ListRooms(ctx context.Context, params ListRoomsParams) ([]Room, error)
+	CreateRoom(ctx context.Context, params CreateRoomParams) (Room, error)
+	UpdateRoom(ctx context.Context, params UpdateRoomParams) (Room, error)
+	GetOrCreateDirectRoom(ctx context.Context, params CreateDirectRoomParams) (Room, bool, error)
+	DeleteRoom(ctx context.Context, id int) error
+	CreateSubscription(ctx context.Context, accountId, roomId int) (Subscription, error)
+	SubscriptionExists(ctx context.Context, accountId, roomId int) bool
+	ListSubscriptions(ctx context.Context, accountId int) ([]Subscription, error)
+	DeleteSubscription(ctx context.Context, accountId, roomId int) error
+	GetSubscriptionRole(ctx context.Context, accountId, roomId int) (string, error)
+	UpdateSubscriptionRole(ctx context.Context, accountId, roomId int, role string) error
+	CreateInvite(ctx context.Context, params CreateInviteParams) (Invite, error)
+	ListInvites(ctx context.Context, roomId int) ([]Invite, error)
+	DeleteInvite(ctx context.Context, roomId, accountId int) error
+	AcceptInvite(ctx context.Context, accountId, roomId int) (Subscription, error)
+	CreateBan(ctx context.Context, params CreateBanParams) (Ban, error)
+	ListBans(ctx context.Context, roomId int) ([]Ban, error)
+	DeleteBan(ctx context.Context, roomId, accountId int) error
+	IsBanned(ctx context.Context, accountId, roomId int) (bool, error)
+	UpdateLastReadSeqId(ctx context.Context, accountId, roomId, seqId int) error
+	GetUnread(ctx context.Context, accountId, roomId int) (Unread, error)
+	ListUnread(ctx context.Context, roomId int) ([]Unread, error)
+	CreateMessage(ctx context.Context, msg Message) (Message, error)
+	CreateMessages(ctx context.Context, msgs []Message) ([]Message, error)
+	GetSubscribersByRoomId(ctx context.Context, roomId int) ([]User, error)
+	GetMessages(ctx context.Context, roomId, since, before, limit int) ([]Message, error)
+	GetMessage(ctx context.Context, roomId, seqId int) (Message, error)
+	SearchMessages(ctx context.Context, params SearchMessagesParams) ([]Message, error)
+	CreateMentions(ctx context.Context, roomId, seqId int, accountIds []int) error
+	ListMentions(ctx context.Context, params ListMentionsParams) ([]Mention, error)
+	EditMessage(ctx context.Context, params EditMessageParams) (Message, error)
+	GetMessageRevisions(ctx context.Context, messageId int) ([]MessageRevision, error)
+	DeleteMessage(ctx context.Context, roomId, seqId int) error
+	GetThread(ctx context.Context, roomId, parentSeqId int) ([]Message, error)
+	CountReplies(ctx context.Context, roomId, parentSeqId int) (int, error)
+	AddReaction(ctx context.Context, params ReactionParams) error
+	RemoveReaction(ctx context.Context, params ReactionParams) error
+	PinMessage(ctx context.Context, params PinParams) (Pin, error)
+	UnpinMessage(ctx context.Context, roomId, seqId int) error
+	ListPins(ctx context.Context, roomId int) ([]Pin, error)
+	CreateAttachment(ctx context.Context, params CreateAttachmentParams) (Attachment, error)
+	GetAttachment(ctx context.Context, externalId string) (Attachment, error)
+	GetAttachments(ctx context.Context, roomId int, externalIds []string) ([]Attachment, error)
+	DeleteUnattachedAttachments(ctx context.Context, createdBefore time.Time) error
+	ListDeletedAttachments(ctx context.Context, limit int) ([]Attachment, error)
+	PurgeAttachments(ctx context.Context, ids []int) error
+	GetLinkPreview(ctx context.Context, url string) (LinkPreview, error)
+	SaveLinkPreview(ctx context.Context, preview LinkPreview) error
+	SaveMessageLinkPreviews(ctx context.Context, roomId, seqId int, urls []string) error
+	AcquireRoomLease(ctx context.Context, roomId int, nodeId string, ttl time.Duration) (string, error)
+	ReleaseRoomLease(ctx context.Context, roomId int, nodeId string) error
 }
@@ -0,0 +1,209 @@
+// Package markdown renders the subset of Markdown supported in messages to HTML.
+//
+// The supported syntax is:
+//
+//   - **bold**, *italic* or _italic_, ~~strikethrough~~ and `inline code`
+//   - [links](https://example.com) and bare http and https links
+//   - fenced code blocks, optionally with a language
+//   - > block quotes
+//   - unordered (- or *) and ordered (1.) lists
+//
+// Text is HTML escaped as it is rendered, so the only markup in the output is
+// generated by the renderer. Raw HTML in the source is displayed as text rather
+// than stripped, and links can only use the http and https schemes.
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+const codeFence = "```"
+
+var (
+	// inlinePattern matches the inline elements that are not formatted further:
+	// code spans, links and bare links.
+	inlinePattern = regexp.MustCompile("`([^`\n]+)`" + `|\[([^\]\n]+)\]\((https?://[^\s()<>"]+)\)|(https?://[^\s<>"]+)`)
+	// emphasisPattern matches bold, strikethrough and italic text. Underscores
+	// only emphasize whole words, so snake_case isn't formatted.
+	emphasisPattern = regexp.MustCompile(`\*\*(.+?)\*\*|~~(.+?)~~|\*([^*\s](?:[^*]*[^*\s])?)\*|\b_([^_]+)_\b`)
+	// unorderedItemPattern and orderedItemPattern match list items and capture their content.
+	unorderedItemPattern = regexp.MustCompile(`^\s{0,3}[-*]\s+(.*)$`)
+	orderedItemPattern   = regexp.MustCompile(`^\s{0,3}\d{1,9}[.)]\s+(.*)$`)
+	// languagePattern matches the characters allowed in the language of a code block.
+	languagePattern = regexp.MustCompile(`^[A-Za-z0-9_+#-]{1,32}$`)
+)
+
+// Render converts Markdown source to HTML that is safe to insert into a page.
+func Render(src string) string {
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+
+	var b strings.Builder
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+		case strings.HasPrefix(line, codeFence):
+			i = renderCodeBlock(&b, lines, i)
+		case isQuote(line):
+			start := i
+			for i < len(lines) && isQuote(lines[i]) {
+				lines[i] = strings.TrimPrefix(strings.TrimPrefix(lines[i], ">"), " ")
+				i++
+			}
+			b.WriteString("<blockquote><p>")
+			b.WriteString(renderLines(lines[start:i]))
+			b.WriteString("</p></blockquote>")
+		case unorderedItemPattern.MatchString(line):
+			i = renderList(&b, lines, i, "ul", unorderedItemPattern)
+		case orderedItemPattern.MatchString(line):
+			i = renderList(&b, lines, i, "ol", orderedItemPattern)
+		default:
+			start := i
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsBlock(lines[i]) {
+				i++
+			}
+			b.WriteString("<p>")
+			b.WriteString(renderLines(lines[start:i]))
+			b.WriteString("</p>")
+		}
+	}
+
+	return b.String()
+}
+
+// startsBlock reports whether a line starts a block other than a paragraph.
+func startsBlock(line string) bool {
+	return strings.HasPrefix(line, codeFence) || isQuote(line) ||
+		unorderedItemPattern.MatchString(line) || orderedItemPattern.MatchString(line)
+}
+
+func isQuote(line string) bool {
+	return strings.HasPrefix(line, ">")
+}
+
+// renderCodeBlock renders the fenced code block starting at lines[i] and returns the
+// index of the line after it. An unclosed block runs to the end of the source.
+func renderCodeBlock(b *strings.Builder, lines []string, i int) int {
+	lang := strings.TrimSpace(strings.TrimPrefix(lines[i], codeFence))
+
+	i++
+	start := i
+	for i < len(lines) && !strings.HasPrefix(lines[i], codeFence) {
+		i++
+	}
+	code := strings.Join(lines[start:i], "\n")
+	if i < len(lines) {
+		// skip the closing fence
+		i++
+	}
+
+	b.WriteString("<pre><code")
+	if languagePattern.MatchString(lang) {
+		b.WriteString(` class="language-`)
+		b.WriteString(html.EscapeString(lang))
+		b.WriteString(`"`)
+	}
+	b.WriteString(">")
+	b.WriteString(html.EscapeString(code))
+	b.WriteString("</code></pre>")
+
+	return i
+}
+
+// renderList renders the consecutive list items matching pattern starting at
+// lines[i] as a list with the given tag and returns the index of the line after it.
+func renderList(b *strings.Builder, lines []string, i int, tag string, pattern *regexp.Regexp) int {
+	b.WriteString("<" + tag + ">")
+	for ; i < len(lines); i++ {
+		m := pattern.FindStringSubmatch(lines[i])
+		if m == nil {
+			break
+		}
+
+		b.WriteString("<li>")
+		b.WriteString(renderInline(m[1]))
+		b.WriteString("</li>")
+	}
+	b.WriteString("</" + tag + ">")
+
+	return i
+}
+
+// renderLines renders the lines of a paragraph, keeping the line breaks.
+func renderLines(lines []string) string {
+	rendered := make([]string, 0, len(lines))
+	for _, line := range lines {
+		rendered = append(rendered, renderInline(strings.TrimSpace(line)))
+	}
+
+	return strings.Join(rendered, "<br>")
+}
+
+// renderInline renders code spans and links, and formats the text between them.
+func renderInline(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range inlinePattern.FindAllStringSubmatchIndex(s, -1) {
+		b.WriteString(renderEmphasis(s[last:m[0]]))
+		last = m[1]
+
+		switch {
+		case m[2] >= 0:
+			b.WriteString("<code>")
+			b.WriteString(html.EscapeString(s[m[2]:m[3]]))
+			b.WriteString("</code>")
+		case m[4] >= 0:
+			writeLink(&b, s[m[6]:m[7]], renderEmphasis(s[m[4]:m[5]]))
+		default:
+			// punctuation at the end of a bare link is usually part of the sentence
+			link := strings.TrimRight(s[m[8]:m[9]], ".,;:!?')")
+			writeLink(&b, link, html.EscapeString(link))
+			last = m[8] + len(link)
+		}
+	}
+	b.WriteString(renderEmphasis(s[last:]))
+
+	return b.String()
+}
+
+func writeLink(b *strings.Builder, href, text string) {
+	b.WriteString(`<a href="`)
+	b.WriteString(html.EscapeString(href))
+	b.WriteString(`" rel="nofollow noopener noreferrer" target="_blank">`)
+	b.WriteString(text)
+	b.WriteString("</a>")
+}
+
+// renderEmphasis escapes text and formats bold, italic and strikethrough text.
+// The content of each element is rendered separately, so nested elements are
+// always closed in the right order.
+func renderEmphasis(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range emphasisPattern.FindAllStringSubmatchIndex(s, -1) {
+		b.WriteString(html.EscapeString(s[last:m[0]]))
+		last = m[1]
+
+		var tag, content string
+		switch {
+		case m[2] >= 0:
+			tag, content = "strong", s[m[2]:m[3]]
+		case m[4] >= 0:
+			tag, content = "del", s[m[4]:m[5]]
+		case m[6] >= 0:
+			tag, content = "em", s[m[6]:m[7]]
+		default:
+			tag, content = "em", s[m[8]:m[9]]
+		}
+
+		b.WriteString("<" + tag + ">")
+		b.WriteString(renderEmphasis(content))
+		b.WriteString("</" + tag + ">")
+	}
+	b.WriteString(html.EscapeString(s[last:]))
+
+	return b.String()
+}
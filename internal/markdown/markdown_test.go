@@ -0,0 +1,86 @@
+package markdown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	tcs := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{"empty", "", ""},
+		{"plain text", "hello world", "<p>hello world</p>"},
+		{"line breaks", "first\nsecond", "<p>first<br>second</p>"},
+		{"paragraphs", "first\n\nsecond", "<p>first</p><p>second</p>"},
+		{"bold", "**bold** text", "<p><strong>bold</strong> text</p>"},
+		{"italic", "*italic* and _also italic_", "<p><em>italic</em> and <em>also italic</em></p>"},
+		{"snake case", "call my_func_name now", "<p>call my_func_name now</p>"},
+		{"strikethrough", "~~gone~~", "<p><del>gone</del></p>"},
+		{"nested emphasis", "**bold _and italic_**", "<p><strong>bold <em>and italic</em></strong></p>"},
+		{"overlapping emphasis", "**a *b** c*", "<p><strong>a *b</strong> c*</p>"},
+		{"unmatched emphasis", "2 * 3 = 6", "<p>2 * 3 = 6</p>"},
+		{"inline code", "run `go test ./...` **now**", "<p>run <code>go test ./...</code> <strong>now</strong></p>"},
+		{"code is not formatted", "`**not bold**`", "<p><code>**not bold**</code></p>"},
+		{
+			"link",
+			"see [the docs](https://example.com/docs?a=1&b=2)",
+			`<p>see <a href="https://example.com/docs?a=1&amp;b=2" rel="nofollow noopener noreferrer" target="_blank">the docs</a></p>`,
+		},
+		{
+			"bare link",
+			"see https://example.com/a_b_c.",
+			`<p>see <a href="https://example.com/a_b_c" rel="nofollow noopener noreferrer" target="_blank">https://example.com/a_b_c</a>.</p>`,
+		},
+		{
+			"code block",
+			"```go\nfunc main() {\n\tfmt.Println(\"<hi>\")\n}\n```\nafter",
+			"<pre><code class=\"language-go\">func main() {\n\tfmt.Println(&#34;&lt;hi&gt;&#34;)\n}</code></pre><p>after</p>",
+		},
+		{"unclosed code block", "```\n**code**", "<pre><code>**code**</code></pre>"},
+		{"block quote", "> quoted\n> **text**\nreply", "<blockquote><p>quoted<br><strong>text</strong></p></blockquote><p>reply</p>"},
+		{"unordered list", "- one\n* two", "<ul><li>one</li><li>two</li></ul>"},
+		{"ordered list", "1. one\n2) two\n\ntext", "<ol><li>one</li><li>two</li></ol><p>text</p>"},
+		{"windows line endings", "a\r\nb", "<p>a<br>b</p>"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Render(tc.src))
+		})
+	}
+}
+
+func TestRender_sanitizes(t *testing.T) {
+	tcs := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{"script tag", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+		{"event handler", `<img src=x onerror="alert(1)">`, "<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>"},
+		{"javascript link", "[click](javascript:alert(1))", "<p>[click](javascript:alert(1))</p>"},
+		{"data link", "[click](data:text/html;base64,PHNjcmlwdD4=)", "<p>[click](data:text/html;base64,PHNjcmlwdD4=)</p>"},
+		{
+			"quote in link",
+			`[x](https://example.com/"onmouseover="alert(1))`,
+			`<p>[x](<a href="https://example.com/" rel="nofollow noopener noreferrer" target="_blank">https://example.com/</a>&#34;onmouseover=&#34;alert(1))</p>`,
+		},
+		{
+			"html in link text",
+			"[<b>x</b>](https://example.com)",
+			`<p><a href="https://example.com" rel="nofollow noopener noreferrer" target="_blank">&lt;b&gt;x&lt;/b&gt;</a></p>`,
+		},
+		{"html in code", "`<script>`", "<p><code>&lt;script&gt;</code></p>"},
+		{"code block language", "```\"><script>\nx\n```", "<pre><code>x</code></pre>"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Render(tc.src))
+		})
+	}
+}
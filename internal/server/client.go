@@ -1,7 +1,10 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"sync"
 	"time"
@@ -12,13 +15,24 @@ import (
 )
 
 const (
-	writeWait      = 10 * time.Second
-	pongWait       = 60 * time.Second
-	pingInterval   = (pongWait * 9) / 10
-	maxMessageSize = 1024
+	writeWait    = 10 * time.Second
+	pongWait     = 60 * time.Second
+	pingInterval = (pongWait * 9) / 10
+	// maxDiscardSize is the size in bytes of the largest message that is discarded
+	// when it exceeds the maximum message size, so the client is sent an error and can
+	// recover. Larger messages close the connection rather than being read to the end.
+	maxDiscardSize int64 = 64 << 20
 )
 
+// errMessageTooLarge is returned when a message exceeds the maximum message size.
+var errMessageTooLarge = errors.New("message too large")
+
 type Client struct {
+	// id identifies the client in a cluster
+	id string
+	// node is the id of the node the client is connected to, if it sent a message
+	// forwarded from another node
+	node       string
 	conn       *websocket.Conn
 	chatServer *ChatServer
 	log        *log.Logger
@@ -35,6 +49,7 @@ type Client struct {
 
 func NewClient(user types.User, conn *websocket.Conn, cs *ChatServer, l *log.Logger, statsUpdater stats.StatsProvider) *Client {
 	return &Client{
+		id:         newId(),
 		conn:       conn,
 		chatServer: cs,
 		log:        l,
@@ -89,11 +104,21 @@ func (c *Client) Read() {
 		c.cleanup()
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
+	maxSize := c.chatServer.maxMessageSize
+	c.conn.SetReadLimit(max(maxSize, maxDiscardSize))
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(appData string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 	for {
-		_, raw, err := c.conn.ReadMessage()
+		var raw []byte
+		_, r, err := c.conn.NextReader()
+		if err == nil {
+			raw, err = readMessage(r, maxSize)
+		}
+		if errors.Is(err, errMessageTooLarge) {
+			c.stats.Incr("TotalIncomingMessages")
+			c.queueMessage(ErrMessageTooLarge(peekMessageId(raw)))
+			continue
+		}
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure,
 				websocket.CloseNormalClosure) {
@@ -121,31 +146,74 @@ func (c *Client) Read() {
 		case msg.Leave != nil:
 			c.leaveRoom(&msg)
 		case msg.Publish != nil:
-			r, ok := c.getRoom(msg.Publish.RoomId)
-			if ok {
-				select {
-				case r.clientMsgChan <- &msg:
-				default:
-					c.queueMessage(ErrServiceUnavailable(msg.Id))
-					c.log.Printf("clientMsgChan full for room %q", r.externalId)
-				}
-			} else {
-				c.queueMessage(ErrRoomNotFound(msg.Id))
-			}
+			c.forwardToRoom(msg.Publish.RoomId, &msg)
 		case msg.Read != nil:
-			r, ok := c.getRoom(msg.Read.RoomId)
-			if ok {
-				select {
-				case r.clientMsgChan <- &msg:
-				default:
-					c.queueMessage(ErrServiceUnavailable(msg.Id))
-					c.log.Printf("readChan full for room %q", r.externalId)
-				}
-			} else {
-				c.queueMessage(ErrRoomNotFound(msg.Id))
+			c.forwardToRoom(msg.Read.RoomId, &msg)
+		case msg.Edit != nil:
+			c.forwardToRoom(msg.Edit.RoomId, &msg)
+		case msg.Delete != nil:
+			c.forwardToRoom(msg.Delete.RoomId, &msg)
+		case msg.React != nil:
+			c.forwardToRoom(msg.React.RoomId, &msg)
+		case msg.Typing != nil:
+			c.forwardToRoom(msg.Typing.RoomId, &msg)
+		case msg.Pin != nil:
+			c.forwardToRoom(msg.Pin.RoomId, &msg)
+		}
+	}
+}
+
+// readMessage reads a whole message, which may span multiple frames, from r. If the
+// message is larger than maxSize, the rest of it is discarded and errMessageTooLarge
+// is returned with the first maxSize bytes of the message, so the next message can
+// still be read from the connection.
+func readMessage(r io.Reader, maxSize int64) ([]byte, error) {
+	raw, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(raw)) > maxSize {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return nil, err
+		}
+		return raw[:maxSize], errMessageTooLarge
+	}
+
+	return raw, nil
+}
+
+// peekMessageId returns the id of a message from its first bytes, so an error about a
+// message that was cut off can still be correlated with it. It returns -1 if the id
+// doesn't appear before the message is cut off, in which case the error is uncorrelated.
+func peekMessageId(head []byte) int {
+	dec := json.NewDecoder(bytes.NewReader(head))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return -1
+	}
+
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return -1
+		}
+
+		if key == "id" {
+			var id int
+			if err := dec.Decode(&id); err != nil {
+				return -1
 			}
+			return id
+		}
+
+		// skip the value of any other field
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return -1
 		}
 	}
+
+	return -1
 }
 
 func (c *Client) queueMessage(msg *ServerMessage) bool {
@@ -232,6 +300,23 @@ func (c *Client) leaveRoom(msg *ClientMessage) {
 	}
 }
 
+// forwardToRoom sends a message to the room's message channel for processing.
+// The client must have joined the room, otherwise a room not found error is returned.
+func (c *Client) forwardToRoom(roomId string, msg *ClientMessage) {
+	r, ok := c.getRoom(roomId)
+	if !ok {
+		c.queueMessage(ErrRoomNotFound(msg.Id))
+		return
+	}
+
+	select {
+	case r.clientMsgChan <- msg:
+	default:
+		c.queueMessage(ErrServiceUnavailable(msg.Id))
+		c.log.Printf("clientMsgChan full for room %q", r.externalId)
+	}
+}
+
 // delRoom removes the room from the client's list of rooms.
 func (c *Client) delRoom(id string) {
 	c.roomsLock.Lock()
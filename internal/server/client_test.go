@@ -1,14 +1,22 @@
 package server
 
 import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/npezzotti/go-chatroom/internal/database"
 	"github.com/npezzotti/go-chatroom/internal/stats"
 	"github.com/npezzotti/go-chatroom/internal/testutil"
 	"github.com/npezzotti/go-chatroom/internal/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func Test_queueMessage(t *testing.T) {
@@ -325,3 +333,192 @@ func Test_addRoom_delRoom_getRoom(t *testing.T) {
 	c.delRoom(r.externalId)
 	assert.NotContains(t, c.rooms, r.externalId, "expected room to be removed after deletion")
 }
+
+func Test_readMessage(t *testing.T) {
+	tcases := []struct {
+		name        string
+		reader      io.Reader
+		maxSize     int64
+		expected    []byte
+		expectedErr error
+	}{
+		{
+			name:     "message under limit",
+			reader:   strings.NewReader("hello"),
+			maxSize:  10,
+			expected: []byte("hello"),
+		},
+		{
+			name:     "message at limit",
+			reader:   strings.NewReader("hello"),
+			maxSize:  5,
+			expected: []byte("hello"),
+		},
+		{
+			name:        "message over limit",
+			reader:      strings.NewReader("hello, world"),
+			maxSize:     5,
+			expected:    []byte("hello"),
+			expectedErr: errMessageTooLarge,
+		},
+		{
+			name:        "read error",
+			reader:      iotest.ErrReader(errors.New("read error")),
+			maxSize:     5,
+			expectedErr: errors.New("read error"),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := readMessage(tc.reader, tc.maxSize)
+			if tc.expectedErr != nil {
+				assert.EqualError(t, err, tc.expectedErr.Error(), "expected error to match")
+				assert.Equal(t, tc.expected, raw, "expected the start of the message to match")
+				return
+			}
+
+			assert.NoError(t, err, "expected no error")
+			assert.Equal(t, tc.expected, raw, "expected message to match")
+		})
+	}
+
+	t.Run("discards rest of oversized message", func(t *testing.T) {
+		r := strings.NewReader("hello, world")
+		_, err := readMessage(r, 5)
+		assert.ErrorIs(t, err, errMessageTooLarge, "expected message too large error")
+		assert.Equal(t, 0, r.Len(), "expected the rest of the message to be discarded")
+	})
+}
+
+func Test_peekMessageId(t *testing.T) {
+	tcases := []struct {
+		name     string
+		head     string
+		expected int
+	}{
+		{
+			name:     "id first",
+			head:     `{"id":7,"publish":{"room_id":"abc","content":"aaaa`,
+			expected: 7,
+		},
+		{
+			name:     "id after other fields",
+			head:     `{"timestamp":"2025-01-01T00:00:00Z","typing":{"room_id":"abc"},"id":8,"publish":{"conte`,
+			expected: 8,
+		},
+		{
+			name:     "id cut off",
+			head:     `{"publish":{"room_id":"abc","content":"aaaa`,
+			expected: -1,
+		},
+		{
+			name:     "id value cut off",
+			head:     `{"id":`,
+			expected: -1,
+		},
+		{
+			name:     "invalid id",
+			head:     `{"id":"seven","publish":{`,
+			expected: -1,
+		},
+		{
+			name:     "not an object",
+			head:     `["id",7]`,
+			expected: -1,
+		},
+		{
+			name:     "not json",
+			head:     `aaaa`,
+			expected: -1,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, peekMessageId([]byte(tc.head)), "expected message id to match")
+		})
+	}
+}
+
+func TestClient_Read_messageTooLarge(t *testing.T) {
+	db := &database.MockGoChatRepository{}
+	su := &stats.MockStatsUpdater{}
+	su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
+	su.On("Incr", "TotalIncomingMessages").Return(nil)
+	su.On("Decr", mock.Anything).Return(nil).Maybe()
+
+	cs, err := NewChatServer(testutil.TestLogger(t), db, su, nil, WithMaxMessageSize(64))
+	if err != nil {
+		t.Fatalf("failed to create chat server: %v", err)
+	}
+
+	clients := make(chan *Client, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade connection: %v", err)
+			return
+		}
+
+		c := NewClient(types.User{Id: 1, Username: "user1"}, conn, cs, cs.log, su)
+		clients <- c
+		go c.Read()
+	}))
+	defer srv.Close()
+
+	// a small write buffer splits messages into multiple frames
+	dialer := &websocket.Dialer{WriteBufferSize: 32}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to dial websocket: %v", err)
+	}
+	defer conn.Close()
+
+	c := <-clients
+
+	// an oversized message spanning multiple frames
+	w, err := conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+	for range 4 {
+		if _, err := w.Write([]byte(strings.Repeat("a", 32))); err != nil {
+			t.Fatalf("failed to write frame: %v", err)
+		}
+	}
+	assert.NoError(t, w.Close(), "expected message to be written")
+
+	select {
+	case resp := <-c.send:
+		assert.NotNil(t, resp.Response, "expected response message")
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Response.ResponseCode, "expected response code 413")
+	case <-time.After(time.Second):
+		t.Fatal("timeout: client did not receive message too large response")
+	}
+
+	// messages far larger than the maximum are discarded too, and the error is
+	// correlated with the message when its id comes first
+	huge := `{"id":7,"publish":{"room_id":"abc","content":"` + strings.Repeat("a", 64*1024) + `"}}`
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(huge)), "expected message to be written")
+
+	select {
+	case resp := <-c.send:
+		assert.NotNil(t, resp.Response, "expected response message")
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Response.ResponseCode, "expected response code 413")
+		assert.Equal(t, 7, resp.Id, "expected the response to be correlated with the message")
+	case <-time.After(time.Second):
+		t.Fatal("timeout: client did not receive message too large response")
+	}
+
+	// the connection stays open for subsequent messages
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("not json")), "expected message to be written")
+
+	select {
+	case resp := <-c.send:
+		assert.NotNil(t, resp.Response, "expected response message")
+		assert.Equal(t, http.StatusBadRequest, resp.Response.ResponseCode, "expected response code 400")
+	case <-time.After(time.Second):
+		t.Fatal("timeout: client did not receive invalid message response")
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/npezzotti/go-chatroom/internal/backplane"
+	"github.com/npezzotti/go-chatroom/internal/types"
+)
+
+const (
+	// publishBufferSize is the number of events that can be waiting to be published to the backplane
+	publishBufferSize = 1024
+	// publishTimeout is how long publishing an event to the backplane can take
+	publishTimeout = time.Second * 5
+	// leaseTTL is how long a node hosts a room after acquiring or renewing the room's lease.
+	// If the node dies, another node with clients in the room takes over once it expires.
+	leaseTTL = time.Second * 15
+	// leaseRenewInterval is how often the nodes with a room loaded renew or try to take over its lease
+	leaseRenewInterval = time.Second * 5
+	// forwardedReplyBufferSize is the number of responses to a forwarded message that can be sent back
+	forwardedReplyBufferSize = 8
+	// forwardTimeout is how long a node waits for the response to a message forwarded to the
+	// node hosting the room before letting the client know it can't be handled
+	forwardTimeout = time.Second * 10
+)
+
+// WithBackplane runs the chat server as a node of a cluster. Messages sent to the clients
+// of a room or user are published to the other nodes through bp, so clients receive them
+// whichever node they are connected to. Each room is hosted by a single node at a time,
+// which holds a lease on the room in the database.
+func WithBackplane(bp backplane.Backplane) Option {
+	return func(cs *ChatServer) {
+		cs.backplane = bp
+	}
+}
+
+// clusterEvent is published through the backplane to the other nodes of a cluster.
+//
+// Messages are fanned out: the node that sends a message to a room or user delivers it
+// to its own clients and the other nodes deliver it to theirs. Notifications about changes
+// made outside of the chat server (i.e. through the REST API) are fanned out the same way
+// by the node the change was made on. Subscribers removed from a room are instead removed
+// by every node that has the room loaded, so each node updates its own state.
+//
+// A room is hosted by the single node holding its lease, which handles the messages from
+// clients. The other nodes with clients in the room forward their messages to it.
+type clusterEvent struct {
+	// Node is the id of the node that published the event
+	Node string `json:"node"`
+	// To is set for events for a single node
+	To string `json:"to,omitempty"`
+	// RoomId is set for events for the clients of a room
+	RoomId string `json:"room_id,omitempty"`
+	// UserId is set for messages for all of the clients of a user
+	UserId int `json:"user_id,omitempty"`
+	// ClientId is set for responses to a message forwarded by the client,
+	// which are handled by the room the message was forwarded from
+	ClientId string `json:"client_id,omitempty"`
+	// Message is sent to the clients of the room or user
+	Message *ServerMessage `json:"message,omitempty"`
+	// SkipClient is the id of the client the message isn't sent to
+	SkipClient string `json:"skip_client,omitempty"`
+	// SkipRoom is the id of the room whose clients the message for a user isn't sent to
+	SkipRoom string `json:"skip_room,omitempty"`
+	// Forward is a message from a client to the node hosting the room
+	Forward *forwardedMessage `json:"forward,omitempty"`
+	// Released is set when the node hosting the room unloaded it
+	Released bool `json:"released,omitempty"`
+	// Remove is the user to remove from the room like with RemoveSubscriber
+	Remove *types.User `json:"remove,omitempty"`
+	Banned bool        `json:"banned,omitempty"`
+	// Deleted is set when the room was deleted and must be unloaded
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// forwardedMessage is a message from a client connected to a node that doesn't host the room.
+type forwardedMessage struct {
+	ClientId string         `json:"client_id"`
+	User     types.User     `json:"user"`
+	Message  *ClientMessage `json:"message"`
+}
+
+// forwardKey identifies a message forwarded to the node hosting a room by the client that sent it.
+type forwardKey struct {
+	clientId string
+	msgId    int
+}
+
+// pendingForward is a forwarded message the node hosting the room hasn't responded to yet.
+type pendingForward struct {
+	client   *Client
+	deadline time.Time
+}
+
+// newId generates a random id identifying a node or client in a cluster.
+func newId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// publish queues an event to be published to the other nodes of the cluster.
+// Like messages to slow clients, events are dropped if the backplane can't keep up.
+func (cs *ChatServer) publish(e *clusterEvent) {
+	if cs.backplane == nil {
+		return
+	}
+
+	e.Node = cs.nodeId
+	select {
+	case cs.publishChan <- e:
+	default:
+		cs.log.Println("publish channel full, dropping cluster event")
+	}
+}
+
+// publishEvents publishes queued events to the backplane until the chat server stops.
+func (cs *ChatServer) publishEvents() {
+	for {
+		select {
+		case e := <-cs.publishChan:
+			payload, err := json.Marshal(e)
+			if err != nil {
+				cs.log.Println("marshal cluster event:", err)
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+			err = cs.backplane.Publish(ctx, payload)
+			cancel()
+			if err != nil {
+				cs.log.Println("publish cluster event:", err)
+			}
+		case <-cs.done:
+			return
+		}
+	}
+}
+
+// receiveEvents handles the events published by the other nodes until
+// the chat server stops or the backplane is closed.
+func (cs *ChatServer) receiveEvents() {
+	for {
+		select {
+		case payload, ok := <-cs.backplane.Messages():
+			if !ok {
+				return
+			}
+
+			var e clusterEvent
+			if err := json.Unmarshal(payload, &e); err != nil {
+				cs.log.Println("unmarshal cluster event:", err)
+				continue
+			}
+
+			// the backplane delivers events to the node that published them too
+			if e.Node != cs.nodeId {
+				cs.handleClusterEvent(&e)
+			}
+		case <-cs.done:
+			return
+		}
+	}
+}
+
+// handleClusterEvent delivers an event published by another node to this node's clients.
+// Events for rooms that aren't loaded are dropped, there are no clients in them, messages
+// forwarded to the room are rejected and responses are delivered to the client directly.
+func (cs *ChatServer) handleClusterEvent(e *clusterEvent) {
+	if e.To != "" && e.To != cs.nodeId {
+		return
+	}
+
+	if e.Message != nil {
+		e.Message.UserId = e.UserId
+		e.Message.skipRoom = e.SkipRoom
+	}
+
+	if e.RoomId == "" {
+		if e.Message != nil {
+			cs.deliverToUser(e.Message)
+		}
+		return
+	}
+
+	room, ok := cs.getRoom(e.RoomId)
+	if !ok {
+		switch {
+		case e.Forward != nil:
+			cs.rejectForwarded(e)
+		case e.ClientId != "":
+			// the room was unloaded, so it no longer waits for the response
+			cs.deliverToClient(e.ClientId, e.Message)
+		}
+		return
+	}
+
+	switch {
+	case e.Message != nil, e.Forward != nil, e.Released:
+		select {
+		case room.remoteChan <- e:
+		default:
+			cs.log.Printf("remote channel full for room %q, dropping event", room.externalId)
+			switch {
+			case e.Forward != nil:
+				cs.rejectForwarded(e)
+			case e.ClientId != "":
+				cs.deliverToClient(e.ClientId, e.Message)
+			}
+		}
+	case e.Remove != nil:
+		select {
+		case room.removeChan <- removeReq{user: *e.Remove, banned: e.Banned}:
+		default:
+			cs.log.Printf("remove channel full for room %q, unable to remove user %d", room.externalId, e.Remove.Id)
+		}
+	case e.Deleted:
+		select {
+		case cs.unloadRoomChan <- unloadRoomRequest{roomId: room.externalId, deleted: true}:
+		default:
+			cs.log.Printf("unloadRoomChan full, unable to unload deleted room %q", room.externalId)
+		}
+	}
+}
+
+// rejectForwarded lets the client that forwarded a message know it can't be handled,
+// e.g. because the room was unloaded, so it can be sent again.
+func (cs *ChatServer) rejectForwarded(e *clusterEvent) {
+	cs.publish(&clusterEvent{
+		To:       e.Node,
+		RoomId:   e.RoomId,
+		ClientId: e.Forward.ClientId,
+		UserId:   e.Forward.User.Id,
+		Message:  ErrServiceUnavailable(e.Forward.Message.Id),
+	})
+}
+
+// deliverToClient queues a message to a client connected to this node by its id.
+func (cs *ChatServer) deliverToClient(clientId string, msg *ServerMessage) {
+	for _, c := range cs.getClients(msg.UserId) {
+		if c.id == clientId {
+			c.queueMessage(msg)
+			return
+		}
+	}
+}
@@ -0,0 +1,677 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/npezzotti/go-chatroom/internal/backplane"
+	"github.com/npezzotti/go-chatroom/internal/database"
+	"github.com/npezzotti/go-chatroom/internal/stats"
+	"github.com/npezzotti/go-chatroom/internal/testutil"
+	"github.com/npezzotti/go-chatroom/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newTestNode creates a chat server connected to the other nodes in hub,
+// which publishes and receives events until the test ends.
+func newTestNode(t *testing.T, hub *backplane.Hub) *ChatServer {
+	su := &stats.MockStatsUpdater{}
+	su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
+	su.On("Incr", mock.Anything).Maybe()
+	su.On("Decr", mock.Anything).Maybe()
+
+	bp := hub.Connect()
+	cs, err := NewChatServer(testutil.TestLogger(t), &database.MockGoChatRepository{}, su, nil, WithBackplane(bp))
+	if err != nil {
+		t.Fatalf("failed to create test ChatServer: %v", err)
+	}
+
+	go cs.publishEvents()
+	go cs.receiveEvents()
+	t.Cleanup(func() {
+		close(cs.done)
+		bp.Close()
+	})
+
+	return cs
+}
+
+// newTestNodeRoom loads a room with a client in it on a node.
+// The room isn't started, so tests handle the events sent to it.
+func newTestNodeRoom(t *testing.T, cs *ChatServer, user types.User) (*Room, *Client) {
+	room := &Room{
+		id:         1,
+		externalId: "testroom",
+		cs:         cs,
+		clients:    make(map[*Client]struct{}),
+		userMap:    make(map[int]map[*Client]struct{}),
+		notifyChan: make(chan *Notification, 1),
+		removeChan: make(chan removeReq, 1),
+		remoteChan: make(chan *clusterEvent, 1),
+		log:        cs.log,
+	}
+	cs.addRoom(room.externalId, room)
+
+	c := &Client{user: user, send: make(chan *ServerMessage, 1), rooms: make(map[string]*Room)}
+	cs.addClient(c)
+	room.addClient(c)
+	c.addRoom(room)
+
+	return room, c
+}
+
+func TestChatServer_backplane(t *testing.T) {
+	user := types.User{Id: 2, Username: "user2"}
+
+	t.Run("room message delivered to other nodes", func(t *testing.T) {
+		hub := backplane.NewHub()
+		node1, node2 := newTestNode(t, hub), newTestNode(t, hub)
+		room1, _ := newTestNodeRoom(t, node1, types.User{Id: 1, Username: "user1"})
+		room2, _ := newTestNodeRoom(t, node2, user)
+
+		room1.broadcast(&ServerMessage{Message: &types.Message{SeqId: 1, RoomId: room1.id, Content: "hi"}})
+
+		select {
+		case e := <-room2.remoteChan:
+			assert.Equal(t, "hi", e.Message.Message.Content, "expected message to be delivered to the room on the other node")
+		case <-time.After(time.Second):
+			t.Error("timeout: message not delivered to the room on the other node")
+		}
+	})
+
+	t.Run("user message delivered to other nodes", func(t *testing.T) {
+		hub := backplane.NewHub()
+		node1, node2 := newTestNode(t, hub), newTestNode(t, hub)
+		c := &Client{user: user, send: make(chan *ServerMessage, 1)}
+		node2.addClient(c)
+
+		node1.handleBroadcast(&ServerMessage{
+			Notification: &Notification{Kicked: &Kicked{RoomId: "testroom"}},
+			UserId:       user.Id,
+		})
+
+		select {
+		case msg := <-c.send:
+			assert.Equal(t, &Kicked{RoomId: "testroom"}, msg.Notification.Kicked, "expected notification to be delivered to the client on the other node")
+		case <-time.After(time.Second):
+			t.Error("timeout: notification not delivered to the client on the other node")
+		}
+	})
+
+	t.Run("notification delivered by other nodes", func(t *testing.T) {
+		hub := backplane.NewHub()
+		node1, node2, node3 := newTestNode(t, hub), newTestNode(t, hub), newTestNode(t, hub)
+		room1, _ := newTestNodeRoom(t, node1, types.User{Id: 1, Username: "user1"})
+		room2, c2 := newTestNodeRoom(t, node2, user)
+		// node3 hasn't loaded the room, its client is an inactive subscriber
+		inactive := types.User{Id: 3, Username: "user3"}
+		c3 := &Client{user: inactive, send: make(chan *ServerMessage, 1), rooms: make(map[string]*Room)}
+		node3.addClient(c3)
+		room1.subscribers = []types.User{{Id: 1, Username: "user1"}, user, inactive}
+
+		n := &Notification{RoomUpdated: &RoomUpdated{RoomId: room1.externalId, Name: "renamed"}}
+		err := node1.NotifyRoom(context.Background(), room1.externalId, n)
+		assert.NoError(t, err, "expected no error notifying room")
+		room1.handleNotification(<-room1.notifyChan)
+		for len(node1.broadcastChan) > 0 {
+			node1.handleBroadcast(<-node1.broadcastChan)
+		}
+
+		select {
+		case e := <-room2.remoteChan:
+			assert.Equal(t, n, e.Message.Notification, "expected notification to be delivered to the room on the other node")
+		case <-time.After(time.Second):
+			t.Error("timeout: notification not delivered to the room on the other node")
+		}
+
+		select {
+		case msg := <-c3.send:
+			assert.Equal(t, n, msg.Notification, "expected notification to be delivered to the inactive subscriber")
+		case <-time.After(time.Second):
+			t.Error("timeout: notification not delivered to the inactive subscriber")
+		}
+
+		assert.Never(t, func() bool { return len(c2.send) > 0 }, 100*time.Millisecond, 10*time.Millisecond,
+			"expected the client in the room to only receive the room's notification")
+	})
+
+	t.Run("notification for a room that isn't loaded delivered by other nodes", func(t *testing.T) {
+		hub := backplane.NewHub()
+		node1, node2, node3 := newTestNode(t, hub), newTestNode(t, hub), newTestNode(t, hub)
+		room2, _ := newTestNodeRoom(t, node2, user)
+		inactive := types.User{Id: 3, Username: "user3"}
+		c3 := &Client{user: inactive, send: make(chan *ServerMessage, 1), rooms: make(map[string]*Room)}
+		node3.addClient(c3)
+
+		db := node1.db.(*database.MockGoChatRepository)
+		db.On("GetRoomByExternalId", mock.Anything, room2.externalId).Return(database.Room{Id: room2.id}, nil).Once()
+		db.On("GetSubscribersByRoomId", mock.Anything, room2.id).Return([]database.User{{Id: user.Id}, {Id: inactive.Id}}, nil).Once()
+
+		n := &Notification{RoomUpdated: &RoomUpdated{RoomId: room2.externalId, Name: "renamed"}}
+		err := node1.NotifyRoom(context.Background(), room2.externalId, n)
+		assert.NoError(t, err, "expected no error notifying room")
+
+		select {
+		case e := <-room2.remoteChan:
+			assert.Equal(t, n, e.Message.Notification, "expected notification to be delivered to the room on the other node")
+		case <-time.After(time.Second):
+			t.Error("timeout: notification not delivered to the room on the other node")
+		}
+
+		select {
+		case msg := <-c3.send:
+			assert.Equal(t, n, msg.Notification, "expected notification to be delivered to the inactive subscriber")
+		case <-time.After(time.Second):
+			t.Error("timeout: notification not delivered to the inactive subscriber")
+		}
+	})
+
+	t.Run("room deleted notification delivered before the room is unloaded on other nodes", func(t *testing.T) {
+		hub := backplane.NewHub()
+		node1, node2 := newTestNode(t, hub), newTestNode(t, hub)
+		room2, inRoom := newTestNodeRoom(t, node2, user)
+		elsewhere := &Client{user: user, send: make(chan *ServerMessage, 1), rooms: make(map[string]*Room)}
+		node2.addClient(elsewhere)
+
+		node1.NotifyUsers(room2.externalId, []int{user.Id}, &Notification{RoomDeleted: &RoomDeleted{RoomId: room2.externalId}})
+		err := node1.UnloadRoom(context.Background(), room2.externalId, true)
+		assert.NoError(t, err, "expected no error unloading room")
+
+		select {
+		case req := <-node2.unloadRoomChan:
+			assert.Equal(t, unloadRoomRequest{roomId: room2.externalId, deleted: true}, req, "expected room to be unloaded on the other node")
+		case <-time.After(time.Second):
+			t.Fatal("timeout: room not unloaded on the other node")
+		}
+
+		// the notification was handled first, while the client was still in the room
+		assert.Len(t, elsewhere.send, 1, "expected client outside of the room to be notified")
+		assert.Empty(t, inRoom.send, "expected client in the room to be notified by the room instead")
+	})
+
+	t.Run("subscriber removed on other nodes", func(t *testing.T) {
+		hub := backplane.NewHub()
+		node1, node2, node3 := newTestNode(t, hub), newTestNode(t, hub), newTestNode(t, hub)
+		room2, _ := newTestNodeRoom(t, node2, user)
+		// the kicked user's other client is connected to a node that hasn't loaded the room
+		c3 := &Client{user: user, send: make(chan *ServerMessage, 1), rooms: make(map[string]*Room)}
+		node3.addClient(c3)
+
+		err := node1.RemoveSubscriber(context.Background(), room2.externalId, user, true)
+		assert.NoError(t, err, "expected no error removing subscriber")
+		node1.handleBroadcast(<-node1.broadcastChan)
+
+		select {
+		case got := <-room2.removeChan:
+			assert.Equal(t, removeReq{user: user, banned: true}, got, "expected remove request to be forwarded to the room on the other node")
+		case <-time.After(time.Second):
+			t.Error("timeout: remove request not forwarded to the room on the other node")
+		}
+
+		select {
+		case msg := <-c3.send:
+			assert.Equal(t, &Kicked{RoomId: room2.externalId, Banned: true}, msg.Notification.Kicked, "expected kick notification to be delivered to the user on the other node")
+		case <-time.After(time.Second):
+			t.Error("timeout: kick notification not delivered to the user on the other node")
+		}
+	})
+}
+
+func TestChatServer_publish(t *testing.T) {
+	tcases := []struct {
+		name      string
+		send      func(cs *ChatServer, room *Room)
+		published bool
+	}{
+		{
+			name: "room message",
+			send: func(cs *ChatServer, room *Room) {
+				room.broadcast(&ServerMessage{Message: &types.Message{SeqId: 1}})
+			},
+			published: true,
+		},
+		{
+			name: "room message handled locally",
+			send: func(cs *ChatServer, room *Room) {
+				room.handleLocally(func() { room.broadcast(&ServerMessage{Message: &types.Message{SeqId: 1}}) })
+			},
+		},
+		{
+			name: "user message",
+			send: func(cs *ChatServer, room *Room) {
+				cs.handleBroadcast(&ServerMessage{UserId: 1})
+			},
+			published: true,
+		},
+		{
+			name: "local user message",
+			send: func(cs *ChatServer, room *Room) {
+				cs.handleBroadcast(&ServerMessage{UserId: 1, local: true})
+			},
+		},
+		{
+			name: "user message sent while handling locally",
+			send: func(cs *ChatServer, room *Room) {
+				room.handleLocally(func() { room.sendToUser(&ServerMessage{UserId: 1}) })
+				cs.handleBroadcast(<-cs.broadcastChan)
+			},
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := newTestChatServer(t, &database.MockGoChatRepository{}, &stats.MockStatsUpdater{})
+			cs.backplane = backplane.NewHub().Connect()
+			room := &Room{externalId: "testroom", cs: cs, clients: make(map[*Client]struct{})}
+
+			tc.send(cs, room)
+
+			if tc.published {
+				assert.Len(t, cs.publishChan, 1, "expected event to be published")
+			} else {
+				assert.Empty(t, cs.publishChan, "expected no event to be published")
+			}
+		})
+	}
+}
+
+func TestChatServer_receiveEvents(t *testing.T) {
+	hub := backplane.NewHub()
+	bp := hub.Connect()
+	defer bp.Close()
+
+	cs := newTestChatServer(t, &database.MockGoChatRepository{}, &stats.MockStatsUpdater{})
+	cs.backplane = hub.Connect()
+	go cs.receiveEvents()
+	defer close(cs.done)
+
+	remoteChan := make(chan *clusterEvent, 2)
+	cs.roomsMap.Store("testroom", &Room{externalId: "testroom", remoteChan: remoteChan})
+
+	publish := func(e clusterEvent) {
+		payload, err := json.Marshal(e)
+		assert.NoError(t, err, "expected no error marshaling event")
+		assert.NoError(t, bp.Publish(context.Background(), payload), "expected no error publishing event")
+	}
+
+	// events published by the node itself are ignored
+	publish(clusterEvent{Node: cs.nodeId, RoomId: "testroom", Message: &ServerMessage{Message: &types.Message{SeqId: 1}}})
+	publish(clusterEvent{Node: "other", RoomId: "testroom", Message: &ServerMessage{Message: &types.Message{SeqId: 2}}})
+
+	select {
+	case e := <-remoteChan:
+		assert.Equal(t, 2, e.Message.Message.SeqId, "expected only the event from the other node to be handled")
+	case <-time.After(time.Second):
+		t.Error("timeout: event from the other node not handled")
+	}
+}
+
+func Test_handleRemoteMessage(t *testing.T) {
+	cs := newTestChatServer(t, &database.MockGoChatRepository{}, &stats.MockStatsUpdater{})
+	cs.backplane = backplane.NewHub().Connect()
+
+	room := &Room{
+		externalId:    "testroom",
+		cs:            cs,
+		clients:       make(map[*Client]struct{}),
+		userMap:       make(map[int]map[*Client]struct{}),
+		seq_id:        3,
+		unread:        map[int]*database.Unread{1: {AccountId: 1, LastReadSeqId: 3}},
+		threadReplies: map[int]int{2: 1},
+	}
+	c := &Client{user: types.User{Id: 1, Username: "user1"}, send: make(chan *ServerMessage, 1), rooms: make(map[string]*Room)}
+	room.addClient(c)
+
+	room.handleRemoteMessage(&ServerMessage{Message: &types.Message{SeqId: 5, ParentSeqId: 2, Content: "hi"}})
+
+	assert.Equal(t, 5, room.seq_id, "expected seq id to advance to the message's")
+	assert.Nil(t, room.unread, "expected unread counts to be reloaded")
+	assert.NotContains(t, room.threadReplies, 2, "expected reply count to be reloaded")
+	assert.Len(t, c.send, 1, "expected message to be delivered to the client")
+	assert.Empty(t, cs.publishChan, "expected remote message not to be published again")
+	assert.False(t, room.localOnly, "expected room to publish messages again")
+}
+
+func TestChatServer_handleJoinRoom_lease(t *testing.T) {
+	tcases := []struct {
+		name          string
+		holder        string
+		leaseErr      error
+		expectedOwner string
+		loaded        bool
+	}{
+		{
+			name:   "lease acquired",
+			loaded: true,
+		},
+		{
+			name:          "lease held by another node",
+			holder:        "other",
+			expectedOwner: "other",
+			loaded:        true,
+		},
+		{
+			name:     "lease error",
+			leaseErr: errors.New("db error"),
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			roomId := "testroom"
+			dbRoom := database.Room{Id: 1, ExternalId: roomId, Subscriptions: []database.Subscription{{AccountId: 1}}}
+			db := &database.MockGoChatRepository{}
+			defer db.AssertExpectations(t)
+
+			su := &stats.MockStatsUpdater{}
+			su.On("Incr", "NumActiveRooms").Maybe()
+			su.On("Decr", "NumActiveRooms").Maybe()
+
+			cs := newTestChatServer(t, db, su)
+			cs.backplane = backplane.NewHub().Connect()
+			if tc.holder == "" {
+				tc.holder = cs.nodeId
+			}
+
+			db.On("GetRoomByExternalId", mock.Anything, roomId).Return(dbRoom, nil).Once()
+			db.On("GetSubscribersByRoomId", mock.Anything, dbRoom.Id).Return([]database.User{}, nil).Once()
+			db.On("AcquireRoomLease", mock.Anything, dbRoom.Id, cs.nodeId, leaseTTL).Return(tc.holder, tc.leaseErr).Once()
+			// These methods may be called in Room.handleJoin and when the room is unloaded
+			db.On("SubscriptionExists", mock.Anything, 1, dbRoom.Id).Return(true).Maybe()
+			db.On("GetRoomWithSubscribers", mock.Anything, dbRoom.Id).Return(&dbRoom, nil).Maybe()
+			db.On("ListPins", mock.Anything, dbRoom.Id).Return([]database.Pin{}, nil).Maybe()
+			db.On("ReleaseRoomLease", mock.Anything, dbRoom.Id, cs.nodeId).Return(nil).Maybe()
+
+			client := &Client{
+				user:     types.User{Id: 1},
+				send:     make(chan *ServerMessage, 1),
+				rooms:    make(map[string]*Room),
+				log:      cs.log,
+				exitRoom: make(chan string, 1),
+			}
+			cs.handleJoinRoom(&ClientMessage{
+				BaseMessage: BaseMessage{Id: 1, Timestamp: time.Now()},
+				Join:        &Join{RoomId: roomId},
+				client:      client,
+			})
+
+			room, ok := cs.getRoom(roomId)
+			assert.Equal(t, tc.loaded, ok, "expected room to be loaded")
+			if !ok {
+				resp := <-client.send
+				assert.Equal(t, http.StatusInternalServerError, resp.Response.ResponseCode, "expected internal error")
+				return
+			}
+
+			defer cs.unloadRoom(roomId, false)
+			assert.Equal(t, tc.expectedOwner, room.owner, "expected room owner to match")
+		})
+	}
+}
+
+func TestRoom_forward(t *testing.T) {
+	hub := backplane.NewHub()
+	node1, node2 := newTestNode(t, hub), newTestNode(t, hub)
+
+	db := &database.MockGoChatRepository{}
+	defer db.AssertExpectations(t)
+
+	// the room is hosted by node1, node2 forwards the messages from its clients to it
+	host, watcher := newTestNodeRoom(t, node1, types.User{Id: 1, Username: "user1"})
+	host.db = db
+	host.typingTimer = time.NewTimer(typingTimeout)
+	replica, other := newTestNodeRoom(t, node2, types.User{Id: 3, Username: "user3"})
+	replica.owner = node1.nodeId
+
+	sender := &Client{id: "sender", user: types.User{Id: 2, Username: "user2"}, send: make(chan *ServerMessage, 1), rooms: make(map[string]*Room)}
+	replica.addClient(sender)
+	node2.addClient(sender)
+
+	receive := func(ch chan *clusterEvent) *clusterEvent {
+		select {
+		case e := <-ch:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("timeout: event not received")
+			return nil
+		}
+	}
+
+	t.Run("message broadcast by host", func(t *testing.T) {
+		replica.forward(&ClientMessage{
+			Typing: &Typing{RoomId: replica.externalId},
+			UserId: sender.user.Id,
+			client: sender,
+		})
+
+		e := receive(host.remoteChan)
+		assert.Equal(t, "sender", e.Forward.ClientId, "expected forwarded message to identify the client")
+		host.handleRemoteEvent(e)
+
+		msg := <-watcher.send
+		assert.Equal(t, sender.user.Id, msg.Notification.Typing.UserId, "expected client on the host to be notified")
+
+		replica.handleRemoteEvent(receive(replica.remoteChan))
+		assert.Len(t, other.send, 1, "expected other client on the replica to be notified")
+		assert.Empty(t, sender.send, "expected sender to be skipped")
+		<-other.send
+	})
+
+	t.Run("response sent back to client", func(t *testing.T) {
+		db.On("UpdateLastReadSeqId", mock.Anything, sender.user.Id, host.id, 0).Return(nil).Once()
+
+		replica.forward(&ClientMessage{
+			BaseMessage: BaseMessage{Id: 7},
+			Read:        &Read{RoomId: replica.externalId},
+			UserId:      sender.user.Id,
+			client:      sender,
+		})
+		assert.Contains(t, replica.forwarded, forwardKey{clientId: sender.id, msgId: 7}, "expected replica to wait for the response")
+		host.handleRemoteEvent(receive(host.remoteChan))
+		replica.handleRemoteEvent(receive(replica.remoteChan))
+
+		select {
+		case msg := <-sender.send:
+			assert.Equal(t, 7, msg.Id, "expected response to the forwarded message")
+			assert.Equal(t, http.StatusOK, msg.Response.ResponseCode, "expected OK response")
+		case <-time.After(time.Second):
+			t.Error("timeout: response not sent back to the client")
+		}
+		assert.Empty(t, replica.forwarded, "expected replica to stop waiting for the response")
+	})
+
+	t.Run("rejected when room is not hosted", func(t *testing.T) {
+		node1.removeRoom(host.externalId)
+		defer node1.addRoom(host.externalId, host)
+
+		replica.forward(&ClientMessage{
+			BaseMessage: BaseMessage{Id: 8},
+			Typing:      &Typing{RoomId: replica.externalId},
+			UserId:      sender.user.Id,
+			client:      sender,
+		})
+		replica.handleRemoteEvent(receive(replica.remoteChan))
+
+		select {
+		case msg := <-sender.send:
+			assert.Equal(t, http.StatusServiceUnavailable, msg.Response.ResponseCode, "expected service unavailable response")
+		case <-time.After(time.Second):
+			t.Error("timeout: rejection not sent back to the client")
+		}
+	})
+}
+
+func TestRoom_forward_hostDied(t *testing.T) {
+	tcases := []struct {
+		name   string
+		holder string
+		handle func(room *Room)
+	}{
+		{
+			name: "rejected when the host doesn't respond in time",
+			handle: func(room *Room) {
+				for key, p := range room.forwarded {
+					p.deadline = time.Now()
+					room.forwarded[key] = p
+				}
+				room.expireForwarded()
+			},
+		},
+		{
+			name:   "rejected when another node takes over the room",
+			holder: "other",
+			handle: func(room *Room) {
+				room.renewLease()
+			},
+		},
+		{
+			name: "rejected when this node takes over the room",
+			handle: func(room *Room) {
+				room.renewLease()
+			},
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			hub := backplane.NewHub()
+			node := newTestNode(t, hub)
+			db := node.db.(*database.MockGoChatRepository)
+			defer db.AssertExpectations(t)
+
+			// the host died after the room's messages were forwarded to it, so nothing responds
+			room, sender := newTestNodeRoom(t, node, types.User{Id: 2, Username: "user2"})
+			room.db = db
+			room.owner = "dead"
+			if tc.holder == "" {
+				tc.holder = node.nodeId
+				db.On("GetRoomByExternalId", mock.Anything, room.externalId).Return(database.Room{Id: room.id}, nil).Maybe()
+			}
+			db.On("AcquireRoomLease", mock.Anything, room.id, node.nodeId, leaseTTL).Return(tc.holder, nil).Maybe()
+
+			room.forward(&ClientMessage{
+				BaseMessage: BaseMessage{Id: 9},
+				Read:        &Read{RoomId: room.externalId},
+				UserId:      sender.user.Id,
+				client:      sender,
+			})
+			assert.Empty(t, sender.send, "expected no response while waiting for the host")
+
+			tc.handle(room)
+
+			select {
+			case msg := <-sender.send:
+				assert.Equal(t, 9, msg.Id, "expected response to the forwarded message")
+				assert.Equal(t, http.StatusServiceUnavailable, msg.Response.ResponseCode, "expected service unavailable response")
+			default:
+				t.Error("expected forwarded message to be rejected")
+			}
+			assert.Empty(t, room.forwarded, "expected room to stop waiting for the response")
+		})
+	}
+}
+
+func TestRoom_renewLease(t *testing.T) {
+	tcases := []struct {
+		name          string
+		owner         string
+		holder        string
+		leaseErr      error
+		dbSeqId       int
+		expectedOwner string
+		expectedSeqId int
+	}{
+		{
+			name:          "host renews lease",
+			expectedSeqId: 3,
+		},
+		{
+			name:          "host lost lease",
+			holder:        "other",
+			expectedOwner: "other",
+			expectedSeqId: 3,
+		},
+		{
+			name:          "takes over expired lease",
+			owner:         "dead",
+			dbSeqId:       5,
+			expectedSeqId: 5,
+		},
+		{
+			name:          "lease error",
+			owner:         "other",
+			leaseErr:      errors.New("db error"),
+			expectedOwner: "other",
+			expectedSeqId: 3,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &database.MockGoChatRepository{}
+			defer db.AssertExpectations(t)
+
+			cs := newTestChatServer(t, db, &stats.MockStatsUpdater{})
+			room := &Room{id: 1, externalId: "testroom", cs: cs, db: db, owner: tc.owner, seq_id: 3, log: cs.log}
+			if tc.holder == "" {
+				tc.holder = cs.nodeId
+			}
+
+			db.On("AcquireRoomLease", mock.Anything, room.id, cs.nodeId, leaseTTL).Return(tc.holder, tc.leaseErr).Once()
+			if tc.dbSeqId > 0 {
+				db.On("GetRoomByExternalId", mock.Anything, room.externalId).Return(database.Room{Id: room.id, SeqId: tc.dbSeqId}, nil).Once()
+			}
+
+			room.renewLease()
+
+			assert.Equal(t, tc.expectedOwner, room.owner, "expected room owner to match")
+			assert.Equal(t, tc.expectedSeqId, room.seq_id, "expected seq id to match")
+		})
+	}
+}
+
+func TestRoom_releaseLease(t *testing.T) {
+	tcases := []struct {
+		name     string
+		owner    string
+		released bool
+	}{
+		{
+			name:     "host releases lease",
+			released: true,
+		},
+		{
+			name:  "room hosted by another node",
+			owner: "other",
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &database.MockGoChatRepository{}
+			defer db.AssertExpectations(t)
+
+			cs := newTestChatServer(t, db, &stats.MockStatsUpdater{})
+			cs.backplane = backplane.NewHub().Connect()
+			room := &Room{id: 1, externalId: "testroom", cs: cs, db: db, owner: tc.owner, log: cs.log}
+
+			if tc.released {
+				db.On("ReleaseRoomLease", mock.Anything, room.id, cs.nodeId).Return(nil).Once()
+			}
+
+			room.releaseLease()
+
+			if tc.released {
+				e := <-cs.publishChan
+				assert.True(t, e.Released, "expected other nodes to be notified the room was released")
+			} else {
+				assert.Empty(t, cs.publishChan, "expected no event to be published")
+			}
+		})
+	}
+}
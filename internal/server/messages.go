@@ -17,7 +17,7 @@ type BaseMessage struct {
 }
 
 // ClientMessage represents a message sent by the client to the server.
-// It can contain a Publish, Join, or Leave action.
+// It can contain a Publish, Join, Leave, Read, Edit, Delete, React, or Typing action.
 // The UserId field is used to identify the user sending the message.
 type ClientMessage struct {
 	BaseMessage
@@ -25,6 +25,11 @@ type ClientMessage struct {
 	Join    *Join    `json:"join,omitempty"`
 	Leave   *Leave   `json:"leave,omitempty"`
 	Read    *Read    `json:"read,omitempty"`
+	Edit    *Edit    `json:"edit,omitempty"`
+	Delete  *Delete  `json:"delete,omitempty"`
+	React   *React   `json:"react,omitempty"`
+	Typing  *Typing  `json:"typing,omitempty"`
+	Pin     *Pin     `json:"pin,omitempty"`
 	UserId  int      `json:"-"`
 	client  *Client  `json:"-"`
 }
@@ -39,17 +44,65 @@ type Read struct {
 
 // Publish represents a message that the client wants to publish to a room.
 // It contains the room ID, content of the message, username of the sender, and a sequence ID.
+// ParentSeqId is optional and makes the message a reply in the thread started by that message.
 type Publish struct {
-	RoomId   string `json:"room_id"`
-	Content  string `json:"content"`
-	Username string `json:"username"`
-	SeqId    int    `json:"seq_id"`
+	RoomId      string `json:"room_id"`
+	Content     string `json:"content"`
+	Username    string `json:"username"`
+	SeqId       int    `json:"seq_id"`
+	ParentSeqId int    `json:"parent_seq_id,omitempty"`
+	// Attachments are the ids of files uploaded to the room to attach to the message
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+// Edit represents a request from the client to change the content of a message
+// it previously published. The message is identified by the room ID and its sequence ID.
+type Edit struct {
+	RoomId  string `json:"room_id"`
+	SeqId   int    `json:"seq_id"`
+	Content string `json:"content"`
+}
+
+// Delete represents a request from the client to delete a message.
+// The message is tombstoned rather than removed so sequence IDs remain contiguous.
+type Delete struct {
+	RoomId string `json:"room_id"`
+	SeqId  int    `json:"seq_id"`
+}
+
+// React represents a request from the client to add or remove an emoji reaction to a message.
+// A reaction is added unless Remove is set.
+type React struct {
+	RoomId string `json:"room_id"`
+	SeqId  int    `json:"seq_id"`
+	Emoji  string `json:"emoji"`
+	Remove bool   `json:"remove,omitempty"`
+}
+
+// Pin represents a request from a moderator to pin or unpin a message in a room.
+// The message is pinned unless Unpin is set.
+type Pin struct {
+	RoomId string `json:"room_id"`
+	SeqId  int    `json:"seq_id"`
+	Unpin  bool   `json:"unpin,omitempty"`
+}
+
+// Typing signals that the client is composing a message in a room. Clients should
+// resend it periodically while the user is typing, and may set Stop when the user
+// stops without publishing. Typing events are not persisted and receive no response.
+type Typing struct {
+	RoomId string `json:"room_id"`
+	Stop   bool   `json:"stop,omitempty"`
 }
 
 // Join represents a request from the client to join a room.
 // It contains the room ID that the client wants to join.
+// Since is optionally the seq id of the last message the client has seen, and defaults
+// to the last message the user read. Messages published after it are delivered to the
+// client after the room info and before any new messages.
 type Join struct {
 	RoomId string `json:"room_id"`
+	Since  int    `json:"since,omitempty"`
 }
 
 // Leave represents a request from the client to leave a room.
@@ -71,6 +124,10 @@ type ServerMessage struct {
 	SkipClient *Client `json:"-"`
 	// UserId (optional) field used to identify a user for whom the message is intended.
 	UserId int `json:"-"`
+	// local (optional) keeps a message for a user from being published to the other nodes of a cluster.
+	local bool
+	// skipRoom (optional) is the external ID of a room whose clients the message for a user isn't sent to.
+	skipRoom string
 }
 
 // Response represents the response sent from the server to the client.
@@ -85,7 +142,19 @@ type Notification struct {
 	Presence           *Presence            `json:"presence,omitempty"`
 	Message            *MessageNotification `json:"message,omitempty"`
 	SubscriptionChange *SubscriptionChange  `json:"subscription_change,omitempty"`
+	RoleChange         *RoleChange          `json:"role_change,omitempty"`
+	Kicked             *Kicked              `json:"kicked,omitempty"`
+	RoomUpdated        *RoomUpdated         `json:"room_updated,omitempty"`
 	RoomDeleted        *RoomDeleted         `json:"room_deleted,omitempty"`
+	MessageEdited      *MessageEdited       `json:"message_edited,omitempty"`
+	MessageDeleted     *MessageDeleted      `json:"message_deleted,omitempty"`
+	ThreadUpdate       *ThreadUpdate        `json:"thread_update,omitempty"`
+	Reaction           *ReactionChange      `json:"reaction,omitempty"`
+	Typing             *TypingNotification  `json:"typing,omitempty"`
+	Unread             *UnreadUpdate        `json:"unread,omitempty"`
+	Mention            *MentionNotification `json:"mention,omitempty"`
+	MessageUpdated     *MessageUpdated      `json:"message_updated,omitempty"`
+	PinChange          *PinChange           `json:"pin_change,omitempty"`
 }
 
 // Presence represents the presence status of a user in a room.
@@ -106,10 +175,107 @@ type SubscriptionChange struct {
 	User       types.User `json:"user"`
 }
 
+// RoleChange notifies clients that the role of a subscriber changed.
+// The new role is set on the user.
+type RoleChange struct {
+	RoomId string     `json:"room_id"`
+	User   types.User `json:"user"`
+}
+
+// Kicked notifies a user that a moderator removed them from a room.
+// Banned is set if they are also barred from joining the room again.
+type Kicked struct {
+	RoomId string `json:"room_id"`
+	Banned bool   `json:"banned,omitempty"`
+}
+
+// RoomUpdated notifies clients that the name or description of a room changed.
+type RoomUpdated struct {
+	RoomId      string    `json:"room_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
 type RoomDeleted struct {
 	RoomId string `json:"room_id"`
 }
 
+// MessageEdited notifies clients that the content of a message has changed
+// so they can update it in place.
+type MessageEdited struct {
+	RoomId      string    `json:"room_id"`
+	SeqId       int       `json:"seq_id"`
+	Content     string    `json:"content"`
+	ContentHTML string    `json:"content_html"`
+	EditedAt    time.Time `json:"edited_at"`
+}
+
+// MessageUpdated notifies clients that the previews of the links in a message are available.
+type MessageUpdated struct {
+	RoomId   string              `json:"room_id"`
+	SeqId    int                 `json:"seq_id"`
+	Previews []types.LinkPreview `json:"previews"`
+}
+
+// MessageDeleted notifies clients that a message has been deleted.
+type MessageDeleted struct {
+	RoomId string `json:"room_id"`
+	SeqId  int    `json:"seq_id"`
+}
+
+// ThreadUpdate notifies clients that a reply was added to a thread.
+type ThreadUpdate struct {
+	RoomId         string `json:"room_id"`
+	ParentSeqId    int    `json:"parent_seq_id"`
+	ReplyCount     int    `json:"reply_count"`
+	LastReplySeqId int    `json:"last_reply_seq_id"`
+}
+
+// ReactionChange notifies clients that a user added or removed a reaction to a message.
+type ReactionChange struct {
+	RoomId  string `json:"room_id"`
+	SeqId   int    `json:"seq_id"`
+	UserId  int    `json:"user_id"`
+	Emoji   string `json:"emoji"`
+	Removed bool   `json:"removed,omitempty"`
+}
+
+// PinChange notifies clients that a message was pinned or unpinned. Pin is only set
+// when the message was pinned.
+type PinChange struct {
+	RoomId   string     `json:"room_id"`
+	SeqId    int        `json:"seq_id"`
+	UserId   int        `json:"user_id"`
+	Unpinned bool       `json:"unpinned,omitempty"`
+	Pin      *types.Pin `json:"pin,omitempty"`
+}
+
+// TypingNotification notifies clients that a user started or stopped typing in a room.
+type TypingNotification struct {
+	RoomId string `json:"room_id"`
+	UserId int    `json:"user_id"`
+	Typing bool   `json:"typing"`
+}
+
+// UnreadUpdate notifies a user that their unread counts in a room changed, either
+// because a message was published or because they read messages on one of their devices.
+type UnreadUpdate struct {
+	RoomId        string `json:"room_id"`
+	LastReadSeqId int    `json:"last_read_seq_id"`
+	UnreadCount   int    `json:"unread_count"`
+	MentionCount  int    `json:"mention_count"`
+}
+
+// MentionNotification notifies a user that they were mentioned in a message.
+// User is the author of the message.
+type MentionNotification struct {
+	RoomId  string     `json:"room_id"`
+	SeqId   int        `json:"seq_id"`
+	User    types.User `json:"user"`
+	Content string     `json:"content"`
+}
+
 // GetUserId returns the UserId of the ClientMessage.
 // If UserId is set to a positive value, it returns that value.
 // If UserId is not set (i.e., it is 0), it attempts to extract the user ID from the associated Client.
@@ -176,6 +342,32 @@ func ErrSubscriptionNotFound(id int) *ServerMessage {
 	}
 }
 
+func ErrMessageNotFound(id int) *ServerMessage {
+	return &ServerMessage{
+		BaseMessage: BaseMessage{
+			Id:        id,
+			Timestamp: Now(),
+		},
+		Response: &Response{
+			ResponseCode: http.StatusNotFound,
+			Error:        "message not found",
+		},
+	}
+}
+
+func ErrForbidden(id int) *ServerMessage {
+	return &ServerMessage{
+		BaseMessage: BaseMessage{
+			Id:        id,
+			Timestamp: Now(),
+		},
+		Response: &Response{
+			ResponseCode: http.StatusForbidden,
+			Error:        "forbidden",
+		},
+	}
+}
+
 func ErrInternalError(id int) *ServerMessage {
 	return &ServerMessage{
 		BaseMessage: BaseMessage{
@@ -219,6 +411,23 @@ func ErrInvalidMessage(id int) *ServerMessage {
 	return msg
 }
 
+func ErrMessageTooLarge(id int) *ServerMessage {
+	msg := &ServerMessage{
+		BaseMessage: BaseMessage{
+			Timestamp: Now(),
+		},
+		Response: &Response{
+			ResponseCode: http.StatusRequestEntityTooLarge,
+			Error:        "message too large",
+		},
+	}
+
+	if id > 0 {
+		msg.Id = id
+	}
+	return msg
+}
+
 func Now() time.Time {
 	return time.Now().UTC().Round(time.Millisecond)
 }
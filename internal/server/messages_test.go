@@ -131,6 +131,50 @@ func TestErrSubscriptionNotFound(t *testing.T) {
 	assert.Equal(t, expected.Response.Error, result.Response.Error, "expected Error message to match")
 }
 
+func TestErrMessageNotFound(t *testing.T) {
+	expected := &ServerMessage{
+		BaseMessage: BaseMessage{
+			Id:        1,
+			Timestamp: Now(),
+		},
+		Response: &Response{
+			ResponseCode: http.StatusNotFound,
+			Error:        "message not found",
+		},
+	}
+
+	result := ErrMessageNotFound(1)
+
+	assert.NotNil(t, result, "expected result to be non-nil")
+	assert.NotNil(t, result.Response, "expected response to be non-nil")
+	assert.Equal(t, expected.Id, result.Id, "expected Id to match")
+	assert.WithinDuration(t, expected.Timestamp, result.Timestamp, time.Duration(time.Second), "expected Timestamp to be within 1 second")
+	assert.Equal(t, expected.Response.ResponseCode, result.Response.ResponseCode, "expected ResponseCode to match")
+	assert.Equal(t, expected.Response.Error, result.Response.Error, "expected Error message to match")
+}
+
+func TestErrForbidden(t *testing.T) {
+	expected := &ServerMessage{
+		BaseMessage: BaseMessage{
+			Id:        1,
+			Timestamp: Now(),
+		},
+		Response: &Response{
+			ResponseCode: http.StatusForbidden,
+			Error:        "forbidden",
+		},
+	}
+
+	result := ErrForbidden(1)
+
+	assert.NotNil(t, result, "expected result to be non-nil")
+	assert.NotNil(t, result.Response, "expected response to be non-nil")
+	assert.Equal(t, expected.Id, result.Id, "expected Id to match")
+	assert.WithinDuration(t, expected.Timestamp, result.Timestamp, time.Duration(time.Second), "expected Timestamp to be within 1 second")
+	assert.Equal(t, expected.Response.ResponseCode, result.Response.ResponseCode, "expected ResponseCode to match")
+	assert.Equal(t, expected.Response.Error, result.Response.Error, "expected Error message to match")
+}
+
 func TestErrInternalError(t *testing.T) {
 	expected := &ServerMessage{
 		BaseMessage: BaseMessage{
@@ -213,3 +257,17 @@ func TestErrorInvalidMessage(t *testing.T) {
 	assert.Equal(t, expectedWithId.Response.ResponseCode, resultWithId.Response.ResponseCode, "expected ResponseCode to match")
 	assert.Equal(t, expectedWithId.Response.Error, resultWithId.Response.Error, "expected Error message to match")
 }
+
+func TestErrorMessageTooLarge(t *testing.T) {
+	result := ErrMessageTooLarge(-1)
+	assert.NotNil(t, result, "expected result to be non-nil")
+	assert.NotNil(t, result.Response, "expected response to be non-nil")
+	assert.Equal(t, 0, result.Id, "expected Id to be zero")
+	assert.WithinDuration(t, Now(), result.Timestamp, time.Duration(time.Second), "expected Timestamp to be within 1 second")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, result.Response.ResponseCode, "expected ResponseCode to match")
+	assert.Equal(t, "message too large", result.Response.Error, "expected Error message to match")
+
+	resultWithId := ErrMessageTooLarge(42)
+	assert.Equal(t, 42, resultWithId.Id, "expected Id to match")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resultWithId.Response.ResponseCode, "expected ResponseCode to match")
+}
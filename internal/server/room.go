@@ -1,46 +1,143 @@
 package server
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"log"
+	"regexp"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"slices"
 
 	"github.com/npezzotti/go-chatroom/internal/database"
+	"github.com/npezzotti/go-chatroom/internal/markdown"
 	"github.com/npezzotti/go-chatroom/internal/types"
 )
 
 const idleRoomTimeout = time.Second * 5
 
+// maxCatchUpMessages is the maximum number of missed messages delivered to a client
+// when it joins a room. It is kept well below the size of the client's send buffer,
+// older messages can be fetched with the REST API.
+const maxCatchUpMessages = 50
+
+// maxEmojiLength is the maximum length in bytes of a reaction. It accommodates
+// multi-codepoint emoji such as flags and skin tone or ZWJ sequences.
+const maxEmojiLength = 32
+
+// maxAttachments is the maximum number of files that can be attached to a message.
+const maxAttachments = 10
+
+// mentionPattern matches @username tokens that aren't part of a word, so email addresses
+// aren't treated as mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])@([\p{L}\p{N}_.\-]+)`)
+
+const (
+	// typingThrottle is the minimum interval between typing notifications for a user
+	typingThrottle = time.Second * 3
+	// typingTimeout is how long a user is considered to be typing without a follow-up typing event
+	typingTimeout = time.Second * 6
+)
+
+// typingState tracks a user that is typing in the room.
+type typingState struct {
+	notifiedAt time.Time
+	expiresAt  time.Time
+}
+
+// removeReq is a request to forcibly remove a subscriber from the room.
+type removeReq struct {
+	user   types.User
+	banned bool
+}
+
 type exitReq struct {
 	deleted bool
 	done    chan string
 }
 
 type Room struct {
-	id            int
-	externalId    string
-	subscribers   []types.User
-	cs            *ChatServer
+	id         int
+	externalId string
+	kind       string
+	// ownerId is the id of the user that created the room, who has every role in it
+	ownerId     int
+	subscribers []types.User
+	cs          *ChatServer
+	// db is called from the room's goroutine, each call is bounded by the repository's
+	// query timeout so a slow database can't block the room indefinitely
 	db            database.GoChatRepository
 	joinChan      chan *ClientMessage
 	leaveChan     chan *ClientMessage
 	clientMsgChan chan *ClientMessage
-	seq_id        int
-	clients       map[*Client]struct{}
-	userMap       map[int]map[*Client]struct{}
-	log           *log.Logger
+	// notifyChan receives notifications originating outside of the room (i.e. the REST API)
+	notifyChan chan *Notification
+	// removeChan receives requests to remove a kicked or banned subscriber from the room
+	removeChan chan removeReq
+	seq_id     int
+	// threadReplies caches the number of replies per thread, keyed by the parent seq id
+	threadReplies map[int]int
+	// typing tracks users that are currently typing, keyed by user id
+	typing map[int]*typingState
+	// typingTimer fires when the earliest typing state expires
+	typingTimer *time.Timer
+	// unread tracks the read state of subscribers, keyed by user id. It is loaded
+	// when the first message is published after the room is loaded.
+	unread  map[int]*database.Unread
+	clients map[*Client]struct{}
+	userMap map[int]map[*Client]struct{}
+	log     *log.Logger
 	// killTimer is used to automatically unload the room when it is no longer active
 	killTimer *time.Timer
 	// exit is used to signal the room to exit
 	exit chan exitReq
+	// remoteChan receives the events for the room published by other nodes of the cluster
+	remoteChan chan *clusterEvent
+	// owner is the id of the node hosting the room in a cluster. It is empty if this node
+	// hosts the room, otherwise messages from clients are forwarded to the owner.
+	owner string
+	// forwarded tracks the messages forwarded to the owner that weren't responded to yet
+	forwarded map[forwardKey]pendingForward
+	// forwardTimer fires when the earliest forwarded message times out
+	forwardTimer *time.Timer
+	// localOnly is set while handling events that every node handles, so the messages
+	// sent to clients aren't also published to the other nodes
+	localOnly bool
+	// writer (optional) saves published messages in batches in the background
+	writer *messageWriter
+	// ctx is passed to the repository calls made by the room. It is canceled once the
+	// room exits, after the queued messages are saved and the lease is released.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func (r *Room) start() {
 	r.log.Printf("starting room %q", r.externalId)
+	r.ctx, r.cancel = context.WithCancel(r.cs.ctx)
 	r.killTimer = time.NewTimer(idleRoomTimeout)
 	r.killTimer.Stop()
+	r.typingTimer = time.NewTimer(typingTimeout)
+	r.typingTimer.Stop()
+	r.forwardTimer = time.NewTimer(forwardTimeout)
+	r.forwardTimer.Stop()
+
+	// in a cluster, the room's lease is renewed while it is loaded
+	var leaseRenewal <-chan time.Time
+	if r.cs.backplane != nil {
+		ticker := time.NewTicker(leaseRenewInterval)
+		defer ticker.Stop()
+		leaseRenewal = ticker.C
+	}
+
+	// with write-behind, published messages are handled once the writer saved them
+	var saved <-chan []*pendingMessage
+	if r.writer != nil {
+		go r.writer.run(r.ctx)
+		saved = r.writer.saved
+	}
 
 	for {
 		select {
@@ -49,20 +146,294 @@ func (r *Room) start() {
 		case leaveMsg := <-r.leaveChan:
 			r.handleLeave(leaveMsg)
 		case msg := <-r.clientMsgChan:
-			if msg.Publish != nil {
-				r.saveAndBroadcast(msg)
-			} else if msg.Read != nil {
-				r.handleRead(msg)
+			if r.owner != "" {
+				r.forward(msg)
+			} else {
+				r.handleClientMessage(msg)
+			}
+		case n := <-r.notifyChan:
+			r.handleNotification(n)
+		case req := <-r.removeChan:
+			r.handleLocally(func() { r.handleRemove(req) })
+		case e := <-r.remoteChan:
+			r.handleRemoteEvent(e)
+		case batch := <-saved:
+			for _, p := range batch {
+				r.handleSaved(p)
 			}
+		case <-leaseRenewal:
+			r.renewLease()
+		case <-r.typingTimer.C:
+			r.expireTyping()
+		case <-r.forwardTimer.C:
+			r.expireForwarded()
 		case <-r.killTimer.C:
 			r.handleRoomTimeout()
 		case e := <-r.exit:
+			r.flushWriter()
+			r.releaseLease()
 			r.handleRoomExit(e)
 			return
 		}
 	}
 }
 
+// handleClientMessage handles a message from a client in a room hosted by this node.
+func (r *Room) handleClientMessage(msg *ClientMessage) {
+	switch {
+	case msg.Publish != nil:
+		r.saveAndBroadcast(msg)
+	case msg.Read != nil:
+		r.handleRead(msg)
+	case msg.Edit != nil:
+		r.handleEdit(msg)
+	case msg.Delete != nil:
+		r.handleDelete(msg)
+	case msg.React != nil:
+		r.handleReact(msg)
+	case msg.Typing != nil:
+		r.handleTyping(msg)
+	case msg.Pin != nil:
+		r.handlePin(msg)
+	}
+}
+
+// handleLocally runs fn without publishing the messages it sends to the other nodes
+// of the cluster. Messages from other nodes and removed subscribers are handled by every
+// node that has the room loaded, so each node only notifies its own clients.
+func (r *Room) handleLocally(fn func()) {
+	r.localOnly = true
+	defer func() { r.localOnly = false }()
+
+	fn()
+}
+
+// handleRemoteEvent handles an event for the room published by another node of the cluster.
+func (r *Room) handleRemoteEvent(e *clusterEvent) {
+	switch {
+	case e.ClientId != "":
+		r.handleForwardedReply(e.ClientId, e.Message)
+	case e.Forward != nil:
+		r.handleForwarded(e.Node, e.Forward)
+	case e.Released:
+		// the host unloaded the room, so this node hosts it while its clients are in it
+		r.renewLease()
+	case e.Message != nil:
+		if e.SkipClient != "" {
+			e.Message.SkipClient = r.getClientById(e.SkipClient)
+		}
+		r.handleRemoteMessage(e.Message)
+	}
+}
+
+// handleRemoteMessage delivers a message sent to the room by another node of the cluster
+// to the clients in the room. A new message advances the room's sequence ID, so the next
+// message published on this node gets the following one.
+func (r *Room) handleRemoteMessage(msg *ServerMessage) {
+	if msg.Notification != nil && msg.Notification.SubscriptionChange != nil {
+		// keep the subscribers in sync with users subscribing through other nodes
+		change := msg.Notification.SubscriptionChange
+		r.removeSubscriber(change.User.Id)
+		if change.Subscribed {
+			r.subscribers = append(r.subscribers, change.User)
+		}
+	}
+
+	if msg.Message != nil && msg.Message.SeqId > r.seq_id {
+		r.seq_id = msg.Message.SeqId
+		// unread counts and reply counts changed on the other node,
+		// so they are loaded again when they are next needed
+		r.unread = nil
+		delete(r.threadReplies, msg.Message.ParentSeqId)
+	}
+
+	r.handleLocally(func() { r.broadcast(msg) })
+}
+
+// forward sends a message from a client to the node hosting the room. The room waits for the
+// response until forwardTimeout, so the message isn't lost if the host dies. Typing events
+// aren't responded to.
+func (r *Room) forward(msg *ClientMessage) {
+	r.cs.publish(&clusterEvent{
+		To:     r.owner,
+		RoomId: r.externalId,
+		Forward: &forwardedMessage{
+			ClientId: msg.client.id,
+			User:     msg.client.user,
+			Message:  msg,
+		},
+	})
+
+	if msg.Typing != nil {
+		return
+	}
+
+	if r.forwarded == nil {
+		r.forwarded = make(map[forwardKey]pendingForward)
+	}
+	r.forwarded[forwardKey{clientId: msg.client.id, msgId: msg.Id}] = pendingForward{
+		client:   msg.client,
+		deadline: time.Now().Add(forwardTimeout),
+	}
+	r.scheduleForwardTimeout()
+}
+
+// handleForwardedReply delivers a response from the node hosting the room to the client
+// that forwarded the message, which is no longer waiting for it.
+func (r *Room) handleForwardedReply(clientId string, msg *ServerMessage) {
+	if msg.Response != nil {
+		delete(r.forwarded, forwardKey{clientId: clientId, msgId: msg.Id})
+	}
+
+	r.cs.deliverToClient(clientId, msg)
+}
+
+// expireForwarded lets the clients know the forwarded messages the host didn't respond to
+// in time can't be handled, so they can be sent again.
+func (r *Room) expireForwarded() {
+	now := time.Now()
+	for key, p := range r.forwarded {
+		if !p.deadline.After(now) {
+			p.client.queueMessage(ErrServiceUnavailable(key.msgId))
+			delete(r.forwarded, key)
+		}
+	}
+
+	r.scheduleForwardTimeout()
+}
+
+// rejectForwarded lets the clients know none of the forwarded messages waiting for
+// a response can be handled, e.g. because the lease moved to another node.
+func (r *Room) rejectForwarded() {
+	for key, p := range r.forwarded {
+		p.client.queueMessage(ErrServiceUnavailable(key.msgId))
+		delete(r.forwarded, key)
+	}
+
+	r.scheduleForwardTimeout()
+}
+
+// scheduleForwardTimeout resets the forward timer to fire when the earliest forwarded message times out.
+func (r *Room) scheduleForwardTimeout() {
+	var next time.Time
+	for _, p := range r.forwarded {
+		if next.IsZero() || p.deadline.Before(next) {
+			next = p.deadline
+		}
+	}
+
+	if r.forwardTimer == nil {
+		r.forwardTimer = time.NewTimer(forwardTimeout)
+	}
+
+	if next.IsZero() {
+		r.forwardTimer.Stop()
+		return
+	}
+
+	r.forwardTimer.Reset(time.Until(next))
+}
+
+// handleForwarded handles a message from a client connected to another node, which forwarded
+// it to this node because it hosts the room. Responses are sent back to the client through the
+// node it is connected to.
+func (r *Room) handleForwarded(node string, f *forwardedMessage) {
+	c := &Client{
+		id:   f.ClientId,
+		node: node,
+		user: f.User,
+		send: make(chan *ServerMessage, forwardedReplyBufferSize),
+		log:  r.log,
+	}
+	msg := f.Message
+	msg.UserId = f.User.Id
+	msg.client = c
+
+	if r.owner != "" {
+		// the lease moved to another node before the message arrived
+		c.queueMessage(ErrServiceUnavailable(msg.Id))
+	} else {
+		r.handleClientMessage(msg)
+	}
+
+	r.replyForwarded(c)
+}
+
+// replyForwarded publishes the responses queued to the client of a forwarded message
+// to the node the client is connected to. It does nothing for clients of this node.
+func (r *Room) replyForwarded(c *Client) {
+	if c.node == "" {
+		return
+	}
+
+	for len(c.send) > 0 {
+		r.cs.publish(&clusterEvent{To: c.node, RoomId: r.externalId, ClientId: c.id, UserId: c.user.Id, Message: <-c.send})
+	}
+}
+
+// flushWriter waits for the published messages that are still being saved when the
+// room exits, so they are acknowledged and sent to the clients that are left.
+func (r *Room) flushWriter() {
+	if r.writer == nil {
+		return
+	}
+
+	r.writer.stop()
+	for batch := range r.writer.saved {
+		for _, p := range batch {
+			r.handleSaved(p)
+		}
+	}
+}
+
+// renewLease renews the room's lease if this node hosts the room, or takes over
+// the room if the lease of its host expired, e.g. because the host died.
+func (r *Room) renewLease() {
+	holder, err := r.db.AcquireRoomLease(r.ctx, r.id, r.cs.nodeId, leaseTTL)
+	if err != nil {
+		r.log.Println("AcquireRoomLease:", err)
+		return
+	}
+
+	prevOwner := r.owner
+	wasHost := r.owner == ""
+	r.owner = ""
+	if holder != r.cs.nodeId {
+		r.owner = holder
+	}
+
+	if !wasHost && r.owner != prevOwner {
+		// the previous host died or unloaded the room, it won't respond to the forwarded messages
+		r.rejectForwarded()
+	}
+
+	switch {
+	case wasHost && r.owner != "":
+		r.log.Printf("room %q was taken over by node %s", r.externalId, r.owner)
+	case !wasHost && r.owner == "":
+		r.log.Printf("taking over room %q", r.externalId)
+		// catch up with any messages saved by the previous host that weren't received
+		if err := r.syncSeqId(); err != nil {
+			r.log.Println("GetRoomByExternalId:", err)
+		}
+	}
+}
+
+// releaseLease gives up the room's lease when it is unloaded, so another node
+// with clients in the room can take over without waiting for the lease to expire.
+func (r *Room) releaseLease() {
+	if r.cs.backplane == nil || r.owner != "" {
+		return
+	}
+
+	if err := r.db.ReleaseRoomLease(r.ctx, r.id, r.cs.nodeId); err != nil {
+		r.log.Println("ReleaseRoomLease:", err)
+		return
+	}
+
+	r.cs.publish(&clusterEvent{RoomId: r.externalId, Released: true})
+}
+
 func (r *Room) handleRoomTimeout() {
 	select {
 	case r.cs.unloadRoomChan <- unloadRoomRequest{
@@ -80,6 +451,9 @@ func (r *Room) handleRoomTimeout() {
 
 func (r *Room) handleRoomExit(e exitReq) {
 	r.log.Printf("room %q is exiting", r.externalId)
+	// each node of a cluster unloads the room separately and only notifies its own clients
+	r.localOnly = true
+
 	if e.deleted {
 		// notify all clients that the room is deleted
 		r.broadcast(&ServerMessage{
@@ -99,8 +473,7 @@ func (r *Room) handleRoomExit(e exitReq) {
 
 	// notify active subscribers that the room is offline
 	for _, sub := range r.subscribers {
-		select {
-		case r.cs.broadcastChan <- &ServerMessage{
+		if !r.sendToUser(&ServerMessage{
 			BaseMessage: BaseMessage{
 				Timestamp: Now(),
 			},
@@ -111,12 +484,13 @@ func (r *Room) handleRoomExit(e exitReq) {
 				},
 			},
 			UserId: sub.Id,
-		}:
-		default:
+		}) {
 			r.log.Printf("broadcast channel full, skipping room presence notification for user %d", sub.Id)
 		}
 	}
 
+	r.cancel()
+
 	// notify the chat server the room is done cleaning up
 	if e.done != nil {
 		e.done <- r.externalId
@@ -126,7 +500,7 @@ func (r *Room) handleRoomExit(e exitReq) {
 func (r *Room) handleLeave(leaveMsg *ClientMessage) {
 	if leaveMsg.Leave.Unsubscribe {
 		// the user is leaving and unsubscribing from the room
-		err := r.db.DeleteSubscription(leaveMsg.UserId, r.id)
+		err := r.db.DeleteSubscription(r.ctx, leaveMsg.UserId, r.id)
 		if err != nil {
 			var errResp *ServerMessage
 			if err == sql.ErrNoRows {
@@ -144,8 +518,10 @@ func (r *Room) handleLeave(leaveMsg *ClientMessage) {
 
 		// evict all clients for this user from the room
 		r.removeAllSessionsForUser(leaveMsg.UserId)
+		r.stopTyping(leaveMsg.UserId, nil)
 		// remove the user from the in memory subscriber list so they don't get subscriber notifications
 		r.removeSubscriber(leaveMsg.UserId)
+		delete(r.unread, leaveMsg.UserId)
 
 		if leaveMsg.GetUserId() != 0 {
 			// if the leave message is from a user, notify the user the unsubscribe was successful
@@ -191,6 +567,7 @@ func (r *Room) handleLeave(leaveMsg *ClientMessage) {
 		// notify all clients user is offline
 		// if no more sessions for user in the room
 		if r.userMap[client.user.Id] == nil {
+			r.stopTyping(client.user.Id, client)
 			r.broadcast(&ServerMessage{
 				BaseMessage: BaseMessage{
 					Timestamp: Now(),
@@ -208,15 +585,479 @@ func (r *Room) handleLeave(leaveMsg *ClientMessage) {
 	}
 }
 
+// handleRemove evicts a user that was kicked or banned by a moderator from the room.
+// Their subscription has already been deleted, so only the in-memory state is updated.
+func (r *Room) handleRemove(req removeReq) {
+	r.removeAllSessionsForUser(req.user.Id)
+	r.stopTyping(req.user.Id, nil)
+	r.removeSubscriber(req.user.Id)
+	delete(r.unread, req.user.Id)
+
+	// broadcast that the user is no longer subscribed
+	r.broadcast(&ServerMessage{
+		BaseMessage: BaseMessage{
+			Timestamp: Now(),
+		},
+		Notification: &Notification{
+			SubscriptionChange: &SubscriptionChange{
+				RoomId:     r.externalId,
+				Subscribed: false,
+				User:       req.user,
+			},
+		},
+	})
+}
+
 func (r *Room) handleRead(msg *ClientMessage) {
 	// update the last read seq id for the user
-	if err := r.db.UpdateLastReadSeqId(msg.UserId, r.id, msg.Read.SeqId); err != nil {
+	if err := r.db.UpdateLastReadSeqId(r.ctx, msg.UserId, r.id, msg.Read.SeqId); err != nil {
 		r.log.Println("UpdateLastReadSeqId:", err)
 		msg.client.queueMessage(ErrInternalError(msg.Id))
 		return
 	}
 
 	msg.client.queueMessage(NoErrOK(msg.Id, nil))
+
+	// if the user read every message nothing is left unread,
+	// otherwise count the mentions in the messages they haven't read
+	unread := &database.Unread{AccountId: msg.UserId, LastReadSeqId: msg.Read.SeqId}
+	if msg.Read.SeqId < r.seq_id {
+		u, err := r.db.GetUnread(r.ctx, msg.UserId, r.id)
+		if err != nil {
+			r.log.Println("GetUnread:", err)
+			return
+		}
+		unread = &u
+	}
+
+	if r.unread != nil {
+		r.unread[msg.UserId] = unread
+	}
+	// update the counts on all of the user's devices
+	r.sendUnread(unread)
+}
+
+// updateUnread updates the unread counts of the subscribers after a message
+// mentioning the given users is published and sends each of them their new counts.
+func (r *Room) updateUnread(mentioned []int) {
+	// counts read from the database already include the new message
+	loaded := r.unread != nil
+	if !loaded {
+		unread, err := r.db.ListUnread(r.ctx, r.id)
+		if err != nil {
+			r.log.Println("ListUnread:", err)
+			return
+		}
+
+		r.unread = make(map[int]*database.Unread, len(unread))
+		for _, u := range unread {
+			r.unread[u.AccountId] = &u
+		}
+	}
+
+	for _, sub := range r.subscribers {
+		unread, ok := r.unread[sub.Id]
+		if !ok {
+			// the user subscribed after the counts were loaded
+			u, err := r.db.GetUnread(r.ctx, sub.Id, r.id)
+			if err != nil {
+				r.log.Println("GetUnread:", err)
+				continue
+			}
+			unread = &u
+			r.unread[sub.Id] = unread
+		} else if loaded && slices.Contains(mentioned, sub.Id) {
+			unread.Mentions++
+		}
+
+		r.sendUnread(unread)
+	}
+}
+
+// sendUnread sends a user their unread counts in the room through the chat server.
+func (r *Room) sendUnread(unread *database.Unread) {
+	if !r.sendToUser(&ServerMessage{
+		BaseMessage: BaseMessage{
+			Timestamp: Now(),
+		},
+		Notification: &Notification{
+			Unread: &UnreadUpdate{
+				RoomId:        r.externalId,
+				LastReadSeqId: unread.LastReadSeqId,
+				UnreadCount:   max(r.seq_id-unread.LastReadSeqId, 0),
+				MentionCount:  unread.Mentions,
+			},
+		},
+		UserId: unread.AccountId,
+	}) {
+		r.log.Printf("broadcast channel full, skipping unread notification for user %d", unread.AccountId)
+	}
+}
+
+// handleEdit replaces the content of a previously published message.
+// Only the author of the message may edit it. The previous content is kept
+// as a revision and all clients in the room are notified of the change.
+func (r *Room) handleEdit(msg *ClientMessage) {
+	if strings.TrimSpace(msg.Edit.Content) == "" {
+		msg.client.queueMessage(ErrInvalidMessage(msg.Id))
+		return
+	}
+	if r.contentTooLong(msg.Edit.Content) {
+		msg.client.queueMessage(ErrMessageTooLarge(msg.Id))
+		return
+	}
+
+	dbMsg, err := r.db.GetMessage(r.ctx, r.id, msg.Edit.SeqId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			msg.client.queueMessage(ErrMessageNotFound(msg.Id))
+		} else {
+			r.log.Println("GetMessage:", err)
+			msg.client.queueMessage(ErrInternalError(msg.Id))
+		}
+		return
+	}
+
+	if dbMsg.Deleted {
+		msg.client.queueMessage(ErrMessageNotFound(msg.Id))
+		return
+	}
+
+	if dbMsg.UserId != msg.UserId {
+		msg.client.queueMessage(ErrForbidden(msg.Id))
+		return
+	}
+
+	edited, err := r.db.EditMessage(r.ctx, database.EditMessageParams{
+		RoomId:      r.id,
+		SeqId:       msg.Edit.SeqId,
+		Content:     msg.Edit.Content,
+		ContentHTML: markdown.Render(msg.Edit.Content),
+		EditedAt:    msg.Timestamp,
+	})
+	if err != nil {
+		r.log.Println("EditMessage:", err)
+		msg.client.queueMessage(ErrInternalError(msg.Id))
+		return
+	}
+
+	msg.client.queueMessage(NoErrOK(msg.Id, nil))
+
+	// notify all clients in the room so they can update the message in place
+	r.broadcast(&ServerMessage{
+		BaseMessage: BaseMessage{
+			Timestamp: Now(),
+		},
+		Notification: &Notification{
+			MessageEdited: &MessageEdited{
+				RoomId:      r.externalId,
+				SeqId:       edited.SeqId,
+				Content:     edited.Content,
+				ContentHTML: edited.ContentHTML,
+				EditedAt:    edited.UpdatedAt,
+			},
+		},
+	})
+}
+
+// handleDelete tombstones a message. The author of the message
+// and the owner and admins of the room may delete it.
+func (r *Room) handleDelete(msg *ClientMessage) {
+	dbMsg, err := r.db.GetMessage(r.ctx, r.id, msg.Delete.SeqId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			msg.client.queueMessage(ErrMessageNotFound(msg.Id))
+		} else {
+			r.log.Println("GetMessage:", err)
+			msg.client.queueMessage(ErrInternalError(msg.Id))
+		}
+		return
+	}
+
+	if dbMsg.Deleted {
+		msg.client.queueMessage(ErrMessageNotFound(msg.Id))
+		return
+	}
+
+	if dbMsg.UserId != msg.UserId && msg.UserId != r.ownerId {
+		role, err := r.db.GetSubscriptionRole(r.ctx, msg.UserId, r.id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			r.log.Println("GetSubscriptionRole:", err)
+			msg.client.queueMessage(ErrInternalError(msg.Id))
+			return
+		}
+
+		if !database.RoleAtLeast(role, database.RoleAdmin) {
+			msg.client.queueMessage(ErrForbidden(msg.Id))
+			return
+		}
+	}
+
+	if err := r.db.DeleteMessage(r.ctx, r.id, msg.Delete.SeqId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			msg.client.queueMessage(ErrMessageNotFound(msg.Id))
+		} else {
+			r.log.Println("DeleteMessage:", err)
+			msg.client.queueMessage(ErrInternalError(msg.Id))
+		}
+		return
+	}
+
+	msg.client.queueMessage(NoErrOK(msg.Id, nil))
+
+	r.handleNotification(&Notification{
+		MessageDeleted: &MessageDeleted{
+			RoomId: r.externalId,
+			SeqId:  msg.Delete.SeqId,
+		},
+	})
+}
+
+// handleReact adds or removes a user's emoji reaction to a message and
+// notifies all clients in the room. Repeating a reaction, or removing one
+// that does not exist, succeeds without notifying the room.
+func (r *Room) handleReact(msg *ClientMessage) {
+	emoji := msg.React.Emoji
+	if emoji == "" || len(emoji) > maxEmojiLength || strings.TrimSpace(emoji) != emoji {
+		msg.client.queueMessage(ErrInvalidMessage(msg.Id))
+		return
+	}
+
+	dbMsg, err := r.db.GetMessage(r.ctx, r.id, msg.React.SeqId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			msg.client.queueMessage(ErrMessageNotFound(msg.Id))
+		} else {
+			r.log.Println("GetMessage:", err)
+			msg.client.queueMessage(ErrInternalError(msg.Id))
+		}
+		return
+	}
+
+	if dbMsg.Deleted {
+		msg.client.queueMessage(ErrMessageNotFound(msg.Id))
+		return
+	}
+
+	params := database.ReactionParams{
+		RoomId:    r.id,
+		SeqId:     msg.React.SeqId,
+		AccountId: msg.UserId,
+		Emoji:     emoji,
+	}
+	if msg.React.Remove {
+		err = r.db.RemoveReaction(r.ctx, params)
+	} else {
+		err = r.db.AddReaction(r.ctx, params)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// nothing changed
+			msg.client.queueMessage(NoErrOK(msg.Id, nil))
+		} else {
+			r.log.Println("AddReaction/RemoveReaction:", err)
+			msg.client.queueMessage(ErrInternalError(msg.Id))
+		}
+		return
+	}
+
+	msg.client.queueMessage(NoErrOK(msg.Id, nil))
+
+	r.broadcast(&ServerMessage{
+		BaseMessage: BaseMessage{
+			Timestamp: Now(),
+		},
+		Notification: &Notification{
+			Reaction: &ReactionChange{
+				RoomId:  r.externalId,
+				SeqId:   msg.React.SeqId,
+				UserId:  msg.UserId,
+				Emoji:   emoji,
+				Removed: msg.React.Remove,
+			},
+		},
+	})
+}
+
+// handlePin pins or unpins a message and notifies the room. Only the owner and admins
+// of the room may pin messages. Pinning a message that is already pinned, or unpinning
+// one that isn't, succeeds without notifying the room.
+func (r *Room) handlePin(msg *ClientMessage) {
+	if msg.UserId != r.ownerId {
+		role, err := r.db.GetSubscriptionRole(r.ctx, msg.UserId, r.id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			r.log.Println("GetSubscriptionRole:", err)
+			msg.client.queueMessage(ErrInternalError(msg.Id))
+			return
+		}
+
+		if !database.RoleAtLeast(role, database.RoleAdmin) {
+			msg.client.queueMessage(ErrForbidden(msg.Id))
+			return
+		}
+	}
+
+	dbMsg, err := r.db.GetMessage(r.ctx, r.id, msg.Pin.SeqId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			msg.client.queueMessage(ErrMessageNotFound(msg.Id))
+		} else {
+			r.log.Println("GetMessage:", err)
+			msg.client.queueMessage(ErrInternalError(msg.Id))
+		}
+		return
+	}
+
+	if dbMsg.Deleted {
+		msg.client.queueMessage(ErrMessageNotFound(msg.Id))
+		return
+	}
+
+	change := &PinChange{
+		RoomId:   r.externalId,
+		SeqId:    msg.Pin.SeqId,
+		UserId:   msg.UserId,
+		Unpinned: msg.Pin.Unpin,
+	}
+	if msg.Pin.Unpin {
+		err = r.db.UnpinMessage(r.ctx, r.id, msg.Pin.SeqId)
+	} else {
+		var pin database.Pin
+		pin, err = r.db.PinMessage(r.ctx, database.PinParams{
+			RoomId:   r.id,
+			SeqId:    msg.Pin.SeqId,
+			PinnedBy: msg.UserId,
+		})
+		pin.Message = dbMsg
+		p := types.NewPin(pin)
+		change.Pin = &p
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// nothing changed
+			msg.client.queueMessage(NoErrOK(msg.Id, nil))
+		} else {
+			r.log.Println("PinMessage/UnpinMessage:", err)
+			msg.client.queueMessage(ErrInternalError(msg.Id))
+		}
+		return
+	}
+
+	msg.client.queueMessage(NoErrOK(msg.Id, nil))
+
+	r.broadcast(&ServerMessage{
+		BaseMessage: BaseMessage{
+			Timestamp: Now(),
+		},
+		Notification: &Notification{
+			PinChange: change,
+		},
+	})
+}
+
+// handleTyping notifies the other sessions in the room that a user is typing.
+// Notifications are throttled per user; events received within typingThrottle of
+// the last notification only extend the typing state. The state expires after
+// typingTimeout, at which point the room notifies that the user stopped typing.
+func (r *Room) handleTyping(msg *ClientMessage) {
+	userId := msg.UserId
+	if msg.Typing.Stop {
+		r.stopTyping(userId, msg.client)
+		return
+	}
+
+	now := time.Now()
+	if st, ok := r.typing[userId]; ok {
+		st.expiresAt = now.Add(typingTimeout)
+		if now.Sub(st.notifiedAt) < typingThrottle {
+			return
+		}
+		st.notifiedAt = now
+	} else {
+		if r.typing == nil {
+			r.typing = make(map[int]*typingState)
+		}
+		r.typing[userId] = &typingState{
+			notifiedAt: now,
+			expiresAt:  now.Add(typingTimeout),
+		}
+		r.scheduleTypingExpiry()
+	}
+
+	r.broadcastTyping(userId, true, msg.client)
+}
+
+// stopTyping clears the typing state for a user and notifies the room.
+// It is a no-op if the user is not typing.
+func (r *Room) stopTyping(userId int, skip *Client) {
+	if _, ok := r.typing[userId]; !ok {
+		return
+	}
+
+	delete(r.typing, userId)
+	r.broadcastTyping(userId, false, skip)
+}
+
+// expireTyping stops typing for all users without a recent typing event.
+func (r *Room) expireTyping() {
+	now := time.Now()
+	for userId, st := range r.typing {
+		if !st.expiresAt.After(now) {
+			r.stopTyping(userId, nil)
+		}
+	}
+
+	r.scheduleTypingExpiry()
+}
+
+// scheduleTypingExpiry resets the typing timer to fire when the earliest typing state expires.
+func (r *Room) scheduleTypingExpiry() {
+	var next time.Time
+	for _, st := range r.typing {
+		if next.IsZero() || st.expiresAt.Before(next) {
+			next = st.expiresAt
+		}
+	}
+
+	if r.typingTimer == nil {
+		r.typingTimer = time.NewTimer(typingTimeout)
+	}
+
+	if next.IsZero() {
+		r.typingTimer.Stop()
+		return
+	}
+
+	r.typingTimer.Reset(time.Until(next))
+}
+
+func (r *Room) broadcastTyping(userId int, typing bool, skip *Client) {
+	r.broadcast(&ServerMessage{
+		BaseMessage: BaseMessage{
+			Timestamp: Now(),
+		},
+		Notification: &Notification{
+			Typing: &TypingNotification{
+				RoomId: r.externalId,
+				UserId: userId,
+				Typing: typing,
+			},
+		},
+		SkipClient: skip,
+	})
+}
+
+// handleNotification broadcasts a notification to all clients in the room
+// and to subscribers that are not currently in the room.
+func (r *Room) handleNotification(n *Notification) {
+	r.broadcast(&ServerMessage{
+		BaseMessage: BaseMessage{
+			Timestamp: Now(),
+		},
+		Notification: n,
+	})
+
+	r.notifyInactiveSubscribers(n)
 }
 
 func (r *Room) handleJoin(join *ClientMessage) {
@@ -225,9 +1066,48 @@ func (r *Room) handleJoin(join *ClientMessage) {
 
 	var subCreated bool
 	c := join.client
-	if !r.db.SubscriptionExists(c.user.Id, r.id) {
+	if !r.db.SubscriptionExists(r.ctx, c.user.Id, r.id) {
+		if r.kind == database.RoomKindDirect {
+			// direct message rooms can only be joined by their two members,
+			// who are subscribed when the room is created
+			if len(r.clients) == 0 {
+				r.killTimer.Reset(idleRoomTimeout)
+			}
+			c.queueMessage(ErrRoomNotFound(join.Id))
+			return
+		}
+
+		// banned users can't subscribe to the room again
+		banned, err := r.db.IsBanned(r.ctx, c.user.Id, r.id)
+		if err != nil || banned {
+			if len(r.clients) == 0 {
+				r.killTimer.Reset(idleRoomTimeout)
+			}
+			if err != nil {
+				r.log.Println("IsBanned:", err)
+				c.queueMessage(ErrInternalError(join.Id))
+			} else {
+				c.queueMessage(ErrForbidden(join.Id))
+			}
+			return
+		}
+
 		// if the user is not subscribed, create a subscription
-		sub, err := r.db.CreateSubscription(c.user.Id, r.id)
+		var sub database.Subscription
+		switch r.kind {
+		case database.RoomKindPrivate:
+			// private rooms require an invitation, which is consumed by subscribing
+			sub, err = r.db.AcceptInvite(r.ctx, c.user.Id, r.id)
+			if errors.Is(err, sql.ErrNoRows) {
+				if len(r.clients) == 0 {
+					r.killTimer.Reset(idleRoomTimeout)
+				}
+				c.queueMessage(ErrForbidden(join.Id))
+				return
+			}
+		default:
+			sub, err = r.db.CreateSubscription(r.ctx, c.user.Id, r.id)
+		}
 		if err != nil {
 			// reset timer since client join failed
 			if len(r.clients) == 0 {
@@ -242,7 +1122,8 @@ func (r *Room) handleJoin(join *ClientMessage) {
 
 		// add the user to the in-memory subscriber list
 		r.subscribers = append(r.subscribers, types.User{
-			Id: sub.AccountId,
+			Id:       sub.AccountId,
+			Username: c.user.Username,
 		})
 
 		// notify users that the user has subscribed
@@ -263,7 +1144,7 @@ func (r *Room) handleJoin(join *ClientMessage) {
 		})
 	}
 
-	dbRoom, err := r.db.GetRoomWithSubscribers(r.id)
+	dbRoom, err := r.db.GetRoomWithSubscribers(r.ctx, r.id)
 	if err != nil {
 		r.log.Println("GetRoomWithSubscribers:", err)
 		c.queueMessage(ErrInternalError(join.Id))
@@ -274,13 +1155,19 @@ func (r *Room) handleJoin(join *ClientMessage) {
 		return
 	}
 
+	// pins are sent with the room info, but the room can still be joined without them
+	dbPins, err := r.db.ListPins(r.ctx, r.id)
+	if err != nil {
+		r.log.Println("ListPins:", err)
+	}
+
 	r.addClient(c)
 
 	if len(r.clients) == 1 {
-		// if this is the first client in the room, notify all subscribers that the room is now active
+		// if this is the first client in the room, notify all subscribers that the room is now active.
+		// Rooms are loaded by each node of a cluster, so only the node's own clients are notified.
 		for _, sub := range r.subscribers {
-			select {
-			case r.cs.broadcastChan <- &ServerMessage{
+			if !r.sendToUser(&ServerMessage{
 				BaseMessage: BaseMessage{
 					Timestamp: Now(),
 				},
@@ -292,8 +1179,8 @@ func (r *Room) handleJoin(join *ClientMessage) {
 				},
 				UserId:     sub.Id,
 				SkipClient: c,
-			}:
-			default:
+				local:      true,
+			}) {
 				// skip if the broadcast channel is full
 				r.log.Printf("Broadcast channel full for user %d, skipping presence notification", sub.Id)
 			}
@@ -307,6 +1194,7 @@ func (r *Room) handleJoin(join *ClientMessage) {
 		Description: dbRoom.Description,
 		SeqId:       dbRoom.SeqId,
 		OwnerId:     dbRoom.OwnerId,
+		Kind:        dbRoom.Kind,
 		Subscribers: func() []types.User {
 			subscribers := make([]types.User, len(dbRoom.Subscriptions))
 			for i, sub := range dbRoom.Subscriptions {
@@ -314,10 +1202,18 @@ func (r *Room) handleJoin(join *ClientMessage) {
 					Id:        sub.AccountId,
 					Username:  sub.Username,
 					IsPresent: r.userMap[sub.AccountId] != nil,
+					Role:      sub.Role,
 				}
 			}
 			return subscribers
 		}(),
+		Pins: func() []types.Pin {
+			pins := make([]types.Pin, len(dbPins))
+			for i, pin := range dbPins {
+				pins[i] = types.NewPin(pin)
+			}
+			return pins
+		}(),
 		CreatedAt: dbRoom.CreatedAt,
 		UpdatedAt: dbRoom.UpdatedAt,
 	}
@@ -325,6 +1221,18 @@ func (r *Room) handleJoin(join *ClientMessage) {
 	// send the room info to the client
 	c.queueMessage(NoErrOK(join.Id, roomInfo))
 
+	// deliver the messages the client missed before any new messages
+	since := join.Join.Since
+	if since <= 0 {
+		for _, sub := range dbRoom.Subscriptions {
+			if sub.AccountId == c.user.Id {
+				since = sub.LastReadSeqId
+				break
+			}
+		}
+	}
+	r.sendMissedMessages(c, since)
+
 	if !subCreated {
 		// notify clients that user is active in the room
 		r.broadcast(&ServerMessage{
@@ -343,6 +1251,44 @@ func (r *Room) handleJoin(join *ClientMessage) {
 	}
 }
 
+// sendMissedMessages queues the messages published after since to the client, oldest first.
+// If more than maxCatchUpMessages were missed, only the most recent are sent so they are
+// contiguous with the live messages that follow. The room publishes messages on the same
+// goroutine, so no message can be published while the client is catching up.
+func (r *Room) sendMissedMessages(c *Client, since int) {
+	if since >= r.seq_id {
+		return
+	}
+
+	messages, err := r.db.GetMessages(r.ctx, r.id, since+1, 0, maxCatchUpMessages)
+	if err != nil {
+		r.log.Println("GetMessages:", err)
+		return
+	}
+
+	// messages are returned newest first
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := types.NewMessage(messages[i])
+		c.queueMessage(&ServerMessage{
+			BaseMessage: BaseMessage{
+				Timestamp: messages[i].CreatedAt,
+			},
+			Message: &msg,
+		})
+	}
+}
+
+// getClientById returns the client in the room with the given id, or nil if there is none.
+func (r *Room) getClientById(id string) *Client {
+	for c := range r.clients {
+		if c.id == id {
+			return c
+		}
+	}
+
+	return nil
+}
+
 func (r *Room) getClient(c *Client) (*Client, bool) {
 	if _, ok := r.clients[c]; !ok {
 		return nil, false
@@ -410,23 +1356,74 @@ func (r *Room) removeSubscriber(userId int) {
 }
 
 func (r *Room) saveAndBroadcast(msg *ClientMessage) {
-	// save the message to the database
-	if err := r.db.CreateMessage(database.Message{
-		SeqId:     r.seq_id + 1,
-		RoomId:    r.id,
-		UserId:    msg.client.user.Id,
-		Content:   msg.Publish.Content,
-		CreatedAt: msg.Timestamp,
-	}); err != nil {
-		r.log.Println("error saving message:", err)
+	if r.contentTooLong(msg.Publish.Content) {
+		msg.client.queueMessage(ErrMessageTooLarge(msg.Id))
+		return
+	}
+
+	parentSeqId := msg.Publish.ParentSeqId
+	if parentSeqId > 0 && !r.validateThreadParent(msg) {
+		return
+	}
+
+	attachments, ok := r.resolveAttachments(msg)
+	if !ok {
+		return
+	}
+
+	// the rendered content is stored so clients never render untrusted markup themselves
+	contentHTML := markdown.Render(msg.Publish.Content)
+
+	p := &pendingMessage{
+		msg: msg,
+		message: database.Message{
+			RoomId:      r.id,
+			UserId:      msg.client.user.Id,
+			Content:     msg.Publish.Content,
+			ContentHTML: contentHTML,
+			ParentSeqId: parentSeqId,
+			Attachments: attachments,
+			CreatedAt:   msg.Timestamp,
+		},
+	}
+
+	if r.writer != nil {
+		if !r.writer.enqueue(p) {
+			r.log.Printf("write queue full for room %q, rejecting message", r.externalId)
+			msg.client.queueMessage(ErrServiceUnavailable(msg.Id))
+		}
+		return
+	}
+
+	// save the message to the database, which allocates its seq id
+	p.stored, p.err = r.db.CreateMessage(r.ctx, p.message)
+	r.handleSaved(p)
+}
+
+// handleSaved acknowledges a published message once it is saved and sends it to the room.
+func (r *Room) handleSaved(p *pendingMessage) {
+	msg, stored := p.msg, p.stored
+	defer r.replyForwarded(msg.client)
+
+	if p.err != nil {
+		r.log.Println("error saving message:", p.err)
 		msg.client.queueMessage(ErrInternalError(msg.Id))
 		return
 	}
 
-	// increment the sequence ID for the room now that the message is saved
-	r.seq_id++
+	parentSeqId := p.message.ParentSeqId
+	if stored.SeqId > r.seq_id+1 {
+		// other nodes of the cluster saved messages in the room that weren't received yet,
+		// so the unread counts are loaded again
+		r.unread = nil
+	}
+	r.seq_id = stored.SeqId
 	msg.client.queueMessage(NoErrAccepted(msg.Id))
 
+	// the message implies the user stopped typing, so clients clear the
+	// typing state on receipt and no typing notification is needed
+	delete(r.typing, msg.UserId)
+
 	// broadcast the message to all clients in the room
 	r.broadcast(&ServerMessage{
 		BaseMessage: BaseMessage{
@@ -434,38 +1431,289 @@ func (r *Room) saveAndBroadcast(msg *ClientMessage) {
 			Timestamp: msg.Timestamp,
 		},
 		Message: &types.Message{
-			SeqId:     r.seq_id,
-			RoomId:    r.id,
-			UserId:    msg.UserId,
-			Content:   msg.Publish.Content,
-			Timestamp: msg.Timestamp,
+			SeqId:       r.seq_id,
+			RoomId:      r.id,
+			UserId:      msg.UserId,
+			Content:     msg.Publish.Content,
+			ContentHTML: p.message.ContentHTML,
+			ParentSeqId: parentSeqId,
+			Attachments: types.NewAttachments(p.message.Attachments),
+			Timestamp:   msg.Timestamp,
 		},
 	})
 
+	if parentSeqId > 0 {
+		r.updateThread(parentSeqId)
+	}
+
 	// notify inactive subscribers of new message
+	r.notifyInactiveSubscribers(&Notification{
+		Message: &MessageNotification{
+			RoomId: r.externalId,
+			SeqId:  r.seq_id,
+		},
+	})
+
+	mentioned := r.resolveMentions(msg.UserId, msg.Publish.Content)
+	if len(mentioned) > 0 {
+		r.notifyMentioned(mentioned, msg)
+	}
+
+	r.updateUnread(mentioned)
+
+	if r.cs.unfurler != nil {
+		r.unfurlLinks(r.seq_id, msg.Publish.Content)
+	}
+}
+
+// syncSeqId catches up with messages saved in the room by other nodes of the cluster.
+func (r *Room) syncSeqId() error {
+	dbRoom, err := r.db.GetRoomByExternalId(r.ctx, r.externalId)
+	if err != nil {
+		return err
+	}
+
+	r.seq_id = max(dbRoom.SeqId, r.seq_id)
+	// unread counts changed with the messages saved on the other nodes
+	r.unread = nil
+
+	return nil
+}
+
+// unfurlLinks previews the links in a message in the background, links the previews
+// to the message so they are loaded with it, and notifies clients in the room when
+// the previews are available.
+func (r *Room) unfurlLinks(seqId int, content string) {
+	cs, id, roomId, logger := r.cs, r.id, r.externalId, r.log
+	cs.unfurler.Unfurl(content, func(previews []types.LinkPreview) {
+		urls := make([]string, 0, len(previews))
+		for _, p := range previews {
+			urls = append(urls, p.URL)
+		}
+		// the previews are still sent to the clients in the room if they couldn't be
+		// saved, they are only missing when the message is loaded again
+		if err := cs.db.SaveMessageLinkPreviews(cs.ctx, id, seqId, urls); err != nil {
+			logger.Println("SaveMessageLinkPreviews:", err)
+		}
+
+		// this runs outside the room, so the notification goes through the chat server
+		// like those from the REST API. If the room was unloaded there is no one to notify.
+		if err := cs.NotifyRoom(cs.ctx, roomId, &Notification{
+			MessageUpdated: &MessageUpdated{
+				RoomId:   roomId,
+				SeqId:    seqId,
+				Previews: previews,
+			},
+		}); err != nil {
+			logger.Println("notify room of link previews:", err)
+		}
+	})
+}
+
+// resolveMentions returns the ids of the subscribers mentioned in the content
+// of a message. Authors don't mention themselves.
+func (r *Room) resolveMentions(authorId int, content string) []int {
+	usernames := parseMentions(content)
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	var mentioned []int
 	for _, sub := range r.subscribers {
-		if r.userMap[sub.Id] != nil {
-			// skip broadcasting to users that are already in the room
-			continue
+		if sub.Id != authorId && slices.Contains(usernames, strings.ToLower(sub.Username)) {
+			mentioned = append(mentioned, sub.Id)
 		}
+	}
 
-		select {
-		case r.cs.broadcastChan <- &ServerMessage{
+	return mentioned
+}
+
+// notifyMentioned saves the mentions in a message and notifies the mentioned
+// users on all of their clients, whether or not they are in the room.
+func (r *Room) notifyMentioned(mentioned []int, msg *ClientMessage) {
+	if err := r.db.CreateMentions(r.ctx, r.id, r.seq_id, mentioned); err != nil {
+		r.log.Println("CreateMentions:", err)
+	}
+
+	for _, userId := range mentioned {
+		if !r.sendToUser(&ServerMessage{
+			BaseMessage: BaseMessage{
+				Timestamp: msg.Timestamp,
+			},
 			Notification: &Notification{
-				Message: &MessageNotification{
+				Mention: &MentionNotification{
 					RoomId: r.externalId,
 					SeqId:  r.seq_id,
+					User: types.User{
+						Id:       msg.client.user.Id,
+						Username: msg.client.user.Username,
+					},
+					Content: msg.Publish.Content,
 				},
 			},
-			UserId: sub.Id,
-		}:
-		default:
+			UserId: userId,
+		}) {
+			r.log.Printf("broadcast channel full, skipping mention notification for user %d", userId)
+		}
+	}
+}
+
+// parseMentions returns the lower cased usernames mentioned with @username in
+// the content of a message. Trailing punctuation is not part of a username.
+func parseMentions(content string) []string {
+	var usernames []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		username := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		if username != "" && !slices.Contains(usernames, username) {
+			usernames = append(usernames, username)
+		}
+	}
+
+	return usernames
+}
+
+// contentTooLong reports whether content has more characters than the server allows in a message.
+func (r *Room) contentTooLong(content string) bool {
+	return utf8.RuneCountInString(content) > r.cs.maxContentLength
+}
+
+// resolveAttachments looks up the attachments of a message being published. Only files
+// the author uploaded to the room that aren't attached to another message can be attached.
+// If the attachments are not valid, an error is sent to the client and false is returned.
+func (r *Room) resolveAttachments(msg *ClientMessage) ([]database.Attachment, bool) {
+	ids := msg.Publish.Attachments
+	if len(ids) == 0 {
+		return nil, true
+	}
+
+	if len(ids) > maxAttachments {
+		msg.client.queueMessage(ErrInvalidMessage(msg.Id))
+		return nil, false
+	}
+
+	attachments, err := r.db.GetAttachments(r.ctx, r.id, ids)
+	if err != nil {
+		r.log.Println("GetAttachments:", err)
+		msg.client.queueMessage(ErrInternalError(msg.Id))
+		return nil, false
+	}
+
+	// every id must match a distinct attachment
+	if len(attachments) != len(ids) {
+		msg.client.queueMessage(ErrInvalidMessage(msg.Id))
+		return nil, false
+	}
+
+	for _, a := range attachments {
+		if a.AccountId != msg.UserId || a.SeqId != 0 {
+			msg.client.queueMessage(ErrInvalidMessage(msg.Id))
+			return nil, false
+		}
+	}
+
+	return attachments, true
+}
+
+// validateThreadParent checks that the parent of a reply exists and can be
+// replied to. Threads are a single level deep, so replies can't be replied to.
+// If the parent is not valid, an error is sent to the client and false is returned.
+func (r *Room) validateThreadParent(msg *ClientMessage) bool {
+	parent, err := r.db.GetMessage(r.ctx, r.id, msg.Publish.ParentSeqId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			msg.client.queueMessage(ErrMessageNotFound(msg.Id))
+		} else {
+			r.log.Println("GetMessage:", err)
+			msg.client.queueMessage(ErrInternalError(msg.Id))
+		}
+		return false
+	}
+
+	if parent.Deleted {
+		msg.client.queueMessage(ErrMessageNotFound(msg.Id))
+		return false
+	}
+
+	if parent.ParentSeqId > 0 {
+		msg.client.queueMessage(ErrInvalidMessage(msg.Id))
+		return false
+	}
+
+	return true
+}
+
+// updateThread increments the reply count for a thread and notifies
+// clients in the room. The count is loaded from the database the first
+// time the thread is replied to after the room is loaded.
+func (r *Room) updateThread(parentSeqId int) {
+	if r.threadReplies == nil {
+		r.threadReplies = make(map[int]int)
+	}
+
+	if count, ok := r.threadReplies[parentSeqId]; ok {
+		r.threadReplies[parentSeqId] = count + 1
+	} else {
+		// the count from the database includes the reply that was just saved
+		count, err := r.db.CountReplies(r.ctx, r.id, parentSeqId)
+		if err != nil {
+			r.log.Println("CountReplies:", err)
+			return
+		}
+		r.threadReplies[parentSeqId] = count
+	}
+
+	r.broadcast(&ServerMessage{
+		BaseMessage: BaseMessage{
+			Timestamp: Now(),
+		},
+		Notification: &Notification{
+			ThreadUpdate: &ThreadUpdate{
+				RoomId:         r.externalId,
+				ParentSeqId:    parentSeqId,
+				ReplyCount:     r.threadReplies[parentSeqId],
+				LastReplySeqId: r.seq_id,
+			},
+		},
+	})
+}
+
+// notifyInactiveSubscribers sends a notification through the chat server
+// to all subscribers of the room that do not have a client in the room.
+// Their clients in the room on other nodes are skipped.
+func (r *Room) notifyInactiveSubscribers(n *Notification) {
+	for _, sub := range r.subscribers {
+		if r.userMap[sub.Id] != nil {
+			// skip broadcasting to users that are already in the room
+			continue
+		}
+
+		if !r.sendToUser(&ServerMessage{
+			Notification: n,
+			UserId:       sub.Id,
+			skipRoom:     r.externalId,
+		}) {
 			// skip if the broadcast channel is full
-			r.log.Printf("broadcast channel full, skipping message notification for user %d", sub.Id)
+			r.log.Printf("broadcast channel full, skipping notification for user %d", sub.Id)
 		}
 	}
 }
 
+// sendToUser sends a message to all of a user's clients through the chat server.
+// It returns false if the message was dropped because the broadcast channel is full.
+func (r *Room) sendToUser(msg *ServerMessage) bool {
+	msg.local = msg.local || r.localOnly
+
+	select {
+	case r.cs.broadcastChan <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// broadcast sends a message to the clients in the room. Unless the room
+// is handling an event locally, the other nodes of the cluster send it
+// to their clients in the room too.
 func (r *Room) broadcast(msg *ServerMessage) {
 	msg.Timestamp = time.Now()
 
@@ -476,4 +1724,12 @@ func (r *Room) broadcast(msg *ServerMessage) {
 
 		client.queueMessage(msg)
 	}
+
+	if !r.localOnly {
+		e := &clusterEvent{RoomId: r.externalId, Message: msg}
+		if msg.SkipClient != nil {
+			e.SkipClient = msg.SkipClient.id
+		}
+		r.cs.publish(e)
+	}
 }
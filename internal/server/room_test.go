@@ -1,19 +1,50 @@
 package server
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/npezzotti/go-chatroom/internal/config"
 	"github.com/npezzotti/go-chatroom/internal/database"
 	"github.com/npezzotti/go-chatroom/internal/stats"
 	"github.com/npezzotti/go-chatroom/internal/testutil"
 	"github.com/npezzotti/go-chatroom/internal/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
+// newTestRoomWithClients creates a room that isn't started, with a client in it for each user.
+func newTestRoomWithClients(t *testing.T, db *database.MockGoChatRepository, users ...types.User) (*Room, []*Client) {
+	room := &Room{
+		id:         1,
+		externalId: "testroom",
+		clients:    make(map[*Client]struct{}),
+		userMap:    make(map[int]map[*Client]struct{}),
+		db:         db,
+		cs:         newTestChatServer(t, db, &stats.MockStatsUpdater{}),
+		log:        testutil.TestLogger(t),
+	}
+
+	clients := make([]*Client, 0, len(users))
+	for _, user := range users {
+		c := &Client{
+			user:  user,
+			send:  make(chan *ServerMessage, 256),
+			rooms: make(map[string]*Room),
+			log:   room.log,
+		}
+		room.addClient(c)
+		clients = append(clients, c)
+	}
+
+	return room, clients
+}
+
 func Test_addClient_getClient_deleteClient(t *testing.T) {
 	room := &Room{
 		externalId: "test-room",
@@ -81,6 +112,7 @@ func Test_handleRoomExit(t *testing.T) {
 			userMap:    make(map[int]map[*Client]struct{}),
 			log:        testutil.TestLogger(t),
 		}
+		room.ctx, room.cancel = context.WithCancel(context.Background())
 
 		done := make(chan string)
 		go room.handleRoomExit(exitReq{deleted: false, done: done})
@@ -94,6 +126,7 @@ func Test_handleRoomExit(t *testing.T) {
 		case <-time.After(100 * time.Millisecond):
 			t.Error("timeout: handleRoomExit did not complete")
 		}
+		assert.ErrorIs(t, room.ctx.Err(), context.Canceled, "expected the room's context to be canceled")
 	})
 
 	t.Run("exit room with clients", func(t *testing.T) {
@@ -103,6 +136,7 @@ func Test_handleRoomExit(t *testing.T) {
 			userMap:    make(map[int]map[*Client]struct{}),
 			log:        testutil.TestLogger(t),
 		}
+		room.ctx, room.cancel = context.WithCancel(context.Background())
 
 		c := &Client{user: types.User{Id: 1, Username: "user1"}, send: make(chan *ServerMessage, 256), rooms: make(map[string]*Room), exitRoom: make(chan string)}
 		room.addClient(c)
@@ -138,6 +172,7 @@ func Test_handleRoomExit(t *testing.T) {
 			cs:  cs,
 			log: testutil.TestLogger(t),
 		}
+		room.ctx, room.cancel = context.WithCancel(context.Background())
 
 		done := make(chan string)
 		go room.handleRoomExit(exitReq{deleted: false, done: done})
@@ -171,6 +206,7 @@ func Test_handleRoomExit(t *testing.T) {
 			userMap:    make(map[int]map[*Client]struct{}),
 			log:        testutil.TestLogger(t),
 		}
+		room.ctx, room.cancel = context.WithCancel(context.Background())
 
 		c := &Client{send: make(chan *ServerMessage, 256), rooms: make(map[string]*Room), exitRoom: make(chan string)}
 		room.addClient(c)
@@ -255,7 +291,7 @@ func Test_handleLeave(t *testing.T) {
 
 		assert.NotContains(t, room.clients, c, "expected client to be removed from room clients")
 		assert.NotContains(t, c.rooms, room.externalId, "expected room to be removed from client's rooms")
-		db.AssertNotCalled(t, "DeleteSubscription", c.user.Id, room.externalId, "expected no subscription deletion for leave without unsubscribe")
+		db.AssertNotCalled(t, "DeleteSubscription", mock.Anything, c.user.Id, room.externalId, "expected no subscription deletion for leave without unsubscribe")
 	})
 
 	t.Run("leave without unsubscribe send presence notification", func(t *testing.T) {
@@ -387,7 +423,7 @@ func Test_handleLeave(t *testing.T) {
 		room.addClient(c)
 		c.addRoom(room)
 
-		db.On("DeleteSubscription", c.user.Id, room.id).Return(nil).Once()
+		db.On("DeleteSubscription", mock.Anything, c.user.Id, room.id).Return(nil).Once()
 
 		done := make(chan struct{})
 		go func() {
@@ -455,7 +491,7 @@ func Test_handleLeave(t *testing.T) {
 		room.addClient(c)
 		c.addRoom(room)
 
-		db.On("DeleteSubscription", c.user.Id, room.id).Return(sql.ErrNoRows).Once()
+		db.On("DeleteSubscription", mock.Anything, c.user.Id, room.id).Return(sql.ErrNoRows).Once()
 
 		room.handleLeave(&ClientMessage{
 			BaseMessage: BaseMessage{
@@ -507,7 +543,7 @@ func Test_handleLeave(t *testing.T) {
 		room.addClient(c)
 		c.addRoom(room)
 
-		db.On("DeleteSubscription", c.user.Id, room.id).Return(errors.New("db error")).Once()
+		db.On("DeleteSubscription", mock.Anything, c.user.Id, room.id).Return(errors.New("db error")).Once()
 
 		room.handleLeave(&ClientMessage{
 			BaseMessage: BaseMessage{
@@ -564,7 +600,7 @@ func Test_handleRead(t *testing.T) {
 			db: db,
 		}
 
-		db.On("UpdateLastReadSeqId", msg.UserId, room.id, msg.Read.SeqId).Return(nil).Once()
+		db.On("UpdateLastReadSeqId", mock.Anything, msg.UserId, room.id, msg.Read.SeqId).Return(nil).Once()
 		room.handleRead(msg)
 
 		select {
@@ -575,6 +611,60 @@ func Test_handleRead(t *testing.T) {
 		case <-time.After(100 * time.Millisecond):
 			t.Error("timeout: client did not receive response message")
 		}
+
+		select {
+		case n := <-room.cs.broadcastChan:
+			assert.Equal(t, msg.UserId, n.UserId, "expected notification for the reader")
+			assert.Equal(t, &UnreadUpdate{LastReadSeqId: 42}, n.Notification.Unread, "expected nothing to be unread")
+		default:
+			t.Error("expected unread notification")
+		}
+	})
+
+	t.Run("read with unread messages left", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		client := &Client{
+			send: make(chan *ServerMessage, 256),
+		}
+
+		msg := &ClientMessage{
+			BaseMessage: BaseMessage{
+				Id:        1,
+				Timestamp: Now(),
+			},
+			Read: &Read{
+				RoomId: "testroom",
+				SeqId:  5,
+			},
+			UserId: 1,
+			client: client,
+		}
+
+		room := &Room{
+			id:         1,
+			externalId: "testroom",
+			seq_id:     8,
+			cs:         newTestChatServer(t, db, &stats.MockStatsUpdater{}),
+			db:         db,
+			unread:     map[int]*database.Unread{1: {AccountId: 1, LastReadSeqId: 2, Mentions: 3}},
+		}
+
+		db.On("UpdateLastReadSeqId", mock.Anything, msg.UserId, room.id, msg.Read.SeqId).Return(nil).Once()
+		db.On("GetUnread", mock.Anything, msg.UserId, room.id).Return(database.Unread{AccountId: 1, LastReadSeqId: 5, Mentions: 1}, nil).Once()
+		room.handleRead(msg)
+
+		response := <-client.send
+		assert.Equal(t, http.StatusOK, response.Response.ResponseCode, "expected response code 200")
+
+		select {
+		case n := <-room.cs.broadcastChan:
+			assert.Equal(t, &UnreadUpdate{RoomId: "testroom", LastReadSeqId: 5, UnreadCount: 3, MentionCount: 1}, n.Notification.Unread, "expected unread counts to match")
+		default:
+			t.Error("expected unread notification")
+		}
+		assert.Equal(t, 1, room.unread[1].Mentions, "expected cached mentions to be updated")
 	})
 
 	t.Run("failure with db error", func(t *testing.T) {
@@ -605,7 +695,7 @@ func Test_handleRead(t *testing.T) {
 			log: testutil.TestLogger(t),
 		}
 
-		db.On("UpdateLastReadSeqId", msg.UserId, room.id, msg.Read.SeqId).Return(errors.New("db error")).Once()
+		db.On("UpdateLastReadSeqId", mock.Anything, msg.UserId, room.id, msg.Read.SeqId).Return(errors.New("db error")).Once()
 		room.handleRead(msg)
 
 		select {
@@ -617,9 +707,958 @@ func Test_handleRead(t *testing.T) {
 			t.Error("timeout: client did not receive response message")
 		}
 	})
-}
+}
+
+func Test_handleEdit(t *testing.T) {
+	users := []types.User{{Id: 1, Username: "author"}, {Id: 2, Username: "other"}}
+
+	newEditMsg := func(c *Client, seqId int, content string) *ClientMessage {
+		return &ClientMessage{
+			BaseMessage: BaseMessage{
+				Id:        1,
+				Timestamp: Now(),
+			},
+			Edit: &Edit{
+				RoomId:  "testroom",
+				SeqId:   seqId,
+				Content: content,
+			},
+			UserId: c.user.Id,
+			client: c,
+		}
+	}
+
+	t.Run("successful edit by author", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		author, other := clients[0], clients[1]
+		msg := newEditMsg(author, 5, "fixed typo")
+
+		db.On("GetMessage", mock.Anything, room.id, 5).Return(database.Message{Id: 10, SeqId: 5, RoomId: room.id, UserId: author.user.Id, Content: "fixd typo"}, nil).Once()
+		db.On("EditMessage", mock.Anything, database.EditMessageParams{
+			RoomId:      room.id,
+			SeqId:       5,
+			Content:     "fixed typo",
+			ContentHTML: "<p>fixed typo</p>",
+			EditedAt:    msg.Timestamp,
+		}).Return(database.Message{Id: 10, SeqId: 5, RoomId: room.id, UserId: author.user.Id, Content: "fixed typo", ContentHTML: "<p>fixed typo</p>", UpdatedAt: msg.Timestamp}, nil).Once()
+
+		room.handleEdit(msg)
+
+		select {
+		case resp := <-author.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusOK, resp.Response.ResponseCode, "expected response code 200")
+		default:
+			t.Error("expected author to receive response message")
+		}
+
+		for _, c := range []*Client{author, other} {
+			select {
+			case n := <-c.send:
+				assert.NotNil(t, n.Notification, "expected notification message")
+				assert.NotNil(t, n.Notification.MessageEdited, "expected message edited notification")
+				assert.Equal(t, room.externalId, n.Notification.MessageEdited.RoomId, "expected room id to match")
+				assert.Equal(t, 5, n.Notification.MessageEdited.SeqId, "expected seq id to match")
+				assert.Equal(t, "fixed typo", n.Notification.MessageEdited.Content, "expected content to match")
+				assert.Equal(t, "<p>fixed typo</p>", n.Notification.MessageEdited.ContentHTML, "expected content html to match")
+				assert.Equal(t, msg.Timestamp, n.Notification.MessageEdited.EditedAt, "expected edited at to match")
+			default:
+				t.Errorf("expected client %d to receive message edited notification", c.user.Id)
+			}
+		}
+	})
+
+	t.Run("edit by non author is forbidden", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		author, other := clients[0], clients[1]
+
+		db.On("GetMessage", mock.Anything, room.id, 5).Return(database.Message{Id: 10, SeqId: 5, RoomId: room.id, UserId: author.user.Id}, nil).Once()
+
+		room.handleEdit(newEditMsg(other, 5, "not mine"))
+
+		select {
+		case resp := <-other.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusForbidden, resp.Response.ResponseCode, "expected response code 403")
+		default:
+			t.Error("expected client to receive response message")
+		}
+		assert.Empty(t, author.send, "expected no notification to be broadcast")
+		db.AssertNotCalled(t, "EditMessage")
+	})
+
+	t.Run("edit message not found", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		author := clients[0]
+
+		db.On("GetMessage", mock.Anything, room.id, 99).Return(database.Message{}, sql.ErrNoRows).Once()
+
+		room.handleEdit(newEditMsg(author, 99, "hello"))
+
+		select {
+		case resp := <-author.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusNotFound, resp.Response.ResponseCode, "expected response code 404")
+			assert.Equal(t, "message not found", resp.Response.Error, "expected message not found error")
+		default:
+			t.Error("expected client to receive response message")
+		}
+	})
+
+	t.Run("edit with empty content", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		author := clients[0]
+
+		room.handleEdit(newEditMsg(author, 5, "   "))
+
+		select {
+		case resp := <-author.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusBadRequest, resp.Response.ResponseCode, "expected response code 400")
+		default:
+			t.Error("expected client to receive response message")
+		}
+		db.AssertNotCalled(t, "GetMessage")
+	})
+
+	t.Run("edit with content too long", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		author := clients[0]
+
+		room.handleEdit(newEditMsg(author, 5, strings.Repeat("a", config.DefaultMaxContentLength+1)))
+
+		select {
+		case resp := <-author.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Response.ResponseCode, "expected response code 413")
+		default:
+			t.Error("expected client to receive response message")
+		}
+		db.AssertNotCalled(t, "GetMessage")
+	})
+
+	t.Run("edit with db error", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		author, other := clients[0], clients[1]
+		msg := newEditMsg(author, 5, "fixed typo")
+
+		db.On("GetMessage", mock.Anything, room.id, 5).Return(database.Message{Id: 10, SeqId: 5, RoomId: room.id, UserId: author.user.Id}, nil).Once()
+		db.On("EditMessage", mock.Anything, mock.Anything).Return(database.Message{}, errors.New("db error")).Once()
+
+		room.handleEdit(msg)
+
+		select {
+		case resp := <-author.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusInternalServerError, resp.Response.ResponseCode, "expected response code 500")
+		default:
+			t.Error("expected client to receive response message")
+		}
+		assert.Empty(t, other.send, "expected no notification to be broadcast")
+	})
+}
+
+func Test_handleDelete(t *testing.T) {
+	users := []types.User{{Id: 1, Username: "author"}, {Id: 2, Username: "other"}}
+
+	newDeleteMsg := func(userId int, c *Client, seqId int) *ClientMessage {
+		return &ClientMessage{
+			BaseMessage: BaseMessage{
+				Id:        1,
+				Timestamp: Now(),
+			},
+			Delete: &Delete{
+				RoomId: "testroom",
+				SeqId:  seqId,
+			},
+			UserId: userId,
+			client: c,
+		}
+	}
+
+	t.Run("successful delete by author", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		author, other := clients[0], clients[1]
+		room.subscribers = []types.User{users[0], users[1], {Id: 3, Username: "owner"}}
+
+		db.On("GetMessage", mock.Anything, room.id, 5).Return(database.Message{Id: 10, SeqId: 5, RoomId: room.id, UserId: author.user.Id}, nil).Once()
+		db.On("DeleteMessage", mock.Anything, room.id, 5).Return(nil).Once()
+
+		room.handleDelete(newDeleteMsg(author.user.Id, author, 5))
+
+		select {
+		case resp := <-author.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusOK, resp.Response.ResponseCode, "expected response code 200")
+		default:
+			t.Error("expected author to receive response message")
+		}
+
+		for _, c := range []*Client{author, other} {
+			select {
+			case n := <-c.send:
+				assert.NotNil(t, n.Notification, "expected notification message")
+				assert.NotNil(t, n.Notification.MessageDeleted, "expected message deleted notification")
+				assert.Equal(t, room.externalId, n.Notification.MessageDeleted.RoomId, "expected room id to match")
+				assert.Equal(t, 5, n.Notification.MessageDeleted.SeqId, "expected seq id to match")
+			default:
+				t.Errorf("expected client %d to receive message deleted notification", c.user.Id)
+			}
+		}
+
+		// the owner is subscribed but not in the room, so they are notified through the chat server
+		select {
+		case n := <-room.cs.broadcastChan:
+			assert.Equal(t, 3, n.UserId, "expected notification for inactive subscriber")
+			assert.NotNil(t, n.Notification.MessageDeleted, "expected message deleted notification")
+		default:
+			t.Error("expected inactive subscriber to be notified")
+		}
+	})
+
+	t.Run("successful delete by room owner", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		author := clients[0]
+		room.subscribers = []types.User{users[0], users[1], {Id: 3, Username: "owner"}}
+		owner := &Client{
+			user:  types.User{Id: 3, Username: "owner"},
+			send:  make(chan *ServerMessage, 256),
+			rooms: make(map[string]*Room),
+			log:   room.log,
+		}
+
+		db.On("GetMessage", mock.Anything, room.id, 5).Return(database.Message{Id: 10, SeqId: 5, RoomId: room.id, UserId: author.user.Id}, nil).Once()
+		db.On("GetSubscriptionRole", mock.Anything, owner.user.Id, room.id).Return(database.RoleOwner, nil).Once()
+		db.On("DeleteMessage", mock.Anything, room.id, 5).Return(nil).Once()
+
+		room.handleDelete(newDeleteMsg(owner.user.Id, owner, 5))
+
+		select {
+		case resp := <-owner.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusOK, resp.Response.ResponseCode, "expected response code 200")
+		default:
+			t.Error("expected owner to receive response message")
+		}
+	})
+
+	t.Run("successful delete by room admin", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		author, other := clients[0], clients[1]
+		room.subscribers = []types.User{users[0], users[1], {Id: 3, Username: "owner"}}
+
+		db.On("GetMessage", mock.Anything, room.id, 5).Return(database.Message{Id: 10, SeqId: 5, RoomId: room.id, UserId: author.user.Id}, nil).Once()
+		db.On("GetSubscriptionRole", mock.Anything, other.user.Id, room.id).Return(database.RoleAdmin, nil).Once()
+		db.On("DeleteMessage", mock.Anything, room.id, 5).Return(nil).Once()
+
+		room.handleDelete(newDeleteMsg(other.user.Id, other, 5))
+
+		select {
+		case resp := <-other.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusOK, resp.Response.ResponseCode, "expected response code 200")
+		default:
+			t.Error("expected admin to receive response message")
+		}
+	})
+
+	t.Run("delete by other member is forbidden", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		author, other := clients[0], clients[1]
+		room.subscribers = []types.User{users[0], users[1], {Id: 3, Username: "owner"}}
+
+		db.On("GetMessage", mock.Anything, room.id, 5).Return(database.Message{Id: 10, SeqId: 5, RoomId: room.id, UserId: author.user.Id}, nil).Once()
+		db.On("GetSubscriptionRole", mock.Anything, other.user.Id, room.id).Return(database.RoleMember, nil).Once()
+
+		room.handleDelete(newDeleteMsg(other.user.Id, other, 5))
+
+		select {
+		case resp := <-other.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusForbidden, resp.Response.ResponseCode, "expected response code 403")
+		default:
+			t.Error("expected client to receive response message")
+		}
+		assert.Empty(t, author.send, "expected no notification to be broadcast")
+		db.AssertNotCalled(t, "DeleteMessage")
+	})
+
+	t.Run("delete already deleted message", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		author := clients[0]
+		room.subscribers = []types.User{users[0], users[1], {Id: 3, Username: "owner"}}
+
+		db.On("GetMessage", mock.Anything, room.id, 5).Return(database.Message{Id: 10, SeqId: 5, RoomId: room.id, UserId: author.user.Id, Deleted: true}, nil).Once()
+
+		room.handleDelete(newDeleteMsg(author.user.Id, author, 5))
+
+		select {
+		case resp := <-author.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusNotFound, resp.Response.ResponseCode, "expected response code 404")
+		default:
+			t.Error("expected client to receive response message")
+		}
+		db.AssertNotCalled(t, "DeleteMessage")
+	})
+
+	t.Run("delete with db error", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		author, other := clients[0], clients[1]
+		room.subscribers = []types.User{users[0], users[1], {Id: 3, Username: "owner"}}
+
+		db.On("GetMessage", mock.Anything, room.id, 5).Return(database.Message{Id: 10, SeqId: 5, RoomId: room.id, UserId: author.user.Id}, nil).Once()
+		db.On("DeleteMessage", mock.Anything, room.id, 5).Return(errors.New("db error")).Once()
+
+		room.handleDelete(newDeleteMsg(author.user.Id, author, 5))
+
+		select {
+		case resp := <-author.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusInternalServerError, resp.Response.ResponseCode, "expected response code 500")
+		default:
+			t.Error("expected client to receive response message")
+		}
+		assert.Empty(t, other.send, "expected no notification to be broadcast")
+	})
+}
+
+func Test_handleReact(t *testing.T) {
+	users := []types.User{{Id: 1, Username: "reactor"}, {Id: 2, Username: "other"}}
+
+	newReactMsg := func(c *Client, seqId int, emoji string, remove bool) *ClientMessage {
+		return &ClientMessage{
+			BaseMessage: BaseMessage{
+				Id:        1,
+				Timestamp: Now(),
+			},
+			React: &React{
+				RoomId: "testroom",
+				SeqId:  seqId,
+				Emoji:  emoji,
+				Remove: remove,
+			},
+			UserId: c.user.Id,
+			client: c,
+		}
+	}
+
+	tcases := []struct {
+		name         string
+		emoji        string
+		remove       bool
+		getErr       error
+		deleted      bool
+		reactErr     error
+		expectedCode int
+		expectNotify bool
+	}{
+		{
+			name:         "adds reaction",
+			emoji:        "👍",
+			expectedCode: http.StatusOK,
+			expectNotify: true,
+		},
+		{
+			name:         "removes reaction",
+			emoji:        "👍",
+			remove:       true,
+			expectedCode: http.StatusOK,
+			expectNotify: true,
+		},
+		{
+			name:         "duplicate reaction is a no-op",
+			emoji:        "👍",
+			reactErr:     sql.ErrNoRows,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "empty emoji",
+			emoji:        "",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "emoji too long",
+			emoji:        strings.Repeat("👍", 10),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "message not found",
+			emoji:        "👍",
+			getErr:       sql.ErrNoRows,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "message deleted",
+			emoji:        "👍",
+			deleted:      true,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "db error",
+			emoji:        "👍",
+			reactErr:     errors.New("db error"),
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &database.MockGoChatRepository{}
+			defer db.AssertExpectations(t)
+
+			room, clients := newTestRoomWithClients(t, db, users...)
+			reactor, other := clients[0], clients[1]
+
+			validEmoji := tc.emoji != "" && len(tc.emoji) <= maxEmojiLength
+			if validEmoji {
+				db.On("GetMessage", mock.Anything, room.id, 5).Return(database.Message{Id: 10, SeqId: 5, RoomId: room.id, UserId: 2, Deleted: tc.deleted}, tc.getErr).Once()
+			}
+			if validEmoji && tc.getErr == nil && !tc.deleted {
+				params := database.ReactionParams{RoomId: room.id, SeqId: 5, AccountId: reactor.user.Id, Emoji: tc.emoji}
+				if tc.remove {
+					db.On("RemoveReaction", mock.Anything, params).Return(tc.reactErr).Once()
+				} else {
+					db.On("AddReaction", mock.Anything, params).Return(tc.reactErr).Once()
+				}
+			}
+
+			room.handleReact(newReactMsg(reactor, 5, tc.emoji, tc.remove))
+
+			select {
+			case resp := <-reactor.send:
+				assert.NotNil(t, resp.Response, "expected response message")
+				assert.Equal(t, tc.expectedCode, resp.Response.ResponseCode, "expected response code to match")
+			default:
+				t.Error("expected reactor to receive response message")
+			}
+
+			if !tc.expectNotify {
+				assert.Len(t, other.send, 0, "expected no notification")
+				return
+			}
+
+			for _, c := range []*Client{reactor, other} {
+				select {
+				case n := <-c.send:
+					assert.NotNil(t, n.Notification, "expected notification message")
+					assert.NotNil(t, n.Notification.Reaction, "expected reaction notification")
+					assert.Equal(t, room.externalId, n.Notification.Reaction.RoomId, "expected room id to match")
+					assert.Equal(t, 5, n.Notification.Reaction.SeqId, "expected seq id to match")
+					assert.Equal(t, reactor.user.Id, n.Notification.Reaction.UserId, "expected user id to match")
+					assert.Equal(t, tc.emoji, n.Notification.Reaction.Emoji, "expected emoji to match")
+					assert.Equal(t, tc.remove, n.Notification.Reaction.Removed, "expected removed flag to match")
+				default:
+					t.Errorf("expected client %d to receive reaction notification", c.user.Id)
+				}
+			}
+		})
+	}
+}
+
+func Test_handlePin(t *testing.T) {
+	users := []types.User{{Id: 1, Username: "moderator"}, {Id: 2, Username: "other"}}
+
+	tcases := []struct {
+		name         string
+		owner        bool
+		role         string
+		roleErr      error
+		unpin        bool
+		getErr       error
+		deleted      bool
+		pinErr       error
+		expectedCode int
+		expectNotify bool
+	}{
+		{
+			name:         "admin pins message",
+			role:         database.RoleAdmin,
+			expectedCode: http.StatusOK,
+			expectNotify: true,
+		},
+		{
+			name:         "owner unpins message",
+			role:         database.RoleOwner,
+			unpin:        true,
+			expectedCode: http.StatusOK,
+			expectNotify: true,
+		},
+		{
+			name:         "room owner pins message without a subscription role",
+			owner:        true,
+			expectedCode: http.StatusOK,
+			expectNotify: true,
+		},
+		{
+			name:         "pinning a pinned message is a no-op",
+			role:         database.RoleAdmin,
+			pinErr:       sql.ErrNoRows,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "unpinning a message that isn't pinned is a no-op",
+			role:         database.RoleAdmin,
+			unpin:        true,
+			pinErr:       sql.ErrNoRows,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "member is forbidden",
+			role:         database.RoleMember,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "role lookup error",
+			roleErr:      errors.New("db error"),
+			expectedCode: http.StatusInternalServerError,
+		},
+		{
+			name:         "message not found",
+			role:         database.RoleAdmin,
+			getErr:       sql.ErrNoRows,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "message deleted",
+			role:         database.RoleAdmin,
+			deleted:      true,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "db error",
+			role:         database.RoleAdmin,
+			pinErr:       errors.New("db error"),
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &database.MockGoChatRepository{}
+			defer db.AssertExpectations(t)
+
+			room, clients := newTestRoomWithClients(t, db, users...)
+			moderator, other := clients[0], clients[1]
+			pinnedAt := Now()
+
+			if tc.owner {
+				room.ownerId = moderator.user.Id
+			} else {
+				db.On("GetSubscriptionRole", mock.Anything, moderator.user.Id, room.id).Return(tc.role, tc.roleErr).Once()
+			}
+			allowed := tc.owner || tc.roleErr == nil && database.RoleAtLeast(tc.role, database.RoleAdmin)
+			if allowed {
+				db.On("GetMessage", mock.Anything, room.id, 5).Return(database.Message{Id: 10, SeqId: 5, RoomId: room.id, UserId: 2, Content: "runbook", Deleted: tc.deleted}, tc.getErr).Once()
+			}
+			if allowed && tc.getErr == nil && !tc.deleted {
+				if tc.unpin {
+					db.On("UnpinMessage", mock.Anything, room.id, 5).Return(tc.pinErr).Once()
+				} else {
+					db.On("PinMessage", mock.Anything, database.PinParams{RoomId: room.id, SeqId: 5, PinnedBy: moderator.user.Id}).
+						Return(database.Pin{Id: 1, RoomId: room.id, PinnedBy: moderator.user.Id, CreatedAt: pinnedAt}, tc.pinErr).Once()
+				}
+			}
+
+			room.handlePin(&ClientMessage{
+				BaseMessage: BaseMessage{
+					Id:        1,
+					Timestamp: Now(),
+				},
+				Pin: &Pin{
+					RoomId: "testroom",
+					SeqId:  5,
+					Unpin:  tc.unpin,
+				},
+				UserId: moderator.user.Id,
+				client: moderator,
+			})
+
+			select {
+			case resp := <-moderator.send:
+				assert.NotNil(t, resp.Response, "expected response message")
+				assert.Equal(t, tc.expectedCode, resp.Response.ResponseCode, "expected response code to match")
+			default:
+				t.Error("expected moderator to receive response message")
+			}
+
+			if !tc.expectNotify {
+				assert.Len(t, other.send, 0, "expected no notification")
+				return
+			}
+
+			for _, c := range []*Client{moderator, other} {
+				select {
+				case n := <-c.send:
+					assert.NotNil(t, n.Notification, "expected notification message")
+					assert.NotNil(t, n.Notification.PinChange, "expected pin change notification")
+					assert.Equal(t, room.externalId, n.Notification.PinChange.RoomId, "expected room id to match")
+					assert.Equal(t, 5, n.Notification.PinChange.SeqId, "expected seq id to match")
+					assert.Equal(t, moderator.user.Id, n.Notification.PinChange.UserId, "expected user id to match")
+					assert.Equal(t, tc.unpin, n.Notification.PinChange.Unpinned, "expected unpinned flag to match")
+					if tc.unpin {
+						assert.Nil(t, n.Notification.PinChange.Pin, "expected no pin when unpinned")
+					} else if assert.NotNil(t, n.Notification.PinChange.Pin, "expected pin when pinned") {
+						assert.Equal(t, "runbook", n.Notification.PinChange.Pin.Message.Content, "expected pinned message content to match")
+						assert.Equal(t, moderator.user.Id, n.Notification.PinChange.Pin.PinnedBy, "expected pinned by to match")
+						assert.Equal(t, pinnedAt, n.Notification.PinChange.Pin.PinnedAt, "expected pinned at to match")
+					}
+				default:
+					t.Errorf("expected client %d to receive pin change notification", c.user.Id)
+				}
+			}
+		})
+	}
+}
+
+func Test_handleTyping(t *testing.T) {
+	// the rooms' mocks have no expectations, so any database access fails the test
+	users := []types.User{{Id: 1, Username: "typist"}, {Id: 2, Username: "other"}}
+
+	newTypingMsg := func(c *Client, stop bool) *ClientMessage {
+		return &ClientMessage{
+			BaseMessage: BaseMessage{
+				Timestamp: Now(),
+			},
+			Typing: &Typing{
+				RoomId: "testroom",
+				Stop:   stop,
+			},
+			UserId: c.user.Id,
+			client: c,
+		}
+	}
+
+	assertTyping := func(t *testing.T, c *Client, userId int, typing bool) {
+		t.Helper()
+		select {
+		case n := <-c.send:
+			assert.NotNil(t, n.Notification, "expected notification message")
+			assert.NotNil(t, n.Notification.Typing, "expected typing notification")
+			assert.Equal(t, "testroom", n.Notification.Typing.RoomId, "expected room id to match")
+			assert.Equal(t, userId, n.Notification.Typing.UserId, "expected user id to match")
+			assert.Equal(t, typing, n.Notification.Typing.Typing, "expected typing flag to match")
+		default:
+			t.Errorf("expected client %d to receive typing notification", c.user.Id)
+		}
+	}
+
+	t.Run("notifies other sessions", func(t *testing.T) {
+		room, clients := newTestRoomWithClients(t, &database.MockGoChatRepository{}, users...)
+		typist, other := clients[0], clients[1]
+		room.handleTyping(newTypingMsg(typist, false))
+
+		assertTyping(t, other, typist.user.Id, true)
+		assert.Len(t, typist.send, 0, "expected typist to not receive a notification or response")
+		assert.Equal(t, 0, room.seq_id, "expected seq id to be unchanged")
+		assert.Contains(t, room.typing, typist.user.Id, "expected typing state for user")
+	})
+
+	t.Run("throttles repeated events", func(t *testing.T) {
+		room, clients := newTestRoomWithClients(t, &database.MockGoChatRepository{}, users...)
+		typist, other := clients[0], clients[1]
+		room.handleTyping(newTypingMsg(typist, false))
+		assertTyping(t, other, typist.user.Id, true)
+
+		expiresAt := room.typing[typist.user.Id].expiresAt
+		room.handleTyping(newTypingMsg(typist, false))
+		assert.Len(t, other.send, 0, "expected repeated event to be throttled")
+		assert.False(t, room.typing[typist.user.Id].expiresAt.Before(expiresAt), "expected expiry to be extended")
+
+		// once the throttle interval has passed, the room is notified again
+		room.typing[typist.user.Id].notifiedAt = time.Now().Add(-typingThrottle)
+		room.handleTyping(newTypingMsg(typist, false))
+		assertTyping(t, other, typist.user.Id, true)
+	})
+
+	t.Run("stop notifies other sessions", func(t *testing.T) {
+		room, clients := newTestRoomWithClients(t, &database.MockGoChatRepository{}, users...)
+		typist, other := clients[0], clients[1]
+		room.handleTyping(newTypingMsg(typist, false))
+		assertTyping(t, other, typist.user.Id, true)
+
+		room.handleTyping(newTypingMsg(typist, true))
+		assertTyping(t, other, typist.user.Id, false)
+		assert.NotContains(t, room.typing, typist.user.Id, "expected typing state to be cleared")
+	})
+
+	t.Run("stop without typing is a no-op", func(t *testing.T) {
+		room, clients := newTestRoomWithClients(t, &database.MockGoChatRepository{}, users...)
+		typist, other := clients[0], clients[1]
+		room.handleTyping(newTypingMsg(typist, true))
+
+		assert.Len(t, other.send, 0, "expected no notification")
+	})
+
+	t.Run("expires without follow-up", func(t *testing.T) {
+		room, clients := newTestRoomWithClients(t, &database.MockGoChatRepository{}, users...)
+		typist, other := clients[0], clients[1]
+		room.handleTyping(newTypingMsg(typist, false))
+		room.handleTyping(newTypingMsg(other, false))
+		assertTyping(t, other, typist.user.Id, true)
+		assertTyping(t, typist, other.user.Id, true)
+
+		room.typing[typist.user.Id].expiresAt = time.Now().Add(-time.Millisecond)
+		room.expireTyping()
+
+		assertTyping(t, other, typist.user.Id, false)
+		assertTyping(t, typist, typist.user.Id, false)
+		assert.NotContains(t, room.typing, typist.user.Id, "expected expired typing state to be cleared")
+		assert.Contains(t, room.typing, other.user.Id, "expected unexpired typing state to remain")
+	})
+}
+
+func Test_handleJoin(t *testing.T) {
+	t.Run("direct room refuses non-member", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room := &Room{
+			cs:         &ChatServer{},
+			id:         1,
+			externalId: "testroom",
+			kind:       database.RoomKindDirect,
+			clients:    make(map[*Client]struct{}),
+			userMap:    make(map[int]map[*Client]struct{}),
+			db:         db,
+			log:        testutil.TestLogger(t),
+			killTimer:  time.NewTimer(idleRoomTimeout),
+		}
+		room.killTimer.Stop()
+
+		c := &Client{
+			user:  types.User{Id: 3, Username: "intruder"},
+			send:  make(chan *ServerMessage, 256),
+			rooms: make(map[string]*Room),
+		}
+
+		db.On("SubscriptionExists", mock.Anything, 3, 1).Return(false).Once()
+
+		room.handleJoin(&ClientMessage{
+			BaseMessage: BaseMessage{
+				Id:        1,
+				Timestamp: Now(),
+			},
+			Join: &Join{
+				RoomId: room.externalId,
+			},
+			UserId: c.user.Id,
+			client: c,
+		})
+
+		db.AssertNotCalled(t, "CreateSubscription", mock.Anything, mock.Anything, mock.Anything)
+		assert.NotContains(t, room.clients, c, "expected client to not be added to room clients")
+		assert.NotContains(t, c.rooms, room.externalId, "expected room to not be added to client's rooms")
+
+		select {
+		case resp := <-c.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusNotFound, resp.Response.ResponseCode, "expected response code 404")
+		default:
+			t.Error("expected client to receive response message")
+		}
+	})
+
+	t.Run("private room refuses user without invitation", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room := &Room{
+			cs:         &ChatServer{},
+			id:         1,
+			externalId: "testroom",
+			kind:       database.RoomKindPrivate,
+			clients:    make(map[*Client]struct{}),
+			userMap:    make(map[int]map[*Client]struct{}),
+			db:         db,
+			log:        testutil.TestLogger(t),
+			killTimer:  time.NewTimer(idleRoomTimeout),
+		}
+		room.killTimer.Stop()
+
+		c := &Client{
+			user:  types.User{Id: 3, Username: "uninvited"},
+			send:  make(chan *ServerMessage, 256),
+			rooms: make(map[string]*Room),
+		}
+
+		db.On("SubscriptionExists", mock.Anything, 3, 1).Return(false).Once()
+		db.On("IsBanned", mock.Anything, 3, 1).Return(false, nil).Once()
+		db.On("AcceptInvite", mock.Anything, 3, 1).Return(database.Subscription{}, sql.ErrNoRows).Once()
+
+		room.handleJoin(&ClientMessage{
+			BaseMessage: BaseMessage{
+				Id:        1,
+				Timestamp: Now(),
+			},
+			Join: &Join{
+				RoomId: room.externalId,
+			},
+			UserId: c.user.Id,
+			client: c,
+		})
+
+		db.AssertNotCalled(t, "CreateSubscription", mock.Anything, mock.Anything, mock.Anything)
+		assert.True(t, room.killTimer.Stop(), "expected room's killTimer to be started after join failure")
+		assert.NotContains(t, room.clients, c, "expected client to not be added to room clients")
+
+		select {
+		case resp := <-c.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusForbidden, resp.Response.ResponseCode, "expected response code 403")
+		default:
+			t.Error("expected client to receive response message")
+		}
+	})
+
+	t.Run("refuses banned user", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room := &Room{
+			cs:         &ChatServer{},
+			id:         1,
+			externalId: "testroom",
+			clients:    make(map[*Client]struct{}),
+			userMap:    make(map[int]map[*Client]struct{}),
+			db:         db,
+			log:        testutil.TestLogger(t),
+			killTimer:  time.NewTimer(idleRoomTimeout),
+		}
+		room.killTimer.Stop()
+
+		c := &Client{
+			user:  types.User{Id: 3, Username: "banned"},
+			send:  make(chan *ServerMessage, 256),
+			rooms: make(map[string]*Room),
+		}
+
+		db.On("SubscriptionExists", mock.Anything, 3, 1).Return(false).Once()
+		db.On("IsBanned", mock.Anything, 3, 1).Return(true, nil).Once()
+
+		room.handleJoin(&ClientMessage{
+			BaseMessage: BaseMessage{
+				Id:        1,
+				Timestamp: Now(),
+			},
+			Join: &Join{
+				RoomId: room.externalId,
+			},
+			UserId: c.user.Id,
+			client: c,
+		})
+
+		db.AssertNotCalled(t, "CreateSubscription", mock.Anything, mock.Anything, mock.Anything)
+		assert.True(t, room.killTimer.Stop(), "expected room's killTimer to be started after join failure")
+		assert.NotContains(t, room.clients, c, "expected client to not be added to room clients")
+
+		select {
+		case resp := <-c.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusForbidden, resp.Response.ResponseCode, "expected response code 403")
+		default:
+			t.Error("expected client to receive response message")
+		}
+	})
+
+	t.Run("private room accepts invited user", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room := &Room{
+			id:         1,
+			externalId: "testroom",
+			kind:       database.RoomKindPrivate,
+			clients:    make(map[*Client]struct{}),
+			userMap:    make(map[int]map[*Client]struct{}),
+			db:         db,
+			cs:         newTestChatServer(t, db, &stats.MockStatsUpdater{}),
+			log:        testutil.TestLogger(t),
+			killTimer:  time.NewTimer(idleRoomTimeout),
+		}
+		room.killTimer.Stop()
+
+		c := &Client{
+			user:  types.User{Id: 3, Username: "invited"},
+			send:  make(chan *ServerMessage, 256),
+			rooms: make(map[string]*Room),
+		}
+
+		db.On("SubscriptionExists", mock.Anything, 3, 1).Return(false).Once()
+		db.On("IsBanned", mock.Anything, 3, 1).Return(false, nil).Once()
+		db.On("AcceptInvite", mock.Anything, 3, 1).Return(database.Subscription{Id: 2, AccountId: 3, RoomId: 1}, nil).Once()
+		db.On("GetRoomWithSubscribers", mock.Anything, 1).Return(&database.Room{
+			Id:         1,
+			ExternalId: "testroom",
+			Kind:       database.RoomKindPrivate,
+			Subscriptions: []database.Subscription{
+				{Id: 2, AccountId: 3, Username: "invited"},
+			},
+		}, nil).Once()
+		db.On("ListPins", mock.Anything, 1).Return([]database.Pin{}, nil).Once()
+
+		room.handleJoin(&ClientMessage{
+			BaseMessage: BaseMessage{
+				Id:        1,
+				Timestamp: Now(),
+			},
+			Join: &Join{
+				RoomId: room.externalId,
+			},
+			UserId: c.user.Id,
+			client: c,
+		})
+
+		db.AssertNotCalled(t, "CreateSubscription", mock.Anything, mock.Anything, mock.Anything)
+		assert.Contains(t, room.clients, c, "expected client to be added to room clients")
+		assert.Contains(t, room.subscribers, types.User{Id: 3, Username: "invited"}, "expected user to be added to room subscribers")
+
+		select {
+		case resp := <-c.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, http.StatusOK, resp.Response.ResponseCode, "expected response code 200")
+			assert.Equal(t, database.RoomKindPrivate, resp.Response.Data.(types.Room).Kind, "expected private room kind")
+		default:
+			t.Error("expected client to receive response message")
+		}
+	})
 
-func Test_handleJoin(t *testing.T) {
 	t.Run("join subscription exists", func(t *testing.T) {
 		db := &database.MockGoChatRepository{}
 		defer db.AssertExpectations(t)
@@ -649,8 +1688,8 @@ func Test_handleJoin(t *testing.T) {
 		}
 
 		now := Now()
-		db.On("SubscriptionExists", 1, 1).Return(true, nil).Once()
-		db.On("GetRoomWithSubscribers", 1).Return(&database.Room{
+		db.On("SubscriptionExists", mock.Anything, 1, 1).Return(true, nil).Once()
+		db.On("GetRoomWithSubscribers", mock.Anything, 1).Return(&database.Room{
 			Id:          1,
 			Name:        "testroom",
 			ExternalId:  "testroom",
@@ -669,6 +1708,23 @@ func Test_handleJoin(t *testing.T) {
 				},
 			},
 		}, nil).Once()
+		db.On("ListPins", mock.Anything, 1).Return([]database.Pin{
+			{
+				Id:       1,
+				RoomId:   1,
+				PinnedBy: 1,
+				Message: database.Message{
+					SeqId:       3,
+					RoomId:      1,
+					UserId:      1,
+					Content:     "runbook",
+					ContentHTML: "<p>runbook</p>",
+					CreatedAt:   now,
+					UpdatedAt:   now,
+				},
+				CreatedAt: now,
+			},
+		}, nil).Once()
 
 		room.handleJoin(&ClientMessage{
 			BaseMessage: BaseMessage{
@@ -700,6 +1756,20 @@ func Test_handleJoin(t *testing.T) {
 					IsPresent: true,
 				},
 			},
+			Pins: []types.Pin{
+				{
+					Message: types.Message{
+						SeqId:       3,
+						RoomId:      1,
+						UserId:      1,
+						Content:     "runbook",
+						ContentHTML: "<p>runbook</p>",
+						Timestamp:   now,
+					},
+					PinnedBy: 1,
+					PinnedAt: now,
+				},
+			},
 			CreatedAt: now,
 			UpdatedAt: now,
 		}
@@ -744,8 +1814,8 @@ func Test_handleJoin(t *testing.T) {
 		}
 
 		now := Now()
-		db.On("SubscriptionExists", 1, 1).Return(true, nil).Once()
-		db.On("GetRoomWithSubscribers", 1).Return(&database.Room{
+		db.On("SubscriptionExists", mock.Anything, 1, 1).Return(true, nil).Once()
+		db.On("GetRoomWithSubscribers", mock.Anything, 1).Return(&database.Room{
 			Id:          1,
 			Name:        "testroom",
 			ExternalId:  "testroom",
@@ -764,6 +1834,7 @@ func Test_handleJoin(t *testing.T) {
 				},
 			},
 		}, nil).Once()
+		db.On("ListPins", mock.Anything, 1).Return([]database.Pin{}, nil).Once()
 
 		clientMsg := &ClientMessage{
 			BaseMessage: BaseMessage{
@@ -815,8 +1886,8 @@ func Test_handleJoin(t *testing.T) {
 		}
 
 		now := Now()
-		db.On("SubscriptionExists", c2.user.Id, room.id).Return(true, nil).Once()
-		db.On("GetRoomWithSubscribers", room.id).Return(&database.Room{
+		db.On("SubscriptionExists", mock.Anything, c2.user.Id, room.id).Return(true, nil).Once()
+		db.On("GetRoomWithSubscribers", mock.Anything, room.id).Return(&database.Room{
 			Id:          room.id,
 			Name:        room.externalId,
 			ExternalId:  room.externalId,
@@ -842,6 +1913,7 @@ func Test_handleJoin(t *testing.T) {
 				},
 			},
 		}, nil).Once()
+		db.On("ListPins", mock.Anything, room.id).Return([]database.Pin{}, nil).Once()
 
 		room.handleJoin(&ClientMessage{
 			BaseMessage: BaseMessage{
@@ -907,14 +1979,15 @@ func Test_handleJoin(t *testing.T) {
 		room.addClient(c2)
 		c2.addRoom(room)
 
-		db.On("SubscriptionExists", c1.user.Id, room.id).Return(false, nil).Once()
-		db.On("CreateSubscription", c1.user.Id, room.id).Return(database.Subscription{
+		db.On("SubscriptionExists", mock.Anything, c1.user.Id, room.id).Return(false, nil).Once()
+		db.On("IsBanned", mock.Anything, c1.user.Id, room.id).Return(false, nil).Once()
+		db.On("CreateSubscription", mock.Anything, c1.user.Id, room.id).Return(database.Subscription{
 			Id:        2,
 			AccountId: c1.user.Id,
 			RoomId:    room.id,
 		}, nil).Once()
 
-		db.On("GetRoomWithSubscribers", room.id).Return(&database.Room{
+		db.On("GetRoomWithSubscribers", mock.Anything, room.id).Return(&database.Room{
 			Id:          room.id,
 			Name:        room.externalId,
 			ExternalId:  room.externalId,
@@ -940,6 +2013,7 @@ func Test_handleJoin(t *testing.T) {
 				},
 			},
 		}, nil).Once()
+		db.On("ListPins", mock.Anything, room.id).Return([]database.Pin{}, nil).Once()
 
 		room.handleJoin(&ClientMessage{
 			BaseMessage: BaseMessage{
@@ -957,7 +2031,7 @@ func Test_handleJoin(t *testing.T) {
 		assert.Contains(t, c1.rooms, room.externalId, "expected room to be added to client's rooms")
 		assert.Contains(t, room.userMap[c1.user.Id], c1, "expected user for client to be added to room's userMap")
 		assert.Equalf(t, 2, len(room.subscribers), "expected room to have 2 subscribers, got %d", len(room.subscribers))
-		assert.Containsf(t, room.subscribers, types.User{Id: c1.user.Id}, "expected user to be added to room subscribers, got %+v", room.subscribers)
+		assert.Containsf(t, room.subscribers, types.User{Id: c1.user.Id, Username: c1.user.Username}, "expected user to be added to room subscribers, got %+v", room.subscribers)
 
 		// Check that c2 receives a notification about c1 subscribing
 		// It should not not receive a presence notification for the room since it is joined.
@@ -1002,8 +2076,8 @@ func Test_handleJoin(t *testing.T) {
 			send:  make(chan *ServerMessage, 256),
 		}
 
-		db.On("SubscriptionExists", c.user.Id, room.id).Return(true).Once()
-		db.On("GetRoomWithSubscribers", room.id).Return(nil, errors.New("db error")).Once()
+		db.On("SubscriptionExists", mock.Anything, c.user.Id, room.id).Return(true).Once()
+		db.On("GetRoomWithSubscribers", mock.Anything, room.id).Return(nil, errors.New("db error")).Once()
 
 		room.handleJoin(&ClientMessage{
 			BaseMessage: BaseMessage{
@@ -1063,8 +2137,9 @@ func Test_handleJoin(t *testing.T) {
 			send:  make(chan *ServerMessage, 256),
 		}
 
-		db.On("SubscriptionExists", c.user.Id, room.id).Return(false, nil).Once()
-		db.On("CreateSubscription", c.user.Id, room.id).Return(database.Subscription{}, errors.New("db error")).Once()
+		db.On("SubscriptionExists", mock.Anything, c.user.Id, room.id).Return(false, nil).Once()
+		db.On("IsBanned", mock.Anything, c.user.Id, room.id).Return(false, nil).Once()
+		db.On("CreateSubscription", mock.Anything, c.user.Id, room.id).Return(database.Subscription{}, errors.New("db error")).Once()
 
 		room.handleJoin(&ClientMessage{
 			BaseMessage: BaseMessage{
@@ -1132,8 +2207,8 @@ func Test_handleJoin(t *testing.T) {
 			send:  make(chan *ServerMessage, 256),
 		}
 
-		db.On("SubscriptionExists", c.user.Id, room.id).Return(true).Once()
-		db.On("GetRoomWithSubscribers", room.id).Return(nil, errors.New("db error")).Once()
+		db.On("SubscriptionExists", mock.Anything, c.user.Id, room.id).Return(true).Once()
+		db.On("GetRoomWithSubscribers", mock.Anything, room.id).Return(nil, errors.New("db error")).Once()
 
 		room.handleJoin(&ClientMessage{
 			BaseMessage: BaseMessage{
@@ -1152,9 +2227,179 @@ func Test_handleJoin(t *testing.T) {
 	})
 }
 
+func Test_handleRemove(t *testing.T) {
+	db := &database.MockGoChatRepository{}
+	defer db.AssertExpectations(t)
+
+	kicked := types.User{Id: 1, Username: "kicked"}
+	other := types.User{Id: 2, Username: "other"}
+	room := &Room{
+		externalId:  "testroom",
+		subscribers: []types.User{kicked, other},
+		clients:     make(map[*Client]struct{}),
+		userMap:     make(map[int]map[*Client]struct{}),
+		typing:      map[int]*typingState{kicked.Id: {expiresAt: time.Now().Add(typingTimeout)}},
+		cs:          newTestChatServer(t, db, &stats.MockStatsUpdater{}),
+		log:         testutil.TestLogger(t),
+		killTimer:   time.NewTimer(idleRoomTimeout),
+		typingTimer: time.NewTimer(typingTimeout),
+	}
+	room.killTimer.Stop()
+	room.typingTimer.Stop()
+
+	c1 := &Client{
+		user:     kicked,
+		send:     make(chan *ServerMessage, 256),
+		rooms:    make(map[string]*Room),
+		exitRoom: make(chan string, 1),
+	}
+	c2 := &Client{
+		user:  other,
+		send:  make(chan *ServerMessage, 256),
+		rooms: make(map[string]*Room),
+	}
+	for _, c := range []*Client{c1, c2} {
+		room.addClient(c)
+		c.addRoom(room)
+	}
+
+	room.handleRemove(removeReq{user: kicked, banned: true})
+
+	assert.NotContains(t, room.clients, c1, "expected kicked client to be removed from room clients")
+	assert.NotContains(t, room.userMap, kicked.Id, "expected kicked user to be removed from room's userMap")
+	assert.Equal(t, []types.User{other}, room.subscribers, "expected kicked user to be removed from subscribers")
+	assert.NotContains(t, room.typing, kicked.Id, "expected kicked user to stop typing")
+	assert.Equal(t, room.externalId, <-c1.exitRoom, "expected kicked client to exit the room")
+
+	assert.Empty(t, room.cs.broadcastChan, "expected kick notification to be sent by the chat server instead of the room")
+
+	// the remaining client is told the user stopped typing and unsubscribed
+	assert.Len(t, c2.send, 2, "expected remaining client to receive two notifications")
+	msg := <-c2.send
+	assert.Equal(t, &TypingNotification{RoomId: room.externalId, UserId: kicked.Id, Typing: false}, msg.Notification.Typing)
+
+	select {
+	case msg := <-c2.send:
+		assert.Equal(t, &SubscriptionChange{
+			RoomId:     room.externalId,
+			Subscribed: false,
+			User:       kicked,
+		}, msg.Notification.SubscriptionChange, "expected subscription change to be broadcast")
+	default:
+		t.Error("expected remaining client to receive subscription change")
+	}
+}
+
+func Test_handleJoin_catchUp(t *testing.T) {
+	now := Now()
+	missed := []database.Message{
+		// messages are returned newest first
+		{Id: 12, SeqId: 5, RoomId: 1, UserId: 2, Content: "edited", CreatedAt: now, UpdatedAt: now.Add(time.Minute)},
+		{Id: 11, SeqId: 4, RoomId: 1, UserId: 2, Deleted: true, CreatedAt: now, UpdatedAt: now},
+		{Id: 10, SeqId: 3, RoomId: 1, UserId: 2, Content: "hello", CreatedAt: now, UpdatedAt: now,
+			Reactions: []database.Reaction{{Emoji: "👍", UserIds: []int{1, 3}}}},
+	}
+
+	tcases := []struct {
+		name          string
+		since         int
+		lastReadSeqId int
+		expectSince   int
+		expected      []*types.Message
+	}{
+		{
+			name:          "defaults to last read message",
+			lastReadSeqId: 2,
+			expectSince:   3,
+			expected: []*types.Message{
+				{SeqId: 3, RoomId: 1, UserId: 2, Content: "hello", Timestamp: now,
+					Reactions: []types.Reaction{{Emoji: "👍", Count: 2, UserIds: []int{1, 3}}}},
+				{SeqId: 4, RoomId: 1, UserId: 2, Deleted: true, Timestamp: now},
+				{SeqId: 5, RoomId: 1, UserId: 2, Content: "edited", Edited: true, Timestamp: now},
+			},
+		},
+		{
+			name:          "uses since from join",
+			since:         2,
+			lastReadSeqId: 1,
+			expectSince:   3,
+			expected: []*types.Message{
+				{SeqId: 3, RoomId: 1, UserId: 2, Content: "hello", Timestamp: now,
+					Reactions: []types.Reaction{{Emoji: "👍", Count: 2, UserIds: []int{1, 3}}}},
+				{SeqId: 4, RoomId: 1, UserId: 2, Deleted: true, Timestamp: now},
+				{SeqId: 5, RoomId: 1, UserId: 2, Content: "edited", Edited: true, Timestamp: now},
+			},
+		},
+		{
+			name:          "nothing missed",
+			lastReadSeqId: 5,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &database.MockGoChatRepository{}
+			defer db.AssertExpectations(t)
+
+			room := &Room{
+				id:          1,
+				externalId:  "testroom",
+				seq_id:      5,
+				clients:     make(map[*Client]struct{}),
+				userMap:     make(map[int]map[*Client]struct{}),
+				db:          db,
+				cs:          newTestChatServer(t, db, &stats.MockStatsUpdater{}),
+				log:         testutil.TestLogger(t),
+				killTimer:   time.NewTimer(idleRoomTimeout),
+				subscribers: []types.User{{Id: 1, Username: "testuser"}},
+			}
+			room.killTimer.Stop()
+
+			c := &Client{
+				user:  types.User{Id: 1, Username: "testuser"},
+				send:  make(chan *ServerMessage, 256),
+				rooms: make(map[string]*Room),
+			}
+
+			db.On("SubscriptionExists", mock.Anything, 1, 1).Return(true).Once()
+			db.On("GetRoomWithSubscribers", mock.Anything, 1).Return(&database.Room{
+				Id:         1,
+				ExternalId: "testroom",
+				SeqId:      5,
+				Subscriptions: []database.Subscription{
+					{Id: 1, AccountId: 1, Username: "testuser", LastReadSeqId: tc.lastReadSeqId},
+				},
+			}, nil).Once()
+			db.On("ListPins", mock.Anything, 1).Return([]database.Pin{}, nil).Once()
+			if tc.expectSince > 0 {
+				db.On("GetMessages", mock.Anything, 1, tc.expectSince, 0, maxCatchUpMessages).Return(missed, nil).Once()
+			}
+
+			room.handleJoin(&ClientMessage{
+				BaseMessage: BaseMessage{Id: 1, Timestamp: Now()},
+				Join:        &Join{RoomId: room.externalId, Since: tc.since},
+				UserId:      c.user.Id,
+				client:      c,
+			})
+
+			resp := <-c.send
+			assert.NotNil(t, resp.Response, "expected room info to be sent first")
+
+			var got []*types.Message
+			for len(c.send) > 0 {
+				msg := <-c.send
+				assert.NotNil(t, msg.Message, "expected only missed messages after room info")
+				got = append(got, msg.Message)
+			}
+			assert.Equal(t, tc.expected, got, "expected missed messages in order")
+		})
+	}
+}
+
 func Test_removeClientSession(t *testing.T) {
 	t.Run("remove single client in room", func(t *testing.T) {
 		room := &Room{
+			cs:         &ChatServer{},
 			externalId: "testroom",
 			clients:    make(map[*Client]struct{}),
 			userMap:    make(map[int]map[*Client]struct{}),
@@ -1182,6 +2427,7 @@ func Test_removeClientSession(t *testing.T) {
 
 	t.Run("remove client when multiple clients in room", func(t *testing.T) {
 		room := &Room{
+			cs:         &ChatServer{},
 			externalId: "testroom",
 			clients:    make(map[*Client]struct{}),
 			userMap:    make(map[int]map[*Client]struct{}),
@@ -1220,6 +2466,7 @@ func Test_removeClientSession(t *testing.T) {
 
 func Test_removeAllClientsForUser(t *testing.T) {
 	room := &Room{
+		cs:         &ChatServer{},
 		externalId: "testroom",
 		clients:    make(map[*Client]struct{}),
 		userMap:    make(map[int]map[(*Client)]struct{}),
@@ -1347,13 +2594,17 @@ func Test_saveAndBroadcast(t *testing.T) {
 			client: c,
 		}
 
-		db.On("CreateMessage", database.Message{
-			SeqId:     1,
-			RoomId:    room.id,
-			UserId:    msg.client.user.Id,
-			Content:   msg.Publish.Content,
-			CreatedAt: msg.Timestamp,
-		}).Return(nil).Once()
+		dbMsg := database.Message{
+			RoomId:      room.id,
+			UserId:      msg.client.user.Id,
+			Content:     msg.Publish.Content,
+			ContentHTML: "<p>Hello, world!</p>",
+			CreatedAt:   msg.Timestamp,
+		}
+		stored := dbMsg
+		stored.Id, stored.SeqId = 10, 1
+		db.On("CreateMessage", mock.Anything, dbMsg).Return(stored, nil).Once()
+		db.On("ListUnread", mock.Anything, room.id).Return([]database.Unread{{AccountId: 2, LastReadSeqId: 0}}, nil).Once()
 
 		room.saveAndBroadcast(msg)
 
@@ -1372,6 +2623,7 @@ func Test_saveAndBroadcast(t *testing.T) {
 			assert.NotNil(t, pub, "expected second message to be non-nil")
 			assert.NotNil(t, pub.Message, "expected second message to be a publish message")
 			assert.Equal(t, msg.Publish.Content, pub.Message.Content, "expected published content to match")
+			assert.Equal(t, "<p>Hello, world!</p>", pub.Message.ContentHTML, "expected published content html to match")
 			assert.Equal(t, c.user.Id, pub.Message.UserId, "expected published user id to match")
 			assert.Equal(t, room.id, pub.Message.RoomId, "expected published room id to match")
 			assert.Equal(t, room.seq_id, pub.Message.SeqId, "expected published seq_id to match")
@@ -1427,13 +2679,13 @@ func Test_saveAndBroadcast(t *testing.T) {
 			client: c,
 		}
 
-		db.On("CreateMessage", database.Message{
-			SeqId:     1,
-			RoomId:    room.id,
-			UserId:    msg.client.user.Id,
-			Content:   msg.Publish.Content,
-			CreatedAt: msg.Timestamp,
-		}).Return(errors.New("db error")).Once()
+		db.On("CreateMessage", mock.Anything, database.Message{
+			RoomId:      room.id,
+			UserId:      msg.client.user.Id,
+			Content:     msg.Publish.Content,
+			ContentHTML: "<p>Hello, world!</p>",
+			CreatedAt:   msg.Timestamp,
+		}).Return(database.Message{}, errors.New("db error")).Once()
 
 		room.saveAndBroadcast(msg)
 
@@ -1450,10 +2702,598 @@ func Test_saveAndBroadcast(t *testing.T) {
 
 		assert.Equal(t, 0, room.seq_id, "expected seq_id to remain unchanged after error")
 	})
+
+	t.Run("message content too long", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		cs := newTestChatServer(t, db, &stats.MockStatsUpdater{})
+		cs.maxContentLength = 5
+
+		room := &Room{
+			id:         1,
+			externalId: "testroom",
+			clients:    make(map[*Client]struct{}),
+			userMap:    make(map[int]map[*Client]struct{}),
+			db:         db,
+			cs:         cs,
+			log:        testutil.TestLogger(t),
+		}
+
+		c := &Client{
+			user:  types.User{Id: 1, Username: "user1"},
+			send:  make(chan *ServerMessage, 256),
+			rooms: make(map[string]*Room),
+			log:   room.log,
+		}
+		room.addClient(c)
+
+		room.saveAndBroadcast(&ClientMessage{
+			BaseMessage: BaseMessage{Id: 1, Timestamp: Now()},
+			Publish:     &Publish{RoomId: "testroom", Content: "héllo!"},
+			UserId:      c.user.Id,
+			client:      c,
+		})
+
+		select {
+		case resp := <-c.send:
+			assert.NotNil(t, resp.Response, "expected response message")
+			assert.Equal(t, 1, resp.Id, "expected response id to match message id")
+			assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Response.ResponseCode, "expected response code 413")
+			assert.Equal(t, "message too large", resp.Response.Error, "expected error message to match")
+		case <-time.After(100 * time.Millisecond):
+			t.Error("timeout: client did not receive server response message")
+		}
+
+		db.AssertNotCalled(t, "CreateMessage", mock.Anything, mock.Anything)
+		assert.Equal(t, 0, room.seq_id, "expected seq_id to remain unchanged")
+	})
+}
+
+func Test_saveAndBroadcast_threadReply(t *testing.T) {
+	users := []types.User{{Id: 1, Username: "user1"}}
+
+	newReplyMsg := func(c *Client, parentSeqId int) *ClientMessage {
+		return &ClientMessage{
+			BaseMessage: BaseMessage{
+				Id:        1,
+				Timestamp: Now(),
+			},
+			Publish: &Publish{
+				RoomId:      "testroom",
+				Content:     "a reply",
+				ParentSeqId: parentSeqId,
+			},
+			UserId: c.user.Id,
+			client: c,
+		}
+	}
+
+	t.Run("reply updates thread", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		c := clients[0]
+		room.seq_id = 3
+
+		db.On("GetMessage", mock.Anything, room.id, 2).Return(database.Message{Id: 2, SeqId: 2, RoomId: room.id, UserId: 2}, nil).Twice()
+		isReply := mock.MatchedBy(func(m database.Message) bool {
+			return m.ParentSeqId == 2
+		})
+		db.On("CreateMessage", mock.Anything, isReply).Return(database.Message{SeqId: 4, ParentSeqId: 2}, nil).Once()
+		db.On("CreateMessage", mock.Anything, isReply).Return(database.Message{SeqId: 5, ParentSeqId: 2}, nil).Once()
+		// the reply count is only loaded from the database for the first reply
+		db.On("CountReplies", mock.Anything, room.id, 2).Return(1, nil).Once()
+		db.On("ListUnread", mock.Anything, room.id).Return([]database.Unread{}, nil).Once()
+
+		for i, expectedCount := range []int{1, 2} {
+			room.saveAndBroadcast(newReplyMsg(c, 2))
+
+			resp := <-c.send
+			assert.Equal(t, http.StatusAccepted, resp.Response.ResponseCode, "expected accepted response")
+
+			pub := <-c.send
+			assert.NotNil(t, pub.Message, "expected published message")
+			assert.Equal(t, 2, pub.Message.ParentSeqId, "expected published message to reference parent")
+			assert.Equal(t, 4+i, pub.Message.SeqId, "expected seq id to be incremented")
+
+			select {
+			case n := <-c.send:
+				assert.NotNil(t, n.Notification, "expected notification")
+				assert.NotNil(t, n.Notification.ThreadUpdate, "expected thread update notification")
+				assert.Equal(t, 2, n.Notification.ThreadUpdate.ParentSeqId, "expected parent seq id to match")
+				assert.Equal(t, expectedCount, n.Notification.ThreadUpdate.ReplyCount, "expected reply count to match")
+				assert.Equal(t, pub.Message.SeqId, n.Notification.ThreadUpdate.LastReplySeqId, "expected last reply seq id to match")
+			default:
+				t.Error("expected thread update notification")
+			}
+		}
+	})
+
+	t.Run("reply to missing parent", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		c := clients[0]
+		room.seq_id = 3
+
+		db.On("GetMessage", mock.Anything, room.id, 99).Return(database.Message{}, sql.ErrNoRows).Once()
+
+		room.saveAndBroadcast(newReplyMsg(c, 99))
+
+		resp := <-c.send
+		assert.Equal(t, http.StatusNotFound, resp.Response.ResponseCode, "expected not found response")
+		assert.Equal(t, 3, room.seq_id, "expected seq_id to remain unchanged")
+		db.AssertNotCalled(t, "CreateMessage", mock.Anything, mock.Anything)
+	})
+
+	t.Run("reply to a reply is invalid", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+
+		room, clients := newTestRoomWithClients(t, db, users...)
+		c := clients[0]
+		room.seq_id = 3
+
+		db.On("GetMessage", mock.Anything, room.id, 3).Return(database.Message{Id: 3, SeqId: 3, RoomId: room.id, ParentSeqId: 2}, nil).Once()
+
+		room.saveAndBroadcast(newReplyMsg(c, 3))
+
+		resp := <-c.send
+		assert.Equal(t, http.StatusBadRequest, resp.Response.ResponseCode, "expected bad request response")
+		db.AssertNotCalled(t, "CreateMessage", mock.Anything, mock.Anything)
+	})
+}
+
+func Test_saveAndBroadcast_attachments(t *testing.T) {
+	attachment := database.Attachment{
+		Id:          5,
+		ExternalId:  "aB3dE5fG7h",
+		RoomId:      1,
+		AccountId:   1,
+		Filename:    "screenshot.png",
+		ContentType: "image/png",
+		Size:        2048,
+	}
+
+	tcs := []struct {
+		name             string
+		attachmentIds    []string
+		mockAttachments  []database.Attachment
+		expectedCode     int
+		expectedAttached []types.Attachment
+	}{
+		{
+			name:            "attaches uploaded file",
+			attachmentIds:   []string{"aB3dE5fG7h"},
+			mockAttachments: []database.Attachment{attachment},
+			expectedCode:    http.StatusAccepted,
+			expectedAttached: []types.Attachment{
+				{
+					Id:          "aB3dE5fG7h",
+					Filename:    "screenshot.png",
+					ContentType: "image/png",
+					Size:        2048,
+					URL:         "/api/attachments/aB3dE5fG7h",
+				},
+			},
+		},
+		{
+			name:          "unknown attachment",
+			attachmentIds: []string{"aB3dE5fG7h", "missing"},
+			mockAttachments: []database.Attachment{
+				attachment,
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:          "attachment uploaded by another user",
+			attachmentIds: []string{"aB3dE5fG7h"},
+			mockAttachments: []database.Attachment{
+				func() database.Attachment {
+					a := attachment
+					a.AccountId = 2
+					return a
+				}(),
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:          "attachment already attached to a message",
+			attachmentIds: []string{"aB3dE5fG7h"},
+			mockAttachments: []database.Attachment{
+				func() database.Attachment {
+					a := attachment
+					a.SeqId = 2
+					return a
+				}(),
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:          "too many attachments",
+			attachmentIds: make([]string, maxAttachments+1),
+			expectedCode:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &database.MockGoChatRepository{}
+			defer db.AssertExpectations(t)
+
+			room := &Room{
+				id:         1,
+				externalId: "testroom",
+				clients:    make(map[*Client]struct{}),
+				userMap:    make(map[int]map[*Client]struct{}),
+				db:         db,
+				cs:         newTestChatServer(t, db, &stats.MockStatsUpdater{}),
+				log:        testutil.TestLogger(t),
+				unread:     make(map[int]*database.Unread),
+			}
+
+			c := &Client{
+				user:  types.User{Id: 1, Username: "user1"},
+				send:  make(chan *ServerMessage, 256),
+				rooms: make(map[string]*Room),
+				log:   room.log,
+			}
+			room.addClient(c)
+
+			if tc.mockAttachments != nil {
+				db.On("GetAttachments", mock.Anything, room.id, tc.attachmentIds).Return(tc.mockAttachments, nil).Once()
+			}
+			if tc.expectedCode == http.StatusAccepted {
+				db.On("CreateMessage", mock.Anything, mock.MatchedBy(func(m database.Message) bool {
+					return assert.Equal(t, tc.mockAttachments, m.Attachments, "expected attachments to be saved with the message")
+				})).Return(database.Message{SeqId: 1}, nil).Once()
+			}
+
+			room.saveAndBroadcast(&ClientMessage{
+				BaseMessage: BaseMessage{
+					Id:        1,
+					Timestamp: Now(),
+				},
+				Publish: &Publish{
+					RoomId:      room.externalId,
+					Content:     "see attached",
+					Attachments: tc.attachmentIds,
+				},
+				UserId: c.user.Id,
+				client: c,
+			})
+
+			resp := <-c.send
+			assert.Equal(t, tc.expectedCode, resp.Response.ResponseCode, "expected response code to match")
+			if tc.expectedCode != http.StatusAccepted {
+				assert.Equal(t, 0, room.seq_id, "expected seq_id to remain unchanged")
+				return
+			}
+
+			pub := <-c.send
+			assert.Equal(t, tc.expectedAttached, pub.Message.Attachments, "expected attachments to be broadcast")
+		})
+	}
+}
+
+// fakeUnfurler records the messages it is asked to preview.
+type fakeUnfurler struct {
+	content string
+	done    func([]types.LinkPreview)
+}
+
+func (f *fakeUnfurler) Unfurl(content string, done func([]types.LinkPreview)) {
+	f.content, f.done = content, done
+}
+
+func Test_saveAndBroadcast_unfurlsLinks(t *testing.T) {
+	db := &database.MockGoChatRepository{}
+	defer db.AssertExpectations(t)
+
+	unfurler := &fakeUnfurler{}
+	cs := newTestChatServer(t, db, &stats.MockStatsUpdater{})
+	cs.unfurler = unfurler
+
+	room := &Room{
+		id:         1,
+		externalId: "testroom",
+		clients:    make(map[*Client]struct{}),
+		userMap:    make(map[int]map[*Client]struct{}),
+		db:         db,
+		cs:         cs,
+		log:        testutil.TestLogger(t),
+		unread:     make(map[int]*database.Unread),
+		notifyChan: make(chan *Notification, 1),
+	}
+	cs.roomsMap.Store(room.externalId, room)
+
+	c := &Client{
+		user:  types.User{Id: 1, Username: "user1"},
+		send:  make(chan *ServerMessage, 256),
+		rooms: make(map[string]*Room),
+		log:   room.log,
+	}
+	room.addClient(c)
+
+	db.On("CreateMessage", mock.Anything, mock.Anything).Return(database.Message{SeqId: 1}, nil).Once()
+
+	room.saveAndBroadcast(&ClientMessage{
+		BaseMessage: BaseMessage{
+			Id:        1,
+			Timestamp: Now(),
+		},
+		Publish: &Publish{
+			RoomId:  room.externalId,
+			Content: "see https://example.com",
+		},
+		UserId: c.user.Id,
+		client: c,
+	})
+
+	assert.Equal(t, "see https://example.com", unfurler.content, "expected message to be unfurled")
+	if !assert.NotNil(t, unfurler.done, "expected a callback for the previews") {
+		return
+	}
+
+	previews := []types.LinkPreview{{URL: "https://example.com", Title: "Example"}}
+	db.On("SaveMessageLinkPreviews", mock.Anything, room.id, 1, []string{"https://example.com"}).Return(nil).Once()
+	unfurler.done(previews)
+
+	select {
+	case n := <-room.notifyChan:
+		assert.Equal(t, &MessageUpdated{RoomId: "testroom", SeqId: 1, Previews: previews}, n.MessageUpdated, "expected message updated notification")
+	default:
+		t.Error("expected room to be notified of the previews")
+	}
+}
+
+func Test_updateUnread(t *testing.T) {
+	db := &database.MockGoChatRepository{}
+	defer db.AssertExpectations(t)
+
+	room := &Room{
+		id:         1,
+		externalId: "testroom",
+		seq_id:     4,
+		db:         db,
+		cs:         newTestChatServer(t, db, &stats.MockStatsUpdater{}),
+		log:        testutil.TestLogger(t),
+		subscribers: []types.User{
+			{Id: 1, Username: "alice"},
+			{Id: 2, Username: "bob"},
+			{Id: 3, Username: "carol"},
+		},
+		unread: map[int]*database.Unread{
+			1: {AccountId: 1, LastReadSeqId: 4},
+			2: {AccountId: 2, LastReadSeqId: 1, Mentions: 1},
+		},
+	}
+
+	// carol subscribed after the counts were loaded
+	db.On("GetUnread", mock.Anything, 3, room.id).Return(database.Unread{AccountId: 3, LastReadSeqId: 2}, nil).Once()
+
+	room.seq_id++
+	room.updateUnread([]int{2})
+
+	expected := map[int]*UnreadUpdate{
+		1: {RoomId: "testroom", LastReadSeqId: 4, UnreadCount: 1},
+		2: {RoomId: "testroom", LastReadSeqId: 1, UnreadCount: 4, MentionCount: 2},
+		3: {RoomId: "testroom", LastReadSeqId: 2, UnreadCount: 3},
+	}
+	for range expected {
+		select {
+		case n := <-room.cs.broadcastChan:
+			assert.Equal(t, expected[n.UserId], n.Notification.Unread, "expected unread counts to match for user %d", n.UserId)
+		default:
+			t.Fatal("expected unread notification")
+		}
+	}
+}
+
+func Test_parseMentions(t *testing.T) {
+	tcs := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{"single mention", "hi @bob", []string{"bob"}},
+		{"case insensitive", "hi @BoB!", []string{"bob"}},
+		{"trailing punctuation", "thanks @bob. and @alice-", []string{"bob", "alice"}},
+		{"duplicate mentions", "@bob @Bob", []string{"bob"}},
+		{"email address", "mail bob@example.com", nil},
+		{"no mentions", "hi bob @", nil},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, parseMentions(tc.content))
+		})
+	}
+}
+
+func Test_saveAndBroadcast_mentions(t *testing.T) {
+	db := &database.MockGoChatRepository{}
+	defer db.AssertExpectations(t)
+
+	room := &Room{
+		id:         1,
+		externalId: "testroom",
+		clients:    make(map[*Client]struct{}),
+		userMap:    make(map[int]map[*Client]struct{}),
+		db:         db,
+		cs:         newTestChatServer(t, db, &stats.MockStatsUpdater{}),
+		log:        testutil.TestLogger(t),
+		subscribers: []types.User{
+			{Id: 1, Username: "alice"},
+			{Id: 2, Username: "bob"},
+			{Id: 3, Username: "carol"},
+		},
+		unread: map[int]*database.Unread{
+			1: {AccountId: 1},
+			2: {AccountId: 2},
+			3: {AccountId: 3},
+		},
+	}
+
+	c := &Client{
+		user:  types.User{Id: 1, Username: "alice"},
+		send:  make(chan *ServerMessage, 256),
+		rooms: make(map[string]*Room),
+		log:   room.log,
+	}
+	room.addClient(c)
+
+	msg := &ClientMessage{
+		BaseMessage: BaseMessage{
+			Id:        1,
+			Timestamp: Now(),
+		},
+		Publish: &Publish{
+			RoomId:  room.externalId,
+			Content: "@Bob @alice @dave can you look at this?",
+		},
+		UserId: c.user.Id,
+		client: c,
+	}
+
+	db.On("CreateMessage", mock.Anything, mock.Anything).Return(database.Message{SeqId: 1}, nil).Once()
+	// authors don't mention themselves and dave isn't subscribed
+	db.On("CreateMentions", mock.Anything, room.id, 1, []int{2}).Return(nil).Once()
+
+	room.saveAndBroadcast(msg)
+
+	var mentionNotifications []*ServerMessage
+	mentions := make(map[int]int)
+	for len(room.cs.broadcastChan) > 0 {
+		n := <-room.cs.broadcastChan
+		switch {
+		case n.Notification.Mention != nil:
+			mentionNotifications = append(mentionNotifications, n)
+		case n.Notification.Unread != nil:
+			mentions[n.UserId] = n.Notification.Unread.MentionCount
+		}
+	}
+
+	if assert.Len(t, mentionNotifications, 1, "expected a single mention notification") {
+		assert.Equal(t, 2, mentionNotifications[0].UserId, "expected mention notification for the mentioned user")
+		assert.Equal(t, &MentionNotification{
+			RoomId:  "testroom",
+			SeqId:   1,
+			User:    types.User{Id: 1, Username: "alice"},
+			Content: msg.Publish.Content,
+		}, mentionNotifications[0].Notification.Mention, "expected mention notification to match")
+	}
+	assert.Equal(t, map[int]int{1: 0, 2: 1, 3: 0}, mentions, "expected only bob's mention count to change")
+}
+
+func Test_saveAndBroadcast_seqId(t *testing.T) {
+	tcases := []struct {
+		name          string
+		storedSeqId   int
+		saveErr       error
+		expectedCode  int
+		expectedSeqId int
+		unreadReload  bool
+	}{
+		{
+			name:          "next seq id",
+			storedSeqId:   2,
+			expectedCode:  http.StatusAccepted,
+			expectedSeqId: 2,
+		},
+		{
+			name:          "messages saved by another node",
+			storedSeqId:   4,
+			expectedCode:  http.StatusAccepted,
+			expectedSeqId: 4,
+			unreadReload:  true,
+		},
+		{
+			name:          "seq id conflict",
+			saveErr:       database.ErrSeqIdConflict,
+			expectedCode:  http.StatusInternalServerError,
+			expectedSeqId: 1,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &database.MockGoChatRepository{}
+			defer db.AssertExpectations(t)
+
+			cs := newTestChatServer(t, db, &stats.MockStatsUpdater{})
+			room := &Room{
+				id:         1,
+				externalId: "testroom",
+				clients:    make(map[*Client]struct{}),
+				userMap:    make(map[int]map[*Client]struct{}),
+				db:         db,
+				cs:         cs,
+				log:        testutil.TestLogger(t),
+				seq_id:     1,
+				unread:     map[int]*database.Unread{1: {AccountId: 1, LastReadSeqId: 1}},
+				subscribers: []types.User{
+					{Id: 1, Username: "user1"},
+				},
+			}
+
+			c := &Client{
+				user:  types.User{Id: 1, Username: "user1"},
+				send:  make(chan *ServerMessage, 256),
+				rooms: make(map[string]*Room),
+				log:   room.log,
+			}
+			room.addClient(c)
+
+			msg := &ClientMessage{
+				BaseMessage: BaseMessage{Id: 1, Timestamp: Now()},
+				Publish:     &Publish{RoomId: room.externalId, Content: "hi"},
+				UserId:      c.user.Id,
+				client:      c,
+			}
+
+			db.On("CreateMessage", mock.Anything, database.Message{
+				RoomId:      room.id,
+				UserId:      c.user.Id,
+				Content:     "hi",
+				ContentHTML: "<p>hi</p>",
+				CreatedAt:   msg.Timestamp,
+			}).Return(database.Message{Id: 10, SeqId: tc.storedSeqId, RoomId: room.id}, tc.saveErr).Once()
+			if tc.unreadReload {
+				db.On("ListUnread", mock.Anything, room.id).Return([]database.Unread{{AccountId: 1, LastReadSeqId: 1}}, nil).Once()
+			}
+
+			room.saveAndBroadcast(msg)
+
+			select {
+			case resp := <-c.send:
+				assert.NotNil(t, resp.Response, "expected response message")
+				assert.Equal(t, tc.expectedCode, resp.Response.ResponseCode, "expected response code to match")
+			default:
+				t.Error("expected response to be sent to client")
+			}
+
+			if tc.saveErr == nil {
+				select {
+				case pub := <-c.send:
+					assert.NotNil(t, pub.Message, "expected published message")
+					assert.Equal(t, tc.expectedSeqId, pub.Message.SeqId, "expected message to be published with the stored seq id")
+				default:
+					t.Error("expected message to be published")
+				}
+			}
+
+			assert.Equal(t, tc.expectedSeqId, room.seq_id, "expected seq id to match")
+		})
+	}
 }
 
 func Test_broadcast(t *testing.T) {
 	r := &Room{
+		cs:         &ChatServer{},
 		externalId: "testroom",
 		clients:    make(map[*Client]struct{}),
 		userMap:    make(map[int]map[*Client]struct{}),
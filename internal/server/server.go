@@ -9,6 +9,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/npezzotti/go-chatroom/internal/backplane"
+	"github.com/npezzotti/go-chatroom/internal/config"
 	"github.com/npezzotti/go-chatroom/internal/database"
 	"github.com/npezzotti/go-chatroom/internal/stats"
 	"github.com/npezzotti/go-chatroom/internal/types"
@@ -27,19 +29,83 @@ type ChatServer struct {
 	roomsMap       sync.Map
 	stop           chan stopReq
 	stats          stats.StatsProvider
+	unfurler       LinkUnfurler
+	// maxMessageSize is the maximum size in bytes of a message read from a client
+	maxMessageSize int64
+	// maxContentLength is the maximum number of characters in a published message
+	maxContentLength int
+	// backplane (optional) connects the chat server to the other nodes of a cluster
+	backplane backplane.Backplane
+	// nodeId identifies the chat server in a cluster
+	nodeId string
+	// publishChan queues the events published to the backplane
+	publishChan chan *clusterEvent
+	// done is closed when the chat server stops
+	done chan struct{}
+	// messageBatchSize is the maximum number of messages a room saves at once in the
+	// background, zero if rooms save each message before handling the next one
+	messageBatchSize int
+	// ctx is passed to the repository calls made by the chat server and is the parent
+	// of the rooms' contexts. It is canceled once the chat server stops.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Option configures optional settings of a ChatServer.
+type Option func(*ChatServer)
+
+// WithMaxMessageSize sets the maximum size in bytes of a message read from a client.
+func WithMaxMessageSize(size int64) Option {
+	return func(cs *ChatServer) {
+		cs.maxMessageSize = size
+	}
 }
 
-func NewChatServer(logger *log.Logger, db database.GoChatRepository, statsUpdater stats.StatsProvider) (*ChatServer, error) {
+// WithMaxContentLength sets the maximum number of characters in a published message.
+func WithMaxContentLength(length int) Option {
+	return func(cs *ChatServer) {
+		cs.maxContentLength = length
+	}
+}
+
+// LinkUnfurler previews the links in published messages in the background.
+type LinkUnfurler interface {
+	// Unfurl previews the links in the content of a message and calls done with
+	// the previews from another goroutine. done isn't called if there are none.
+	Unfurl(content string, done func([]types.LinkPreview))
+}
+
+// NewChatServer creates a chat server. unfurler is optional, links aren't previewed if it is nil.
+// Message limits default to config.DefaultMaxMessageSize and config.DefaultMaxContentLength.
+func NewChatServer(logger *log.Logger, db database.GoChatRepository, statsUpdater stats.StatsProvider, unfurler LinkUnfurler, opts ...Option) (*ChatServer, error) {
 	cs := &ChatServer{
-		log:            logger,
-		db:             db,
-		clients:        make(map[*Client]struct{}),
-		userMap:        make(map[int][]*Client),
-		joinChan:       make(chan *ClientMessage, 256),
-		unloadRoomChan: make(chan unloadRoomRequest, 64),
-		broadcastChan:  make(chan *ServerMessage, 256),
-		stop:           make(chan stopReq),
-		stats:          statsUpdater,
+		log:              logger,
+		db:               db,
+		clients:          make(map[*Client]struct{}),
+		userMap:          make(map[int][]*Client),
+		joinChan:         make(chan *ClientMessage, 256),
+		unloadRoomChan:   make(chan unloadRoomRequest, 64),
+		broadcastChan:    make(chan *ServerMessage, 256),
+		stop:             make(chan stopReq),
+		stats:            statsUpdater,
+		unfurler:         unfurler,
+		maxMessageSize:   config.DefaultMaxMessageSize,
+		maxContentLength: config.DefaultMaxContentLength,
+		nodeId:           newId(),
+		publishChan:      make(chan *clusterEvent, publishBufferSize),
+		done:             make(chan struct{}),
+	}
+	cs.ctx, cs.cancel = context.WithCancel(context.Background())
+
+	for _, opt := range opts {
+		opt(cs)
+	}
+
+	if cs.maxMessageSize <= 0 || cs.maxContentLength <= 0 {
+		return nil, fmt.Errorf("message limits must be positive")
+	}
+	if cs.messageBatchSize < 0 || cs.messageBatchSize > database.MaxMessageBatchSize {
+		return nil, fmt.Errorf("message batch size must be between 0 and %d", database.MaxMessageBatchSize)
 	}
 
 	cs.stats.RegisterMetric("NumActiveRooms")
@@ -51,6 +117,11 @@ func NewChatServer(logger *log.Logger, db database.GoChatRepository, statsUpdate
 }
 
 func (cs *ChatServer) Run() {
+	if cs.backplane != nil {
+		go cs.publishEvents()
+		go cs.receiveEvents()
+	}
+
 	for {
 		select {
 		case joinMsg := <-cs.joinChan:
@@ -61,6 +132,8 @@ func (cs *ChatServer) Run() {
 			cs.unloadRoom(req.roomId, req.deleted)
 		case req := <-cs.stop:
 			cs.unloadAllRooms()
+			cs.cancel()
+			close(cs.done)
 			close(req.done)
 			return
 		}
@@ -83,7 +156,7 @@ func (cs *ChatServer) handleJoinRoom(joinMsg *ClientMessage) {
 		}
 	} else {
 		// room not loaded, load it
-		dbRoom, err := cs.db.GetRoomByExternalId(joinMsg.Join.RoomId)
+		dbRoom, err := cs.db.GetRoomByExternalId(cs.ctx, joinMsg.Join.RoomId)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				joinMsg.client.queueMessage(ErrRoomNotFound(joinMsg.Id))
@@ -95,7 +168,7 @@ func (cs *ChatServer) handleJoinRoom(joinMsg *ClientMessage) {
 			}
 		}
 
-		dbSubs, err := cs.db.GetSubscribersByRoomId(dbRoom.Id)
+		dbSubs, err := cs.db.GetSubscribersByRoomId(cs.ctx, dbRoom.Id)
 		if err != nil {
 			joinMsg.client.queueMessage(ErrInternalError(joinMsg.Id))
 			cs.log.Println("GetSubscriptionsByRoomId:", err)
@@ -110,21 +183,45 @@ func (cs *ChatServer) handleJoinRoom(joinMsg *ClientMessage) {
 			})
 		}
 
+		// in a cluster, the room is hosted by the node holding its lease and
+		// the other nodes forward the messages from their clients to it
+		var owner string
+		if cs.backplane != nil {
+			holder, err := cs.db.AcquireRoomLease(cs.ctx, dbRoom.Id, cs.nodeId, leaseTTL)
+			if err != nil {
+				joinMsg.client.queueMessage(ErrInternalError(joinMsg.Id))
+				cs.log.Println("AcquireRoomLease:", err)
+				return
+			}
+			if holder != cs.nodeId {
+				owner = holder
+			}
+		}
+
 		room := &Room{
 			id:            dbRoom.Id,
 			externalId:    dbRoom.ExternalId,
+			kind:          dbRoom.Kind,
+			ownerId:       dbRoom.OwnerId,
 			subscribers:   subs,
 			cs:            cs,
 			db:            cs.db,
 			joinChan:      make(chan *ClientMessage, 256),
 			leaveChan:     make(chan *ClientMessage, 256),
 			clientMsgChan: make(chan *ClientMessage, 256),
+			notifyChan:    make(chan *Notification, 64),
+			removeChan:    make(chan removeReq, 64),
 			seq_id:        dbRoom.SeqId,
 			clients:       make(map[*Client]struct{}),
 			userMap:       make(map[int]map[*Client]struct{}),
 			log:           cs.log,
 			killTimer:     time.NewTimer(time.Second * 10),
 			exit:          make(chan exitReq, 1),
+			remoteChan:    make(chan *clusterEvent, 256),
+			owner:         owner,
+		}
+		if cs.messageBatchSize > 0 {
+			room.writer = newMessageWriter(cs.db, cs.log, cs.messageBatchSize)
 		}
 
 		cs.addRoom(room.externalId, room)
@@ -163,6 +260,12 @@ func (cs *ChatServer) getRoom(id string) (*Room, bool) {
 	return r.(*Room), ok
 }
 
+// IsRoomLoaded reports whether a room is currently active on the server.
+func (cs *ChatServer) IsRoomLoaded(id string) bool {
+	_, ok := cs.getRoom(id)
+	return ok
+}
+
 // unloadAllRooms unloads all active rooms.
 // It signals each room to exit and waits for all rooms to complete their shutdown.
 func (cs *ChatServer) unloadAllRooms() {
@@ -182,8 +285,19 @@ func (cs *ChatServer) unloadAllRooms() {
 
 // handleBroadcast processes a broadcast message.
 // It queues a message to all clients associated with the user ID in the message,
-// except for any client specified in SkipClient.
+// except for any client specified in SkipClient. Unless the message is local,
+// it is also published to the other nodes of the cluster.
 func (cs *ChatServer) handleBroadcast(msg *ServerMessage) {
+	cs.deliverToUser(msg)
+
+	if !msg.local {
+		cs.publish(&clusterEvent{UserId: msg.UserId, Message: msg, SkipRoom: msg.skipRoom})
+	}
+}
+
+// deliverToUser queues a message to the user's clients connected to this node,
+// except for the clients in the room the message skips.
+func (cs *ChatServer) deliverToUser(msg *ServerMessage) {
 	userClients := cs.getClients(msg.UserId)
 	// if there are no clients for this user, skip broadcasting
 	if userClients == nil {
@@ -194,6 +308,11 @@ func (cs *ChatServer) handleBroadcast(msg *ServerMessage) {
 		if msg.SkipClient != nil && c == msg.SkipClient {
 			continue
 		}
+		if msg.skipRoom != "" {
+			if _, ok := c.getRoom(msg.skipRoom); ok {
+				continue
+			}
+		}
 		c.queueMessage(msg)
 	}
 }
@@ -290,10 +409,11 @@ func (cs *ChatServer) Shutdown(ctx context.Context) error {
 }
 
 // RegisterClient adds a new client to the server's list of active clients.
-func (cs *ChatServer) RegisterClient(client *Client) {
+// ctx is used to look up the rooms the client is subscribed to.
+func (cs *ChatServer) RegisterClient(ctx context.Context, client *Client) {
 	cs.addClient(client)
 
-	subs, err := cs.db.ListSubscriptions(client.user.Id)
+	subs, err := cs.db.ListSubscriptions(ctx, client.user.Id)
 	if err != nil {
 		cs.log.Println("ListSubscriptions:", err)
 		return
@@ -338,6 +458,11 @@ func (cs *ChatServer) UnloadRoom(ctx context.Context, roomId string, deleted boo
 		return fmt.Errorf("roomId cannot be empty")
 	}
 
+	// a deleted room is unloaded from every node
+	if deleted {
+		cs.publish(&clusterEvent{RoomId: roomId, Deleted: true})
+	}
+
 	// Attempt to send the unload request to the unloadRoomChan.
 	// If the channel is full, return an error.
 	select {
@@ -352,3 +477,113 @@ func (cs *ChatServer) UnloadRoom(ctx context.Context, roomId string, deleted boo
 		return fmt.Errorf("unload room channel is full, unable to unload room %s", roomId)
 	}
 }
+
+// NotifyRoom delivers a notification originating outside of the chat server,
+// such as a change made through the REST API, to a room by its external ID.
+// The notification is broadcast to the clients in the room and sent to the room's
+// inactive subscribers. If the room is not loaded, the subscribers are loaded from
+// the database. In a cluster, the notification is handled once by the node it is
+// sent to, which publishes it to the clients connected to the other nodes.
+func (cs *ChatServer) NotifyRoom(ctx context.Context, roomId string, n *Notification) error {
+	room, ok := cs.getRoom(roomId)
+	if !ok {
+		// other nodes may have clients in the room
+		cs.publish(&clusterEvent{
+			RoomId: roomId,
+			Message: &ServerMessage{
+				BaseMessage: BaseMessage{
+					Timestamp: Now(),
+				},
+				Notification: n,
+			},
+		})
+
+		return cs.notifySubscribers(ctx, roomId, n)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case room.notifyChan <- n:
+		return nil
+	default:
+		return fmt.Errorf("notify channel is full, unable to notify room %s", roomId)
+	}
+}
+
+// notifySubscribers sends a notification to the subscribers of a room that isn't loaded.
+func (cs *ChatServer) notifySubscribers(ctx context.Context, roomId string, n *Notification) error {
+	dbRoom, err := cs.db.GetRoomByExternalId(ctx, roomId)
+	if err != nil {
+		return fmt.Errorf("get room %s: %w", roomId, err)
+	}
+
+	subs, err := cs.db.GetSubscribersByRoomId(ctx, dbRoom.Id)
+	if err != nil {
+		return fmt.Errorf("get subscribers of room %s: %w", roomId, err)
+	}
+
+	userIds := make([]int, 0, len(subs))
+	for _, sub := range subs {
+		userIds = append(userIds, sub.Id)
+	}
+
+	cs.NotifyUsers(roomId, userIds, n)
+	return nil
+}
+
+// NotifyUsers sends a notification about a room to the clients of the given users,
+// whichever node of the cluster they are connected to. Clients in the room are skipped,
+// since the room's notifications are broadcast to them. The notification is delivered to
+// this node's clients before NotifyUsers returns and published ahead of any later event,
+// so the clients that are skipped are decided before e.g. the room is unloaded.
+func (cs *ChatServer) NotifyUsers(roomId string, userIds []int, n *Notification) {
+	for _, id := range userIds {
+		cs.handleBroadcast(&ServerMessage{
+			Notification: n,
+			UserId:       id,
+			skipRoom:     roomId,
+		})
+	}
+}
+
+// RemoveSubscriber evicts a user that was kicked or banned from a room by its external ID.
+// All of the user's clients are notified they were removed, the user's sessions are removed
+// from the room and the remaining clients are notified. If the room is not loaded, the user
+// has no sessions in it. In a cluster, the user is also removed from the room on the other nodes.
+func (cs *ChatServer) RemoveSubscriber(ctx context.Context, roomId string, user types.User, banned bool) error {
+	cs.publish(&clusterEvent{RoomId: roomId, Remove: &user, Banned: banned})
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case cs.broadcastChan <- &ServerMessage{
+		BaseMessage: BaseMessage{
+			Timestamp: Now(),
+		},
+		Notification: &Notification{
+			Kicked: &Kicked{
+				RoomId: roomId,
+				Banned: banned,
+			},
+		},
+		UserId: user.Id,
+	}:
+	default:
+		return fmt.Errorf("broadcast channel is full, unable to notify user %d", user.Id)
+	}
+
+	room, ok := cs.getRoom(roomId)
+	if !ok {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case room.removeChan <- removeReq{user: user, banned: banned}:
+		return nil
+	default:
+		return fmt.Errorf("remove channel is full, unable to remove user from room %s", roomId)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/npezzotti/go-chatroom/internal/backplane"
 	"github.com/npezzotti/go-chatroom/internal/database"
 	"github.com/npezzotti/go-chatroom/internal/stats"
 	"github.com/npezzotti/go-chatroom/internal/testutil"
@@ -23,7 +24,7 @@ func newTestChatServer(t *testing.T, db database.GoChatRepository, su *stats.Moc
 	su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
 
 	logger := testutil.TestLogger(t)
-	cs, err := NewChatServer(logger, db, su)
+	cs, err := NewChatServer(logger, db, su, nil)
 	if err != nil {
 		t.Fatalf("failed to create test ChatServer: %v", err)
 	}
@@ -39,7 +40,7 @@ func TestNewChatServer(t *testing.T) {
 	su.On("RegisterMetric", mock.Anything).Return(nil).Times(4)
 
 	logger := testutil.TestLogger(t)
-	cs, err := NewChatServer(logger, db, su)
+	cs, err := NewChatServer(logger, db, su, nil)
 	assert.NoError(t, err, "expected no error creating ChatServer")
 	assert.NotNil(t, cs, "expected ChatServer to be non-nil")
 	assert.Equal(t, logger, cs.log, "expected logger to be set")
@@ -117,6 +118,7 @@ func TestChatServerShutdown_Integration(t *testing.T) {
 		// Create an active room to test shutdown behavior
 		room := &Room{
 			externalId: "testroom",
+			cs:         cs,
 			exit:       make(chan exitReq, 1),
 			log:        cs.log,
 		}
@@ -230,10 +232,12 @@ func TestChatServer_addRoom_getRoom_removeRoom(t *testing.T) {
 	got, ok := cs.getRoom("testroom")
 	assert.True(t, ok, "expected room to be found")
 	assert.Equal(t, room, got, "expected retrieved room to match added room")
+	assert.True(t, cs.IsRoomLoaded("testroom"), "expected room to be loaded")
 
 	cs.removeRoom("testroom")
 	_, ok = cs.getRoom("testroom")
 	assert.False(t, ok, "expected room to be removed")
+	assert.False(t, cs.IsRoomLoaded("testroom"), "expected room to not be loaded after removal")
 	assert.Equal(t, 0, cs.numRooms, "expected numRooms to be 0 after removing room")
 }
 
@@ -294,6 +298,26 @@ func TestChatServer_handleBroadcast(t *testing.T) {
 			// ok, no message sent to client2
 		}
 	})
+
+	t.Run("skips clients in room", func(t *testing.T) {
+		su := &stats.MockStatsUpdater{}
+		su.On("Incr", "NumActiveClients").Twice()
+		defer su.AssertExpectations(t)
+
+		cs := newTestChatServer(t, &database.MockGoChatRepository{}, su)
+		user := types.User{Id: 1, Username: "testuser"}
+
+		inRoom := &Client{user: user, send: make(chan *ServerMessage, 1), rooms: make(map[string]*Room)}
+		inRoom.addRoom(&Room{externalId: "testroom"})
+		elsewhere := &Client{user: user, send: make(chan *ServerMessage, 1), rooms: make(map[string]*Room)}
+		cs.addClient(inRoom)
+		cs.addClient(elsewhere)
+
+		cs.handleBroadcast(&ServerMessage{UserId: 1, skipRoom: "testroom"})
+
+		assert.Empty(t, inRoom.send, "expected message to be skipped for the client in the room")
+		assert.Len(t, elsewhere.send, 1, "expected message to be queued to the client outside of the room")
+	})
 }
 
 func TestChatServerRegisterClient(t *testing.T) {
@@ -301,7 +325,7 @@ func TestChatServerRegisterClient(t *testing.T) {
 		db := &database.MockGoChatRepository{}
 		defer db.AssertExpectations(t)
 
-		db.On("ListSubscriptions", 1).Return([]database.Subscription{
+		db.On("ListSubscriptions", mock.Anything, 1).Return([]database.Subscription{
 			{Room: database.Room{ExternalId: "testroom"}},
 		}, nil).Once()
 
@@ -322,7 +346,7 @@ func TestChatServerRegisterClient(t *testing.T) {
 			send: make(chan *ServerMessage, 1),
 		}
 
-		cs.RegisterClient(client)
+		cs.RegisterClient(context.Background(), client)
 		assert.Len(t, cs.clients, 1, "expected 1 client after registration")
 		assert.Contains(t, cs.clients, client, "expected client to be registered")
 
@@ -343,7 +367,7 @@ func TestChatServerRegisterClient(t *testing.T) {
 		db := &database.MockGoChatRepository{}
 		defer db.AssertExpectations(t)
 
-		db.On("ListSubscriptions", 1).Return([]database.Subscription{}, nil).Once()
+		db.On("ListSubscriptions", mock.Anything, 1).Return([]database.Subscription{}, nil).Once()
 
 		su := &stats.MockStatsUpdater{}
 		su.On("Incr", "NumActiveClients").Once()
@@ -355,7 +379,7 @@ func TestChatServerRegisterClient(t *testing.T) {
 			send: make(chan *ServerMessage, 1),
 		}
 
-		cs.RegisterClient(client)
+		cs.RegisterClient(context.Background(), client)
 		assert.Len(t, cs.clients, 1, "expected 1 client after registration")
 		assert.Contains(t, cs.clients, client, "expected client to be registered")
 
@@ -370,7 +394,7 @@ func TestChatServerRegisterClient(t *testing.T) {
 		db := &database.MockGoChatRepository{}
 		defer db.AssertExpectations(t)
 
-		db.On("ListSubscriptions", 1).Return([]database.Subscription{
+		db.On("ListSubscriptions", mock.Anything, 1).Return([]database.Subscription{
 			{Room: database.Room{ExternalId: "testroom"}},
 		}, nil).Once()
 
@@ -384,7 +408,7 @@ func TestChatServerRegisterClient(t *testing.T) {
 			send: make(chan *ServerMessage, 1),
 		}
 
-		cs.RegisterClient(client)
+		cs.RegisterClient(context.Background(), client)
 		assert.Len(t, cs.clients, 1, "expected 1 client after registration")
 		assert.Contains(t, cs.clients, client, "expected client to be registered")
 
@@ -399,7 +423,7 @@ func TestChatServerRegisterClient(t *testing.T) {
 		db := &database.MockGoChatRepository{}
 		defer db.AssertExpectations(t)
 
-		db.On("ListSubscriptions", 1).Return([]database.Subscription{}, errors.New("db error")).Once()
+		db.On("ListSubscriptions", mock.Anything, 1).Return([]database.Subscription{}, errors.New("db error")).Once()
 
 		su := &stats.MockStatsUpdater{}
 		su.On("Incr", "NumActiveClients").Once()
@@ -411,7 +435,7 @@ func TestChatServerRegisterClient(t *testing.T) {
 			send: make(chan *ServerMessage, 1),
 		}
 
-		cs.RegisterClient(client)
+		cs.RegisterClient(context.Background(), client)
 		assert.Len(t, cs.clients, 1, "expected 1 client after registration")
 		assert.Contains(t, cs.clients, client, "expected client to be registered")
 
@@ -566,6 +590,7 @@ func TestChatServer_unloadAllRooms_Integration(t *testing.T) {
 	for i := 1; i <= numRooms; i++ {
 		rooms[i-1] = &Room{
 			externalId: "testroom" + strconv.Itoa(i),
+			cs:         cs,
 			exit:       make(chan exitReq, 1),
 			log:        cs.log,
 		}
@@ -712,11 +737,12 @@ func TestChatServer_handleJoinRoom(t *testing.T) {
 		roomId := "testroom"
 		db := &database.MockGoChatRepository{}
 		dbRoom := database.Room{Id: 1, ExternalId: roomId, Subscriptions: []database.Subscription{{AccountId: 1}}}
-		db.On("GetRoomByExternalId", roomId).Return(dbRoom, nil).Once()
-		db.On("GetSubscribersByRoomId", dbRoom.Id).Return([]database.User{}, nil).Once()
+		db.On("GetRoomByExternalId", mock.Anything, roomId).Return(dbRoom, nil).Once()
+		db.On("GetSubscribersByRoomId", mock.Anything, dbRoom.Id).Return([]database.User{}, nil).Once()
 		// These methods may be called in Room.handleJoin
-		db.On("SubscriptionExists", 1, dbRoom.Id).Return(true).Maybe()
-		db.On("GetRoomWithSubscribers", dbRoom.Id).Return(&dbRoom, nil).Maybe()
+		db.On("SubscriptionExists", mock.Anything, 1, dbRoom.Id).Return(true).Maybe()
+		db.On("GetRoomWithSubscribers", mock.Anything, dbRoom.Id).Return(&dbRoom, nil).Maybe()
+		db.On("ListPins", mock.Anything, dbRoom.Id).Return([]database.Pin{}, nil).Maybe()
 		defer db.AssertExpectations(t)
 
 		su := &stats.MockStatsUpdater{}
@@ -751,7 +777,7 @@ func TestChatServer_handleJoinRoom(t *testing.T) {
 	t.Run("join inactive room room not found", func(t *testing.T) {
 		roomId := "notfound"
 		db := &database.MockGoChatRepository{}
-		db.On("GetRoomByExternalId", roomId).Return(database.Room{}, sql.ErrNoRows).Once()
+		db.On("GetRoomByExternalId", mock.Anything, roomId).Return(database.Room{}, sql.ErrNoRows).Once()
 		defer db.AssertExpectations(t)
 
 		cs := newTestChatServer(t, db, &stats.MockStatsUpdater{})
@@ -781,7 +807,7 @@ func TestChatServer_handleJoinRoom(t *testing.T) {
 	t.Run("join inactive room db error getting room", func(t *testing.T) {
 		roomId := "dberr"
 		db := &database.MockGoChatRepository{}
-		db.On("GetRoomByExternalId", roomId).Return(database.Room{}, errors.New("db error")).Once()
+		db.On("GetRoomByExternalId", mock.Anything, roomId).Return(database.Room{}, errors.New("db error")).Once()
 		defer db.AssertExpectations(t)
 
 		cs := newTestChatServer(t, db, &stats.MockStatsUpdater{})
@@ -812,8 +838,8 @@ func TestChatServer_handleJoinRoom(t *testing.T) {
 		roomId := "subserr"
 		db := &database.MockGoChatRepository{}
 		dbRoom := database.Room{Id: 1, ExternalId: roomId}
-		db.On("GetRoomByExternalId", roomId).Return(dbRoom, nil).Once()
-		db.On("GetSubscribersByRoomId", dbRoom.Id).Return([]database.User{}, errors.New("subscribers error")).Once()
+		db.On("GetRoomByExternalId", mock.Anything, roomId).Return(dbRoom, nil).Once()
+		db.On("GetSubscribersByRoomId", mock.Anything, dbRoom.Id).Return([]database.User{}, errors.New("subscribers error")).Once()
 		defer db.AssertExpectations(t)
 
 		cs := newTestChatServer(t, db, &stats.MockStatsUpdater{})
@@ -840,3 +866,162 @@ func TestChatServer_handleJoinRoom(t *testing.T) {
 		}
 	})
 }
+
+func TestChatServer_NotifyRoom(t *testing.T) {
+	t.Run("notification forwarded to loaded room", func(t *testing.T) {
+		su := &stats.MockStatsUpdater{}
+		su.On("Incr", "NumActiveRooms").Once()
+		defer su.AssertExpectations(t)
+
+		cs := newTestChatServer(t, &database.MockGoChatRepository{}, su)
+		room := &Room{
+			externalId: "testroom",
+			notifyChan: make(chan *Notification, 1),
+		}
+		cs.addRoom(room.externalId, room)
+
+		n := &Notification{MessageDeleted: &MessageDeleted{RoomId: room.externalId, SeqId: 1}}
+		err := cs.NotifyRoom(context.Background(), room.externalId, n)
+		assert.NoError(t, err, "expected no error notifying room")
+
+		select {
+		case got := <-room.notifyChan:
+			assert.Equal(t, n, got, "expected notification to be forwarded to room")
+		default:
+			t.Error("expected notification to be sent to room")
+		}
+	})
+
+	t.Run("room not loaded", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+		db.On("GetRoomByExternalId", mock.Anything, "notloaded").Return(database.Room{Id: 1}, nil).Once()
+		db.On("GetSubscribersByRoomId", mock.Anything, 1).Return([]database.User{{Id: 2}, {Id: 3}}, nil).Once()
+
+		su := &stats.MockStatsUpdater{}
+		su.On("Incr", "NumActiveClients").Twice()
+		cs := newTestChatServer(t, db, su)
+		clients := []*Client{
+			{user: types.User{Id: 2}, send: make(chan *ServerMessage, 1)},
+			{user: types.User{Id: 3}, send: make(chan *ServerMessage, 1)},
+		}
+		for _, c := range clients {
+			cs.addClient(c)
+		}
+
+		n := &Notification{RoomUpdated: &RoomUpdated{RoomId: "notloaded", Name: "renamed"}}
+		err := cs.NotifyRoom(context.Background(), "notloaded", n)
+		assert.NoError(t, err, "expected no error when room is not loaded")
+
+		for _, c := range clients {
+			if assert.Len(t, c.send, 1, "expected notification to be delivered to each subscriber") {
+				assert.Equal(t, n, (<-c.send).Notification, "expected notification to be delivered to subscriber")
+			}
+		}
+	})
+
+	t.Run("room not loaded fails to get subscribers", func(t *testing.T) {
+		db := &database.MockGoChatRepository{}
+		defer db.AssertExpectations(t)
+		db.On("GetRoomByExternalId", mock.Anything, "notloaded").Return(database.Room{}, errors.New("db error")).Once()
+
+		cs := newTestChatServer(t, db, &stats.MockStatsUpdater{})
+
+		err := cs.NotifyRoom(context.Background(), "notloaded", &Notification{})
+		assert.Error(t, err, "expected error when subscribers can't be loaded")
+	})
+
+	t.Run("notify channel full", func(t *testing.T) {
+		su := &stats.MockStatsUpdater{}
+		su.On("Incr", "NumActiveRooms").Once()
+		defer su.AssertExpectations(t)
+
+		cs := newTestChatServer(t, &database.MockGoChatRepository{}, su)
+		room := &Room{
+			externalId: "fullroom",
+			notifyChan: make(chan *Notification, 1),
+		}
+		cs.addRoom(room.externalId, room)
+		room.notifyChan <- &Notification{}
+
+		err := cs.NotifyRoom(context.Background(), room.externalId, &Notification{})
+		assert.Error(t, err, "expected error when notify channel is full")
+	})
+}
+
+func TestChatServer_NotifyUsers(t *testing.T) {
+	su := &stats.MockStatsUpdater{}
+	su.On("Incr", "NumActiveClients").Times(3)
+	defer su.AssertExpectations(t)
+
+	cs := newTestChatServer(t, &database.MockGoChatRepository{}, su)
+	cs.backplane = backplane.NewHub().Connect()
+
+	inRoom := &Client{user: types.User{Id: 1}, send: make(chan *ServerMessage, 1), rooms: make(map[string]*Room)}
+	inRoom.addRoom(&Room{externalId: "testroom"})
+	elsewhere := &Client{user: types.User{Id: 1}, send: make(chan *ServerMessage, 1), rooms: make(map[string]*Room)}
+	other := &Client{user: types.User{Id: 2}, send: make(chan *ServerMessage, 1), rooms: make(map[string]*Room)}
+	for _, c := range []*Client{inRoom, elsewhere, other} {
+		cs.addClient(c)
+	}
+
+	n := &Notification{RoomDeleted: &RoomDeleted{RoomId: "testroom"}}
+	cs.NotifyUsers("testroom", []int{1, 2}, n)
+
+	// the clients are notified before NotifyUsers returns
+	assert.Empty(t, inRoom.send, "expected the client in the room to be skipped")
+	for _, c := range []*Client{elsewhere, other} {
+		if assert.Len(t, c.send, 1, "expected the client outside of the room to be notified") {
+			assert.Equal(t, n, (<-c.send).Notification, "expected notification to be delivered")
+		}
+	}
+	assert.Len(t, cs.publishChan, 2, "expected notification to be published for each user")
+}
+
+func TestChatServer_RemoveSubscriber(t *testing.T) {
+	t.Run("request forwarded to loaded room", func(t *testing.T) {
+		su := &stats.MockStatsUpdater{}
+		su.On("Incr", "NumActiveRooms").Once()
+		defer su.AssertExpectations(t)
+
+		cs := newTestChatServer(t, &database.MockGoChatRepository{}, su)
+		room := &Room{
+			externalId: "testroom",
+			removeChan: make(chan removeReq, 1),
+		}
+		cs.addRoom(room.externalId, room)
+
+		user := types.User{Id: 2, Username: "kicked"}
+		err := cs.RemoveSubscriber(context.Background(), room.externalId, user, true)
+		assert.NoError(t, err, "expected no error removing subscriber")
+
+		select {
+		case got := <-room.removeChan:
+			assert.Equal(t, removeReq{user: user, banned: true}, got, "expected remove request to be forwarded to room")
+		default:
+			t.Error("expected remove request to be sent to room")
+		}
+
+		select {
+		case msg := <-cs.broadcastChan:
+			assert.Equal(t, user.Id, msg.UserId, "expected kick notification to be sent to kicked user")
+			assert.Equal(t, &Kicked{RoomId: room.externalId, Banned: true}, msg.Notification.Kicked)
+		default:
+			t.Error("expected kick notification to be sent to kicked user")
+		}
+	})
+
+	t.Run("room not loaded", func(t *testing.T) {
+		cs := newTestChatServer(t, &database.MockGoChatRepository{}, &stats.MockStatsUpdater{})
+
+		err := cs.RemoveSubscriber(context.Background(), "notloaded", types.User{Id: 2}, false)
+		assert.NoError(t, err, "expected no error when room is not loaded")
+
+		select {
+		case msg := <-cs.broadcastChan:
+			assert.Equal(t, &Kicked{RoomId: "notloaded"}, msg.Notification.Kicked, "expected kick notification to be sent to kicked user")
+		default:
+			t.Error("expected kick notification to be sent to kicked user")
+		}
+	})
+}
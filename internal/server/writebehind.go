@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"log"
+
+	"github.com/npezzotti/go-chatroom/internal/database"
+)
+
+// writeQueueSize is the number of published messages that can be waiting to be saved
+// in a room. Messages published while the queue is full are rejected.
+const writeQueueSize = 1024
+
+// WithMessageBatchSize saves the messages published in a room in the background, in
+// batches of up to size messages, instead of saving each message before the room handles
+// the next one. A room's messages are saved in the order they were published, and each
+// message is acknowledged and sent to the room once the batch it is in is committed.
+// A size of zero saves each message synchronously, which is the default.
+func WithMessageBatchSize(size int) Option {
+	return func(cs *ChatServer) {
+		cs.messageBatchSize = size
+	}
+}
+
+// pendingMessage is a published message waiting to be saved.
+type pendingMessage struct {
+	msg *ClientMessage
+	// message is saved in the database
+	message database.Message
+	// stored is the message as saved, with its seq id, or err is set if saving it failed
+	stored database.Message
+	err    error
+}
+
+// messageWriter saves the messages published in a room in batches from its own goroutine,
+// so the room keeps handling messages while the database is written to. Messages queued
+// while a batch is being saved are saved together in the next batch, so batches grow with
+// the load of the room and messages aren't delayed when it is idle.
+type messageWriter struct {
+	db           database.GoChatRepository
+	log          *log.Logger
+	maxBatchSize int
+	// queue receives the messages to save in the order they were published
+	queue chan *pendingMessage
+	// saved receives the batches once they are saved, in the order they were queued
+	saved chan []*pendingMessage
+}
+
+func newMessageWriter(db database.GoChatRepository, logger *log.Logger, maxBatchSize int) *messageWriter {
+	return &messageWriter{
+		db:           db,
+		log:          logger,
+		maxBatchSize: maxBatchSize,
+		queue:        make(chan *pendingMessage, writeQueueSize),
+		saved:        make(chan []*pendingMessage, 1),
+	}
+}
+
+// enqueue queues a message to be saved. It returns false if the queue is full.
+func (w *messageWriter) enqueue(p *pendingMessage) bool {
+	select {
+	case w.queue <- p:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop stops accepting messages. The messages already queued are saved before saved is closed.
+func (w *messageWriter) stop() {
+	close(w.queue)
+}
+
+// run saves the queued messages until the writer is stopped.
+func (w *messageWriter) run(ctx context.Context) {
+	defer close(w.saved)
+
+	for p := range w.queue {
+		batch := []*pendingMessage{p}
+
+	collect:
+		for len(batch) < w.maxBatchSize {
+			select {
+			case p, ok := <-w.queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, p)
+			default:
+				break collect
+			}
+		}
+
+		w.save(ctx, batch)
+		w.saved <- batch
+	}
+}
+
+// save saves a batch of messages in a single transaction. A message that can't be saved,
+// e.g. because its attachments were attached to another message meanwhile, fails the
+// whole transaction, so the messages are then saved one at a time to only fail that one.
+func (w *messageWriter) save(ctx context.Context, batch []*pendingMessage) {
+	messages := make([]database.Message, 0, len(batch))
+	for _, p := range batch {
+		messages = append(messages, p.message)
+	}
+
+	stored, err := w.db.CreateMessages(ctx, messages)
+	if err == nil {
+		for i, p := range batch {
+			p.stored = stored[i]
+		}
+		return
+	}
+
+	if len(batch) == 1 {
+		batch[0].err = err
+		return
+	}
+
+	w.log.Printf("error saving batch of %d messages, saving them one at a time: %v", len(batch), err)
+	for _, p := range batch {
+		p.stored, p.err = w.db.CreateMessage(ctx, p.message)
+	}
+}
@@ -0,0 +1,428 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/npezzotti/go-chatroom/internal/backplane"
+	"github.com/npezzotti/go-chatroom/internal/config"
+	"github.com/npezzotti/go-chatroom/internal/database"
+	"github.com/npezzotti/go-chatroom/internal/stats"
+	"github.com/npezzotti/go-chatroom/internal/testutil"
+	"github.com/npezzotti/go-chatroom/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewChatServer_messageBatchSize(t *testing.T) {
+	tcases := []struct {
+		name      string
+		batchSize int
+		err       bool
+	}{
+		{
+			name:      "synchronous",
+			batchSize: 0,
+		},
+		{
+			name:      "batches",
+			batchSize: 100,
+		},
+		{
+			name:      "negative",
+			batchSize: -1,
+			err:       true,
+		},
+		{
+			name:      "too large",
+			batchSize: database.MaxMessageBatchSize + 1,
+			err:       true,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			su := &stats.MockStatsUpdater{}
+			su.On("RegisterMetric", mock.Anything).Return(nil).Maybe()
+
+			cs, err := NewChatServer(testutil.TestLogger(t), &database.MockGoChatRepository{}, su, nil, WithMessageBatchSize(tc.batchSize))
+			if tc.err {
+				assert.Error(t, err, "expected error for message batch size %d", tc.batchSize)
+				return
+			}
+			assert.NoError(t, err, "expected no error for message batch size %d", tc.batchSize)
+			assert.Equal(t, tc.batchSize, cs.messageBatchSize, "expected message batch size to be set")
+		})
+	}
+}
+
+func newTestPendingMessage(content string) *pendingMessage {
+	return &pendingMessage{
+		msg:     &ClientMessage{Publish: &Publish{Content: content}},
+		message: database.Message{RoomId: 1, UserId: 1, Content: content},
+	}
+}
+
+func Test_messageWriter_run(t *testing.T) {
+	db := &database.MockGoChatRepository{}
+	defer db.AssertExpectations(t)
+
+	w := newMessageWriter(db, testutil.TestLogger(t), 2)
+	pending := []*pendingMessage{
+		newTestPendingMessage("one"),
+		newTestPendingMessage("two"),
+		newTestPendingMessage("three"),
+	}
+	for _, p := range pending {
+		assert.True(t, w.enqueue(p), "expected message to be queued")
+	}
+
+	db.On("CreateMessages", mock.Anything, []database.Message{pending[0].message, pending[1].message}).
+		Return([]database.Message{{SeqId: 1}, {SeqId: 2}}, nil).Once()
+	db.On("CreateMessages", mock.Anything, []database.Message{pending[2].message}).
+		Return([]database.Message{{SeqId: 3}}, nil).Once()
+
+	go w.run(context.Background())
+	w.stop()
+
+	var seqIds []int
+	var batches int
+	for batch := range w.saved {
+		batches++
+		for _, p := range batch {
+			assert.NoError(t, p.err, "expected message to be saved")
+			seqIds = append(seqIds, p.stored.SeqId)
+		}
+	}
+
+	assert.Equal(t, 2, batches, "expected messages to be saved in batches of up to 2")
+	assert.Equal(t, []int{1, 2, 3}, seqIds, "expected messages to be saved in the order they were queued")
+}
+
+func Test_messageWriter_save(t *testing.T) {
+	saveErr := errors.New("attachments are already attached to a message")
+
+	tcases := []struct {
+		name     string
+		size     int
+		setup    func(db *database.MockGoChatRepository, pending []*pendingMessage)
+		expected []int
+		failed   []bool
+	}{
+		{
+			name: "batch saved",
+			size: 2,
+			setup: func(db *database.MockGoChatRepository, pending []*pendingMessage) {
+				db.On("CreateMessages", mock.Anything, []database.Message{pending[0].message, pending[1].message}).
+					Return([]database.Message{{SeqId: 1}, {SeqId: 2}}, nil).Once()
+			},
+			expected: []int{1, 2},
+			failed:   []bool{false, false},
+		},
+		{
+			name: "single message fails",
+			size: 1,
+			setup: func(db *database.MockGoChatRepository, pending []*pendingMessage) {
+				db.On("CreateMessages", mock.Anything, []database.Message{pending[0].message}).
+					Return(nil, saveErr).Once()
+			},
+			expected: []int{0},
+			failed:   []bool{true},
+		},
+		{
+			name: "batch fails",
+			size: 2,
+			setup: func(db *database.MockGoChatRepository, pending []*pendingMessage) {
+				db.On("CreateMessages", mock.Anything, []database.Message{pending[0].message, pending[1].message}).
+					Return(nil, saveErr).Once()
+				db.On("CreateMessage", mock.Anything, pending[0].message).Return(database.Message{SeqId: 1}, nil).Once()
+				db.On("CreateMessage", mock.Anything, pending[1].message).Return(database.Message{}, saveErr).Once()
+			},
+			expected: []int{1, 0},
+			failed:   []bool{false, true},
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &database.MockGoChatRepository{}
+			defer db.AssertExpectations(t)
+
+			pending := []*pendingMessage{newTestPendingMessage("one"), newTestPendingMessage("two")}[:tc.size]
+			tc.setup(db, pending)
+
+			w := newMessageWriter(db, testutil.TestLogger(t), 10)
+			w.save(context.Background(), pending)
+
+			for i, p := range pending {
+				assert.Equal(t, tc.expected[i], p.stored.SeqId, "expected seq id of message %d to match", i)
+				if tc.failed[i] {
+					assert.ErrorIs(t, p.err, saveErr, "expected message %d to fail", i)
+				} else {
+					assert.NoError(t, p.err, "expected message %d to be saved", i)
+				}
+			}
+		})
+	}
+}
+
+func newTestWriteBehindRoom(t *testing.T, db database.GoChatRepository, cs *ChatServer) *Room {
+	return &Room{
+		id:          1,
+		externalId:  "testroom",
+		clients:     make(map[*Client]struct{}),
+		userMap:     make(map[int]map[*Client]struct{}),
+		db:          db,
+		cs:          cs,
+		log:         testutil.TestLogger(t),
+		seq_id:      1,
+		unread:      map[int]*database.Unread{1: {AccountId: 1, LastReadSeqId: 1}},
+		subscribers: []types.User{{Id: 1, Username: "user1"}},
+		writer:      newMessageWriter(db, testutil.TestLogger(t), 10),
+	}
+}
+
+func Test_saveAndBroadcast_writeBehind(t *testing.T) {
+	db := &database.MockGoChatRepository{}
+	defer db.AssertExpectations(t)
+
+	room := newTestWriteBehindRoom(t, db, newTestChatServer(t, db, &stats.MockStatsUpdater{}))
+	c := &Client{
+		user:  types.User{Id: 1, Username: "user1"},
+		send:  make(chan *ServerMessage, 256),
+		rooms: make(map[string]*Room),
+		log:   room.log,
+	}
+	room.addClient(c)
+
+	msg := &ClientMessage{
+		BaseMessage: BaseMessage{Id: 1, Timestamp: Now()},
+		Publish:     &Publish{RoomId: room.externalId, Content: "hi"},
+		UserId:      c.user.Id,
+		client:      c,
+	}
+
+	room.saveAndBroadcast(msg)
+	assert.Empty(t, c.send, "expected message not to be acknowledged before it is saved")
+	assert.Len(t, room.writer.queue, 1, "expected message to be queued")
+
+	db.On("CreateMessages", mock.Anything, []database.Message{{
+		RoomId:      room.id,
+		UserId:      c.user.Id,
+		Content:     "hi",
+		ContentHTML: "<p>hi</p>",
+		CreatedAt:   msg.Timestamp,
+	}}).Return([]database.Message{{Id: 10, SeqId: 2, RoomId: room.id}}, nil).Once()
+
+	go room.writer.run(context.Background())
+	room.flushWriter()
+
+	select {
+	case resp := <-c.send:
+		assert.NotNil(t, resp.Response, "expected response message")
+		assert.Equal(t, http.StatusAccepted, resp.Response.ResponseCode, "expected message to be accepted once saved")
+	default:
+		t.Fatal("expected response to be sent to client")
+	}
+
+	select {
+	case pub := <-c.send:
+		assert.NotNil(t, pub.Message, "expected published message")
+		assert.Equal(t, 2, pub.Message.SeqId, "expected published message to have the stored seq id")
+		assert.Equal(t, "<p>hi</p>", pub.Message.ContentHTML, "expected published message to have the rendered content")
+	default:
+		t.Fatal("expected message to be published to the room")
+	}
+
+	assert.Equal(t, 2, room.seq_id, "expected room seq id to be updated")
+}
+
+func Test_saveAndBroadcast_writeQueueFull(t *testing.T) {
+	db := &database.MockGoChatRepository{}
+	defer db.AssertExpectations(t)
+
+	room := newTestWriteBehindRoom(t, db, newTestChatServer(t, db, &stats.MockStatsUpdater{}))
+	room.writer.queue = make(chan *pendingMessage)
+
+	c := &Client{
+		user: types.User{Id: 1, Username: "user1"},
+		send: make(chan *ServerMessage, 1),
+		log:  room.log,
+	}
+	room.saveAndBroadcast(&ClientMessage{
+		BaseMessage: BaseMessage{Id: 1, Timestamp: Now()},
+		Publish:     &Publish{RoomId: room.externalId, Content: "hi"},
+		UserId:      c.user.Id,
+		client:      c,
+	})
+
+	select {
+	case resp := <-c.send:
+		assert.NotNil(t, resp.Response, "expected response message")
+		assert.Equal(t, http.StatusServiceUnavailable, resp.Response.ResponseCode, "expected message to be rejected")
+	default:
+		t.Fatal("expected response to be sent to client")
+	}
+}
+
+func Test_handleSaved_forwarded(t *testing.T) {
+	db := &database.MockGoChatRepository{}
+	defer db.AssertExpectations(t)
+
+	cs := newTestChatServer(t, db, &stats.MockStatsUpdater{})
+	cs.backplane = backplane.NewHub().Connect()
+	room := newTestWriteBehindRoom(t, db, cs)
+
+	// the client of a message forwarded by another node
+	c := &Client{
+		id:   "sender",
+		node: "node2",
+		user: types.User{Id: 1, Username: "user1"},
+		send: make(chan *ServerMessage, forwardedReplyBufferSize),
+		log:  room.log,
+	}
+	p := &pendingMessage{
+		msg: &ClientMessage{
+			BaseMessage: BaseMessage{Id: 7, Timestamp: Now()},
+			Publish:     &Publish{RoomId: room.externalId, Content: "hi"},
+			UserId:      c.user.Id,
+			client:      c,
+		},
+		stored: database.Message{SeqId: 2},
+	}
+
+	room.handleSaved(p)
+
+	assert.Empty(t, c.send, "expected replies to be sent back to the node")
+	var reply *clusterEvent
+	for len(cs.publishChan) > 0 {
+		if e := <-cs.publishChan; e.To == "node2" {
+			reply = e
+		}
+	}
+	if assert.NotNil(t, reply, "expected reply to be published to the node the client is connected to") {
+		assert.Equal(t, "sender", reply.ClientId, "expected reply to identify the client")
+		assert.Equal(t, http.StatusAccepted, reply.Message.Response.ResponseCode, "expected message to be accepted")
+	}
+}
+
+// benchmarkSaveLatency is the simulated round trip to the database of saving messages.
+const benchmarkSaveLatency = time.Millisecond
+
+// latencyRepository saves messages after a delay, like a database on the network would.
+type latencyRepository struct {
+	database.GoChatRepository
+	mu    sync.Mutex
+	seqId int
+}
+
+func (db *latencyRepository) CreateMessage(ctx context.Context, msg database.Message) (database.Message, error) {
+	stored, err := db.CreateMessages(ctx, []database.Message{msg})
+	if err != nil {
+		return database.Message{}, err
+	}
+	return stored[0], nil
+}
+
+func (db *latencyRepository) CreateMessages(_ context.Context, msgs []database.Message) ([]database.Message, error) {
+	time.Sleep(benchmarkSaveLatency)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored := make([]database.Message, len(msgs))
+	for i, msg := range msgs {
+		db.seqId++
+		msg.SeqId = db.seqId
+		stored[i] = msg
+	}
+	return stored, nil
+}
+
+func (db *latencyRepository) ListUnread(context.Context, int) ([]database.Unread, error) {
+	return nil, nil
+}
+
+// BenchmarkRoom_publish measures how many messages a room saves and broadcasts per second
+// when saving each message takes benchmarkSaveLatency. Each publisher waits for its
+// message to be accepted before publishing the next one, like a client does.
+func BenchmarkRoom_publish(b *testing.B) {
+	const publishers = 64
+
+	bcases := []struct {
+		name      string
+		batchSize int
+	}{
+		{name: "synchronous"},
+		{name: "write-behind", batchSize: 100},
+	}
+
+	for _, bc := range bcases {
+		b.Run(bc.name, func(b *testing.B) {
+			db := &latencyRepository{}
+			room := &Room{
+				id:            1,
+				externalId:    "benchroom",
+				cs:            &ChatServer{maxContentLength: config.DefaultMaxContentLength, ctx: context.Background()},
+				db:            db,
+				clientMsgChan: make(chan *ClientMessage, 256),
+				clients:       make(map[*Client]struct{}),
+				userMap:       make(map[int]map[*Client]struct{}),
+				log:           log.New(io.Discard, "", 0),
+				exit:          make(chan exitReq, 1),
+			}
+			if bc.batchSize > 0 {
+				room.writer = newMessageWriter(db, room.log, bc.batchSize)
+			}
+			go room.start()
+
+			b.ResetTimer()
+
+			var wg sync.WaitGroup
+			for i := range publishers {
+				// the messages are shared between the publishers
+				n := b.N / publishers
+				if i < b.N%publishers {
+					n++
+				}
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					c := &Client{
+						user: types.User{Id: i + 1},
+						send: make(chan *ServerMessage, 1),
+						log:  room.log,
+					}
+					for j := range n {
+						room.clientMsgChan <- &ClientMessage{
+							BaseMessage: BaseMessage{Id: j + 1, Timestamp: Now()},
+							Publish:     &Publish{RoomId: room.externalId, Content: "hello"},
+							UserId:      c.user.Id,
+							client:      c,
+						}
+						if resp := <-c.send; resp.Response.ResponseCode != http.StatusAccepted {
+							b.Errorf("expected message to be accepted, got %d", resp.Response.ResponseCode)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+
+			done := make(chan string, 1)
+			room.exit <- exitReq{done: done}
+			<-done
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package types
+
+import (
+	"github.com/npezzotti/go-chatroom/internal/database"
+)
+
+// NewMessage converts a stored message to the message sent to clients.
+func NewMessage(msg database.Message) Message {
+	return Message{
+		SeqId:          msg.SeqId,
+		RoomId:         msg.RoomId,
+		UserId:         msg.UserId,
+		Content:        msg.Content,
+		ContentHTML:    msg.ContentHTML,
+		Edited:         !msg.Deleted && msg.UpdatedAt.After(msg.CreatedAt),
+		Deleted:        msg.Deleted,
+		ParentSeqId:    msg.ParentSeqId,
+		ReplyCount:     msg.ReplyCount,
+		Reactions:      NewReactions(msg.Reactions),
+		Attachments:    NewAttachments(msg.Attachments),
+		Previews:       NewLinkPreviews(msg.LinkPreviews),
+		Timestamp:      msg.CreatedAt,
+		ExternalRoomId: msg.ExternalRoomId,
+		Snippet:        msg.Snippet,
+	}
+}
+
+// NewReactions converts the stored reactions to a message to the reactions sent to
+// clients.
+func NewReactions(reactions []database.Reaction) []Reaction {
+	if len(reactions) == 0 {
+		return nil
+	}
+
+	res := make([]Reaction, 0, len(reactions))
+	for _, reaction := range reactions {
+		res = append(res, Reaction{
+			Emoji:   reaction.Emoji,
+			Count:   len(reaction.UserIds),
+			UserIds: reaction.UserIds,
+		})
+	}
+
+	return res
+}
+
+// NewPin converts a stored pin to the pin sent to clients.
+func NewPin(pin database.Pin) Pin {
+	return Pin{
+		Message:  NewMessage(pin.Message),
+		PinnedBy: pin.PinnedBy,
+		PinnedAt: pin.CreatedAt,
+	}
+}
+
+// AttachmentURL returns the path an attachment is downloaded from.
+func AttachmentURL(externalId string) string {
+	return "/api/attachments/" + externalId
+}
+
+// NewAttachment converts a stored attachment to the attachment sent to clients.
+func NewAttachment(a database.Attachment) Attachment {
+	return Attachment{
+		Id:          a.ExternalId,
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		URL:         AttachmentURL(a.ExternalId),
+	}
+}
+
+// NewAttachments converts stored attachments to the attachments sent to clients.
+func NewAttachments(attachments []database.Attachment) []Attachment {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	res := make([]Attachment, 0, len(attachments))
+	for _, a := range attachments {
+		res = append(res, NewAttachment(a))
+	}
+
+	return res
+}
+
+// NewLinkPreview converts a cached link preview to the preview sent to clients.
+func NewLinkPreview(p database.LinkPreview) LinkPreview {
+	return LinkPreview{
+		URL:         p.URL,
+		Title:       p.Title,
+		Description: p.Description,
+		ImageURL:    p.ImageURL,
+		SiteName:    p.SiteName,
+	}
+}
+
+// NewLinkPreviews converts the cached previews of the links in a message to the
+// previews sent to clients.
+func NewLinkPreviews(previews []database.LinkPreview) []LinkPreview {
+	if len(previews) == 0 {
+		return nil
+	}
+
+	res := make([]LinkPreview, 0, len(previews))
+	for _, p := range previews {
+		res = append(res, NewLinkPreview(p))
+	}
+
+	return res
+}
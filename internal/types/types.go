@@ -9,34 +9,131 @@ type User struct {
 	Username     string    `json:"username"`
 	EmailAddress string    `json:"email_address,omitempty"`
 	IsPresent    bool      `json:"is_present,omitempty"`
+	Role         string    `json:"role,omitempty"`
 	CreatedAt    time.Time `json:"created_at,omitempty"`
 	UpdatedAt    time.Time `json:"updated_at,omitempty"`
 }
 
 type Room struct {
-	Id          int       `json:"id"`
-	Name        string    `json:"name"`
-	ExternalId  string    `json:"external_id"`
-	Description string    `json:"description"`
-	SeqId       int       `json:"seq_id"`
-	OwnerId     int       `json:"owner_id,omitempty"`
-	Subscribers []User    `json:"subscribers,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	Id              int       `json:"id"`
+	Name            string    `json:"name"`
+	ExternalId      string    `json:"external_id"`
+	Description     string    `json:"description"`
+	SeqId           int       `json:"seq_id"`
+	OwnerId         int       `json:"owner_id,omitempty"`
+	Kind            string    `json:"kind,omitempty"`
+	Subscribers     []User    `json:"subscribers,omitempty"`
+	Pins            []Pin     `json:"pins,omitempty"`
+	SubscriberCount int       `json:"subscriber_count,omitempty"`
+	Loaded          bool      `json:"loaded,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type Invite struct {
+	RoomId    string    `json:"room_id"`
+	User      User      `json:"user"`
+	InvitedBy int       `json:"invited_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Ban struct {
+	RoomId    string     `json:"room_id"`
+	User      User       `json:"user"`
+	BannedBy  int        `json:"banned_by"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
 }
 
 type Subscription struct {
 	Id            int       `json:"id"`
 	LastReadSeqId int       `json:"last_read_seq_id"`
+	UnreadCount   int       `json:"unread_count"`
+	MentionCount  int       `json:"mention_count"`
+	Role          string    `json:"role"`
 	Room          Room      `json:"room"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 type Message struct {
-	SeqId     int       `json:"seq_id"`
-	RoomId    int       `json:"room_id"`
-	UserId    int       `json:"user_id"`
+	SeqId       int           `json:"seq_id"`
+	RoomId      int           `json:"room_id"`
+	UserId      int           `json:"user_id"`
+	Content     string        `json:"content"`
+	ContentHTML string        `json:"content_html"`
+	Edited      bool          `json:"edited,omitempty"`
+	Deleted     bool          `json:"deleted,omitempty"`
+	ParentSeqId int           `json:"parent_seq_id,omitempty"`
+	ReplyCount  int           `json:"reply_count,omitempty"`
+	Reactions   []Reaction    `json:"reactions,omitempty"`
+	Attachments []Attachment  `json:"attachments,omitempty"`
+	Previews    []LinkPreview `json:"previews,omitempty"`
+	Timestamp   time.Time     `json:"timestamp"`
+	// ExternalRoomId and Snippet are only set on search results
+	ExternalRoomId string `json:"external_room_id,omitempty"`
+	Snippet        string `json:"snippet,omitempty"`
+}
+
+// SearchResults is a page of messages matching a search query. NextCursor is set
+// if there may be more results, and is passed as the cursor to get the next page.
+type SearchResults struct {
+	Messages   []Message `json:"messages"`
+	NextCursor int       `json:"next_cursor,omitempty"`
+}
+
+// Attachment is a file attached to a message. URL is where the file is downloaded from.
+type Attachment struct {
+	Id          string `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	URL         string `json:"url"`
+}
+
+// LinkPreview is the preview of a page linked in a message.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// Pin is a message pinned to a room.
+type Pin struct {
+	Message  Message   `json:"message"`
+	PinnedBy int       `json:"pinned_by"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// Mention is a message that mentions the user.
+type Mention struct {
+	Id        int       `json:"id"`
+	Message   Message   `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Mentions is a page of the user's mentions, from newest to oldest. NextCursor is set
+// if there may be more mentions, and is passed as the cursor to get the next page.
+type Mentions struct {
+	Mentions   []Mention `json:"mentions"`
+	NextCursor int       `json:"next_cursor,omitempty"`
+}
+
+type Reaction struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	UserIds []int  `json:"user_ids"`
+}
+
+type Thread struct {
+	Parent  Message   `json:"parent"`
+	Replies []Message `json:"replies"`
+}
+
+type MessageRevision struct {
 	Content   string    `json:"content"`
 	Timestamp time.Time `json:"timestamp"`
 }
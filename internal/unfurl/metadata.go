@@ -0,0 +1,106 @@
+package unfurl
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 500
+)
+
+var (
+	metaTagPattern   = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attributePattern = regexp.MustCompile(`(?is)([a-z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titlePattern     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	headEndPattern   = regexp.MustCompile(`(?i)</head\s*>`)
+)
+
+// metadata is the preview of a page read from its Open Graph tags.
+type metadata struct {
+	Title       string
+	Description string
+	ImageURL    string
+	SiteName    string
+}
+
+// parseMetadata reads the Open Graph tags of a page, falling back to its title and
+// description meta tag. pageURL is used to resolve a relative image link.
+func parseMetadata(doc string, pageURL *url.URL) metadata {
+	// the metadata is in the head, so the body isn't searched
+	if loc := headEndPattern.FindStringIndex(doc); loc != nil {
+		doc = doc[:loc[0]]
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range metaTagPattern.FindAllString(doc, -1) {
+		var key, content string
+		hasContent := false
+		for _, attr := range attributePattern.FindAllStringSubmatch(tag, -1) {
+			value := attr[2] + attr[3] + attr[4]
+			switch strings.ToLower(attr[1]) {
+			case "property", "name":
+				key = strings.ToLower(value)
+			case "content":
+				content, hasContent = value, true
+			}
+		}
+
+		// the first tag for a property wins
+		if _, ok := tags[key]; key != "" && hasContent && !ok {
+			tags[key] = clean(content)
+		}
+	}
+
+	meta := metadata{
+		Title:       first(tags["og:title"], tags["twitter:title"]),
+		Description: first(tags["og:description"], tags["description"], tags["twitter:description"]),
+		SiteName:    tags["og:site_name"],
+	}
+	if meta.Title == "" {
+		if m := titlePattern.FindStringSubmatch(doc); m != nil {
+			meta.Title = clean(m[1])
+		}
+	}
+	meta.Title = truncate(meta.Title, maxTitleLength)
+	meta.Description = truncate(meta.Description, maxDescriptionLength)
+
+	if image := first(tags["og:image"], tags["og:image:url"], tags["twitter:image"]); image != "" {
+		if imageURL, err := pageURL.Parse(image); err == nil && checkURL(imageURL) == nil {
+			meta.ImageURL = imageURL.String()
+		}
+	}
+
+	return meta
+}
+
+// clean unescapes HTML entities and collapses whitespace.
+func clean(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// truncate shortens s to at most max bytes without splitting a character.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	s = s[:max]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return strings.TrimSpace(s) + "…"
+}
@@ -0,0 +1,70 @@
+package unfurl
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMetadata(t *testing.T) {
+	pageURL, _ := url.Parse("https://example.com/blog/post")
+
+	tcs := []struct {
+		name     string
+		doc      string
+		expected metadata
+	}{
+		{
+			name: "open graph tags",
+			doc:  testPage,
+			expected: metadata{
+				Title:       "Release notes & changes",
+				Description: "What's new in this release",
+				ImageURL:    "https://example.com/images/banner.png",
+				SiteName:    "Example",
+			},
+		},
+		{
+			name: "falls back to title and description",
+			doc:  `<head><TITLE> My  page </TITLE><meta name='description' content='A page'></head>`,
+			expected: metadata{
+				Title:       "My page",
+				Description: "A page",
+			},
+		},
+		{
+			name: "content before property",
+			doc:  `<meta content="Reordered" property="og:title">`,
+			expected: metadata{
+				Title: "Reordered",
+			},
+		},
+		{
+			name: "ignores unsafe image links",
+			doc:  `<meta property="og:title" content="Title"><meta property="og:image" content="javascript:alert(1)">`,
+			expected: metadata{
+				Title: "Title",
+			},
+		},
+		{
+			name: "truncates long titles",
+			doc:  `<meta property="og:title" content="` + strings.Repeat("é", maxTitleLength) + `">`,
+			expected: metadata{
+				Title: strings.Repeat("é", maxTitleLength/2) + "…",
+			},
+		},
+		{
+			name:     "no metadata",
+			doc:      `<html><body>hello</body></html>`,
+			expected: metadata{},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, parseMetadata(tc.doc, pageURL))
+		})
+	}
+}
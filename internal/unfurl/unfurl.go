@@ -0,0 +1,295 @@
+// Package unfurl fetches previews of the links in messages from the Open Graph
+// metadata of the linked pages.
+package unfurl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/npezzotti/go-chatroom/internal/database"
+	"github.com/npezzotti/go-chatroom/internal/types"
+)
+
+const (
+	// maxLinks is the maximum number of links previewed per message
+	maxLinks = 3
+	// maxBodySize is the maximum number of bytes of a page read to find its metadata
+	maxBodySize = 512 << 10
+	// maxRedirects is the maximum number of redirects followed when fetching a page
+	maxRedirects = 3
+	// cacheTTL is how long a fetched preview is used before the page is fetched again
+	cacheTTL = 24 * time.Hour
+	// userAgent identifies the unfurler to the sites it fetches
+	userAgent = "go-chat-unfurler/1.0"
+)
+
+// ErrPrivateAddress is returned when a link resolves to an address that isn't publicly routable.
+var ErrPrivateAddress = errors.New("address is not publicly routable")
+
+// linkPattern matches http and https links in the content of a message.
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// sharedAddressSpace is the carrier-grade NAT range, which net/netip doesn't treat as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+type job struct {
+	links []string
+	done  func([]types.LinkPreview)
+}
+
+// Unfurler fetches link previews in the background with a fixed number of workers.
+// Previews are cached in the database so popular links are only fetched once a day.
+type Unfurler struct {
+	log     *log.Logger
+	db      database.GoChatRepository
+	client  *http.Client
+	timeout time.Duration
+	workers int
+	jobs    chan job
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewUnfurler creates an Unfurler that fetches pages with the given number of workers,
+// queueing up to queueSize messages. Fetching a page is limited to timeout. Unless
+// allowPrivate is set, links that resolve to loopback, private or link-local addresses
+// are never fetched, so messages can't be used to probe the internal network.
+func NewUnfurler(logger *log.Logger, db database.GoChatRepository, workers, queueSize int, timeout time.Duration, allowPrivate bool) *Unfurler {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		// the address is checked after it is resolved, so DNS can't be used to get around it
+		dialer.Control = checkAddress
+	}
+
+	return &Unfurler{
+		log: logger,
+		db:  db,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				// a proxy would make the connection on our behalf without the address check
+				Proxy:                 nil,
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   timeout,
+				ResponseHeaderTimeout: timeout,
+				MaxIdleConns:          workers,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return errors.New("too many redirects")
+				}
+				return checkURL(req.URL)
+			},
+		},
+		timeout: timeout,
+		workers: workers,
+		jobs:    make(chan job, queueSize),
+		quit:    make(chan struct{}),
+	}
+}
+
+// Run starts the workers.
+func (u *Unfurler) Run() {
+	for range u.workers {
+		u.wg.Add(1)
+		go u.work()
+	}
+}
+
+// Stop stops the workers once they finish the messages they are previewing.
+// Messages still in the queue are not previewed.
+func (u *Unfurler) Stop() {
+	close(u.quit)
+	u.wg.Wait()
+}
+
+// Unfurl queues the links in the content of a message to be previewed. done is called
+// from a worker with the previews that were found, and isn't called if there are none.
+// If the queue is full the links are not previewed.
+func (u *Unfurler) Unfurl(content string, done func([]types.LinkPreview)) {
+	links := ExtractLinks(content)
+	if len(links) == 0 {
+		return
+	}
+
+	select {
+	case u.jobs <- job{links: links, done: done}:
+	default:
+		u.log.Println("unfurl queue full, skipping link previews")
+	}
+}
+
+func (u *Unfurler) work() {
+	defer u.wg.Done()
+
+	for {
+		select {
+		case <-u.quit:
+			return
+		case j := <-u.jobs:
+			var previews []types.LinkPreview
+			for _, link := range j.links {
+				if preview, ok := u.preview(link); ok {
+					previews = append(previews, preview)
+				}
+			}
+
+			if len(previews) > 0 {
+				j.done(previews)
+			}
+		}
+	}
+}
+
+// preview returns the preview of a link from the cache, or fetches it if it isn't cached.
+// Pages without metadata are cached too, so they aren't fetched for every message.
+func (u *Unfurler) preview(link string) (types.LinkPreview, bool) {
+	// the cache is looked up and updated within the time limit of the fetch
+	ctx, cancel := context.WithTimeout(context.Background(), u.timeout)
+	defer cancel()
+
+	cached, err := u.db.GetLinkPreview(ctx, link)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		u.log.Println("GetLinkPreview:", err)
+	}
+	if err == nil && time.Since(cached.FetchedAt) < cacheTTL {
+		return types.NewLinkPreview(cached), cached.Title != ""
+	}
+
+	preview, err := u.fetch(ctx, link)
+	if err != nil {
+		// failures are not cached, the page may be available next time
+		u.log.Printf("unfurl %s: %v", link, err)
+		return types.LinkPreview{}, false
+	}
+
+	if err := u.db.SaveLinkPreview(ctx, database.LinkPreview{
+		URL:         preview.URL,
+		Title:       preview.Title,
+		Description: preview.Description,
+		ImageURL:    preview.ImageURL,
+		SiteName:    preview.SiteName,
+		FetchedAt:   time.Now().UTC(),
+	}); err != nil {
+		u.log.Println("SaveLinkPreview:", err)
+	}
+
+	return preview, preview.Title != ""
+}
+
+// fetch gets a page and reads the preview from its metadata.
+func (u *Unfurler) fetch(ctx context.Context, link string) (types.LinkPreview, error) {
+	pageURL, err := url.Parse(link)
+	if err != nil {
+		return types.LinkPreview{}, err
+	}
+	if err := checkURL(pageURL); err != nil {
+		return types.LinkPreview{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL.String(), nil)
+	if err != nil {
+		return types.LinkPreview{}, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return types.LinkPreview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return types.LinkPreview{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	preview := types.LinkPreview{URL: link}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		// there is nothing to preview, but the result is still cached
+		return preview, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return types.LinkPreview{}, err
+	}
+
+	// relative image links are resolved against the page the request ended up at
+	meta := parseMetadata(string(body), resp.Request.URL)
+	preview.Title = meta.Title
+	preview.Description = meta.Description
+	preview.ImageURL = meta.ImageURL
+	preview.SiteName = meta.SiteName
+
+	return preview, nil
+}
+
+// ExtractLinks returns the distinct http and https links in the content of a message,
+// up to the maximum number previewed per message.
+func ExtractLinks(content string) []string {
+	var links []string
+	for _, link := range linkPattern.FindAllString(content, -1) {
+		// punctuation at the end of a link is usually part of the sentence
+		link = strings.TrimRight(link, ".,;:!?'")
+		if strings.HasSuffix(link, ")") && !strings.Contains(link, "(") {
+			link = strings.TrimSuffix(link, ")")
+		}
+
+		if _, err := url.Parse(link); err != nil || slices.Contains(links, link) {
+			continue
+		}
+
+		links = append(links, link)
+		if len(links) == maxLinks {
+			break
+		}
+	}
+
+	return links
+}
+
+// checkURL checks that a URL can be fetched.
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return errors.New("missing host")
+	}
+	return nil
+}
+
+// checkAddress is a net.Dialer Control function that refuses to connect to
+// addresses that aren't publicly routable.
+func checkAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+
+	if !isPublic(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
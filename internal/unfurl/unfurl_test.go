@@ -0,0 +1,229 @@
+package unfurl
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/npezzotti/go-chatroom/internal/database"
+	"github.com/npezzotti/go-chatroom/internal/testutil"
+	"github.com/npezzotti/go-chatroom/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const testPage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Fallback title</title>
+  <meta property="og:title" content="Release notes &amp; changes">
+  <meta property="og:description" content="What's new in
+    this release">
+  <meta property="og:image" content="/images/banner.png">
+  <meta property="og:site_name" content="Example">
+</head>
+<body><meta property="og:title" content="ignored"></body>
+</html>`
+
+func TestExtractLinks(t *testing.T) {
+	tcs := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{"no links", "hello world", nil},
+		{"single link", "see https://example.com/docs", []string{"https://example.com/docs"}},
+		{"trailing punctuation", "read https://example.com/a, then http://example.com/b.", []string{"https://example.com/a", "http://example.com/b"}},
+		{"parenthesized link", "(see https://example.com/a)", []string{"https://example.com/a"}},
+		{"link with parentheses", "https://en.wikipedia.org/wiki/Go_(programming_language)", []string{"https://en.wikipedia.org/wiki/Go_(programming_language)"}},
+		{"duplicate links", "https://example.com https://example.com", []string{"https://example.com"}},
+		{"other schemes", "ftp://example.com javascript:alert(1)", nil},
+		{
+			"too many links",
+			"https://a.com https://b.com https://c.com https://d.com",
+			[]string{"https://a.com", "https://b.com", "https://c.com"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ExtractLinks(tc.content))
+		})
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tcs := []struct {
+		addr     string
+		expected bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.addr, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isPublic(netip.MustParseAddr(tc.addr)))
+		})
+	}
+}
+
+func TestUnfurler_Unfurl(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, testPage)
+		case "/file":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte{0, 1, 2})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	db := &database.MockGoChatRepository{}
+	defer db.AssertExpectations(t)
+
+	db.On("GetLinkPreview", mock.Anything, srv.URL+"/page").Return(database.LinkPreview{}, sql.ErrNoRows).Once()
+	db.On("GetLinkPreview", mock.Anything, srv.URL+"/file").Return(database.LinkPreview{}, sql.ErrNoRows).Once()
+	db.On("GetLinkPreview", mock.Anything, srv.URL+"/missing").Return(database.LinkPreview{}, sql.ErrNoRows).Once()
+	db.On("SaveLinkPreview", mock.Anything, mock.MatchedBy(func(p database.LinkPreview) bool {
+		return p.URL == srv.URL+"/page" && p.Title == "Release notes & changes" && !p.FetchedAt.IsZero()
+	})).Return(nil).Once()
+	// pages without metadata are cached, but failures aren't
+	db.On("SaveLinkPreview", mock.Anything, mock.MatchedBy(func(p database.LinkPreview) bool {
+		return p.URL == srv.URL+"/file" && p.Title == ""
+	})).Return(nil).Once()
+
+	u := NewUnfurler(testutil.TestLogger(t), db, 2, 10, time.Second, true)
+	u.Run()
+	defer u.Stop()
+
+	done := make(chan []types.LinkPreview, 1)
+	u.Unfurl("look at "+srv.URL+"/page and "+srv.URL+"/file and "+srv.URL+"/missing", func(previews []types.LinkPreview) {
+		done <- previews
+	})
+
+	select {
+	case previews := <-done:
+		assert.Equal(t, []types.LinkPreview{
+			{
+				URL:         srv.URL + "/page",
+				Title:       "Release notes & changes",
+				Description: "What's new in this release",
+				ImageURL:    srv.URL + "/images/banner.png",
+				SiteName:    "Example",
+			},
+		}, previews, "expected only the page with metadata to be previewed")
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout: previews were not delivered")
+	}
+	assert.Equal(t, int32(3), hits.Load(), "expected each page to be fetched once")
+}
+
+func TestUnfurler_cachedPreview(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+
+	db := &database.MockGoChatRepository{}
+	defer db.AssertExpectations(t)
+
+	cached := database.LinkPreview{URL: srv.URL, Title: "Cached", FetchedAt: time.Now().UTC()}
+	db.On("GetLinkPreview", mock.Anything, srv.URL).Return(cached, nil).Once()
+
+	u := NewUnfurler(testutil.TestLogger(t), db, 1, 1, time.Second, true)
+
+	preview, ok := u.preview(srv.URL)
+	assert.True(t, ok, "expected cached preview")
+	assert.Equal(t, types.LinkPreview{URL: srv.URL, Title: "Cached"}, preview, "expected cached preview to match")
+	assert.Zero(t, hits.Load(), "expected cached page not to be fetched")
+}
+
+func TestUnfurler_refusesPrivateAddresses(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+
+	redirect := httptest.NewServer(http.RedirectHandler(srv.URL, http.StatusFound))
+	defer redirect.Close()
+
+	u := NewUnfurler(testutil.TestLogger(t), &database.MockGoChatRepository{}, 1, 1, time.Second, false)
+
+	for _, link := range []string{srv.URL, redirect.URL, "http://localhost:1/"} {
+		_, err := u.fetch(context.Background(), link)
+		assert.ErrorIs(t, err, ErrPrivateAddress, "expected %s to be refused", link)
+	}
+	assert.Zero(t, hits.Load(), "expected no request to reach the server")
+}
+
+func TestUnfurler_timeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	u := NewUnfurler(testutil.TestLogger(t), &database.MockGoChatRepository{}, 1, 1, 50*time.Millisecond, true)
+
+	start := time.Now()
+	_, err := u.fetch(context.Background(), srv.URL)
+	assert.Error(t, err, "expected fetch to time out")
+	assert.Less(t, time.Since(start), time.Second, "expected fetch to give up after the timeout")
+}
+
+func TestUnfurler_queueFull(t *testing.T) {
+	u := NewUnfurler(testutil.TestLogger(t), &database.MockGoChatRepository{}, 1, 1, time.Second, true)
+
+	// the workers aren't running, so the first message fills the queue
+	u.Unfurl("https://example.com/a", func([]types.LinkPreview) {})
+	u.Unfurl("https://example.com/b", func([]types.LinkPreview) {})
+	u.Unfurl("no links", func([]types.LinkPreview) {})
+
+	assert.Len(t, u.jobs, 1, "expected messages to be skipped when the queue is full")
+	j := <-u.jobs
+	assert.Equal(t, []string{"https://example.com/a"}, j.links)
+}
+
+func TestUnfurler_Stop(t *testing.T) {
+	u := NewUnfurler(testutil.TestLogger(t), &database.MockGoChatRepository{}, 3, 1, time.Second, true)
+	u.Run()
+
+	stopped := make(chan struct{})
+	go func() {
+		u.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("timeout: workers did not stop")
+	}
+}